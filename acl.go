@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ACLUser is a simplified ACL user: an optional password and the commands it
+// may run. It is not the full Redis ACL system; there are no key or channel
+// permissions and no command categories beyond @all.
+type ACLUser struct {
+	Name     string
+	Password string
+	allowAll bool
+	allowed  map[string]bool
+	denied   map[string]bool
+}
+
+// parseACLUser parses a user from a rule line in the style of ACL SETUSER:
+// the user name followed by rules. ">password" sets the password, "nopass"
+// clears it, "+cmd" and "-cmd" allow or deny a command, and "+@all"/"-@all"
+// allow or deny every command. A user with no rules may run nothing.
+func parseACLUser(line string) (*ACLUser, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errors.New("empty ACL user")
+	}
+	u := &ACLUser{
+		Name:    fields[0],
+		allowed: make(map[string]bool),
+		denied:  make(map[string]bool),
+	}
+	for _, rule := range fields[1:] {
+		switch {
+		case rule == "nopass":
+			u.Password = ""
+		case rule == "+@all" || rule == "allcommands":
+			u.allowAll = true
+			clear(u.denied)
+		case rule == "-@all" || rule == "nocommands":
+			u.allowAll = false
+			clear(u.allowed)
+		case strings.HasPrefix(rule, ">"):
+			u.Password = rule[1:]
+		case strings.HasPrefix(rule, "+") && len(rule) > 1:
+			name := strings.ToUpper(rule[1:])
+			u.allowed[name] = true
+			delete(u.denied, name)
+		case strings.HasPrefix(rule, "-") && len(rule) > 1:
+			name := strings.ToUpper(rule[1:])
+			u.denied[name] = true
+			delete(u.allowed, name)
+		default:
+			return nil, fmt.Errorf("invalid ACL rule %q for user %s", rule, u.Name)
+		}
+	}
+	return u, nil
+}
+
+// permits reports whether the user may run the named command.
+func (u *ACLUser) permits(name string) bool {
+	if u.denied[name] {
+		return false
+	}
+	return u.allowAll || u.allowed[name]
+}
+
+// checkPassword compares in constant time so the reply timing doesn't leak
+// the password. A user without a password accepts any, like nopass.
+func (u *ACLUser) checkPassword(password string) bool {
+	if u.Password == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+}
+
+var (
+	errNoAuth    = errors.New("NOAUTH Authentication required.")
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+func errNoPerm(name string) error {
+	return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(name))
+}
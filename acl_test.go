@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// newACLStore returns a test store configured with the given user rules.
+func newACLStore(t *testing.T, rules ...string) *RedisStore {
+	t.Helper()
+	rs := newTestStore(t)
+	rs.config.Users = make(map[string]*ACLUser)
+	for _, rule := range rules {
+		u, err := parseACLUser(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.config.Users[u.Name] = u
+	}
+	return rs
+}
+
+func TestACLRestrictedUser(t *testing.T) {
+	rs := newACLStore(t, "reader >secret +get", "admin >hunter2 +@all")
+	run(rs, "SET k v")
+
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "CONFIG GET tcp-keepalive"); got != "1) tcp-keepalive\n2) 300" {
+		t.Fatalf("CONFIG GET before AUTH = %q", got)
+	}
+	if got := send(c, "AUTH reader wrong"); got != "-WRONGPASS invalid username-password pair or user is disabled." {
+		t.Errorf("AUTH with a bad password = %q", got)
+	}
+	if got := send(c, "AUTH reader secret"); got != "OK" {
+		t.Fatalf("AUTH = %q", got)
+	}
+	if got := send(c, "GET k"); got != "v" {
+		t.Errorf("GET as reader = %q, want v", got)
+	}
+	if got := send(c, "CONFIG GET tcp-keepalive"); got != "-NOPERM this user has no permissions to run the 'config' command" {
+		t.Errorf("CONFIG as reader = %q", got)
+	}
+	if got := send(c, "SET k other"); got != "-NOPERM this user has no permissions to run the 'set' command" {
+		t.Errorf("SET as reader = %q", got)
+	}
+
+	if got := send(c, "AUTH admin hunter2"); got != "OK" {
+		t.Fatalf("AUTH admin = %q", got)
+	}
+	if got := send(c, "CONFIG GET tcp-keepalive"); got != "1) tcp-keepalive\n2) 300" {
+		t.Errorf("CONFIG as admin = %q", got)
+	}
+}
+
+func TestACLDefaultUser(t *testing.T) {
+	rs := newACLStore(t, "default >pw +@all -config")
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "GET k"); got != "-NOAUTH Authentication required." {
+		t.Errorf("GET before AUTH = %q", got)
+	}
+	if got := send(c, "AUTH pw"); got != "OK" {
+		t.Fatalf("AUTH = %q", got)
+	}
+	if got := send(c, "GET k"); got != "nil" {
+		t.Errorf("GET after AUTH = %q", got)
+	}
+	if got := send(c, "CONFIG SET tcp-keepalive 10"); got != "-NOPERM this user has no permissions to run the 'config' command" {
+		t.Errorf("denied CONFIG = %q", got)
+	}
+}
+
+func TestParseACLUserErrors(t *testing.T) {
+	if _, err := parseACLUser("bob ~keys:*"); err == nil {
+		t.Error("parseACLUser accepted an unsupported rule")
+	}
+	u, err := parseACLUser("bob +@all -flushall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.permits("GET") || u.permits("FLUSHALL") {
+		t.Errorf("bob permits GET=%v FLUSHALL=%v", u.permits("GET"), u.permits("FLUSHALL"))
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const aofFilename = "redisstore.aof"
+
+// path returns where the named persistence file lives, under the configured
+// working directory.
+func (r *RedisStore) path(name string) string {
+	return filepath.Join(r.config.Dir, name)
+}
+
+func (r *RedisStore) openAOF() (*os.File, error) {
+	return os.OpenFile(r.path(aofFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// keyspaceCommands returns the commands that rebuild the current keyspace,
+// one key at a time. A volatile key is followed by a PEXPIREAT carrying its
+// absolute expiry, so it expires at the same moment after a reload however
+// long that takes. The caller must hold the mutex.
+func (r *RedisStore) keyspaceCommands() []string {
+	keys := make([]string, 0, len(r.data))
+	for key := range r.data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var lines []string
+	now := r.clock.Now()
+	for _, key := range keys {
+		sv := r.data[key]
+		if sv.expired(now) {
+			continue
+		}
+		switch v := sv.value.(type) {
+		case string:
+			lines = append(lines, aofLine("SET", key, v))
+		case []byte:
+			lines = append(lines, aofLine("SET", key, string(v)))
+		case []string:
+			lines = append(lines, r.aofChunks("RPUSH", key, v, 1)...)
+		case map[string]struct{}:
+			members := slices.Sorted(maps.Keys(v))
+			lines = append(lines, r.aofChunks("SADD", key, members, 1)...)
+		case *hashValue:
+			fields := v.fields()
+			slices.SortFunc(fields, func(a, b hashField) int { return strings.Compare(a.field, b.field) })
+			var args []string
+			for _, p := range fields {
+				args = append(args, p.field, p.value)
+			}
+			lines = append(lines, r.aofChunks("HSET", key, args, 2)...)
+		case *sortedSet:
+			var args []string
+			for _, e := range v.entries {
+				args = append(args, formatScore(e.score), e.member)
+			}
+			lines = append(lines, r.aofChunks("ZADD", key, args, 2)...)
+		case *stream:
+			for _, e := range v.entries {
+				lines = append(lines, aofLine("XADD", append([]string{key, e.id.String()}, e.fields...)...))
+			}
+			// A last ID past the last entry, left by deletions, is kept so
+			// that the deleted IDs are not reused.
+			switch n := len(v.entries); {
+			case n == 0 && v.lastID != streamID{}:
+				lines = append(lines, aofLine("XADD", key, "MAXLEN", "0", v.lastID.String(), "x", "y"))
+			case n > 0 && v.entries[n-1].id != v.lastID:
+				lines = append(lines, aofLine("XSETID", key, v.lastID.String()))
+			}
+			for _, name := range slices.Sorted(maps.Keys(v.groups)) {
+				lines = append(lines, aofLine("XGROUP", "CREATE", key, name, v.groups[name].lastDelivered.String(), "MKSTREAM"))
+			}
+		}
+		if sv.expireAt != 0 {
+			lines = append(lines, aofLine("PEXPIREAT", key, strconv.FormatInt(sv.expireAt, 10)))
+		}
+	}
+	return lines
+}
+
+// aofChunks returns the commands adding the elements in args to key, at
+// most aof-rewrite-items-per-cmd per command. Each element takes width
+// arguments, such as a hash's field and value.
+func (r *RedisStore) aofChunks(command, key string, args []string, width int) []string {
+	var lines []string
+	for chunk := range slices.Chunk(args, r.config.AOFRewriteItemsPerCmd*width) {
+		lines = append(lines, aofLine(command, append([]string{key}, chunk...)...))
+	}
+	return lines
+}
+
+// RewriteAOF replaces the AOF with the shortest command sequence that
+// rebuilds the registered procedures and the current keyspace. The new file
+// is written without holding the lock; writes made meanwhile still go to the
+// old file and are also buffered, then appended to the new one just before
+// it is swapped in.
+func (r *RedisStore) RewriteAOF() error {
+	r.mutex.Lock()
+	lines, err := r.startRewrite()
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.finishRewrite(lines)
+}
+
+// startRewrite returns the commands of a new AOF and starts buffering the
+// records written until finishRewrite swaps it in. The caller must hold the
+// mutex.
+func (r *RedisStore) startRewrite() ([]string, error) {
+	if r.rewriteBuf != nil {
+		return nil, errRewriteInProgress
+	}
+	r.rewriteBuf = &strings.Builder{}
+	return append(r.procCommands(), r.keyspaceCommands()...), nil
+}
+
+// finishRewrite writes lines to a new AOF and swaps it in.
+func (r *RedisStore) finishRewrite(lines []string) error {
+	tmp, err := os.CreateTemp(r.config.Dir, "temp-rewriteaof-*.aof")
+	if err == nil {
+		w := bufio.NewWriter(tmp)
+		for _, line := range lines {
+			w.WriteString(line)
+		}
+		err = w.Flush()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	defer func() { r.rewriteBuf = nil }()
+	if err != nil {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+		return err
+	}
+	return r.swapAOF(tmp)
+}
+
+// swapAOF appends the buffered rewrite records to tmp and renames it over the
+// AOF, then reopens the AOF for appending. The caller must hold the mutex.
+func (r *RedisStore) swapAOF(tmp *os.File) error {
+	_, err := tmp.WriteString(r.rewriteBuf.String())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path(aofFilename))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Records still pending from a failed write are in rewriteBuf, so they
+	// are already in the new file.
+	r.aofBuf, r.aofErr = nil, nil
+	r.aofFile.Close()
+	aofFile, err := r.openAOF()
+	if err != nil {
+		return err
+	}
+	r.aofFile = aofFile
+	r.aofWriter = aofFile
+	r.aofWritten.Store(r.aofAppended)
+	r.aofSync.swapped(aofFile, r.aofAppended)
+	return r.resetAOFSize()
+}
+
+// resetAOFSize takes the size of the AOF as it is now as both its current
+// size and the base that auto-aof-rewrite-percentage is measured from. The
+// caller must hold the mutex.
+func (r *RedisStore) resetAOFSize() error {
+	info, err := r.aofFile.Stat()
+	if err != nil {
+		return err
+	}
+	r.aofSize, r.aofBaseSize = info.Size(), info.Size()
+	return nil
+}
+
+// BGRewriteAOF starts RewriteAOF in the background.
+func (r *RedisStore) BGRewriteAOF() error {
+	r.mutex.Lock()
+	lines, err := r.startRewrite()
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	go r.backgroundRewrite(lines)
+	return nil
+}
+
+func (r *RedisStore) backgroundRewrite(lines []string) {
+	if err := r.finishRewrite(lines); err != nil {
+		log.Println("background AOF rewrite failed: ", err)
+	}
+}
+
+// rewriteDue reports whether the AOF has grown past auto-aof-rewrite-min-size
+// and by auto-aof-rewrite-percentage of its size after the last rewrite, or
+// at startup, with no rewrite running. A percentage of 0 turns automatic
+// rewrites off. The caller must hold the mutex.
+func (r *RedisStore) rewriteDue() bool {
+	pct := r.config.AutoAOFRewritePercentage
+	if pct == 0 || r.rewriteBuf != nil || r.loading || r.aofSize < r.config.AutoAOFRewriteMinSize {
+		return false
+	}
+	base := max(r.aofBaseSize, 1)
+	return (r.aofSize-base)*100/base >= int64(pct)
+}
+
+// autoRewriteAOF starts a background rewrite once one is due.
+func (r *RedisStore) autoRewriteAOF() {
+	r.mutex.RLock()
+	due := r.rewriteDue()
+	r.mutex.RUnlock()
+	if !due {
+		return
+	}
+	r.mutex.Lock()
+	var lines []string
+	// Another command may have started one since.
+	if due = r.rewriteDue(); due {
+		lines, _ = r.startRewrite()
+	}
+	r.mutex.Unlock()
+	if due {
+		go r.backgroundRewrite(lines)
+	}
+}
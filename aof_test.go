@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func itoa64(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// aofText returns the records in rs's AOF, each as a line of its
+// space-separated command and arguments.
+func aofText(t *testing.T, rs *RedisStore) string {
+	t.Helper()
+	file, err := os.Open(rs.path(aofFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var b strings.Builder
+	reader := bufio.NewReader(file)
+	for {
+		cmd, err := readRequest(reader)
+		if err == io.EOF {
+			return b.String()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString(strings.Join(append([]string{cmd.Name}, cmd.Args...), " ") + "\n")
+	}
+}
+
+// reopen closes rs and returns a new store sharing its clock, loaded from
+// the files rs left behind.
+func reopen(t *testing.T, rs *RedisStore) *RedisStore {
+	t.Helper()
+	rs.Close()
+	reloaded, err := NewRedisStore(rs.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(reloaded.Close)
+	reloaded.clock = rs.clock
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	return reloaded
+}
+
+func TestAOFKeepsArbitraryBytes(t *testing.T) {
+	values := map[string]string{
+		"space": "hello world",
+		"crlf":  "x\r\nDEL space",
+		"lf":    "x\nDEL space",
+		"empty": "",
+	}
+	rs := newTestStore(t)
+	for key, val := range values {
+		processCommand(Command{Name: "SET", Args: []string{key, val}}, rs)
+	}
+	processCommand(Command{Name: "RPUSH", Args: []string{"list", "a b", "", "c\r\n"}}, rs)
+	check := func(rs *RedisStore, when string) {
+		t.Helper()
+		for key, val := range values {
+			if got, ok, _ := rs.Get(key); !ok || got != val {
+				t.Errorf("%s: GET %s = %q, %v, want %q", when, key, got, ok, val)
+			}
+		}
+		if got, _ := rs.LRange("list", 0, -1); !slices.Equal(got, []string{"a b", "", "c\r\n"}) {
+			t.Errorf("%s: LRANGE list = %q", when, got)
+		}
+	}
+
+	rs = reopen(t, rs)
+	check(rs, "after replay")
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	rs = reopen(t, rs)
+	check(rs, "after rewrite")
+}
+
+func TestAOFLoadsInlineRecords(t *testing.T) {
+	rs := newTestStore(t)
+	rs.Close()
+	if err := os.WriteFile(rs.path(aofFilename), []byte("SET k v\nRPUSH l a b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rs = reopen(t, rs)
+	if got := run(rs, "GET k"); got != "v" {
+		t.Errorf("GET k = %q", got)
+	}
+	if got := run(rs, "LLEN l"); got != "2" {
+		t.Errorf("LLEN l = %q", got)
+	}
+}
+
+func TestAutoRewriteAOFShrinksFile(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "CONFIG SET auto-aof-rewrite-min-size 4kb")
+	run(rs, "CONFIG SET auto-aof-rewrite-percentage 50")
+	persistence := func(name string) string {
+		return infoField(t, rs.Info("persistence"), name)
+	}
+
+	// Overwriting one key grows the AOF while the keyspace stays the same
+	// size, so the rewrite leaves a single SET. Writing stops once it has
+	// started, so that nothing grows the new file afterwards.
+	var written, last int
+	for persistence("aof_rewrite_in_progress") == "0" && persistence("aof_base_size") == "0" {
+		if written > 8*1024 {
+			t.Fatalf("no rewrite was triggered after %d bytes of writes", written)
+		}
+		written += len(aofLine("SET", "k", strconv.Itoa(last)))
+		run(rs, "SET k "+strconv.Itoa(last))
+		last++
+	}
+	waitUntil(t, func() bool { return persistence("aof_rewrite_in_progress") == "0" })
+
+	stat, err := os.Stat(rs.path(aofFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() >= 4*1024 {
+		t.Errorf("AOF is %d bytes after %d written, want it rewritten below the minimum size", stat.Size(), written)
+	}
+	if got := persistence("aof_current_size"); got != itoa64(stat.Size()) {
+		t.Errorf("aof_current_size = %s, file is %d bytes", got, stat.Size())
+	}
+
+	rs = reopen(t, rs)
+	want := strconv.Itoa(last - 1)
+	if got := run(rs, "GET k"); got != want {
+		t.Errorf("GET k after reload = %q, want %s", got, want)
+	}
+}
+
+func TestRewriteAOFKeepsAbsoluteTTL(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	run(rs, "SET k v")
+	run(rs, "SET k v2")
+	run(rs, "PEXPIRE k 10000")
+	run(rs, "RPUSH l a b c")
+	run(rs, "LMOVE l l LEFT RIGHT")
+	run(rs, "ZADD z 1 m 2 n")
+	clk.Advance(2 * time.Second)
+
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	run(rs, "SET after rewrite")
+
+	aof := aofText(t, rs)
+	want := "PEXPIREAT k " + itoa64(clk.Now().Add(8*time.Second).UnixMilli()) + "\n"
+	if !strings.Contains(string(aof), want) {
+		t.Errorf("rewritten AOF lacks %q:\n%s", want, aof)
+	}
+	if strings.Contains(string(aof), "LMOVE") || strings.Count(string(aof), "SET k") != 1 {
+		t.Errorf("rewritten AOF was not compacted:\n%s", aof)
+	}
+
+	clk.Advance(3 * time.Second)
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "PTTL k"); got != "5000" {
+		t.Errorf("PTTL after reload = %q, want 5000", got)
+	}
+	if got := run(reloaded, "GET k"); got != "v2" {
+		t.Errorf("GET k = %q", got)
+	}
+	if got := run(reloaded, "LRANGE l 0 -1"); got != "1) b\n2) c\n3) a" {
+		t.Errorf("LRANGE l = %q", got)
+	}
+	if got := run(reloaded, "ZRANGE z 0 -1 WITHSCORES"); got != "1) m\n2) 1\n3) n\n4) 2" {
+		t.Errorf("ZRANGE z = %q", got)
+	}
+	if got := run(reloaded, "GET after"); got != "rewrite" {
+		t.Errorf("write after the rewrite was lost: GET after = %q", got)
+	}
+
+	clk.Advance(5 * time.Second)
+	if got := run(reloaded, "GET k"); got != "nil" {
+		t.Errorf("GET k past its original deadline = %q, want nil", got)
+	}
+}
+
+func TestRewriteAOFChunksLargeCollections(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "CONFIG SET aof-rewrite-items-per-cmd 64")
+	elems := make([]string, 150)
+	for i := range elems {
+		elems[i] = "e" + strconv.Itoa(i)
+	}
+	run(rs, "RPUSH l "+strings.Join(elems, " "))
+	aof := aofText(t, rs)
+	if n := strings.Count(string(aof), "RPUSH l "); n != 1 {
+		t.Errorf("variadic RPUSH logged as %d records, want 1", n)
+	}
+	want := run(rs, "LRANGE l 0 -1")
+
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	aof = aofText(t, rs)
+	var sizes []int
+	for line := range strings.Lines(string(aof)) {
+		if args, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "RPUSH l "); ok {
+			sizes = append(sizes, len(strings.Fields(args)))
+		}
+	}
+	if !slices.Equal(sizes, []int{64, 64, 22}) {
+		t.Errorf("rewritten RPUSH records have %v elements, want [64 64 22]", sizes)
+	}
+	if got := run(reopen(t, rs), "LRANGE l 0 -1"); got != want {
+		t.Errorf("list after reloading the rewrite differs:\n%s", got)
+	}
+}
+
+func TestRewriteAOFIncludesConcurrentWrites(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+
+	// Simulate a write landing while the new file is being built.
+	rs.mutex.Lock()
+	tmp, err := os.CreateTemp(rs.config.Dir, "temp-rewriteaof-*.aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range rs.keyspaceCommands() {
+		tmp.WriteString(line)
+	}
+	rs.rewriteBuf = &strings.Builder{}
+	rs.mutex.Unlock()
+
+	run(rs, "SET b 2")
+
+	rs.mutex.Lock()
+	err = rs.swapAOF(tmp)
+	rs.rewriteBuf = nil
+	rs.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "GET b"); got != "2" {
+		t.Errorf("GET b = %q, want the write made during the rewrite", got)
+	}
+}
+
+// failingWriter fails every write while full is set, like a full disk.
+type failingWriter struct {
+	w    strings.Builder
+	full bool
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.full {
+		return 0, errors.New("no space left on device")
+	}
+	return f.w.Write(p)
+}
+
+func TestAOFWriteErrorFailsWrite(t *testing.T) {
+	rs := newTestStore(t)
+	w := &failingWriter{full: true}
+	rs.aofWriter = w
+
+	if got := run(rs, "SET foo bar"); !strings.HasPrefix(got, "-MISCONF ") {
+		t.Fatalf("SET with a full disk = %q, want a MISCONF error", got)
+	}
+	if got := run(rs, "RPUSH list a"); !strings.HasPrefix(got, "-MISCONF ") {
+		t.Fatalf("RPUSH with a full disk = %q, want a MISCONF error", got)
+	}
+	if !strings.Contains(rs.Info("persistence"), "aof_last_write_status:err") {
+		t.Errorf("INFO does not report the write error:\n%s", rs.Info("persistence"))
+	}
+
+	w.full = false
+	if got := run(rs, "SET foo baz"); got != "OK" {
+		t.Fatalf("SET after the disk recovered = %q, want OK", got)
+	}
+	want := aofLine("SET", "foo", "bar") + aofLine("RPUSH", "list", "a") + aofLine("SET", "foo", "baz")
+	if w.w.String() != want {
+		t.Errorf("AOF = %q, want the pending records then the new one %q", w.w.String(), want)
+	}
+	if !strings.Contains(rs.Info("persistence"), "aof_last_write_status:ok") {
+		t.Errorf("INFO still reports a write error:\n%s", rs.Info("persistence"))
+	}
+}
+
+func TestAOFWriteErrorLenientPolicy(t *testing.T) {
+	rs := newTestStore(t)
+	rs.aofWriter = &failingWriter{full: true}
+	if got := run(rs, "CONFIG SET aof-stop-writes-on-error no"); got != "OK" {
+		t.Fatalf("CONFIG SET = %q", got)
+	}
+	if got := run(rs, "SET foo bar"); got != "OK" {
+		t.Fatalf("SET = %q, want OK under the lenient policy", got)
+	}
+	if !strings.Contains(run(rs, "INFO persistence"), "aof_last_write_status:err") {
+		t.Errorf("INFO does not report the write error:\n%s", run(rs, "INFO persistence"))
+	}
+}
+
+// syncRecorder is an AOF that only counts records as durable once Sync has
+// been called after they were written.
+type syncRecorder struct {
+	mu      sync.Mutex
+	written strings.Builder
+	durable string
+	syncs   int
+	err     error
+}
+
+func (s *syncRecorder) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written.Write(p)
+}
+
+func (s *syncRecorder) Sync() error {
+	// A real fsync takes a while, which is what lets writers batch up.
+	time.Sleep(time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.durable = s.written.String()
+	s.syncs++
+	return nil
+}
+
+func (s *syncRecorder) isDurable(record string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Contains(s.durable, record)
+}
+
+func useSyncRecorder(rs *RedisStore) *syncRecorder {
+	rec := &syncRecorder{}
+	rs.mutex.Lock()
+	rs.aofWriter = rec
+	rs.mutex.Unlock()
+	rs.aofSync.swapped(rec, 0)
+	return rec
+}
+
+func TestAppendFsyncAlwaysAcknowledgesDurableWrites(t *testing.T) {
+	rs := newTestStore(t)
+	rec := useSyncRecorder(rs)
+	if got := run(rs, "CONFIG SET appendfsync always"); got != "OK" {
+		t.Fatalf("CONFIG SET = %q", got)
+	}
+
+	const writers, writes = 20, 25
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				key := "k" + strconv.Itoa(w) + "-" + strconv.Itoa(i)
+				if got := run(rs, "SET "+key+" v"); got != "OK" {
+					t.Errorf("SET %s = %q", key, got)
+					return
+				}
+				if !rec.isDurable(aofLine("SET", key, "v")) {
+					t.Errorf("SET %s was acknowledged before it was fsynced", key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rec.syncs >= writers*writes {
+		t.Errorf("%d fsyncs for %d writes, want concurrent writes to share them", rec.syncs, writers*writes)
+	}
+}
+
+func TestAppendFsyncAlwaysSyncError(t *testing.T) {
+	rs := newTestStore(t)
+	rec := useSyncRecorder(rs)
+	rec.err = errors.New("input/output error")
+	run(rs, "CONFIG SET appendfsync always")
+
+	if got := run(rs, "SET foo bar"); !strings.HasPrefix(got, "-MISCONF ") {
+		t.Fatalf("SET with a failing fsync = %q, want a MISCONF error", got)
+	}
+	if !strings.Contains(rs.Info("persistence"), "aof_last_write_status:err") {
+		t.Errorf("INFO does not report the fsync error:\n%s", rs.Info("persistence"))
+	}
+
+	rec.mu.Lock()
+	rec.err = nil
+	rec.mu.Unlock()
+	if got := run(rs, "SET foo baz"); got != "OK" {
+		t.Fatalf("SET after fsync recovered = %q, want OK", got)
+	}
+	if !rec.isDurable(aofLine("SET", "foo", "bar") + aofLine("SET", "foo", "baz")) {
+		t.Error("records written while fsync failed were not synced later")
+	}
+}
+
+func newBenchStore(b *testing.B) *RedisStore {
+	cfg := DefaultConfig()
+	cfg.Dir = b.TempDir()
+	cfg.AppendFsync = "always"
+	rs, err := NewRedisStore(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(rs.Close)
+	return rs
+}
+
+// BenchmarkAOFPerWriteFsync fsyncs each write while holding the store lock,
+// as a store without group commit would.
+func BenchmarkAOFPerWriteFsync(b *testing.B) {
+	rs := newBenchStore(b)
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rs.mutex.Lock()
+			rs.writeAOF("SET", "key", "value")
+			if err := rs.aofFile.Sync(); err != nil {
+				b.Error(err)
+			}
+			rs.mutex.Unlock()
+		}
+	})
+}
+
+func BenchmarkAOFGroupCommit(b *testing.B) {
+	rs := newBenchStore(b)
+	cmd := parseCommand("SET key value")
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if got := processCommand(cmd, rs); got != "OK" {
+				b.Error(got)
+			}
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// syncFile is the part of the AOF file the fsync loop needs.
+type syncFile interface {
+	Sync() error
+}
+
+// aofSync batches AOF fsyncs. Records are written to the file as commands
+// run, under the store's mutex; under appendfsync always each writer then
+// waits, with the mutex released, until a single background loop has
+// fsynced past its record. One fsync covers every record written before
+// it started, so concurrent writers share it instead of each paying for
+// their own. Under everysec the loop is asked to sync once a second.
+type aofSync struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	file syncFile
+	// requested is the highest record count a writer is waiting on and
+	// synced is the record count the last fsync covered.
+	requested uint64
+	synced    uint64
+	err       error
+	closed    bool
+	done      chan struct{}
+}
+
+func newAOFSync(file syncFile) *aofSync {
+	s := &aofSync{file: file, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// run is the fsync loop. written reports how many records have been
+// written to the file so far.
+func (s *aofSync) run(written func() uint64) {
+	defer close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for s.requested <= s.synced && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			return
+		}
+		// Let writers that are already runnable add their records before
+		// the batch is cut. Everything written by then goes out in this
+		// fsync, including records of writers that have not asked yet.
+		s.mu.Unlock()
+		runtime.Gosched()
+		s.mu.Lock()
+		target := max(written(), s.requested)
+		file := s.file
+		s.mu.Unlock()
+		err := file.Sync()
+		s.mu.Lock()
+		if err != nil && s.file != file {
+			// The AOF was swapped by a rewrite, which syncs the new file
+			// with everything written so far.
+			err = nil
+		}
+		if err != nil {
+			if s.err == nil {
+				log.Println("error syncing the AOF file: ", err)
+			}
+			s.err = err
+			// Fail the current waiters rather than retrying in a tight
+			// loop; the next writer asks again.
+			s.synced, s.requested = target, target
+		} else {
+			if s.err != nil {
+				log.Println("AOF fsync error cleared")
+			}
+			s.synced, s.err = max(s.synced, target), nil
+		}
+		s.cond.Broadcast()
+	}
+}
+
+// wait blocks until the first n records are durable, returning the error of
+// the fsync that covered them.
+func (s *aofSync) wait(n uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > s.requested {
+		s.requested = n
+		s.cond.Broadcast()
+	}
+	for s.synced < n && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed && s.synced < n {
+		return errAOFClosed
+	}
+	return s.err
+}
+
+// request asks for an fsync of the first n records without waiting for it.
+func (s *aofSync) request(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > s.requested {
+		s.requested = n
+		s.cond.Broadcast()
+	}
+}
+
+// swapped records that the AOF is now file, already synced through the
+// first n records.
+func (s *aofSync) swapped(file syncFile, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = file
+	s.synced = max(s.synced, n)
+	s.cond.Broadcast()
+}
+
+func (s *aofSync) status() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *aofSync) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	<-s.done
+}
+
+var errAOFClosed = errors.New("AOF closed")
+
+// startAOFSync starts the fsync loop, and under everysec a ticker asking it
+// to sync once a second.
+func (r *RedisStore) startAOFSync(file *os.File) {
+	r.aofSync = newAOFSync(file)
+	go r.aofSync.run(r.aofWritten.Load)
+	stop := make(chan struct{})
+	r.stopAOFTicker = sync.OnceFunc(func() { close(stop) })
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.mutex.RLock()
+				everysec := r.config.AppendFsync == "everysec"
+				r.mutex.RUnlock()
+				if everysec {
+					r.aofSync.request(r.aofWritten.Load())
+				}
+			}
+		}
+	}()
+}
+
+// waitAOFSync makes a write command that appended to the AOF wait, under
+// appendfsync always, until its records are on disk. written is the AOF
+// record count when the command finished, which covers its own records.
+func (r *RedisStore) waitAOFSync(written uint64) error {
+	r.mutex.RLock()
+	always, strict := r.config.AppendFsync == "always", r.config.AOFStopWritesOnError
+	r.mutex.RUnlock()
+	if !always {
+		return nil
+	}
+	if err := r.aofSync.wait(written); err != nil && strict {
+		return errors.New("MISCONF Errors writing to the AOF file: " + err.Error())
+	}
+	return nil
+}
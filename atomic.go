@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// parseAtomicBatch splits the arguments of ATOMIC into the commands it runs,
+// separated by ";" arguments of their own, so that any other argument may
+// hold spaces or semicolons. The whole batch is rejected if any command is
+// unknown, has the wrong number of arguments or could block.
+func parseAtomicBatch(args []string) ([]Command, error) {
+	var batch []Command
+	for part := range splitBatch(args) {
+		cmd := Command{Name: strings.ToUpper(part[0]), Args: slices.Clip(part[1:])}
+		if _, ok := commandTable[cmd.Name]; !ok {
+			return nil, fmt.Errorf("ERR ATOMIC aborted, unknown command '%s'", cmd.Name)
+		}
+		if !checkArity(cmd) {
+			return nil, fmt.Errorf("ERR ATOMIC aborted, wrong number of arguments for '%s' command", strings.ToLower(cmd.Name))
+		}
+		if cmd.Name == "ATOMIC" || blockingCommands[cmd.Name] {
+			return nil, fmt.Errorf("ERR ATOMIC aborted, '%s' is not allowed in a batch", strings.ToLower(cmd.Name))
+		}
+		batch = append(batch, cmd)
+	}
+	if len(batch) == 0 {
+		return nil, errSyntax
+	}
+	return batch, nil
+}
+
+// splitBatch yields the non-empty runs of args between ";" arguments.
+func splitBatch(args []string) iter.Seq[[]string] {
+	return func(yield func([]string) bool) {
+		start := 0
+		for i := 0; i <= len(args); i++ {
+			if i < len(args) && args[i] != ";" {
+				continue
+			}
+			if i > start && !yield(args[start:i]) {
+				return
+			}
+			start = i + 1
+		}
+	}
+}
+
+// batchCommands returns the commands an ATOMIC or PROC command runs, or
+// nil for any other command or one that would fail before running any.
+func batchCommands(cmd Command, rs *RedisStore) []Command {
+	switch {
+	case cmd.Name == "ATOMIC":
+		batch, _ := parseAtomicBatch(cmd.Args)
+		return batch
+	case cmd.Name != "PROC" || len(cmd.Args) < 2:
+		return nil
+	case strings.EqualFold(cmd.Args[0], "REGISTER"):
+		batch, _ := parseAtomicBatch(cmd.Args[2:])
+		return batch
+	case strings.EqualFold(cmd.Args[0], "CALL"):
+		if p := rs.proc(cmd.Args[1]); p != nil {
+			return p.batch
+		}
+	}
+	return nil
+}
+
+// atomicCommand runs a batch of commands with no other command running in
+// between, returning their replies. processCommand holds execMu for writing
+// while it runs.
+func atomicCommand(args []string, rs *RedisStore) string {
+	batch, err := parseAtomicBatch(args)
+	if err != nil {
+		return formatError(err)
+	}
+	replies := make([]string, len(batch))
+	for i, cmd := range batch {
+		replies[i] = executeCommand(cmd, rs)
+	}
+	return formatArray(replies)
+}
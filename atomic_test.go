@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestAtomicAllOrNothing(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "ATOMIC SET counter 10 ; INCR counter"); got != "1) OK\n2) 11" {
+		t.Fatalf("ATOMIC = %q", got)
+	}
+	if got := run(rs, "GET counter"); got != "11" {
+		t.Errorf("GET after ATOMIC = %q, want 11", got)
+	}
+
+	if got := run(rs, "ATOMIC SET other 1 ; INCR"); got != "-ERR ATOMIC aborted, wrong number of arguments for 'incr' command" {
+		t.Fatalf("ATOMIC with an arity error = %q", got)
+	}
+	if got := run(rs, "GET other"); got != "nil" {
+		t.Errorf("GET after an aborted ATOMIC = %q, want nil as nothing ran", got)
+	}
+	if got := run(rs, "ATOMIC SET other 1 ; NOPE"); got != "-ERR ATOMIC aborted, unknown command 'NOPE'" {
+		t.Errorf("ATOMIC with an unknown command = %q", got)
+	}
+	if got := run(rs, "ATOMIC BLMOVE a b LEFT RIGHT 0"); got != "-ERR ATOMIC aborted, 'blmove' is not allowed in a batch" {
+		t.Errorf("ATOMIC with a blocking command = %q", got)
+	}
+}
+
+func TestAtomicKeepsArgumentsWhole(t *testing.T) {
+	rs := newTestStore(t)
+	cmd := Command{Name: "ATOMIC", Args: []string{"SET", "k", "a b; c", ";", "GET", "k"}}
+	if got := processCommand(cmd, rs); got != "1) OK\n2) a b; c" {
+		t.Errorf("ATOMIC with a value holding a space and a semicolon = %q", got)
+	}
+	if got := run(rs, "ATOMIC ; ;"); got != formatError(errSyntax) {
+		t.Errorf("ATOMIC of separators only = %q", got)
+	}
+}
+
+func TestAtomicIsolation(t *testing.T) {
+	rs := newTestStore(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			run(rs, "INCR n")
+		}
+	}()
+	for range 100 {
+		got := run(rs, "ATOMIC SET n 0 ; INCR n ; INCR n")
+		if got != "1) OK\n2) 1\n3) 2" {
+			t.Fatalf("ATOMIC interleaved with another client: %q", got)
+		}
+	}
+	<-done
+}
+
+func TestAtomicChecksPermissions(t *testing.T) {
+	rs := newACLStore(t, "default nopass +atomic +get")
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "ATOMIC GET k ; SET k v"); got != "-NOPERM this user has no permissions to run the 'set' command" {
+		t.Errorf("ATOMIC with a forbidden command = %q", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchConfig is a load test run by -benchmark, in the manner of
+// redis-benchmark: clients connections share requests commands between
+// them, each sending pipeline commands at a time. A setRatio share of the
+// commands are SETs of dataSize-byte values and the rest GETs, over keys
+// picked at random from keyspace of them.
+type benchConfig struct {
+	addr     string
+	clients  int
+	requests int
+	pipeline int
+	setRatio float64
+	keyspace int
+	dataSize int
+}
+
+// benchResult is what a load test measured. The latency of a command is the
+// time from sending the pipeline it was in to reading its reply.
+type benchResult struct {
+	requests int
+	elapsed  time.Duration
+	latency  latencyHistogram
+}
+
+func (b *benchResult) throughput() float64 {
+	if b.elapsed <= 0 {
+		return 0
+	}
+	return float64(b.requests) / b.elapsed.Seconds()
+}
+
+// report renders the result the way redis-benchmark summarizes a test.
+func (b *benchResult) report(cfg benchConfig) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "====== SET/GET (%.0f%% SET) ======\n", cfg.setRatio*100)
+	fmt.Fprintf(&sb, "  %d requests completed in %.2f seconds\n", b.requests, b.elapsed.Seconds())
+	fmt.Fprintf(&sb, "  %d parallel clients\n", cfg.clients)
+	fmt.Fprintf(&sb, "  %d bytes payload\n", cfg.dataSize)
+	fmt.Fprintf(&sb, "  pipeline %d\n\n", cfg.pipeline)
+	fmt.Fprintf(&sb, "  throughput summary: %.2f requests per second\n", b.throughput())
+	fmt.Fprintf(&sb, "  latency summary (msec):")
+	for _, p := range latencyPercentiles {
+		fmt.Fprintf(&sb, " p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), float64(b.latency.percentile(p))/float64(time.Millisecond))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// runBenchmark runs the load test cfg describes against the server at
+// cfg.addr. It fails if a connection fails or the server replies with an
+// error.
+func runBenchmark(cfg benchConfig) (*benchResult, error) {
+	if cfg.clients < 1 || cfg.requests < 1 || cfg.pipeline < 1 || cfg.keyspace < 1 {
+		return nil, errors.New("benchmark clients, requests, pipeline and keyspace must be positive")
+	}
+	value := strings.Repeat("x", max(cfg.dataSize, 1))
+	results := make([]benchResult, cfg.clients)
+	errs := make([]error, cfg.clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range cfg.clients {
+		// The requests are shared out as evenly as they divide.
+		n := cfg.requests / cfg.clients
+		if i < cfg.requests%cfg.clients {
+			n++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = benchClient(cfg, value, n, &results[i])
+		}()
+	}
+	wg.Wait()
+	total := &benchResult{elapsed: time.Since(start)}
+	for i := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		total.requests += results[i].requests
+		total.latency.merge(&results[i].latency)
+	}
+	return total, nil
+}
+
+// benchClient sends n commands over a connection of its own, recording them
+// in result.
+func benchClient(cfg benchConfig, value string, n int, result *benchResult) error {
+	conn, err := net.Dial("tcp", cfg.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for n > 0 {
+		batch := min(n, cfg.pipeline)
+		for range batch {
+			key := "key:" + strconv.Itoa(rand.IntN(cfg.keyspace))
+			if rand.Float64() < cfg.setRatio {
+				fmt.Fprintf(w, "SET %s %s\n", key, value)
+			} else {
+				fmt.Fprintf(w, "GET %s\n", key)
+			}
+		}
+		sent := time.Now()
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		// SET and GET reply on a single line each.
+		for range batch {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "-") {
+				return fmt.Errorf("benchmark: server replied %s", strings.TrimSpace(line))
+			}
+			result.latency.record(time.Since(sent))
+		}
+		result.requests += batch
+		n -= batch
+	}
+	return nil
+}
+
+// benchmarkMain runs -benchmark, printing the report to w.
+func benchmarkMain(cfg benchConfig, w io.Writer) error {
+	result, err := runBenchmark(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, result.report(cfg))
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBenchmarkAgainstServer(t *testing.T) {
+	rs := newTestStore(t)
+	srv, addr := startServer(t, rs)
+	defer srv.Shutdown()
+
+	cfg := benchConfig{addr: addr, clients: 3, requests: 200, pipeline: 4, setRatio: 0.5, keyspace: 10, dataSize: 8}
+	result, err := runBenchmark(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.requests != 200 || result.latency.total != 200 {
+		t.Errorf("benchmark completed %d requests with %d latencies, want 200", result.requests, result.latency.total)
+	}
+	if result.throughput() <= 0 {
+		t.Errorf("throughput = %v, want more than zero", result.throughput())
+	}
+	if report := result.report(cfg); !strings.Contains(report, "200 requests completed") || !strings.Contains(report, "requests per second") {
+		t.Errorf("report = %q", report)
+	}
+
+	if _, err := runBenchmark(benchConfig{addr: addr, clients: 1, requests: 1, pipeline: 0, keyspace: 1}); err == nil {
+		t.Error("benchmark with a zero pipeline succeeded")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// bitfieldType is an integer type of BITFIELD: signed widths i1 to i64 and
+// unsigned widths u1 to u63, so that every value fits in an int64.
+type bitfieldType struct {
+	signed bool
+	bits   uint
+}
+
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldOpcode int
+
+const (
+	bitfieldGet bitfieldOpcode = iota
+	bitfieldSet
+	bitfieldIncrBy
+)
+
+// bitfieldOp is one GET, SET or INCRBY subcommand, with the OVERFLOW mode in
+// effect where it appears.
+type bitfieldOp struct {
+	op       bitfieldOpcode
+	typ      bitfieldType
+	offset   int64
+	value    int64
+	overflow bitfieldOverflow
+}
+
+var (
+	errBitfieldType     = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	errBitfieldOverflow = errors.New("ERR Invalid OVERFLOW type specified")
+)
+
+func parseBitfieldType(s string) (bitfieldType, error) {
+	var t bitfieldType
+	switch {
+	case strings.HasPrefix(s, "i"), strings.HasPrefix(s, "I"):
+		t.signed = true
+	case strings.HasPrefix(s, "u"), strings.HasPrefix(s, "U"):
+	default:
+		return t, errBitfieldType
+	}
+	bits, err := strconv.Atoi(s[1:])
+	if err != nil || bits < 1 || bits > 64 || (!t.signed && bits == 64) {
+		return t, errBitfieldType
+	}
+	t.bits = uint(bits)
+	return t, nil
+}
+
+// parseBitfieldOffset parses a bit offset, or with a # prefix an index
+// counted in fields of typ's width.
+func parseBitfieldOffset(s string, typ bitfieldType) (int64, error) {
+	multiplier := int64(1)
+	if rest, ok := strings.CutPrefix(s, "#"); ok {
+		s, multiplier = rest, int64(typ.bits)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (maxStringSize*8-int64(typ.bits))/multiplier {
+		return 0, errBitOffset
+	}
+	return n * multiplier, nil
+}
+
+// parseBitfieldOps parses the subcommands of BITFIELD.
+func parseBitfieldOps(args []string) ([]bitfieldOp, error) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		sub := strings.ToUpper(args[i])
+		if sub == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, errBitfieldOverflow
+			}
+			i += 2
+			continue
+		}
+		op := bitfieldOp{overflow: overflow}
+		n := 3
+		switch sub {
+		case "GET":
+			op.op = bitfieldGet
+		case "SET":
+			op.op, n = bitfieldSet, 4
+		case "INCRBY":
+			op.op, n = bitfieldIncrBy, 4
+		default:
+			return nil, errSyntax
+		}
+		if i+n > len(args) {
+			return nil, errSyntax
+		}
+		var err error
+		if op.typ, err = parseBitfieldType(args[i+1]); err != nil {
+			return nil, err
+		}
+		if op.offset, err = parseBitfieldOffset(args[i+2], op.typ); err != nil {
+			return nil, err
+		}
+		if n == 4 {
+			if op.value, err = strconv.ParseInt(args[i+3], 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+		}
+		ops = append(ops, op)
+		i += n
+	}
+	return ops, nil
+}
+
+// getBits reads bits bits of b from bit offset, most significant first, as
+// GETBIT numbers them. Bits past the end of b read as 0.
+func getBits(b []byte, offset int64, bits uint) uint64 {
+	var v uint64
+	for i := range int64(bits) {
+		v <<= 1
+		if idx := (offset + i) / 8; idx < int64(len(b)) {
+			v |= uint64(b[idx]>>(7-(offset+i)%8)) & 1
+		}
+	}
+	return v
+}
+
+// setBits writes the low bits bits of v to b at bit offset, which b must be
+// long enough to hold.
+func setBits(b []byte, offset int64, bits uint, v uint64) {
+	for i := range int64(bits) {
+		bit := byte(v>>(int64(bits)-1-i)) & 1
+		idx, shift := (offset+i)/8, 7-(offset+i)%8
+		b[idx] = b[idx]&^(1<<shift) | bit<<shift
+	}
+}
+
+// get reads the field of type t at offset.
+func (t bitfieldType) get(b []byte, offset int64) int64 {
+	v := getBits(b, offset, t.bits)
+	if t.signed && t.bits < 64 && v&(1<<(t.bits-1)) != 0 {
+		v |= ^uint64(0) << t.bits
+	}
+	return int64(v)
+}
+
+// fit brings n into the range of t according to overflow, reporting false
+// if it is out of range under FAIL.
+func (t bitfieldType) fit(n *big.Int, overflow bitfieldOverflow) (int64, bool) {
+	lo, hi := big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), t.bits)
+	if t.signed {
+		lo.Lsh(big.NewInt(1), t.bits-1).Neg(lo)
+		hi.Lsh(big.NewInt(1), t.bits-1)
+	}
+	hi.Sub(hi, big.NewInt(1))
+	if n.Cmp(lo) >= 0 && n.Cmp(hi) <= 0 {
+		return n.Int64(), true
+	}
+	switch overflow {
+	case overflowSat:
+		if n.Cmp(lo) < 0 {
+			return lo.Int64(), true
+		}
+		return hi.Int64(), true
+	case overflowWrap:
+		// Keep the low bits, then read them back as t does.
+		mod := new(big.Int).Lsh(big.NewInt(1), t.bits)
+		w := new(big.Int).Mod(n, mod)
+		if t.signed && w.Cmp(hi) > 0 {
+			w.Sub(w, mod)
+		}
+		return w.Int64(), true
+	}
+	return 0, false
+}
+
+// BitField runs the BITFIELD subcommands ops on the string at key, returning
+// the result of each: the value read by GET, the previous value for SET and
+// the new value for INCRBY. A SET or INCRBY that overflows under OVERFLOW
+// FAIL has a nil result and writes nothing. args are the command's
+// arguments, recorded in the AOF if anything was written.
+func (r *RedisStore) BitField(key string, ops []bitfieldOp, args []string) ([]*int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, err := r.stringBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*int64, len(ops))
+	written := false
+	for i, op := range ops {
+		old := op.typ.get(b, op.offset)
+		if op.op == bitfieldGet {
+			results[i] = &old
+			continue
+		}
+		var n *big.Int
+		if op.op == bitfieldSet {
+			n = big.NewInt(op.value)
+			if !op.typ.signed {
+				// Like Redis, a negative value for an unsigned field is
+				// taken as its 64-bit two's complement.
+				n.SetUint64(uint64(op.value))
+			}
+		} else {
+			n = new(big.Int).Add(big.NewInt(old), big.NewInt(op.value))
+		}
+		v, ok := op.typ.fit(n, op.overflow)
+		if !ok {
+			continue
+		}
+		if end := int((op.offset + int64(op.typ.bits) + 7) / 8); end > len(b) {
+			b = append(b, make([]byte, end-len(b))...)
+		}
+		setBits(b, op.offset, op.typ.bits, uint64(v))
+		written = true
+		if op.op == bitfieldSet {
+			results[i] = &old
+		} else {
+			results[i] = &v
+		}
+	}
+	if written {
+		r.putBytes(key, b)
+		r.touch(key)
+		if err := r.writeAOF("BITFIELD", append([]string{key}, args...)...); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func bitfieldCommand(args []string, rs Store) string {
+	ops, err := parseBitfieldOps(args[1:])
+	if err != nil {
+		return formatError(err)
+	}
+	results, err := rs.BitField(args[0], ops, args[1:])
+	if err != nil {
+		return formatError(err)
+	}
+	replies := make([]string, len(results))
+	for i, v := range results {
+		if v == nil {
+			replies[i] = "nil"
+		} else {
+			replies[i] = strconv.FormatInt(*v, 10)
+		}
+	}
+	return formatArray(replies)
+}
@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestBitField(t *testing.T) {
+	rs := newTestStore(t)
+	tests := []struct{ cmd, want string }{
+		{"BITFIELD missing GET u8 0", "1) 0"},
+		{"GET missing", "nil"},
+		{"BITFIELD k SET u8 0 255 GET u8 0 GET i8 0", "1) 0\n2) 255\n3) -1"},
+		{"BITFIELD k SET i4 8 -3 GET i4 8 GET u4 8", "1) 0\n2) -3\n3) 13"},
+		{"BITFIELD k SET u8 #2 200 GET u8 16 GET u16 8", "1) 0\n2) 200\n3) 53448"},
+		{"STRLEN k", "3"},
+		{"BITFIELD w SET i64 0 -1 GET u63 0 GET i64 0 GET u1 63", "1) 0\n2) 9223372036854775807\n3) -1\n4) 1"},
+		{"BITFIELD x INCRBY i5 100 1 GET u4 0", "1) 1\n2) 0"},
+		// Unaligned fields spanning bytes.
+		{"BITFIELD y SET u12 3 4095 GET u12 3 GET u16 0", "1) 0\n2) 4095\n3) 8190"},
+		{"GETBIT y 2", "0"},
+		{"GETBIT y 3", "1"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestBitFieldOverflow(t *testing.T) {
+	rs := newTestStore(t)
+	// The sequence from the BITFIELD documentation: u2 at 100 wraps, the one
+	// at 102 saturates.
+	for _, want := range []string{"1) 1\n2) 1", "1) 2\n2) 2", "1) 3\n2) 3", "1) 0\n2) 3"} {
+		if got := run(rs, "BITFIELD k INCRBY u2 100 1 OVERFLOW SAT INCRBY u2 102 1"); got != want {
+			t.Errorf("INCRBY WRAP then SAT = %q, want %q", got, want)
+		}
+	}
+	tests := []struct{ cmd, want string }{
+		{"BITFIELD s SET i8 0 127 OVERFLOW SAT INCRBY i8 0 10", "1) 0\n2) 127"},
+		{"BITFIELD s OVERFLOW SAT INCRBY i8 0 -300", "1) -128"},
+		{"BITFIELD s INCRBY i8 0 -1", "1) 127"},
+		{"BITFIELD s OVERFLOW SAT SET u8 8 -1 GET u8 8", "1) 0\n2) 255"},
+		{"BITFIELD s SET u8 16 -1 GET u8 16", "1) 0\n2) 255"},
+		{"BITFIELD s OVERFLOW SAT SET i8 24 1000 GET i8 24", "1) 0\n2) 127"},
+		{"BITFIELD f SET u2 0 3 OVERFLOW FAIL INCRBY u2 0 1 GET u2 0", "1) 0\n2) nil\n3) 3"},
+		{"BITFIELD f OVERFLOW FAIL SET i4 4 8 INCRBY i4 4 -8 SET i4 4 7", "1) nil\n2) -8\n3) -8"},
+		{"BITFIELD f GET i4 4", "1) 7"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	// Nothing is written, or created, when every write fails.
+	if got := run(rs, "BITFIELD none OVERFLOW FAIL SET u2 0 4"); got != "1) nil" {
+		t.Errorf("failing SET = %q", got)
+	}
+	if got := run(rs, "GET none"); got != "nil" {
+		t.Errorf("GET after a failed BITFIELD = %q, want nil", got)
+	}
+}
+
+func TestBitFieldErrors(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SADD set a")
+	tests := []struct{ cmd, want string }{
+		{"BITFIELD k GET u64 0", formatError(errBitfieldType)},
+		{"BITFIELD k GET i65 0", formatError(errBitfieldType)},
+		{"BITFIELD k GET x8 0", formatError(errBitfieldType)},
+		{"BITFIELD k GET u8 -1", formatError(errBitOffset)},
+		{"BITFIELD k GET u8 4294967296", formatError(errBitOffset)},
+		{"BITFIELD k SET u8 0 x", formatError(errNotInteger)},
+		{"BITFIELD k OVERFLOW NOPE", formatError(errBitfieldOverflow)},
+		{"BITFIELD k SET u8 0", formatError(errSyntax)},
+		{"BITFIELD k FROB u8 0", formatError(errSyntax)},
+		{"BITFIELD set GET u8 0", formatError(errWrongType)},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestBitFieldIsPersisted(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "BITFIELD k SET u8 0 65 OVERFLOW FAIL INCRBY u8 0 1000 INCRBY u8 0 1")
+	rs = reopen(t, rs)
+	if got := run(rs, "GET k"); got != "B" {
+		t.Errorf("GET after reload = %q, want B", got)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// waiter is a client blocked until one of its keys may be able to serve it.
+type waiter struct {
+	keys []string
+	// try is the blocked command's attempt, which serveBlocked runs under
+	// the mutex on its behalf.
+	try func() (bool, error)
+	// served and err are try's outcome once it has served the client or
+	// failed, set under the mutex before ready is signalled.
+	served bool
+	err    error
+	ready  chan struct{}
+}
+
+var (
+	errTimeout         = errors.New("ERR timeout is not a float or out of range")
+	errNegativeTimeout = errors.New("ERR timeout is negative")
+)
+
+// parseTimeout parses a blocking command timeout given in (possibly
+// fractional) seconds. Zero means block forever.
+func parseTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errTimeout
+	}
+	if secs < 0 {
+		return 0, errNegativeTimeout
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// wakeWaiters marks key as written for serveBlocked, if any client is
+// blocked on it. The caller must hold the mutex.
+func (r *RedisStore) wakeWaiters(key string) {
+	if len(r.waiters[key]) > 0 && !slices.Contains(r.readyKeys, key) {
+		r.readyKeys = append(r.readyKeys, key)
+		r.anyReady.Store(true)
+	}
+}
+
+// serveBlocked hands the keys written by the last command to the clients
+// blocked on them. The clients on each key are tried in the order they
+// blocked, each served before the next is tried, so an RPUSH of one element
+// wakes only the longest waiting and one of N elements up to N of them. A
+// client served this way may write a key others wait on, as BLMOVE does,
+// which is then served in turn. With no key ready it returns without taking
+// a lock, so commands that wrote nothing are not serialized through it.
+func (r *RedisStore) serveBlocked() {
+	if !r.anyReady.Load() {
+		return
+	}
+	r.execMu.RLock()
+	defer r.execMu.RUnlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for len(r.readyKeys) > 0 {
+		key := r.readyKeys[0]
+		r.readyKeys = r.readyKeys[1:]
+		for _, w := range slices.Clone(r.waiters[key]) {
+			ok, err := w.try()
+			if !ok && err == nil {
+				continue
+			}
+			w.served, w.err = ok, err
+			r.removeWaiter(w.keys, w)
+			w.ready <- struct{}{}
+		}
+	}
+	r.anyReady.Store(false)
+}
+
+func (r *RedisStore) addWaiter(keys []string, try func() (bool, error)) *waiter {
+	w := &waiter{keys: keys, try: try, ready: make(chan struct{}, 1)}
+	for _, key := range keys {
+		r.waiters[key] = append(r.waiters[key], w)
+	}
+	return w
+}
+
+func (r *RedisStore) removeWaiter(keys []string, w *waiter) {
+	for _, key := range keys {
+		ws := r.waiters[key]
+		for i, other := range ws {
+			if other == w {
+				ws = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		if len(ws) == 0 {
+			delete(r.waiters, key)
+		} else {
+			r.waiters[key] = ws
+		}
+	}
+}
+
+// block runs try under the write lock, and if it does not serve the request
+// waits for serveBlocked to run it on the client's behalf after a write to
+// one of keys. The first attempt also holds execMu for reading, so it cannot
+// land inside an ATOMIC batch. It gives up and returns false once timeout
+// has elapsed; a zero timeout blocks forever and a negative one makes a
+// single attempt without blocking, for a command such as XREAD that only
+// blocks when asked to. While it waits, ci is marked blocked in the client
+// registry and CLIENT UNBLOCK or KILL can end the wait early.
+func (r *RedisStore) block(ci *clientInfo, keys []string, timeout time.Duration, try func() (bool, error)) (bool, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = r.clock.After(timeout)
+	}
+	if timeout >= 0 {
+		ci.setBlocked(true, r.clock.Now())
+		defer ci.setBlocked(false, time.Time{})
+	}
+	r.execMu.RLock()
+	r.mutex.Lock()
+	ok, err := try()
+	if ok || err != nil || timeout < 0 {
+		r.mutex.Unlock()
+		r.execMu.RUnlock()
+		return ok, err
+	}
+	w := r.addWaiter(keys, try)
+	r.mutex.Unlock()
+	r.execMu.RUnlock()
+
+	var unblockErr error
+	select {
+	case <-w.ready:
+	case <-deadline:
+	case unblockErr = <-ci.unblocked():
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// The client may have been served while it gave up waiting.
+	if w.served || w.err != nil {
+		return w.served, w.err
+	}
+	r.removeWaiter(keys, w)
+	return false, unblockErr
+}
+
+// BLMove is the blocking form of LMove, waiting for src to become non-empty.
+func (r *RedisStore) BLMove(ci *clientInfo, src, dst string, from, to listEnd, timeout time.Duration) (string, bool, error) {
+	var val string
+	ok, err := r.block(ci, []string{src}, timeout, func() (bool, error) {
+		var ok bool
+		var err error
+		val, ok, err = r.lmove(src, dst, from, to)
+		return ok, err
+	})
+	return val, ok, err
+}
+
+// BLMPop is the blocking form of LMPop, waiting for any of keys to become
+// non-empty.
+func (r *RedisStore) BLMPop(ci *clientInfo, keys []string, end listEnd, count int, timeout time.Duration) (string, []string, error) {
+	var key string
+	var vals []string
+	_, err := r.block(ci, keys, timeout, func() (bool, error) {
+		var err error
+		key, vals, err = r.lmpop(keys, end, count)
+		return vals != nil, err
+	})
+	return key, vals, err
+}
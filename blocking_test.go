@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitUntil polls cond until it holds, failing the test after a second.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for condition")
+		}
+	}
+}
+
+// blockedOn reports how many clients are blocked on key.
+func blockedOn(rs *RedisStore, key string) int {
+	rs.mutex.RLock()
+	defer rs.mutex.RUnlock()
+	return len(rs.waiters[key])
+}
+
+func TestBLMoveWakesOnPush(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+
+	reply := make(chan string)
+	go func() { reply <- run(rs, "BLMOVE src dst RIGHT LEFT 5") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "src") == 1 })
+
+	run(rs, "RPUSH src a")
+	select {
+	case got := <-reply:
+		if got != "a" {
+			t.Errorf("BLMOVE returned %q, want a", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLMOVE did not wake on push")
+	}
+	if got := run(rs, "LRANGE dst 0 -1"); got != "1) a" {
+		t.Errorf("dst = %q, want the moved element", got)
+	}
+	if got := run(rs, "LLEN src"); got != "0" {
+		t.Errorf("src LLEN = %q, want 0", got)
+	}
+	if n := blockedOn(rs, "src"); n != 0 {
+		t.Errorf("%d waiters left registered", n)
+	}
+}
+
+func TestBLMPopTimesOut(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	reply := make(chan string)
+	go func() { reply <- run(rs, "BLMPOP 2 2 a b LEFT COUNT 3") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "b") == 1 && clk.pendingTimers() == 1 })
+
+	clk.Advance(time.Second)
+	select {
+	case <-reply:
+		t.Fatal("BLMPOP returned before its timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case got := <-reply:
+		if got != "nil" {
+			t.Errorf("BLMPOP returned %q, want nil", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLMPOP did not time out")
+	}
+	if n := blockedOn(rs, "a") + blockedOn(rs, "b"); n != 0 {
+		t.Errorf("%d waiters left registered", n)
+	}
+}
+
+func TestBLMPopServedImmediately(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "RPUSH b x y z")
+	if got := run(rs, "BLMPOP 0 2 a b RIGHT COUNT 2"); got != "1) b\n2) 1) z\n   2) y" {
+		t.Errorf("BLMPOP = %q", got)
+	}
+	if got := run(rs, "LMPOP 1 a LEFT"); got != "nil" {
+		t.Errorf("LMPOP on empty key = %q, want nil", got)
+	}
+	if got := run(rs, "BLMPOP -1 1 a LEFT"); got != "-ERR timeout is negative" {
+		t.Errorf("BLMPOP with negative timeout = %q", got)
+	}
+	// A numkeys that would overflow once the other arguments are added.
+	huge := strconv.Itoa(math.MaxInt)
+	for _, cmd := range []string{"LMPOP " + huge + " a LEFT", "BLMPOP 0 " + huge + " a LEFT"} {
+		if got := run(rs, cmd); got != formatError(errSyntax) {
+			t.Errorf("%s = %q, want a syntax error", cmd, got)
+		}
+	}
+}
+
+func TestBLPopServesWaitersInOrder(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+
+	replies := make([]chan string, 3)
+	for i := range replies {
+		replies[i] = make(chan string, 1)
+		go func() { replies[i] <- run(rs, "BLPOP q 0") }()
+		waitUntil(t, func() bool { return blockedOn(rs, "q") == i+1 })
+	}
+
+	run(rs, "RPUSH q a b")
+	for i, want := range []string{"1) q\n2) a", "1) q\n2) b"} {
+		select {
+		case got := <-replies[i]:
+			if got != want {
+				t.Errorf("waiter %d got %q, want %q", i+1, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("waiter %d was not served", i+1)
+		}
+	}
+	select {
+	case got := <-replies[2]:
+		t.Fatalf("third waiter returned %q with nothing left to pop", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if n := blockedOn(rs, "q"); n != 1 {
+		t.Errorf("%d waiters registered, want the third still blocked", n)
+	}
+
+	run(rs, "LPUSH q c")
+	select {
+	case got := <-replies[2]:
+		if got != "1) q\n2) c" {
+			t.Errorf("third waiter got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("third waiter was not served")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// budgetCheckInterval is how many units of work a budget counts between
+// looks at the clock, which is far slower than the work itself.
+const budgetCheckInterval = 1024
+
+// budget bounds how long an O(n) command such as KEYS may run, per the
+// command-time-budget setting, so that one command over a huge keyspace or
+// collection cannot stall every other client.
+type budget struct {
+	clock clock
+	// deadline is when the budget runs out, or zero for no limit.
+	deadline time.Time
+	n        int
+}
+
+// newBudget starts a budget for a command. The caller must hold the mutex.
+func (r *RedisStore) newBudget() budget {
+	b := budget{clock: r.clock}
+	if limit := r.config.CommandTimeBudget; limit > 0 {
+		b.deadline = r.clock.Now().Add(limit)
+	}
+	return b
+}
+
+// spend counts one unit of work, reporting false once the budget has run
+// out.
+func (b *budget) spend() bool {
+	if b.deadline.IsZero() {
+		return true
+	}
+	if b.n++; b.n%budgetCheckInterval != 0 {
+		return true
+	}
+	return b.clock.Now().Before(b.deadline)
+}
+
+// errOverBudget is the error of a command that ran out of budget, pointing
+// at the incremental command to use instead.
+func errOverBudget(cmd, incremental string) error {
+	return fmt.Errorf("ERR %s exceeded command-time-budget, use %s to iterate incrementally", cmd, incremental)
+}
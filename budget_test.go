@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tickingClock is a fake clock that moves forward by tick every time it is
+// read, standing in for work that takes time.
+type tickingClock struct {
+	*fakeClock
+	tick time.Duration
+}
+
+func (c tickingClock) Now() time.Time {
+	c.Advance(c.tick)
+	return c.fakeClock.Now()
+}
+
+func TestKeysRespectsBudget(t *testing.T) {
+	rs := newTestStore(t)
+	rs.mutex.Lock()
+	for i := range 100_000 {
+		key := "key:" + strconv.Itoa(i)
+		rs.data[key] = rs.newValue("v")
+		rs.indexKey(key)
+	}
+	rs.mutex.Unlock()
+	run(rs, "SET other v")
+	run(rs, "SADD big "+strings.Repeat("m ", 10)+"x")
+
+	rs.clock = tickingClock{newFakeClock(), time.Millisecond}
+	if got := run(rs, "KEYS other"); got != "1) other" {
+		t.Errorf("KEYS without a budget = %q", got)
+	}
+	run(rs, "CONFIG SET command-time-budget 50000")
+	if got := run(rs, "KEYS other"); got != formatError(errOverBudget("KEYS", "SCAN")) {
+		t.Errorf("KEYS over budget = %q", got)
+	}
+	// SCAN is the way through a large keyspace and is not limited.
+	if got := run(rs, "SCAN 0 MATCH other COUNT 200000"); !strings.HasSuffix(got, "1) other") {
+		t.Errorf("SCAN = %q", got)
+	}
+	if got := run(rs, "SMEMBERS big"); got != "1) m\n2) x" {
+		t.Errorf("SMEMBERS of a small set = %q", got)
+	}
+
+	run(rs, "FLUSHALL")
+	run(rs, "SET a 1")
+	run(rs, "SET b 2")
+	if got := run(rs, "KEYS *"); got != "1) a\n2) b" {
+		t.Errorf("KEYS within budget = %q", got)
+	}
+}
+
+func TestSMembersRespectsBudget(t *testing.T) {
+	rs := newTestStore(t)
+	members := make([]string, 10_000)
+	for i := range members {
+		members[i] = strconv.Itoa(i)
+	}
+	rs.SAdd("big", members...)
+	rs.clock = tickingClock{newFakeClock(), time.Millisecond}
+	run(rs, "CONFIG SET command-time-budget 5000")
+	if got := run(rs, "SMEMBERS big"); got != formatError(errOverBudget("SMEMBERS", "SSCAN")) {
+		t.Errorf("SMEMBERS over budget = %q", got)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// client is the state of a single connection. Replies and Pub/Sub messages
+// published from other connections share the connection, so everything is
+// written through the client's outbox, in the order it was queued.
+type client struct {
+	rs  *RedisStore
+	out *outbox
+
+	// channels, patterns and shardChannels are the client's subscriptions
+	// in the regular and sharded Pub/Sub registries. They are only touched
+	// by the connection's own goroutine.
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+
+	// user is the ACL user the connection runs as, nil when no users are
+	// configured and everything is allowed.
+	user          *ACLUser
+	authenticated bool
+
+	limiter rateLimiter
+
+	// multi is set between MULTI and EXEC or DISCARD, and queued holds the
+	// commands sent meanwhile. dirty is set once one of them was refused,
+	// so that EXEC discards the transaction. watches are the keys the
+	// client is WATCHing.
+	multi   bool
+	queued  []Command
+	dirty   bool
+	watches map[string]watch
+
+	// info is the client's entry in the store's client registry.
+	info *clientInfo
+}
+
+func newClient(w io.Writer, rs *RedisStore) *client {
+	c := &client{
+		rs:            rs,
+		out:           newOutbox(w),
+		channels:      make(map[string]bool),
+		patterns:      make(map[string]bool),
+		shardChannels: make(map[string]bool),
+		watches:       make(map[string]watch),
+		authenticated: true,
+		info:          rs.newClientInfo(w),
+	}
+	c.info.push = c.deliver
+	if u := rs.config.Users["default"]; u != nil {
+		c.user = u
+		c.authenticated = u.Password == ""
+	}
+	return c
+}
+
+// write queues reply to be sent to the client, under the output buffer limit
+// of pubsub clients once it has subscriptions and of normal ones otherwise.
+func (c *client) write(reply string) {
+	class := clientClassNormal
+	if len(c.channels)+len(c.patterns)+len(c.shardChannels) > 0 {
+		class = clientClassPubSub
+	}
+	c.send(reply, class)
+}
+
+// deliver queues a message published to one of the client's subscriptions,
+// reporting whether it did. It may be called from any goroutine, so the
+// message counts as pubsub output.
+func (c *client) deliver(frame string) bool {
+	return c.send(frame, clientClassPubSub)
+}
+
+// send queues frame under the output buffer limit of class. A client whose
+// output would break the limit is disconnected rather than let it grow
+// without bound, as happens to a subscriber that stops reading.
+func (c *client) send(frame string, class int) bool {
+	c.rs.mutex.RLock()
+	limit := c.rs.config.ClientOutputBufferLimits[class]
+	c.rs.mutex.RUnlock()
+	queued, overflowed := c.out.push(frame+"\n", limit, c.rs.clock.Now())
+	if overflowed {
+		log.Printf("client id=%d addr=%s closed for overcoming of output buffer limits", c.info.id, c.info.addr)
+		killClient(c.info)
+	}
+	return queued
+}
+
+// flush waits until everything written to the client so far has been sent.
+func (c *client) flush() {
+	c.out.flush()
+}
+
+// close releases everything the client holds in the server.
+func (c *client) close() {
+	for channel := range c.channels {
+		c.rs.pubsub.unsubscribe(c, channel)
+	}
+	for pattern := range c.patterns {
+		c.rs.pubsub.punsubscribe(c, pattern)
+	}
+	for channel := range c.shardChannels {
+		c.rs.shardPubsub.unsubscribe(c, channel)
+	}
+	c.unwatch()
+	c.rs.tracking.disable(c)
+	c.rs.unregisterClient(c.info)
+	c.out.close()
+}
+
+// subscribedCommands are the only commands accepted while the client has
+// subscriptions.
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+}
+
+// processCommand handles the commands that depend on connection state and
+// hands everything else to the shared dispatch. As there, arguments that
+// match none of a command's forms are a syntax error.
+func (c *client) processCommand(cmd Command) string {
+	if reply := c.dispatch(cmd); reply != "" {
+		return reply
+	}
+	return formatError(errSyntax)
+}
+
+// dispatch is processCommand before an empty reply is turned into an error.
+func (c *client) dispatch(cmd Command) string {
+	if cmd.Name != "" {
+		c.info.setCommand(cmd.Name)
+		cmd.client = c.info
+		if err := c.throttle(cmd); err != nil {
+			return formatError(err)
+		}
+	}
+	if cmd.Name == "AUTH" {
+		return c.auth(cmd.Args)
+	}
+	if cmd.Name != "" && !c.authenticated {
+		return formatError(errNoAuth)
+	}
+	if cmd.Name != "" && c.user != nil && !c.user.permits(cmd.Name) {
+		return formatError(errNoPerm(cmd.Name))
+	}
+	if c.user != nil {
+		// The batch is parsed again when it runs; here only the permission
+		// of each command in it matters.
+		for _, inner := range batchCommands(cmd, c.rs) {
+			if !c.user.permits(inner.Name) {
+				return formatError(errNoPerm(inner.Name))
+			}
+		}
+	}
+	if len(c.channels)+len(c.patterns)+len(c.shardChannels) > 0 && cmd.Name != "" && !subscribedCommands[cmd.Name] {
+		return formatError(fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE are allowed in this context", cmd.Name))
+	}
+	if transactionCommands[cmd.Name] {
+		return c.transaction(cmd)
+	}
+	if c.multi && cmd.Name != "" {
+		return c.queue(cmd)
+	}
+	ps, shard := c.rs.pubsub, c.rs.shardPubsub
+	switch cmd.Name {
+	case "SUBSCRIBE":
+		if len(cmd.Args) >= 1 {
+			return c.subscribe(c.channels, "subscribe", cmd.Args, ps.subscribe)
+		}
+	case "PSUBSCRIBE":
+		if len(cmd.Args) >= 1 {
+			return c.subscribe(c.patterns, "psubscribe", cmd.Args, ps.psubscribe)
+		}
+	case "SSUBSCRIBE":
+		if len(cmd.Args) >= 1 {
+			return c.subscribe(c.shardChannels, "ssubscribe", cmd.Args, shard.subscribe)
+		}
+	case "UNSUBSCRIBE":
+		return c.unsubscribe(c.channels, "unsubscribe", cmd.Args, ps.unsubscribe)
+	case "PUNSUBSCRIBE":
+		return c.unsubscribe(c.patterns, "punsubscribe", cmd.Args, ps.punsubscribe)
+	case "SUNSUBSCRIBE":
+		return c.unsubscribe(c.shardChannels, "sunsubscribe", cmd.Args, shard.unsubscribe)
+	case "CLIENT":
+		if len(cmd.Args) >= 1 {
+			return c.clientCommand(cmd.Args)
+		}
+	default:
+		c.rs.waitPause(cmd)
+		// The keys are tracked before the read, so that a write racing
+		// with it still invalidates them.
+		if keys := readKeys(cmd); keys != nil {
+			c.rs.tracking.track(c, keys)
+		}
+		return processCommand(cmd, c.rs)
+	}
+	return ""
+}
+
+// auth implements AUTH [username] password, switching the connection to
+// that user's permissions.
+func (c *client) auth(args []string) string {
+	var name, password string
+	switch len(args) {
+	case 1:
+		name, password = "default", args[0]
+	case 2:
+		name, password = args[0], args[1]
+	default:
+		return ""
+	}
+	u := c.rs.config.Users[name]
+	if u == nil && len(args) == 1 {
+		return formatError(errors.New("ERR AUTH <password> called without any password configured for the default user."))
+	}
+	if u == nil || !u.checkPassword(password) {
+		return formatError(errWrongPass)
+	}
+	c.user = u
+	c.authenticated = true
+	return "OK"
+}
+
+// subscriptionCount is the running count carried in (un)subscribe replies.
+// As in Redis, channel and pattern subscriptions are counted together and
+// sharded subscriptions on their own.
+func (c *client) subscriptionCount(kind string) int {
+	if kind == "ssubscribe" || kind == "sunsubscribe" {
+		return len(c.shardChannels)
+	}
+	return len(c.channels) + len(c.patterns)
+}
+
+// subscribe adds the client to each of names, registering it with add,
+// replying with one frame per name carrying the running subscription count.
+func (c *client) subscribe(subs map[string]bool, kind string, names []string, add func(*client, string)) string {
+	frames := make([]string, 0, len(names))
+	for _, name := range names {
+		if !subs[name] {
+			subs[name] = true
+			add(c, name)
+		}
+		frames = append(frames, formatArray([]string{kind, name, strconv.Itoa(c.subscriptionCount(kind))}))
+	}
+	return strings.Join(frames, "\n")
+}
+
+// unsubscribe removes the client from the given names, or from all of its
+// subscriptions in subs if none are named.
+func (c *client) unsubscribe(subs map[string]bool, kind string, names []string, remove func(*client, string)) string {
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	if len(names) == 0 {
+		return formatArray([]string{kind, "nil", strconv.Itoa(c.subscriptionCount(kind))})
+	}
+	frames := make([]string, 0, len(names))
+	for _, name := range names {
+		if subs[name] {
+			delete(subs, name)
+			remove(c, name)
+		}
+		frames = append(frames, formatArray([]string{kind, name, strconv.Itoa(c.subscriptionCount(kind))}))
+	}
+	return strings.Join(frames, "\n")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientInfo is a connection's entry in the client registry, which CLIENT
+// LIST, KILL and UNBLOCK work from.
+type clientInfo struct {
+	id      int64
+	addr    string
+	created time.Time
+	// kill closes the connection, or is nil for a client without one.
+	kill func()
+	// push queues a message to the client, as a Pub/Sub message is, or is
+	// nil for a client that cannot receive one.
+	push func(frame string) bool
+	// unblock wakes the blocking command the client is waiting in with the
+	// error it should fail with, or nil to have it time out.
+	unblock chan error
+
+	mu sync.Mutex
+	// cmd is the last command the client sent. blocked is set while it is
+	// waiting in a blocking command, since blockedSince.
+	cmd          string
+	blocked      bool
+	blockedSince time.Time
+}
+
+// clientRegistry holds every connected client. It has its own lock, like the
+// Pub/Sub registries.
+type clientRegistry struct {
+	mu      sync.Mutex
+	lastID  int64
+	clients map[int64]*clientInfo
+}
+
+var (
+	errNoSuchClient = errors.New("ERR No such client")
+	errUnblocked    = errors.New("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+	errClientKilled = errors.New("ERR client killed")
+	errHungUp       = errors.New("ERR client closed the connection")
+)
+
+// registerClient adds a client to the registry. addr and kill are empty for
+// a client that is not a network connection.
+func (r *RedisStore) registerClient(addr string, kill func()) *clientInfo {
+	reg := &r.clients
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.clients == nil {
+		reg.clients = make(map[int64]*clientInfo)
+	}
+	reg.lastID++
+	ci := &clientInfo{
+		id:      reg.lastID,
+		addr:    addr,
+		created: r.clock.Now(),
+		kill:    kill,
+		unblock: make(chan error, 1),
+	}
+	reg.clients[ci.id] = ci
+	return ci
+}
+
+// unregisterClient removes a client from the registry, and from the replicas
+// if it was one.
+func (r *RedisStore) unregisterClient(ci *clientInfo) {
+	r.repl.remove(ci)
+	r.clients.mu.Lock()
+	defer r.clients.mu.Unlock()
+	delete(r.clients.clients, ci.id)
+}
+
+func (r *RedisStore) findClient(id int64) *clientInfo {
+	r.clients.mu.Lock()
+	defer r.clients.mu.Unlock()
+	return r.clients.clients[id]
+}
+
+// newClientInfo registers the client writing to w, which can be killed by
+// closing it if it is a connection.
+func (r *RedisStore) newClientInfo(w io.Writer) *clientInfo {
+	if conn, ok := w.(net.Conn); ok {
+		return r.registerClient(conn.RemoteAddr().String(), func() { conn.Close() })
+	}
+	return r.registerClient("", nil)
+}
+
+// setCommand records the command the client is running. A nil clientInfo,
+// for a command run internally, is ignored, as by the other methods below.
+func (ci *clientInfo) setCommand(name string) {
+	if ci == nil {
+		return
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.cmd = strings.ToLower(name)
+}
+
+// setBlocked records whether the client is waiting in a blocking command.
+// Unblocking drains a wakeup that arrived too late to be seen.
+func (ci *clientInfo) setBlocked(blocked bool, now time.Time) {
+	if ci == nil {
+		return
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.blocked, ci.blockedSince = blocked, now
+	if !blocked {
+		select {
+		case <-ci.unblock:
+		default:
+		}
+	}
+}
+
+// unblocked returns the channel the client's blocking command is woken on,
+// nil for a nil clientInfo so that it is never woken.
+func (ci *clientInfo) unblocked() <-chan error {
+	if ci == nil {
+		return nil
+	}
+	return ci.unblock
+}
+
+// wake ends the client's blocking command, if it is in one, with err or
+// as if it timed out if err is nil. It reports whether the client was
+// blocked.
+func (ci *clientInfo) wake(err error) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if !ci.blocked {
+		return false
+	}
+	select {
+	case ci.unblock <- err:
+	default:
+	}
+	return true
+}
+
+// describe renders the client as a CLIENT LIST line. Blocked clients have
+// the b flag and blocked-for gives how long they have waited, in seconds.
+func (ci *clientInfo) describe(now time.Time) string {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	flags, blockedFor := "N", time.Duration(0)
+	if ci.blocked {
+		flags, blockedFor = "b", now.Sub(ci.blockedSince)
+	}
+	return fmt.Sprintf("id=%d addr=%s age=%d flags=%s cmd=%s blocked-for=%d",
+		ci.id, ci.addr, int64(now.Sub(ci.created)/time.Second), flags, ci.cmd, int64(blockedFor/time.Second))
+}
+
+// ClientList describes every connected client, in the order they connected.
+func (r *RedisStore) ClientList() string {
+	r.clients.mu.Lock()
+	clients := make([]*clientInfo, 0, len(r.clients.clients))
+	for _, ci := range r.clients.clients {
+		clients = append(clients, ci)
+	}
+	r.clients.mu.Unlock()
+	slices.SortFunc(clients, func(a, b *clientInfo) int { return cmp.Compare(a.id, b.id) })
+	now := r.clock.Now()
+	lines := make([]string, len(clients))
+	for i, ci := range clients {
+		lines[i] = ci.describe(now)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hangUp ends the client's blocking command because the peer closed the
+// connection. Unlike wake it does not need the client to be blocked yet:
+// the wakeup stays queued for a command still on its way to block, and
+// there is no later command to drain it.
+func (ci *clientInfo) hangUp() {
+	select {
+	case ci.unblock <- errHungUp:
+	default:
+	}
+}
+
+// killClient closes a client's connection and wakes it if it is blocked, so
+// that it goes away at once rather than when its command would have ended.
+func killClient(ci *clientInfo) {
+	if ci.kill != nil {
+		ci.kill()
+	}
+	ci.wake(errClientKilled)
+}
+
+// ClientKill kills the clients matching the filters of CLIENT KILL ID id or
+// ADDR addr, returning how many there were.
+func (r *RedisStore) ClientKill(filters []string) (int, error) {
+	if len(filters) == 0 || len(filters)%2 != 0 {
+		return 0, errSyntax
+	}
+	var id int64
+	var addr string
+	for i := 0; i < len(filters); i += 2 {
+		switch val := filters[i+1]; strings.ToUpper(filters[i]) {
+		case "ID":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n <= 0 {
+				return 0, errNotInteger
+			}
+			id = n
+		case "ADDR":
+			addr = val
+		default:
+			return 0, errSyntax
+		}
+	}
+	r.clients.mu.Lock()
+	var matched []*clientInfo
+	for _, ci := range r.clients.clients {
+		if (id == 0 || ci.id == id) && (addr == "" || ci.addr == addr) {
+			matched = append(matched, ci)
+		}
+	}
+	r.clients.mu.Unlock()
+	for _, ci := range matched {
+		killClient(ci)
+	}
+	return len(matched), nil
+}
+
+// clientCommand handles CLIENT for connection c.
+func (c *client) clientCommand(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		if len(args) == 1 {
+			return strconv.FormatInt(c.info.id, 10)
+		}
+	case "LIST":
+		if len(args) == 1 {
+			return c.rs.ClientList()
+		}
+	case "TRACKING":
+		if len(args) >= 2 {
+			return c.trackingCommand(args[1:])
+		}
+	case "KILL":
+		if len(args) == 2 {
+			// The old form, CLIENT KILL addr, replies OK or an error
+			// rather than a count.
+			n, _ := c.rs.ClientKill([]string{"ADDR", args[1]})
+			if n == 0 {
+				return formatError(errNoSuchClient)
+			}
+			return "OK"
+		}
+		if len(args) >= 3 {
+			n, err := c.rs.ClientKill(args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "PAUSE":
+		if len(args) == 2 || len(args) == 3 {
+			return pauseCommand(args[1:], c.rs)
+		}
+	case "UNPAUSE":
+		if len(args) == 1 {
+			c.rs.Unpause()
+			return "OK"
+		}
+	case "UNBLOCK":
+		if len(args) == 2 || len(args) == 3 {
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return formatError(errNotInteger)
+			}
+			var reason error
+			if len(args) == 3 {
+				switch strings.ToUpper(args[2]) {
+				case "TIMEOUT":
+				case "ERROR":
+					reason = errUnblocked
+				default:
+					return formatError(errors.New("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR"))
+				}
+			}
+			ci := c.rs.findClient(id)
+			if ci == nil || !ci.wake(reason) {
+				return "0"
+			}
+			return "1"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientLine returns the CLIENT LIST line of the client with id.
+func clientLine(rs *RedisStore, id string) string {
+	for line := range strings.SplitSeq(rs.ClientList(), "\n") {
+		if strings.HasPrefix(line, "id="+id+" ") {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestClientKillUnblocksBLPOP(t *testing.T) {
+	rs := newTestStore(t)
+	_, addr := startServer(t, rs)
+	blocked := dial(t, addr)
+	r := bufio.NewReader(blocked)
+	io.WriteString(blocked, "CLIENT ID\n")
+	id, _ := r.ReadString('\n')
+	id = strings.TrimSpace(id)
+	io.WriteString(blocked, "BLPOP queue 0\n")
+	waitUntil(t, func() bool { return strings.Contains(clientLine(rs, id), " flags=b cmd=blpop ") })
+
+	admin := dial(t, addr)
+	adminReader := bufio.NewReader(admin)
+	io.WriteString(admin, "CLIENT KILL ID "+id+"\n")
+	if line, _ := adminReader.ReadString('\n'); line != "1\n" {
+		t.Fatalf("CLIENT KILL = %q, want 1", line)
+	}
+	blocked.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("killed client read %q, %v; want the connection closed", line, err)
+	}
+	waitUntil(t, func() bool { return blockedOn(rs, "queue") == 0 && clientLine(rs, id) == "" })
+}
+
+func TestHungUpWaiterIsNotServed(t *testing.T) {
+	rs := newTestStore(t)
+	_, addr := startServer(t, rs)
+	gone := dial(t, addr)
+	io.WriteString(gone, "BLPOP queue 0\n")
+	waitUntil(t, func() bool { return blockedOn(rs, "queue") == 1 })
+	waiting := dial(t, addr)
+	r := bufio.NewReader(waiting)
+	io.WriteString(waiting, "BLPOP queue 0\n")
+	waitUntil(t, func() bool { return blockedOn(rs, "queue") == 2 })
+
+	gone.Close()
+	waitUntil(t, func() bool { return blockedOn(rs, "queue") == 1 })
+	run(rs, "RPUSH queue x")
+	waiting.SetReadDeadline(time.Now().Add(time.Second))
+	var reply strings.Builder
+	for range 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("second waiter read %q, %v", reply.String()+line, err)
+		}
+		reply.WriteString(line)
+	}
+	if got := reply.String(); got != "1) queue\n2) x\n" {
+		t.Errorf("second waiter got %q, want the pushed element", got)
+	}
+}
+
+func TestClientPauseAndUnpause(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	c, _ := newTestClient(t, rs)
+	admin, _ := newTestClient(t, rs)
+
+	if got := send(admin, "CLIENT PAUSE 10000"); got != "OK" {
+		t.Fatalf("CLIENT PAUSE = %q", got)
+	}
+	done := make(chan string)
+	go func() { done <- send(c, "GET k") }()
+	select {
+	case got := <-done:
+		t.Fatalf("GET ran during the pause: %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := send(admin, "CLIENT UNPAUSE"); got != "OK" {
+		t.Fatalf("CLIENT UNPAUSE = %q", got)
+	}
+	select {
+	case got := <-done:
+		if got != "nil" {
+			t.Errorf("GET after UNPAUSE = %q, want nil", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CLIENT UNPAUSE did not wake the paused client")
+	}
+
+	// Under PAUSE WRITE reads go ahead, and writes wait out the timeout.
+	send(admin, "CLIENT PAUSE 100 WRITE")
+	if got := send(c, "GET k"); got != "nil" {
+		t.Errorf("GET during PAUSE WRITE = %q, want nil", got)
+	}
+	go func() { done <- send(c, "SET k v") }()
+	select {
+	case got := <-done:
+		t.Fatalf("SET ran during PAUSE WRITE: %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(100 * time.Millisecond)
+	select {
+	case got := <-done:
+		if got != "OK" {
+			t.Errorf("SET after the pause timed out = %q, want OK", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the pause did not end at its timeout")
+	}
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"CLIENT PAUSE -1", formatError(errPauseTimeout)},
+		{"CLIENT PAUSE 10 READ", formatError(errSyntax)},
+		{"CLIENT UNPAUSE", "OK"},
+	} {
+		if got := send(admin, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestClientUnblock(t *testing.T) {
+	rs := newTestStore(t)
+	clock := newFakeClock()
+	rs.clock = clock
+	c, _ := newTestClient(t, rs)
+	admin, _ := newTestClient(t, rs)
+	id := send(c, "CLIENT ID")
+	if got := send(admin, "CLIENT UNBLOCK "+id); got != "0" {
+		t.Errorf("CLIENT UNBLOCK of an idle client = %q, want 0", got)
+	}
+	for _, tt := range []struct{ reason, want string }{
+		{"", "nil"},
+		{" TIMEOUT", "nil"},
+		{" ERROR", formatError(errUnblocked)},
+	} {
+		done := make(chan string)
+		go func() { done <- send(c, "BRPOP a b 0") }()
+		waitUntil(t, func() bool { return strings.Contains(clientLine(rs, id), " flags=b cmd=brpop ") })
+		clock.Advance(5 * time.Second)
+		if line := clientLine(rs, id); !strings.HasSuffix(line, " blocked-for=5") {
+			t.Errorf("CLIENT LIST line = %q, want blocked-for=5", line)
+		}
+		if got := send(admin, "CLIENT UNBLOCK "+id+tt.reason); got != "1" {
+			t.Errorf("CLIENT UNBLOCK%s = %q, want 1", tt.reason, got)
+		}
+		if got := <-done; got != tt.want {
+			t.Errorf("BRPOP unblocked with%s = %q, want %q", tt.reason, got, tt.want)
+		}
+		if line := clientLine(rs, id); !strings.Contains(line, " flags=N ") {
+			t.Errorf("CLIENT LIST line after unblocking = %q", line)
+		}
+	}
+}
+
+func TestBLPOP(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	send(c, "RPUSH b x y")
+	if got := send(c, "BLPOP a b 1"); got != "1) b\n2) x" {
+		t.Errorf("BLPOP = %q", got)
+	}
+	if got := send(c, "BRPOP a b 1"); got != "1) b\n2) y" {
+		t.Errorf("BRPOP = %q", got)
+	}
+	done := make(chan string)
+	go func() { done <- send(c, "BLPOP a 0") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "a") == 1 })
+	run(rs, "LPUSH a z")
+	if got := <-done; got != "1) a\n2) z" {
+		t.Errorf("woken BLPOP = %q", got)
+	}
+}
+
+func TestClientTrackingInvalidates(t *testing.T) {
+	rs := newTestStore(t)
+	reader, readerOut := newTestClient(t, rs)
+	writer, _ := newTestClient(t, rs)
+	invalidation := func(key string) string {
+		return formatArray([]string{"message", invalidateChannel, formatArray([]string{key})})
+	}
+
+	if got := send(reader, "CLIENT TRACKING ON"); got != "OK" {
+		t.Fatalf("CLIENT TRACKING ON = %q", got)
+	}
+	send(reader, "GET k")
+	send(writer, "SET other v")
+	send(writer, "SET k v")
+	reader.flush()
+	if got := readerOut.String(); strings.Count(got, invalidation("k")) != 1 || strings.Contains(got, invalidation("other")) {
+		t.Fatalf("reader got %q, want one invalidation of k", got)
+	}
+	// k is not tracked again until it is read again.
+	send(writer, "SET k v2")
+	reader.flush()
+	if got := readerOut.String(); strings.Count(got, invalidation("k")) != 1 {
+		t.Errorf("second write without a read invalidated again: %q", got)
+	}
+
+	// A redirected client's invalidations go to the target.
+	target, targetOut := newTestClient(t, rs)
+	id := send(target, "CLIENT ID")
+	if got := send(reader, "CLIENT TRACKING ON REDIRECT "+id); got != "OK" {
+		t.Fatalf("CLIENT TRACKING ON REDIRECT = %q", got)
+	}
+	send(reader, "HGET h f")
+	send(writer, "HSET h f v")
+	target.flush()
+	if got := targetOut.String(); !strings.Contains(got, invalidation("h")) {
+		t.Errorf("redirect target got %q, want an invalidation of h", got)
+	}
+	if strings.Contains(readerOut.String(), invalidation("h")) {
+		t.Error("a redirected invalidation was also sent to the reader")
+	}
+
+	send(reader, "CLIENT TRACKING OFF")
+	send(reader, "GET k")
+	send(writer, "DEL k")
+	reader.flush()
+	target.flush()
+	if strings.Count(readerOut.String()+targetOut.String(), invalidation("k")) != 1 {
+		t.Error("tracking OFF still invalidated k")
+	}
+	if got := send(reader, "CLIENT TRACKING ON REDIRECT 9999"); got != formatError(errNoRedirectClient) {
+		t.Errorf("REDIRECT to an unknown client = %q", got)
+	}
+
+	// A flush invalidates everything, with a nil key, for every tracking
+	// client whether or not it read anything.
+	flushed := formatArray([]string{"message", invalidateChannel, "nil"})
+	other, otherOut := newTestClient(t, rs)
+	send(other, "CLIENT TRACKING ON")
+	send(reader, "CLIENT TRACKING ON")
+	send(reader, "GET k")
+	send(writer, "FLUSHALL")
+	reader.flush()
+	other.flush()
+	if got := readerOut.String(); strings.Count(got, flushed) != 1 {
+		t.Errorf("reader got %q after FLUSHALL, want one nil invalidation", got)
+	}
+	if got := otherOut.String(); strings.Count(got, flushed) != 1 {
+		t.Errorf("client that read nothing got %q after FLUSHALL, want one nil invalidation", got)
+	}
+	// The flush stopped tracking k, so writing it sends nothing more.
+	send(writer, "SET k v")
+	reader.flush()
+	if got := readerOut.String(); strings.Count(got, invalidation("k")) != 1 {
+		t.Errorf("write after FLUSHALL invalidated k again: %q", got)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// clock abstracts time so that expiry and blocking timeouts can be driven
+// deterministically in tests.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// commandSpec is a command's entry in the command table. arity is the
+// number of words it takes, counting its name, as in Redis's command table:
+// n means exactly n and -n means at least n.
+type commandSpec struct {
+	arity int
+	flags commandFlag
+}
+
+type commandFlag uint8
+
+const (
+	// flagWrite marks a command that may change the keyspace, even if a
+	// given call does not, as GETEX without options does not.
+	flagWrite commandFlag = 1 << iota
+	// flagReadOnly marks a command that reads the keyspace and never
+	// changes it. Commands that do neither, such as CONFIG and PUBLISH,
+	// have no flag. ATOMIC and PROC are writes when their batches are; see
+	// isWriteCommand.
+	flagReadOnly
+)
+
+// commandTable lists every command the server dispatches. Commands handled
+// by the client, such as AUTH and SUBSCRIBE, are not listed.
+var commandTable = map[string]commandSpec{
+	"GET":          {2, flagReadOnly},
+	"GETDEL":       {2, flagWrite},
+	"GETEX":        {-2, flagWrite},
+	"SET":          {-3, flagWrite},
+	"DEL":          {-2, flagWrite},
+	"RENAME":       {3, flagWrite},
+	"RENAMENX":     {3, flagWrite},
+	"COPY":         {-3, flagWrite},
+	"STRLEN":       {2, flagReadOnly},
+	"GETRANGE":     {4, flagReadOnly},
+	"SETRANGE":     {4, flagWrite},
+	"APPEND":       {3, flagWrite},
+	"GETBIT":       {3, flagReadOnly},
+	"BITFIELD":     {-2, flagWrite},
+	"CAS":          {4, flagWrite},
+	"INCR":         {2, flagWrite},
+	"DECR":         {2, flagWrite},
+	"INCRBY":       {3, flagWrite},
+	"DECRBY":       {3, flagWrite},
+	"LPUSH":        {-3, flagWrite},
+	"RPUSH":        {-3, flagWrite},
+	"LLEN":         {2, flagReadOnly},
+	"LRANGE":       {4, flagReadOnly},
+	"LMOVE":        {5, flagWrite},
+	"BLMOVE":       {6, flagWrite},
+	"LMPOP":        {-4, flagWrite},
+	"BLMPOP":       {-5, flagWrite},
+	"BLPOP":        {-3, flagWrite},
+	"BRPOP":        {-3, flagWrite},
+	"RPOPLPUSH":    {3, flagWrite},
+	"ZADD":         {-4, flagWrite},
+	"ZINCRBY":      {4, flagWrite},
+	"ZREM":         {-3, flagWrite},
+	"ZSCORE":       {3, flagReadOnly},
+	"ZCARD":        {2, flagReadOnly},
+	"ZRANK":        {3, flagReadOnly},
+	"ZRANGE":       {-4, flagReadOnly},
+	"ZUNIONSTORE":  {-4, flagWrite},
+	"ZINTERSTORE":  {-4, flagWrite},
+	"ZDIFFSTORE":   {-4, flagWrite},
+	"ZINTERCARD":   {-3, flagReadOnly},
+	"SADD":         {-3, flagWrite},
+	"SREM":         {-3, flagWrite},
+	"SISMEMBER":    {3, flagReadOnly},
+	"SCARD":        {2, flagReadOnly},
+	"SMEMBERS":     {2, flagReadOnly},
+	"SINTERCARD":   {-3, flagReadOnly},
+	"SMOVE":        {4, flagWrite},
+	"HSET":         {-4, flagWrite},
+	"HGET":         {3, flagReadOnly},
+	"HDEL":         {-3, flagWrite},
+	"HLEN":         {2, flagReadOnly},
+	"HGETALL":      {2, flagReadOnly},
+	"XADD":         {-5, flagWrite},
+	"XLEN":         {2, flagReadOnly},
+	"XRANGE":       {-4, flagReadOnly},
+	"XDEL":         {-3, flagWrite},
+	"XTRIM":        {-4, flagWrite},
+	"XSETID":       {3, flagWrite},
+	"XREAD":        {-4, flagReadOnly},
+	"XREADGROUP":   {-7, flagWrite},
+	"XGROUP":       {-2, flagWrite},
+	"GEOADD":       {-5, flagWrite},
+	"GEOPOS":       {-2, flagReadOnly},
+	"GEODIST":      {-4, flagReadOnly},
+	"GEOSEARCH":    {-7, flagReadOnly},
+	"PFADD":        {-2, flagWrite},
+	"PFCOUNT":      {-2, flagReadOnly},
+	"PFMERGE":      {-2, flagWrite},
+	"EXPIRE":       {3, flagWrite},
+	"PEXPIRE":      {3, flagWrite},
+	"EXPIREAT":     {3, flagWrite},
+	"PEXPIREAT":    {3, flagWrite},
+	"PERSIST":      {2, flagWrite},
+	"EXPIRETIME":   {2, flagReadOnly},
+	"PEXPIRETIME":  {2, flagReadOnly},
+	"TTL":          {2, flagReadOnly},
+	"PTTL":         {2, flagReadOnly},
+	"DUMP":         {2, flagReadOnly},
+	"RESTORE":      {-4, flagWrite},
+	"FLUSHALL":     {-1, flagWrite},
+	"FLUSHDB":      {-1, flagWrite},
+	"SAVE":         {1, 0},
+	"BGSAVE":       {1, 0},
+	"BGREWRITEAOF": {1, 0},
+	"KEYS":         {2, flagReadOnly},
+	"SCAN":         {-2, flagReadOnly},
+	"RANDOMKEY":    {1, flagReadOnly},
+	"DBSIZE":       {1, flagReadOnly},
+	"HSCAN":        {-3, flagReadOnly},
+	"SSCAN":        {-3, flagReadOnly},
+	"ZSCAN":        {-3, flagReadOnly},
+	"WAIT":         {3, 0},
+	"REPLCONF":     {-2, 0},
+	"PUBLISH":      {3, 0},
+	"SPUBLISH":     {3, 0},
+	"PUBSUB":       {-2, 0},
+	"CONFIG":       {-2, 0},
+	"INFO":         {-1, 0},
+	"SLOWLOG":      {-2, 0},
+	"OBJECT":       {-2, flagReadOnly},
+	"TYPE":         {2, flagReadOnly},
+	"MEMORY":       {-2, flagReadOnly},
+	"DEBUG":        {-2, 0},
+	"ATOMIC":       {-2, 0},
+	"PROC":         {-2, 0},
+}
+
+// keySpec locates a command's keys among its arguments, as the first, last
+// and step of Redis's command table, counting from the first argument. A
+// negative last counts from the end.
+type keySpec struct {
+	first, last, step int
+}
+
+// readKeySpecs are the keys of the read-only commands, which CLIENT TRACKING
+// records a client as having read.
+var readKeySpecs = map[string]keySpec{
+	"GET":         {0, 0, 1},
+	"STRLEN":      {0, 0, 1},
+	"GETRANGE":    {0, 0, 1},
+	"GETBIT":      {0, 0, 1},
+	"LLEN":        {0, 0, 1},
+	"LRANGE":      {0, 0, 1},
+	"SISMEMBER":   {0, 0, 1},
+	"SCARD":       {0, 0, 1},
+	"SMEMBERS":    {0, 0, 1},
+	"HGET":        {0, 0, 1},
+	"HLEN":        {0, 0, 1},
+	"HGETALL":     {0, 0, 1},
+	"ZSCORE":      {0, 0, 1},
+	"ZCARD":       {0, 0, 1},
+	"ZRANK":       {0, 0, 1},
+	"ZRANGE":      {0, 0, 1},
+	"XLEN":        {0, 0, 1},
+	"XRANGE":      {0, 0, 1},
+	"GEOPOS":      {0, 0, 1},
+	"GEODIST":     {0, 0, 1},
+	"PFCOUNT":     {0, -1, 1},
+	"TTL":         {0, 0, 1},
+	"PTTL":        {0, 0, 1},
+	"EXPIRETIME":  {0, 0, 1},
+	"PEXPIRETIME": {0, 0, 1},
+	"TYPE":        {0, 0, 1},
+	"DUMP":        {0, 0, 1},
+}
+
+// readKeys returns the keys cmd reads, or nil if it is not a read-only
+// command.
+func readKeys(cmd Command) []string {
+	spec, ok := readKeySpecs[cmd.Name]
+	if !ok {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(cmd.Args)
+	}
+	var keys []string
+	for i := spec.first; i <= last && i < len(cmd.Args); i += spec.step {
+		keys = append(keys, cmd.Args[i])
+	}
+	return keys
+}
+
+var errReadOnly = errors.New("READONLY You can't write against a read only server.")
+
+// isWriteCommand reports whether cmd may change the keyspace: it is flagged
+// as a write, or is an ATOMIC or PROC whose batch has one. Registering a
+// procedure is a write, as it is persisted.
+func isWriteCommand(cmd Command, rs *RedisStore) bool {
+	if commandTable[cmd.Name].flags&flagWrite != 0 {
+		return true
+	}
+	if cmd.Name == "PROC" && len(cmd.Args) > 0 && strings.EqualFold(cmd.Args[0], "REGISTER") {
+		return true
+	}
+	return slices.ContainsFunc(batchCommands(cmd, rs), func(inner Command) bool {
+		return commandTable[inner.Name].flags&flagWrite != 0
+	})
+}
+
+// errUnknownCommand is the error for a command missing from commandTable.
+func errUnknownCommand(cmd Command) error {
+	var args strings.Builder
+	for _, arg := range cmd.Args {
+		fmt.Fprintf(&args, "'%s' ", arg)
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", cmd.Name, args.String())
+}
+
+// errArity is the error for a command with the wrong number of arguments.
+func errArity(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+// checkArity reports whether cmd is a known command with an acceptable
+// number of arguments.
+func checkArity(cmd Command) bool {
+	spec, ok := commandTable[cmd.Name]
+	if !ok {
+		return false
+	}
+	arity := spec.arity
+	n := len(cmd.Args) + 1
+	if arity < 0 {
+		return n >= -arity
+	}
+	return n == arity
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// invocation returns a call of name with as few arguments as its arity
+// allows, each a placeholder.
+func invocation(name string) string {
+	arity := commandTable[name].arity
+	if arity < 0 {
+		arity = -arity
+	}
+	return strings.TrimSpace(name + strings.Repeat(" k", arity-1))
+}
+
+// background are the commands that leave work running after they reply,
+// which would outlive the test's store.
+var background = map[string]bool{"BGSAVE": true, "BGREWRITEAOF": true}
+
+func TestCommandTableFlags(t *testing.T) {
+	for name, spec := range commandTable {
+		if spec.flags&flagWrite != 0 && spec.flags&flagReadOnly != 0 {
+			t.Errorf("%s is flagged both write and read-only", name)
+		}
+		if background[name] {
+			continue
+		}
+		// Every listed command must be dispatched, or its flags are
+		// describing nothing.
+		rs := newTestStore(t)
+		if got := dispatchCommand(parseCommand(invocation(name)), rs); got == "" {
+			t.Errorf("%s is in the command table but not dispatched", name)
+		}
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	rs := newTestStore(t)
+	want := "-ERR unknown command 'FOO', with args beginning with: 'a' 'b' "
+	if got := run(rs, "FOO a b"); got != want {
+		t.Errorf("FOO a b = %q, want %q", got, want)
+	}
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "FOO"); got != "-ERR unknown command 'FOO', with args beginning with: " {
+		t.Errorf("FOO from a client = %q", got)
+	}
+}
+
+func TestMalformedArgumentsAreSyntaxErrors(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	for _, cmd := range []string{
+		"ZADD k 1 a 2",
+		"HSET h a b c",
+		"ZRANGE k 0 1 foo",
+		"ZRANGE k 0 1 withscores x",
+		"CONFIG GET",
+		"CONFIG SET a",
+		"CONFIG RESETSTAT x",
+		"OBJECT ENCODING",
+		"MEMORY USAGE",
+		"XGROUP CREATE s",
+		"INFO a b",
+	} {
+		if got := run(rs, cmd); !strings.HasPrefix(got, "-ERR") {
+			t.Errorf("%s = %q, want an error", cmd, got)
+		}
+	}
+	for _, cmd := range []string{"SUBSCRIBE", "CLIENT ID x", "AUTH a b c"} {
+		if got := send(c, cmd); got != formatError(errSyntax) {
+			t.Errorf("%s from a client = %q, want a syntax error", cmd, got)
+		}
+	}
+
+	// An empty value is a reply like any other, and is counted as a call.
+	processCommand(Command{Name: "SET", Args: []string{"empty", ""}}, rs)
+	if got := processCommand(Command{Name: "GET", Args: []string{"empty"}}, rs); got != emptyReply {
+		t.Errorf("GET of an empty value = %q, want %q", got, emptyReply)
+	}
+	if info := run(rs, "INFO commandstats"); !strings.Contains(info, "cmdstat_get:calls=1,") {
+		t.Errorf("GET of an empty value not counted:\n%s", info)
+	}
+}
+
+func TestReadOnlyRejectsExactlyWrites(t *testing.T) {
+	rs := newTestStore(t)
+	rs.config.ReadOnly = true
+	for name, spec := range commandTable {
+		if background[name] {
+			continue
+		}
+		got := run(rs, invocation(name))
+		rejected := got == formatError(errReadOnly)
+		switch {
+		case spec.flags&flagWrite != 0 && !rejected:
+			t.Errorf("read-only server ran write command %s: %q", name, got)
+		case spec.flags&flagWrite == 0 && rejected:
+			t.Errorf("read-only server rejected %s, which does not write", name)
+		}
+	}
+
+	// Batches are writes when any command in them is.
+	if got := run(rs, "ATOMIC GET k ; SET k v"); got != formatError(errReadOnly) {
+		t.Errorf("ATOMIC with a write = %q", got)
+	}
+	if got := run(rs, "ATOMIC GET k ; TTL k"); got != "1) nil\n2) -2" {
+		t.Errorf("ATOMIC of reads = %q", got)
+	}
+	c, _ := newTestClient(t, rs)
+	send(c, "MULTI")
+	if got := send(c, "INCR n"); got != formatError(errReadOnly) {
+		t.Errorf("queueing a write = %q", got)
+	}
+	if got := send(c, "EXEC"); got != formatError(errExecAbort) {
+		t.Errorf("EXEC after a refused write = %q", got)
+	}
+
+	// CONFIG is no write, so the mode can be turned off again.
+	if got := run(rs, "CONFIG SET read-only no"); got != "OK" {
+		t.Fatalf("CONFIG SET read-only no = %q", got)
+	}
+	if got := run(rs, "SET k v"); got != "OK" {
+		t.Errorf("SET once writable = %q", got)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server's tunable settings.
+type Config struct {
+	// File is the config file the settings were read from, which CONFIG
+	// REWRITE writes back to and SIGHUP reloads. It is empty if there is
+	// none.
+	File string
+	// Port is the TCP port the server listens on.
+	Port int
+	// Dir is the working directory where the AOF and snapshot files are
+	// kept. It is created if it does not exist.
+	Dir string
+	// TCPKeepAlive is the idle time before keepalive probes are sent on
+	// client connections. Zero disables keepalive.
+	TCPKeepAlive time.Duration
+	// EmbstrSizeLimit is the longest string, in bytes, reported with the
+	// compact embstr encoding rather than raw.
+	EmbstrSizeLimit int
+	// AOFStopWritesOnError makes write commands fail while the AOF cannot
+	// be written, rather than succeeding without being persisted.
+	AOFStopWritesOnError bool
+	// ReadOnly rejects the commands flagged as writes in the command
+	// table, as a read-only replica does.
+	ReadOnly bool
+	// AppendFsync is when the AOF is fsynced: "always" before a write is
+	// acknowledged, "everysec" once a second, or "no" to leave it to the
+	// operating system.
+	AppendFsync string
+	// AOFRewriteItemsPerCmd caps the elements each command of an AOF
+	// rewrite adds, so that huge collections are split over several
+	// commands rather than written as one enormous line.
+	AOFRewriteItemsPerCmd int
+	// AutoAOFRewritePercentage is how much, as a percentage of its size
+	// after the last rewrite, the AOF grows before it is rewritten in the
+	// background, once it is at least AutoAOFRewriteMinSize bytes. Zero
+	// turns automatic rewrites off.
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+	// ListMaxListpackSize bounds lists kept in the compact listpack
+	// encoding: a positive value is the most entries, and -1 to -5 allow
+	// about 4, 8, 16, 32 or 64 KB of elements.
+	ListMaxListpackSize int
+	// ZSetMaxListpackEntries and ZSetMaxListpackValue bound the member count
+	// and member length of sorted sets kept in the compact listpack
+	// encoding.
+	ZSetMaxListpackEntries int
+	ZSetMaxListpackValue   int
+	// HashMaxListpackEntries and HashMaxListpackValue bound the field count
+	// and field and value length of hashes kept in the compact listpack
+	// encoding.
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	// SetMaxIntsetEntries bounds sets of integers kept in the intset
+	// encoding, and SetMaxListpackEntries and SetMaxListpackValue the
+	// member count and member length of other sets kept in listpack.
+	SetMaxIntsetEntries   int
+	SetMaxListpackEntries int
+	SetMaxListpackValue   int
+	// HLLSparseMaxBytes is the largest HyperLogLog kept in the sparse
+	// encoding before it is converted to dense.
+	HLLSparseMaxBytes int
+	// SlowlogLogSlowerThan is the execution time at which a command is
+	// recorded in the slow log; negative disables it. SlowlogMaxLen caps
+	// the number of entries kept.
+	SlowlogLogSlowerThan time.Duration
+	SlowlogMaxLen        int
+	// CommandTimeBudget is how long an O(n) command such as KEYS or
+	// SMEMBERS may run before it gives up with an error suggesting the
+	// incremental SCAN variant. Zero or negative means no limit.
+	CommandTimeBudget time.Duration
+	// ClientRateLimit caps the commands per second each connection may
+	// send; zero disables it. ClientRateLimitPolicy is "delay" to hold
+	// commands over the limit until they fit, or "error" to reject them.
+	ClientRateLimit       int
+	ClientRateLimitPolicy string
+	// NotifyKeyspaceEvents selects the keyspace notifications published,
+	// using the flags of redis.conf's notify-keyspace-events. Empty
+	// disables them.
+	NotifyKeyspaceEvents string
+	// Maxmemory is the memory limit in bytes, zero for none, and
+	// MaxmemoryPolicy is what happens when it is reached.
+	Maxmemory       int64
+	MaxmemoryPolicy string
+	// ClientOutputBufferLimits are the output buffer limits of each client
+	// class, indexed by clientClassNormal, clientClassReplica and
+	// clientClassPubSub.
+	ClientOutputBufferLimits [3]outputBufferLimit
+	// TCPBacklog is the length of the listen queue of pending connections.
+	// The kernel may cap it, on Linux at net.core.somaxconn.
+	TCPBacklog int
+	// ShutdownTimeout is how long a graceful shutdown waits for in-flight
+	// commands before closing their connections anyway.
+	ShutdownTimeout time.Duration
+	// Users are the ACL users connections may AUTH as. If there is a
+	// "default" user, new connections start as it; otherwise they are
+	// unrestricted.
+	Users map[string]*ACLUser
+}
+
+// DefaultConfig returns the settings used when none are given, matching
+// redis.conf defaults.
+func DefaultConfig() Config {
+	return Config{
+		Port:                     6379,
+		Dir:                      ".",
+		TCPKeepAlive:             300 * time.Second,
+		EmbstrSizeLimit:          44,
+		AOFStopWritesOnError:     true,
+		AppendFsync:              "everysec",
+		AOFRewriteItemsPerCmd:    64,
+		AutoAOFRewritePercentage: 100,
+		AutoAOFRewriteMinSize:    64 << 20,
+		ListMaxListpackSize:      -2,
+		ZSetMaxListpackEntries:   128,
+		ZSetMaxListpackValue:     64,
+		HashMaxListpackEntries:   128,
+		HashMaxListpackValue:     64,
+		SetMaxIntsetEntries:      512,
+		SetMaxListpackEntries:    128,
+		SetMaxListpackValue:      64,
+		HLLSparseMaxBytes:        3000,
+		SlowlogLogSlowerThan:     10 * time.Millisecond,
+		SlowlogMaxLen:            128,
+		ClientRateLimitPolicy:    "delay",
+		MaxmemoryPolicy:          "noeviction",
+		TCPBacklog:               511,
+		ClientOutputBufferLimits: [3]outputBufferLimit{
+			clientClassReplica: {256 << 20, 64 << 20, 60 * time.Second},
+			clientClassPubSub:  {32 << 20, 8 << 20, 60 * time.Second},
+		},
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// configParam is a setting exposed through CONFIG GET and CONFIG SET, and
+// read from the config file. An immutable setting can only be given in the
+// file or on the command line, not changed once the server is running.
+type configParam struct {
+	name      string
+	get       func(*Config) string
+	set       func(*Config, string) error
+	immutable bool
+}
+
+var errInvalidConfigValue = errors.New("invalid value")
+
+func intParam(name string, field func(*Config) *int) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, val string) error {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return errInvalidConfigValue
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// boolParam exposes a yes/no setting.
+func boolParam(name string, field func(*Config) *bool) configParam {
+	return configParam{
+		name: name,
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Config, val string) error {
+			switch strings.ToLower(val) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return errInvalidConfigValue
+			}
+			return nil
+		},
+	}
+}
+
+// enumParam exposes a setting that takes one of values.
+func enumParam(name string, values []string, field func(*Config) *string) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return *field(c) },
+		set: func(c *Config, val string) error {
+			val = strings.ToLower(val)
+			if !slices.Contains(values, val) {
+				return errInvalidConfigValue
+			}
+			*field(c) = val
+			return nil
+		},
+	}
+}
+
+// intRangeParam exposes an integer setting limited to lo..hi.
+func intRangeParam(name string, lo, hi int, field func(*Config) *int) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, val string) error {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < lo || n > hi {
+				return errInvalidConfigValue
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// memoryParam exposes a size in bytes, which may be given with redis.conf's
+// units: k, kb, m, mb, g or gb, where the b forms are powers of 1024.
+func memoryParam(name string, field func(*Config) *int64) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *Config, val string) error {
+			n, err := parseMemory(val)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+var memoryUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+func parseMemory(val string) (int64, error) {
+	val = strings.ToLower(val)
+	mult := int64(1)
+	for _, u := range memoryUnits {
+		if s, ok := strings.CutSuffix(val, u.suffix); ok {
+			val, mult = s, u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, errInvalidConfigValue
+	}
+	return n * mult, nil
+}
+
+// Client classes of client-output-buffer-limit.
+const (
+	clientClassNormal = iota
+	clientClassReplica
+	clientClassPubSub
+)
+
+// clientClassNames are the names client-output-buffer-limit gives the
+// classes, which are also accepted with "replica" for "slave".
+var clientClassNames = [3]string{"normal", "slave", "pubsub"}
+
+// outputBufferLimitParam exposes client-output-buffer-limit: a class name,
+// hard and soft limits in bytes and soft seconds, repeated for each class
+// set. Classes not named keep their limits.
+func outputBufferLimitParam(name string, field func(*Config) *[3]outputBufferLimit) configParam {
+	return configParam{
+		name: name,
+		get: func(c *Config) string {
+			var parts []string
+			for class, l := range field(c) {
+				parts = append(parts, clientClassNames[class], strconv.FormatInt(l.hard, 10),
+					strconv.FormatInt(l.soft, 10), strconv.Itoa(int(l.softSeconds.Seconds())))
+			}
+			return strings.Join(parts, " ")
+		},
+		set: func(c *Config, val string) error {
+			parts := strings.Fields(val)
+			if len(parts) == 0 || len(parts)%4 != 0 {
+				return errInvalidConfigValue
+			}
+			limits := *field(c)
+			for i := 0; i < len(parts); i += 4 {
+				name := strings.ToLower(parts[i])
+				if name == "replica" {
+					name = "slave"
+				}
+				class := slices.Index(clientClassNames[:], name)
+				hard, herr := parseMemory(parts[i+1])
+				soft, serr := parseMemory(parts[i+2])
+				seconds, err := strconv.Atoi(parts[i+3])
+				if class < 0 || herr != nil || serr != nil || err != nil || seconds < 0 {
+					return errInvalidConfigValue
+				}
+				limits[class] = outputBufferLimit{hard, soft, time.Duration(seconds) * time.Second}
+			}
+			*field(c) = limits
+			return nil
+		},
+	}
+}
+
+// stringParam exposes a setting that takes any string.
+func stringParam(name string, field func(*Config) *string) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return *field(c) },
+		set: func(c *Config, val string) error {
+			*field(c) = val
+			return nil
+		},
+	}
+}
+
+// immutable makes p a setting that can only be given at startup.
+func immutable(p configParam) configParam {
+	p.immutable = true
+	return p
+}
+
+// secondsParam exposes a duration in whole seconds, as redis.conf does.
+func secondsParam(name string, field func(*Config) *time.Duration) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(int(field(c).Seconds())) },
+		set: func(c *Config, val string) error {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return errInvalidConfigValue
+			}
+			*field(c) = time.Duration(n) * time.Second
+			return nil
+		},
+	}
+}
+
+// microsParam exposes a duration in microseconds. Negative values are
+// allowed and mean the setting is disabled.
+func microsParam(name string, field func(*Config) *time.Duration) configParam {
+	return configParam{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatInt(field(c).Microseconds(), 10) },
+		set: func(c *Config, val string) error {
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return errInvalidConfigValue
+			}
+			*field(c) = time.Duration(n) * time.Microsecond
+			return nil
+		},
+	}
+}
+
+var configParams = []configParam{
+	immutable(intRangeParam("port", 0, 65535, func(c *Config) *int { return &c.Port })),
+	immutable(stringParam("dir", func(c *Config) *string { return &c.Dir })),
+	boolParam("aof-stop-writes-on-error", func(c *Config) *bool { return &c.AOFStopWritesOnError }),
+	boolParam("read-only", func(c *Config) *bool { return &c.ReadOnly }),
+	enumParam("appendfsync", []string{"always", "everysec", "no"}, func(c *Config) *string { return &c.AppendFsync }),
+	intRangeParam("aof-rewrite-items-per-cmd", 1, math.MaxInt, func(c *Config) *int { return &c.AOFRewriteItemsPerCmd }),
+	intRangeParam("auto-aof-rewrite-percentage", 0, math.MaxInt, func(c *Config) *int { return &c.AutoAOFRewritePercentage }),
+	memoryParam("auto-aof-rewrite-min-size", func(c *Config) *int64 { return &c.AutoAOFRewriteMinSize }),
+	immutable(intParam("tcp-backlog", func(c *Config) *int { return &c.TCPBacklog })),
+	secondsParam("tcp-keepalive", func(c *Config) *time.Duration { return &c.TCPKeepAlive }),
+	secondsParam("shutdown-timeout", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	intRangeParam("list-max-listpack-size", -5, math.MaxInt, func(c *Config) *int { return &c.ListMaxListpackSize }),
+	intParam("zset-max-listpack-entries", func(c *Config) *int { return &c.ZSetMaxListpackEntries }),
+	intParam("zset-max-listpack-value", func(c *Config) *int { return &c.ZSetMaxListpackValue }),
+	intParam("hash-max-listpack-entries", func(c *Config) *int { return &c.HashMaxListpackEntries }),
+	intParam("hash-max-listpack-value", func(c *Config) *int { return &c.HashMaxListpackValue }),
+	intParam("set-max-intset-entries", func(c *Config) *int { return &c.SetMaxIntsetEntries }),
+	intParam("set-max-listpack-entries", func(c *Config) *int { return &c.SetMaxListpackEntries }),
+	intParam("set-max-listpack-value", func(c *Config) *int { return &c.SetMaxListpackValue }),
+	intParam("hll-sparse-max-bytes", func(c *Config) *int { return &c.HLLSparseMaxBytes }),
+	microsParam("slowlog-log-slower-than", func(c *Config) *time.Duration { return &c.SlowlogLogSlowerThan }),
+	intParam("slowlog-max-len", func(c *Config) *int { return &c.SlowlogMaxLen }),
+	microsParam("command-time-budget", func(c *Config) *time.Duration { return &c.CommandTimeBudget }),
+	{
+		name: "notify-keyspace-events",
+		get:  func(c *Config) string { return c.NotifyKeyspaceEvents },
+		set: func(c *Config, val string) error {
+			flags, err := parseNotifyFlags(val)
+			if err != nil {
+				return errInvalidConfigValue
+			}
+			c.NotifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+	memoryParam("maxmemory", func(c *Config) *int64 { return &c.Maxmemory }),
+	enumParam("maxmemory-policy", []string{
+		"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+		"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+	}, func(c *Config) *string { return &c.MaxmemoryPolicy }),
+	outputBufferLimitParam("client-output-buffer-limit", func(c *Config) *[3]outputBufferLimit { return &c.ClientOutputBufferLimits }),
+	intParam("client-rate-limit", func(c *Config) *int { return &c.ClientRateLimit }),
+	enumParam("client-rate-limit-policy", []string{"delay", "error"}, func(c *Config) *string { return &c.ClientRateLimitPolicy }),
+}
+
+func findConfigParam(name string) (configParam, bool) {
+	for _, p := range configParams {
+		if p.name == strings.ToLower(name) {
+			return p, true
+		}
+	}
+	return configParam{}, false
+}
+
+// ConfigGet returns each parameter matching pattern followed by its value.
+func (r *RedisStore) ConfigGet(pattern string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var reply []string
+	for _, p := range configParams {
+		if matchPattern(strings.ToLower(pattern), p.name) {
+			reply = append(reply, p.name, p.get(&r.config))
+		}
+	}
+	return reply
+}
+
+func (r *RedisStore) ConfigSet(name, val string) error {
+	p, ok := findConfigParam(name)
+	if !ok {
+		return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	if p.immutable {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", p.name)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := p.set(&r.config, val); err != nil {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET '%s'", val, p.name)
+	}
+	return nil
+}
+
+// readOnly reports whether the server is rejecting writes.
+func (r *RedisStore) readOnly() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.config.ReadOnly
+}
+
+func configCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) == 2 {
+			return formatArray(rs.ConfigGet(args[1]))
+		}
+	case "SET":
+		if len(args) == 3 {
+			if err := rs.ConfigSet(args[1], args[2]); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	case "REWRITE":
+		if len(args) == 1 {
+			if err := rs.RewriteConfig(); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	case "RESETSTAT":
+		if len(args) == 1 {
+			rs.stats.reset()
+			return "OK"
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
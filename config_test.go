@@ -0,0 +1,222 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestConfigGetSet(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "CONFIG GET zset-max-listpack-*"); got != "1) zset-max-listpack-entries\n2) 128\n3) zset-max-listpack-value\n4) 64" {
+		t.Errorf("CONFIG GET = %q", got)
+	}
+	if got := run(rs, "CONFIG SET tcp-keepalive 60"); got != "OK" {
+		t.Fatalf("CONFIG SET = %q", got)
+	}
+	if rs.config.TCPKeepAlive.Seconds() != 60 {
+		t.Errorf("TCPKeepAlive = %v, want 60s", rs.config.TCPKeepAlive)
+	}
+	if got := run(rs, "CONFIG SET tcp-keepalive soon"); got != "-ERR Invalid argument 'soon' for CONFIG SET 'tcp-keepalive'" {
+		t.Errorf("CONFIG SET with a bad value = %q", got)
+	}
+	if got := run(rs, "CONFIG SET nope 1"); got != "-ERR Unknown option or number of arguments for CONFIG SET - 'nope'" {
+		t.Errorf("CONFIG SET of an unknown option = %q", got)
+	}
+}
+
+func TestClientOutputBufferLimitConfig(t *testing.T) {
+	rs := newTestStore(t)
+	if err := rs.ConfigSet("client-output-buffer-limit", "replica 1mb 512kb 10 normal 0 0 0"); err != nil {
+		t.Fatal(err)
+	}
+	want := "1) client-output-buffer-limit\n2) normal 0 0 0 slave 1048576 524288 10 pubsub 33554432 8388608 60"
+	if got := run(rs, "CONFIG GET client-output-buffer-limit"); got != want {
+		t.Errorf("CONFIG GET = %q, want %q", got, want)
+	}
+	for _, val := range []string{"pubsub 1mb 1mb", "master 0 0 0", "pubsub 1mb 1mb -1"} {
+		if err := rs.ConfigSet("client-output-buffer-limit", val); err == nil {
+			t.Errorf("CONFIG SET client-output-buffer-limit %q succeeded", val)
+		}
+	}
+}
+
+func TestDirHoldsPersistenceFiles(t *testing.T) {
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	cfg := DefaultConfig()
+	cfg.Dir = filepath.Join(t.TempDir(), "data", "redis")
+	rs, err := NewRedisStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	run(rs, "SET k v")
+	if got := run(rs, "SAVE"); got != "OK" {
+		t.Fatalf("SAVE = %q", got)
+	}
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	run(rs, "SET k2 v2")
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{aofFilename, snapshotFilename}) {
+		t.Errorf("dir holds %v, want just the AOF and snapshot", names)
+	}
+	if aof := aofText(t, rs); aof != "SET k v\nSET k2 v2\n" {
+		t.Errorf("AOF in dir = %q", aof)
+	}
+	if leftover, _ := os.ReadDir(cwd); len(leftover) != 0 {
+		t.Errorf("files written to the current directory: %v", leftover)
+	}
+
+	if got := run(rs, "CONFIG GET dir"); got != "1) dir\n2) "+cfg.Dir {
+		t.Errorf("CONFIG GET dir = %q", got)
+	}
+	if got := run(rs, "CONFIG SET dir /tmp"); got != "-ERR CONFIG SET failed (possibly related to argument 'dir') - can't set immutable config" {
+		t.Errorf("CONFIG SET dir = %q", got)
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+	run(rs, "SET k v")
+	run(rs, "GET k")
+	run(rs, "GET k")
+	if info := run(rs, "INFO commandstats"); !strings.Contains(info, "cmdstat_get:calls=2,") {
+		t.Fatalf("INFO commandstats does not count the GETs:\n%s", info)
+	}
+
+	if got := run(rs, "CONFIG RESETSTAT"); got != "OK" {
+		t.Fatalf("CONFIG RESETSTAT = %q", got)
+	}
+	// Like Redis, the RESETSTAT itself is counted once it has run.
+	if info := run(rs, "INFO commandstats"); info != "# Commandstats\ncmdstat_config:calls=1,usec=0,usec_per_call=0.00" {
+		t.Errorf("INFO commandstats after RESETSTAT = %q, want just the CONFIG", info)
+	}
+	if info := run(rs, "INFO stats"); !strings.Contains(info, "total_commands_processed:2\n") {
+		t.Errorf("INFO stats after RESETSTAT:\n%s", info)
+	}
+	if got := run(rs, "GET k"); got != "v" {
+		t.Errorf("GET after RESETSTAT = %q, want the key kept", got)
+	}
+}
+
+// newConfigFileStore returns a store started from a config file holding
+// conf.
+func newConfigFileStore(t *testing.T, conf string) (*RedisStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	if err := loadConfigFile(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Dir = t.TempDir()
+	rs, err := NewRedisStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rs.Close)
+	return rs, path
+}
+
+func TestSIGHUPReloadsConfigFile(t *testing.T) {
+	rs, path := newConfigFileStore(t, "# limits\nmaxmemory 1mb\nport 7000\n")
+	if got := infoField(t, run(rs, "INFO memory"), "maxmemory"); got != "1048576" {
+		t.Fatalf("maxmemory from the config file = %s", got)
+	}
+
+	conf := "# limits\nmaxmemory 2mb\nmaxmemory-policy allkeys-lru\nport 7001\n"
+	if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan os.Signal, 1)
+	defer close(signals)
+	go reloadOnSignal(rs, signals)
+	signals <- syscall.SIGHUP
+	waitUntil(t, func() bool { return infoField(t, run(rs, "INFO memory"), "maxmemory") == "2097152" })
+	if got := run(rs, "CONFIG GET maxmemory-policy"); got != "1) maxmemory-policy\n2) allkeys-lru" {
+		t.Errorf("CONFIG GET maxmemory-policy after reload = %q", got)
+	}
+
+	changed, restart, err := rs.ReloadConfig()
+	if err != nil || len(changed) != 0 || !slices.Equal(restart, []string{"port"}) {
+		t.Errorf("ReloadConfig = %v, %v, %v; want the port needing a restart", changed, restart, err)
+	}
+	if got := run(rs, "CONFIG GET port"); got != "1) port\n2) 7000" {
+		t.Errorf("CONFIG GET port after reload = %q, want the startup port", got)
+	}
+
+	if err := os.WriteFile(path, []byte("maxmemory 3mb\nmaxmemory-policy sometimes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rs.ReloadConfig(); err == nil {
+		t.Error("ReloadConfig of an invalid setting succeeded")
+	}
+	if got := run(rs, "CONFIG GET maxmemory"); got != "1) maxmemory\n2) 2097152" {
+		t.Errorf("maxmemory after a failed reload = %q, want it unchanged", got)
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	rs, path := newConfigFileStore(t, "# limits\nmaxmemory 1mb\n\nmaxmemory 2mb\nport 7000\n")
+	run(rs, "CONFIG SET maxmemory 4mb")
+	run(rs, "CONFIG SET notify-keyspace-events Ex")
+	if got := run(rs, "CONFIG REWRITE"); got != "OK" {
+		t.Fatalf("CONFIG REWRITE = %q", got)
+	}
+	conf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# limits\nmaxmemory 4194304\n\nport 7000\n# Generated by CONFIG REWRITE\n" +
+		"dir " + rs.config.Dir + "\nnotify-keyspace-events Ex\n"
+	if string(conf) != want {
+		t.Errorf("rewritten config =\n%s\nwant\n%s", conf, want)
+	}
+
+	cfg := DefaultConfig()
+	if err := loadConfigFile(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Maxmemory != 4<<20 || cfg.NotifyKeyspaceEvents != rs.config.NotifyKeyspaceEvents || cfg.Port != 7000 {
+		t.Errorf("rewritten config read back as %+v", cfg)
+	}
+
+	if got := run(newTestStore(t), "CONFIG REWRITE"); got != "-ERR The server is running without a config file" {
+		t.Errorf("CONFIG REWRITE without a config file = %q", got)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	for conf, want := range map[string]string{
+		"# ok\nbogus 1\n":               ":2: bad directive",
+		"tcp-keepalive soon\n":          `:1: invalid value "soon"`,
+		"notify-keyspace-events \"Ex\n": ":1: unbalanced quotes",
+	} {
+		if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := DefaultConfig()
+		if err := loadConfigFile(&cfg, path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loading %q: err = %v, want %q", conf, err, want)
+		}
+	}
+}
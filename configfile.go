@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The config file has redis.conf's format: one setting per line, its name
+// followed by its value, which may be double-quoted. Blank lines and lines
+// starting with # are ignored.
+
+// configDirective is a setting given on line line of the config file.
+type configDirective struct {
+	line      int
+	name, val string
+}
+
+var errNoConfigFile = errors.New("ERR The server is running without a config file")
+
+// parseConfigLine splits a config file line into a setting's name and value,
+// reporting false for a blank or comment line.
+func parseConfigLine(line string) (name, val string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	name, val, _ = strings.Cut(line, " ")
+	val = strings.TrimSpace(val)
+	if strings.HasPrefix(val, `"`) {
+		if val, err = strconv.Unquote(val); err != nil {
+			return "", "", false, errors.New("unbalanced quotes")
+		}
+	}
+	return strings.ToLower(name), val, true, nil
+}
+
+// formatConfigLine renders a setting as a config file line, quoting a value
+// parseConfigLine would not read back as it is.
+func formatConfigLine(name, val string) string {
+	if val == "" || strings.ContainsAny(val, "\"\\ \t") {
+		val = strconv.Quote(val)
+	}
+	return name + " " + val
+}
+
+// readConfigFile returns the settings in the config file at path.
+func readConfigFile(path string) ([]configDirective, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var directives []configDirective
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		name, val, ok, err := parseConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if ok {
+			directives = append(directives, configDirective{n, name, val})
+		}
+	}
+	return directives, scanner.Err()
+}
+
+// applyConfig sets each of directives in cfg, failing on the first that
+// is unknown or has an invalid value.
+func applyConfig(cfg *Config, path string, directives []configDirective) error {
+	for _, d := range directives {
+		p, ok := findConfigParam(d.name)
+		if !ok {
+			return fmt.Errorf("%s:%d: bad directive or wrong number of arguments: %s", path, d.line, d.name)
+		}
+		if err := p.set(cfg, d.val); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s", path, d.line, d.val, d.name)
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads the config file at path into cfg at startup, when
+// every setting may be given.
+func loadConfigFile(cfg *Config, path string) error {
+	directives, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(cfg, path, directives); err != nil {
+		return err
+	}
+	cfg.File = path
+	return nil
+}
+
+// ReloadConfig reads the config file again and applies the settings that
+// changed, returning their names. Immutable settings that changed are
+// left as they are and returned in restart, as needing a restart to take
+// effect. Settings missing from the file keep their current values. If
+// the file has an invalid setting, nothing is applied.
+func (r *RedisStore) ReloadConfig() (changed, restart []string, err error) {
+	path := r.config.File
+	if path == "" {
+		return nil, nil, errNoConfigFile
+	}
+	directives, err := readConfigFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	next := r.config
+	if err := applyConfig(&next, path, directives); err != nil {
+		return nil, nil, err
+	}
+	// Only the settings that changed are written, since others, such as
+	// dir, are read without the mutex.
+	for _, p := range configParams {
+		old, val := p.get(&r.config), p.get(&next)
+		switch {
+		case old == val:
+		case p.immutable:
+			log.Printf("config reload: %s changed from %s to %s, which requires a restart", p.name, old, val)
+			restart = append(restart, p.name)
+		default:
+			p.set(&r.config, val)
+			log.Printf("config reload: %s changed from %s to %s", p.name, old, val)
+			changed = append(changed, p.name)
+		}
+	}
+	return changed, restart, nil
+}
+
+// RewriteConfig writes the current settings back to the config file. Lines
+// for settings are rewritten with their current values and comments are
+// kept. Settings not in the file are appended if they differ from their
+// defaults.
+func (r *RedisStore) RewriteConfig() error {
+	path := r.config.File
+	if path == "" {
+		return errNoConfigFile
+	}
+	old, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	r.mutex.RLock()
+	cfg := r.config
+	r.mutex.RUnlock()
+	defaults := DefaultConfig()
+
+	var lines []string
+	written := make(map[string]bool)
+	for line := range strings.Lines(string(old)) {
+		line = strings.TrimRight(line, "\r\n")
+		name, _, ok, _ := parseConfigLine(line)
+		p, known := findConfigParam(name)
+		switch {
+		case !ok || !known:
+			lines = append(lines, line)
+		case !written[p.name]:
+			lines = append(lines, formatConfigLine(p.name, p.get(&cfg)))
+			written[p.name] = true
+		}
+	}
+	header := false
+	for _, p := range configParams {
+		if written[p.name] || p.get(&cfg) == p.get(&defaults) {
+			continue
+		}
+		if !header {
+			lines = append(lines, "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		lines = append(lines, formatConfigLine(p.name, p.get(&cfg)))
+	}
+
+	if err := writeFileAtomic(path, strings.Join(lines, "\n")+"\n"); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, going through a
+// temporary file so that it is never left half written.
+func writeFileAtomic(path, data string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-config-*.conf")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// reloadOnSignal reloads the config file each time a signal arrives, as
+// main does on SIGHUP, until signals is closed.
+func reloadOnSignal(rs *RedisStore, signals <-chan os.Signal) {
+	for range signals {
+		if _, _, err := rs.ReloadConfig(); err != nil {
+			log.Printf("config reload failed: %v", err)
+		}
+	}
+}
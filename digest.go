@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"strconv"
+)
+
+type digest [sha1.Size]byte
+
+func (d *digest) xor(other digest) {
+	for i := range d {
+		d[i] ^= other[i]
+	}
+}
+
+func (d digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// writeField writes s length-prefixed, so that adjacent fields cannot run
+// into each other.
+func writeField(h hash.Hash, s string) {
+	h.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	h.Write([]byte(s))
+}
+
+// valueDigest hashes a value together with its type. Set members and hash
+// fields are combined order-independently, since they have no order; list
+// and sorted set elements are hashed in order.
+func valueDigest(sv *StoredValue) digest {
+	h := sha1.New()
+	switch v := sv.value.(type) {
+	case string:
+		writeField(h, "string")
+		writeField(h, v)
+	case []byte:
+		writeField(h, "string")
+		writeField(h, string(v))
+	case []string:
+		writeField(h, "list")
+		for _, e := range v {
+			writeField(h, e)
+		}
+	case map[string]struct{}:
+		writeField(h, "set")
+		var members digest
+		for m := range v {
+			members.xor(sha1.Sum([]byte(m)))
+		}
+		h.Write(members[:])
+	case *hashValue:
+		writeField(h, "hash")
+		var fields digest
+		for _, p := range v.fields() {
+			fh := sha1.New()
+			writeField(fh, p.field)
+			writeField(fh, p.value)
+			var fd digest
+			fh.Sum(fd[:0])
+			fields.xor(fd)
+		}
+		h.Write(fields[:])
+	case *sortedSet:
+		writeField(h, "zset")
+		for _, e := range v.entries {
+			writeField(h, e.member)
+			writeField(h, formatScore(e.score))
+		}
+	case *stream:
+		writeField(h, "stream")
+		for _, e := range v.entries {
+			writeField(h, e.id.String())
+			for _, f := range e.fields {
+				writeField(h, f)
+			}
+		}
+	}
+	var d digest
+	h.Sum(d[:0])
+	return d
+}
+
+// keyDigest hashes a key's name, value and absolute expiry.
+func keyDigest(key string, sv *StoredValue) digest {
+	h := sha1.New()
+	writeField(h, key)
+	vd := valueDigest(sv)
+	h.Write(vd[:])
+	writeField(h, strconv.FormatInt(sv.expireAt, 10))
+	var d digest
+	h.Sum(d[:0])
+	return d
+}
+
+// Digest returns a hash of the whole keyspace that is the same for any two
+// stores holding the same data, whatever order it was written in. An empty
+// keyspace digests to all zeros.
+func (r *RedisStore) Digest() digest {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var d digest
+	now := r.clock.Now()
+	for key, sv := range r.data {
+		if !sv.expired(now) {
+			d.xor(keyDigest(key, sv))
+		}
+	}
+	return d
+}
+
+// DigestValues returns the digest of each key's value, all zeros for keys
+// that do not exist.
+func (r *RedisStore) DigestValues(keys []string) []digest {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	digests := make([]digest, len(keys))
+	for i, key := range keys {
+		if sv := r.lookupNoTouch(key); sv != nil {
+			digests[i] = valueDigest(sv)
+		}
+	}
+	return digests
+}
+
+func debugDigest(args []string, rs *RedisStore) string {
+	if len(args) != 0 {
+		return formatError(errSyntax)
+	}
+	return rs.Digest().String()
+}
+
+func debugDigestValue(keys []string, rs *RedisStore) string {
+	digests := rs.DigestValues(keys)
+	items := make([]string, len(digests))
+	for i, d := range digests {
+		items[i] = d.String()
+	}
+	return formatArray(items)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugDigest(t *testing.T) {
+	a, b := newTestStore(t), newTestStore(t)
+	empty := strings.Repeat("0", 40)
+	if got := run(a, "DEBUG DIGEST"); got != empty {
+		t.Errorf("DEBUG DIGEST of an empty store = %q, want %q", got, empty)
+	}
+
+	// The same data written in a different order digests the same.
+	for _, line := range []string{"SET s v", "RPUSH l a b", "SADD set x y z", "ZADD z 1 m 2 n", "SET t w", "PEXPIREAT t 4102444800000"} {
+		run(a, line)
+	}
+	for _, line := range []string{"ZADD z 2 n 1 m", "SADD set z y x", "SET t w", "PEXPIREAT t 4102444800000", "RPUSH l a b", "SET s v"} {
+		run(b, line)
+	}
+	da := run(a, "DEBUG DIGEST")
+	if da == empty || run(b, "DEBUG DIGEST") != da {
+		t.Fatalf("digests of the same data differ: %q and %q", da, run(b, "DEBUG DIGEST"))
+	}
+	if got := run(reopen(t, a), "DEBUG DIGEST"); got != da {
+		t.Errorf("DEBUG DIGEST after reload = %q, want %q", got, da)
+	}
+
+	run(b, "SET s changed")
+	if run(b, "DEBUG DIGEST") == da {
+		t.Error("DEBUG DIGEST did not change after a SET")
+	}
+}
+
+func TestDebugDigestValue(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a v")
+	run(rs, "SET b v")
+	run(rs, "RPUSH l v")
+	got := strings.Split(run(rs, "DEBUG DIGEST-VALUE a b l missing"), "\n")
+	if len(got) != 4 {
+		t.Fatalf("DEBUG DIGEST-VALUE = %q", got)
+	}
+	if got[0][3:] != got[1][3:] {
+		t.Errorf("equal values digest differently: %q", got)
+	}
+	if got[0][3:] == got[2][3:] {
+		t.Errorf("a string and a list digest the same: %q", got)
+	}
+	if got[3] != "4) "+strings.Repeat("0", 40) {
+		t.Errorf("digest of a missing key = %q", got[3])
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DUMP payload ends, as in Redis, with a footer of a 2-byte version and
+// an 8-byte CRC-64 of everything before the CRC, both little-endian, so
+// that RESTORE refuses payloads from an incompatible format and ones that
+// were truncated or corrupted.
+const (
+	dumpVersion    = 2
+	dumpFooterSize = 2 + 8
+)
+
+var (
+	errBadPayload  = errors.New("ERR DUMP payload version or checksum are wrong")
+	errBusyKey     = errors.New("BUSYKEY Target key name already exists.")
+	errInvalidTTL  = errors.New("ERR Invalid TTL value, must be >= 0")
+	errInvalidIdle = errors.New("ERR Invalid IDLETIME value, must be >= 0")
+	errInvalidFreq = errors.New("ERR Invalid FREQ value, must be >= 0 and <= 255")
+)
+
+// Dump serializes the value at key in the form RESTORE accepts: the
+// snapshot encoding of the value, hex encoded so that it is a single word
+// on the wire.
+func (r *RedisStore) Dump(key string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookup(key)
+	if sv == nil {
+		return "", false
+	}
+	e := snapshotValue("", sv)
+	e.ExpireAt = 0
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return "", false
+	}
+	b := binary.LittleEndian.AppendUint16(buf.Bytes(), dumpVersion)
+	b = binary.LittleEndian.AppendUint64(b, rdbCRC(0, b))
+	return hex.EncodeToString(b), true
+}
+
+func decodeDump(payload string) (snapshotEntry, error) {
+	var e snapshotEntry
+	b, err := hex.DecodeString(payload)
+	if err != nil || len(b) < dumpFooterSize {
+		return e, errBadPayload
+	}
+	body, footer := b[:len(b)-dumpFooterSize], b[len(b)-dumpFooterSize:]
+	if binary.LittleEndian.Uint16(footer) != dumpVersion ||
+		binary.LittleEndian.Uint64(footer[2:]) != rdbCRC(0, b[:len(b)-8]) {
+		return e, errBadPayload
+	}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&e); err != nil {
+		return e, errBadPayload
+	}
+	return e, nil
+}
+
+// restoreOptions are the modifiers of RESTORE.
+type restoreOptions struct {
+	replace bool
+	absTTL  bool
+	// idle and freq are set when IDLETIME or FREQ was given; hasIdle and
+	// hasFreq say which.
+	idle    time.Duration
+	hasIdle bool
+	freq    uint8
+	hasFreq bool
+}
+
+func parseRestoreOptions(args []string) (restoreOptions, error) {
+	var opts restoreOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			opts.replace = true
+		case "ABSTTL":
+			opts.absTTL = true
+		case "IDLETIME":
+			if i+1 == len(args) || opts.hasFreq {
+				return opts, errSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 0 {
+				return opts, errInvalidIdle
+			}
+			opts.idle, opts.hasIdle = time.Duration(n)*time.Second, true
+		case "FREQ":
+			if i+1 == len(args) || opts.hasIdle {
+				return opts, errSyntax
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 0 || n > 255 {
+				return opts, errInvalidFreq
+			}
+			opts.freq, opts.hasFreq = uint8(n), true
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// Restore creates key from a DUMP payload. ttl is relative in milliseconds,
+// or with ABSTTL a Unix time in milliseconds; zero means no expiry. A key
+// whose absolute TTL has already passed is not created, or is deleted if
+// REPLACE was given. The key is persisted as a RESTORE with an absolute TTL
+// so that replaying it does not restart the TTL.
+func (r *RedisStore) Restore(key string, ttl int64, payload string, opts restoreOptions) error {
+	if ttl < 0 {
+		return errInvalidTTL
+	}
+	e, err := decodeDump(payload)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !opts.replace && r.lookup(key) != nil {
+		return errBusyKey
+	}
+	sv, err := r.storedValue(e)
+	if err != nil {
+		return errBadPayload
+	}
+	now := r.clock.Now()
+	if ttl > 0 {
+		if opts.absTTL {
+			sv.expireAt = ttl
+		} else {
+			sv.expireAt = now.UnixMilli() + ttl
+		}
+	}
+	if sv.expired(now) {
+		delete(r.data, key)
+	} else {
+		if opts.hasIdle {
+			sv.accessed.Store(now.Add(-opts.idle).UnixNano())
+		}
+		if opts.hasFreq {
+			sv.freq = opts.freq
+		}
+		r.data[key] = sv
+	}
+	args := []string{key, "0", payload, "REPLACE"}
+	if sv.expireAt != 0 {
+		args = []string{key, strconv.FormatInt(sv.expireAt, 10), payload, "REPLACE", "ABSTTL"}
+	}
+	r.touch(key)
+	return r.writeAOF("RESTORE", args...)
+}
+
+func restoreCommand(args []string, rs *RedisStore) string {
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return formatError(errNotInteger)
+	}
+	opts, err := parseRestoreOptions(args[3:])
+	if err != nil {
+		return formatError(err)
+	}
+	if err := rs.Restore(args[0], ttl, args[2], opts); err != nil {
+		return formatError(err)
+	}
+	return "OK"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "RPUSH src a b c")
+	payload := run(rs, "DUMP src")
+	if got := run(rs, "RESTORE dst 0 "+payload); got != "OK" {
+		t.Fatalf("RESTORE = %q", got)
+	}
+	if got := run(rs, "LRANGE dst 0 -1"); got != "1) a\n2) b\n3) c" {
+		t.Errorf("LRANGE of the restored list = %q", got)
+	}
+	if got := run(rs, "RESTORE dst 0 "+payload); got != "-BUSYKEY Target key name already exists." {
+		t.Errorf("RESTORE over an existing key = %q", got)
+	}
+	if got := run(rs, "RESTORE dst 0 "+payload+" REPLACE"); got != "OK" {
+		t.Errorf("RESTORE REPLACE = %q", got)
+	}
+	if got := run(rs, "RESTORE x 0 zz"); got != "-ERR DUMP payload version or checksum are wrong" {
+		t.Errorf("RESTORE of a bad payload = %q", got)
+	}
+}
+
+func TestRestoreAbsTTLInThePast(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "SET src v")
+	payload := run(rs, "DUMP src")
+
+	past := itoa64(clk.Now().Add(-time.Second).UnixMilli())
+	if got := run(rs, "RESTORE k "+past+" "+payload+" ABSTTL"); got != "OK" {
+		t.Fatalf("RESTORE ABSTTL = %q", got)
+	}
+	if got := run(rs, "GET k"); got != "nil" {
+		t.Errorf("GET of a key restored already expired = %q, want nil", got)
+	}
+
+	future := itoa64(clk.Now().Add(time.Minute).UnixMilli())
+	run(rs, "RESTORE k "+future+" "+payload+" ABSTTL")
+	if got := run(rs, "PTTL k"); got != "60000" {
+		t.Errorf("PTTL after RESTORE ABSTTL = %q, want 60000", got)
+	}
+	if got := run(reopen(t, rs), "PTTL k"); got != "60000" {
+		t.Errorf("PTTL after reload = %q, want the absolute TTL kept", got)
+	}
+}
+
+func TestRestoreIdleTimeAndFreq(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "SET src v")
+	payload := run(rs, "DUMP src")
+
+	run(rs, "RESTORE k 0 "+payload+" IDLETIME 1000")
+	if got := run(rs, "OBJECT IDLETIME k"); got != "1000" {
+		t.Errorf("OBJECT IDLETIME = %q, want 1000", got)
+	}
+	clk.Advance(5 * time.Second)
+	if got := run(rs, "OBJECT IDLETIME k"); got != "1005" {
+		t.Errorf("OBJECT IDLETIME after 5s = %q, want 1005 as OBJECT does not touch the key", got)
+	}
+	run(rs, "GET k")
+	if got := run(rs, "OBJECT IDLETIME k"); got != "0" {
+		t.Errorf("OBJECT IDLETIME after GET = %q, want 0", got)
+	}
+
+	run(rs, "RESTORE k 0 "+payload+" REPLACE FREQ 42")
+	if got := run(rs, "OBJECT FREQ k"); got != "42" {
+		t.Errorf("OBJECT FREQ = %q, want 42", got)
+	}
+	if got := run(rs, "RESTORE k 0 "+payload+" REPLACE IDLETIME 1 FREQ 1"); got != "-ERR syntax error" {
+		t.Errorf("RESTORE with IDLETIME and FREQ = %q", got)
+	}
+}
+
+func TestRestoreRejectsCorruptPayloads(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "RPUSH src a b c")
+	b, err := hex.DecodeString(run(rs, "DUMP src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := binary.LittleEndian.Uint16(b[len(b)-10:]); v != dumpVersion {
+		t.Errorf("payload version = %d, want %d", v, dumpVersion)
+	}
+	if crc := binary.LittleEndian.Uint64(b[len(b)-8:]); crc != rdbCRC(0, b[:len(b)-8]) {
+		t.Errorf("payload CRC = %#x, want the CRC-64 of the rest", crc)
+	}
+
+	corrupt := func(i int) []byte {
+		c := slices.Clone(b)
+		c[i] ^= 0x01
+		return c
+	}
+	for name, payload := range map[string][]byte{
+		"value":     corrupt(len(b) / 2),
+		"version":   corrupt(len(b) - 10),
+		"checksum":  corrupt(len(b) - 1),
+		"truncated": b[:len(b)-1],
+		"footer":    b[len(b)-10:],
+	} {
+		if got := run(rs, "RESTORE "+name+" 0 "+hex.EncodeToString(payload)); got != "-ERR DUMP payload version or checksum are wrong" {
+			t.Errorf("RESTORE with a corrupt %s = %q", name, got)
+		}
+	}
+	if got := run(rs, "LRANGE value 0 -1"); got != "(empty array)" {
+		t.Errorf("a rejected payload created its key: %q", got)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expired reports whether the value's TTL has run out at now.
+func (sv *StoredValue) expired(now time.Time) bool {
+	return sv.expireAt != 0 && now.UnixMilli() >= sv.expireAt
+}
+
+// lookup returns the value at key, or nil if the key does not exist or has
+// expired. Expired keys are treated as absent by every read and are replaced
+// by the next write. It counts as an access of the key for OBJECT IDLETIME.
+// The caller must hold the mutex, for reading at least.
+func (r *RedisStore) lookup(key string) *StoredValue {
+	sv := r.lookupNoTouch(key)
+	if sv != nil {
+		sv.accessed.Store(r.clock.Now().UnixNano())
+	}
+	return sv
+}
+
+// lookupNoTouch is lookup for introspection commands, which should not
+// disturb the idle time they report.
+func (r *RedisStore) lookupNoTouch(key string) *StoredValue {
+	sv, exists := r.data[key]
+	if !exists {
+		return nil
+	}
+	if sv.expired(r.clock.Now()) {
+		r.queueExpired(key, sv)
+		return nil
+	}
+	return sv
+}
+
+// queueExpired records that a lookup found key expired. Reads only hold the
+// mutex for reading, so the key is deleted afterwards by reapExpired.
+func (r *RedisStore) queueExpired(key string, sv *StoredValue) {
+	if r.loading {
+		return
+	}
+	r.expiredMu.Lock()
+	defer r.expiredMu.Unlock()
+	if r.expiredKeys == nil {
+		r.expiredKeys = make(map[string]*StoredValue)
+	}
+	r.expiredKeys[key] = sv
+}
+
+// The active expiry cycle runs every activeExpireInterval, as Redis's does
+// at its default hz of 10, so that a key no one reads again is still
+// deleted. Each pass samples activeExpireSample of the keys with a TTL, and
+// another follows while more than a quarter of the sample had expired.
+const (
+	activeExpireInterval = 100 * time.Millisecond
+	activeExpireSample   = 20
+)
+
+// startActiveExpire starts the active expiry cycle on the store's clock,
+// until Close. It is started once the data is loaded.
+func (r *RedisStore) startActiveExpire() {
+	clk := r.clock
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.stopActiveExpire = sync.OnceFunc(func() {
+		close(stop)
+		<-done
+	})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-clk.After(activeExpireInterval):
+				r.activeExpireCycle()
+			}
+		}
+	}()
+}
+
+// activeExpireCycle deletes expired keys found by sampling the keys with a
+// TTL, until a sample is mostly live.
+func (r *RedisStore) activeExpireCycle() {
+	for {
+		n := r.sampleExpired(activeExpireSample)
+		r.reapExpired()
+		r.sendInvalidations()
+		if n <= activeExpireSample/4 {
+			return
+		}
+	}
+}
+
+// sampleExpired queues for reapExpired those of up to n keys with a TTL
+// that have expired, returning how many did.
+func (r *RedisStore) sampleExpired(n int) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	now := r.clock.Now()
+	expired := 0
+	for key := range r.volatile {
+		if n == 0 {
+			break
+		}
+		n--
+		if sv := r.data[key]; sv.expired(now) {
+			r.queueExpired(key, sv)
+			expired++
+		}
+	}
+	return expired
+}
+
+// reapExpired deletes the keys lookups and the active expiry cycle found
+// expired, writing a DEL to the AOF and firing an expired event for each. A
+// key is handled once: if another command already reaped it or replaced its
+// value with a new one, there is nothing to delete, though the expiry is
+// still notified when a write replaced it. The caller must hold neither the
+// mutex nor execMu.
+func (r *RedisStore) reapExpired() {
+	r.expiredMu.Lock()
+	queued := r.expiredKeys
+	r.expiredKeys = nil
+	r.expiredMu.Unlock()
+	if len(queued) == 0 {
+		return
+	}
+
+	var events []keyspaceEvent
+	r.execMu.RLock()
+	r.mutex.Lock()
+	for key, sv := range queued {
+		if sv.reaped {
+			continue
+		}
+		sv.reaped = true
+		if r.data[key] == sv {
+			delete(r.data, key)
+			r.touch(key)
+			if err := r.writeAOF("DEL", key); err != nil {
+				log.Println("error propagating the expiry of ", key, ": ", err)
+			}
+		}
+		events = append(events, keyspaceEvent{r.db, notifyExpired, "expired", key})
+	}
+	r.mutex.Unlock()
+	r.execMu.RUnlock()
+	r.publishKeyspaceEvents(events)
+}
+
+// ExpireAt sets key to expire at the given Unix time in milliseconds,
+// deleting it straight away if that time has passed. It reports whether the
+// key exists. Whichever command set the expiry, it is persisted as an
+// absolute PEXPIREAT: replaying a relative EXPIRE would restart the TTL from
+// the time of the reload.
+func (r *RedisStore) ExpireAt(key string, at int64) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(key)
+	if sv == nil {
+		return false, nil
+	}
+	if at <= r.clock.Now().UnixMilli() {
+		delete(r.data, key)
+	} else {
+		sv.expireAt = at
+	}
+	r.touch(key)
+	if err := r.writeAOF("PEXPIREAT", key, strconv.FormatInt(at, 10)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Persist removes the TTL of key, reporting whether it had one.
+func (r *RedisStore) Persist(key string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(key)
+	if sv == nil || sv.expireAt == 0 {
+		return false, nil
+	}
+	sv.expireAt = 0
+	r.touch(key)
+	if err := r.writeAOF("PERSIST", key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExpireTime returns when key expires, in Unix milliseconds, which TTL,
+// PTTL, EXPIRETIME and PEXPIRETIME all report from. Like Redis it reports
+// -2 if the key does not exist and -1 if it has no TTL, in place of a time.
+func (r *RedisStore) ExpireTime(key string) (int64, int) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookup(key)
+	if sv == nil {
+		return 0, -2
+	}
+	if sv.expireAt == 0 {
+		return 0, -1
+	}
+	return sv.expireAt, 0
+}
+
+// errInvalidExpire is the error for an expiry too far off to represent in
+// milliseconds.
+func errInvalidExpire(name string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", strings.ToLower(name))
+}
+
+// expiryMillis converts an expiry of n, in seconds or milliseconds and
+// relative to now or absolute, to the absolute milliseconds it is stored
+// as. Relative expiries are resolved against the current millisecond. It
+// reports false if the result does not fit.
+func expiryMillis(n int64, seconds, relative bool, now time.Time) (int64, bool) {
+	if seconds {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	var base int64
+	if relative {
+		base = now.UnixMilli()
+	}
+	if n > 0 && base > math.MaxInt64-n {
+		return 0, false
+	}
+	return base + n, true
+}
+
+func expireCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		if len(args) == 2 {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return formatError(errNotInteger)
+			}
+			at, ok := expiryMillis(n, cmd.Name == "EXPIRE" || cmd.Name == "EXPIREAT", cmd.Name == "EXPIRE" || cmd.Name == "PEXPIRE", rs.Now())
+			if !ok {
+				return formatError(errInvalidExpire(cmd.Name))
+			}
+			return boolReply(rs.ExpireAt(args[0], at))
+		}
+	case "PERSIST":
+		if len(args) == 1 {
+			return boolReply(rs.Persist(args[0]))
+		}
+	case "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME":
+		if len(args) == 1 {
+			at, status := rs.ExpireTime(args[0])
+			if status < 0 {
+				return strconv.Itoa(status)
+			}
+			switch cmd.Name {
+			case "TTL":
+				// Round to the nearest second, as Redis does, so that
+				// 1500ms left is 2.
+				return strconv.FormatInt((at-rs.Now().UnixMilli()+500)/1000, 10)
+			case "PTTL":
+				return strconv.FormatInt(at-rs.Now().UnixMilli(), 10)
+			case "EXPIRETIME":
+				return strconv.FormatInt(at/1000, 10)
+			}
+			return strconv.FormatInt(at, 10)
+		}
+	}
+	return ""
+}
+
+// boolReply renders a yes/no result as the integer reply 1 or 0.
+func boolReply(ok bool, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	if ok {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireAndTTL(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	run(rs, "SET k v")
+	if got := run(rs, "TTL k"); got != "-1" {
+		t.Errorf("TTL without expiry = %q, want -1", got)
+	}
+	if got := run(rs, "TTL missing"); got != "-2" {
+		t.Errorf("TTL of a missing key = %q, want -2", got)
+	}
+	if got := run(rs, "EXPIRE k 10"); got != "1" {
+		t.Fatalf("EXPIRE = %q, want 1", got)
+	}
+	if got := run(rs, "EXPIRE missing 10"); got != "0" {
+		t.Errorf("EXPIRE of a missing key = %q, want 0", got)
+	}
+
+	clk.Advance(4 * time.Second)
+	if got := run(rs, "TTL k"); got != "6" {
+		t.Errorf("TTL = %q, want 6", got)
+	}
+	if got := run(rs, "PTTL k"); got != "6000" {
+		t.Errorf("PTTL = %q, want 6000", got)
+	}
+
+	clk.Advance(6 * time.Second)
+	if got := run(rs, "GET k"); got != "nil" {
+		t.Errorf("GET of an expired key = %q, want nil", got)
+	}
+	if got := run(rs, "TTL k"); got != "-2" {
+		t.Errorf("TTL of an expired key = %q, want -2", got)
+	}
+}
+
+func TestExpireOtherTypes(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	run(rs, "RPUSH l a")
+	run(rs, "PEXPIRE l 1500")
+	run(rs, "RPUSH l b")
+	if got := run(rs, "PTTL l"); got != "1500" {
+		t.Errorf("push onto a volatile list reset its TTL: PTTL = %q", got)
+	}
+	if got := run(rs, "PERSIST l"); got != "1" {
+		t.Errorf("PERSIST = %q, want 1", got)
+	}
+	if got := run(rs, "TTL l"); got != "-1" {
+		t.Errorf("TTL after PERSIST = %q, want -1", got)
+	}
+
+	run(rs, "ZADD z 1 m")
+	run(rs, "PEXPIREAT z "+itoa64(clk.Now().Add(time.Second).UnixMilli()))
+	clk.Advance(time.Second)
+	if got := run(rs, "ZCARD z"); got != "0" {
+		t.Errorf("ZCARD of an expired zset = %q, want 0", got)
+	}
+	if got := run(rs, "ZADD z 2 n"); got != "1" {
+		t.Errorf("ZADD onto an expired key = %q, want 1", got)
+	}
+	if got := run(rs, "TTL z"); got != "-1" {
+		t.Errorf("recreated key kept the old TTL: %q", got)
+	}
+
+	run(rs, "SET s v")
+	run(rs, "EXPIRE s -1")
+	if got := run(rs, "GET s"); got != "nil" {
+		t.Errorf("GET after a negative EXPIRE = %q, want nil", got)
+	}
+}
+
+func TestExpirePersistedAsPExpireAt(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	run(rs, "SET k v")
+	run(rs, "EXPIRE k 10")
+	run(rs, "SET p v")
+	run(rs, "PEXPIRE p 60000")
+	deadline := clk.Now().Add(10 * time.Second).UnixMilli()
+
+	aof := aofText(t, rs)
+	if !strings.Contains(string(aof), "PEXPIREAT k "+itoa64(deadline)+"\n") {
+		t.Errorf("AOF lacks the absolute expiry of k:\n%s", aof)
+	}
+	if strings.Contains(string(aof), "EXPIRE k") || strings.Contains(string(aof), "PEXPIRE p") {
+		t.Errorf("AOF kept a relative expiry:\n%s", aof)
+	}
+
+	// Reloading after the deadline must not give the key a fresh TTL.
+	clk.Advance(15 * time.Second)
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "GET k"); got != "nil" {
+		t.Errorf("GET k after a delayed reload = %q, want nil", got)
+	}
+	if got := run(reloaded, "PTTL p"); got != "45000" {
+		t.Errorf("PTTL p after a delayed reload = %q, want 45000", got)
+	}
+}
+
+func TestLazyExpiryPropagatesDel(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "CONFIG SET notify-keyspace-events Ex")
+	sub, out := newTestClient(t, rs)
+	send(sub, "SUBSCRIBE __keyevent@0__:expired")
+
+	run(rs, "SET k v")
+	run(rs, "PEXPIRE k 100")
+	clk.Advance(time.Second)
+	for range 2 {
+		if got := run(rs, "GET k"); got != "nil" {
+			t.Fatalf("GET of an expired key = %q, want nil", got)
+		}
+	}
+
+	aof := aofText(t, rs)
+	if n := strings.Count(string(aof), "DEL k\n"); n != 1 {
+		t.Errorf("AOF has %d DELs for the expired key, want 1:\n%s", n, aof)
+	}
+	sub.flush()
+	event := formatArray([]string{"message", "__keyevent@0__:expired", "k"})
+	if n := strings.Count(out.String(), event); n != 1 {
+		t.Errorf("subscriber got %d expired events, want 1:\n%s", n, out)
+	}
+	rs.mutex.RLock()
+	_, present := rs.data["k"]
+	rs.mutex.RUnlock()
+	if present {
+		t.Error("expired key still in the keyspace after it was read")
+	}
+}
+
+func TestActiveExpiryDeletesUnreadKeys(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "CONFIG SET notify-keyspace-events Ex")
+	sub, out := newTestClient(t, rs)
+	send(sub, "SUBSCRIBE __keyevent@0__:expired")
+
+	// More keys expire than one sample holds, so the cycle has to go round
+	// again to get them all.
+	const n = 3 * activeExpireSample
+	for i := range n {
+		run(rs, fmt.Sprintf("SET k%d v PX 100", i))
+	}
+	run(rs, "SET live v PX 60000")
+	run(rs, "SET plain v")
+	rs.startActiveExpire()
+	waitUntil(t, func() bool { return clk.pendingTimers() > 0 })
+	clk.Advance(time.Second)
+
+	size := func() int {
+		rs.mutex.RLock()
+		defer rs.mutex.RUnlock()
+		return len(rs.data)
+	}
+	waitUntil(t, func() bool { return size() == 2 })
+	if got := run(rs, "GET live"); got != "v" {
+		t.Errorf("GET of a key yet to expire = %q, want v", got)
+	}
+	if got := strings.Count(aofText(t, rs), "DEL "); got != n {
+		t.Errorf("AOF has %d DELs, want %d", got, n)
+	}
+	sub.flush()
+	if got := strings.Count(out.String(), "1) message"); got != n {
+		t.Errorf("subscriber got %d expired events, want %d", got, n)
+	}
+}
+
+func TestLazyExpiryWithoutNotifications(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	sub, out := newTestClient(t, rs)
+	send(sub, "SUBSCRIBE __keyevent@0__:expired")
+	run(rs, "SET k v")
+	run(rs, "PEXPIRE k 100")
+	clk.Advance(time.Second)
+	run(rs, "GET k")
+	sub.flush()
+	if strings.Contains(out.String(), "message") {
+		t.Errorf("expired event published with notify-keyspace-events off:\n%s", out)
+	}
+}
+
+func TestKeyspaceEventsScopedToDB(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	// There is no SELECT, so the store is put in database 2 directly.
+	rs.db = 2
+	run(rs, "CONFIG SET notify-keyspace-events KEx")
+	db0, out0 := newTestClient(t, rs)
+	send(db0, "SUBSCRIBE __keyevent@0__:expired __keyspace@0__:k")
+	db2, out2 := newTestClient(t, rs)
+	send(db2, "SUBSCRIBE __keyevent@2__:expired __keyspace@2__:k")
+
+	run(rs, "SET k v")
+	run(rs, "PEXPIRE k 100")
+	clk.Advance(time.Second)
+	if got := run(rs, "GET k"); got != "nil" {
+		t.Fatalf("GET of an expired key = %q, want nil", got)
+	}
+	db0.flush()
+	db2.flush()
+	for _, want := range []string{
+		formatArray([]string{"message", "__keyevent@2__:expired", "k"}),
+		formatArray([]string{"message", "__keyspace@2__:k", "expired"}),
+	} {
+		if !strings.Contains(out2.String(), want) {
+			t.Errorf("db 2 subscriber did not get %q:\n%s", want, out2)
+		}
+	}
+	if strings.Contains(out0.String(), "message") {
+		t.Errorf("db 0 subscriber got an event from db 2:\n%s", out0)
+	}
+}
+
+func TestExpiryKeptInMilliseconds(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	// A clock partway through a millisecond: the expiry is resolved
+	// against the millisecond, so no fraction of one is lost or gained.
+	clk.Advance(300 * time.Microsecond)
+	start := clk.Now().UnixMilli()
+
+	run(rs, "SET k v")
+	run(rs, "PEXPIRE k 1500")
+	if got := run(rs, "TTL k"); got != "2" {
+		t.Errorf("TTL with 1500ms left = %q, want 2", got)
+	}
+	if got := run(rs, "PTTL k"); got != "1500" {
+		t.Errorf("PTTL = %q, want 1500", got)
+	}
+	if got := run(rs, "PEXPIRETIME k"); got != itoa64(start+1500) {
+		t.Errorf("PEXPIRETIME = %q, want %d", got, start+1500)
+	}
+	clk.Advance(1100 * time.Millisecond)
+	if got := run(rs, "TTL k"); got != "0" {
+		t.Errorf("TTL with 400ms left = %q, want 0", got)
+	}
+	if got := run(rs, "PTTL k"); got != "400" {
+		t.Errorf("PTTL = %q, want 400", got)
+	}
+
+	// Seconds are stored as whole milliseconds too.
+	run(rs, "SET s v")
+	run(rs, "EXPIREAT s 1704067300")
+	if got := run(rs, "PEXPIRETIME s"); got != "1704067300000" {
+		t.Errorf("PEXPIRETIME after EXPIREAT = %q", got)
+	}
+	if got := run(rs, "EXPIRETIME s"); got != "1704067300" {
+		t.Errorf("EXPIRETIME = %q", got)
+	}
+	// EXPIRETIME is a point in time, and is truncated rather than rounded.
+	run(rs, "PEXPIREAT s 1704067300999")
+	if got := run(rs, "EXPIRETIME s"); got != "1704067300" {
+		t.Errorf("EXPIRETIME 999ms past a second = %q, want 1704067300", got)
+	}
+	if got := run(rs, "EXPIRETIME missing"); got != "-2" {
+		t.Errorf("EXPIRETIME of a missing key = %q, want -2", got)
+	}
+	if got := run(rs, "EXPIRE s 9223372036854775807"); got != "-ERR invalid expire time in 'expire' command" {
+		t.Errorf("EXPIRE past the end of time = %q", got)
+	}
+}
+
+func TestNotifyFlagsRejectUnpublishedClasses(t *testing.T) {
+	rs := newTestStore(t)
+	for _, flags := range []string{"Eg", "K$", "El", "Es", "Eh", "Ez", "Ee"} {
+		if got := run(rs, "CONFIG SET notify-keyspace-events "+flags); !strings.HasPrefix(got, "-ERR Invalid argument") {
+			t.Errorf("CONFIG SET notify-keyspace-events %s = %q, want it refused", flags, got)
+		}
+	}
+	run(rs, "CONFIG SET notify-keyspace-events KEA")
+	if got := run(rs, "CONFIG GET notify-keyspace-events"); got != "1) notify-keyspace-events\n2) KEx" {
+		t.Errorf("notify-keyspace-events KEA reads back as %q", got)
+	}
+}
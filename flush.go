@@ -0,0 +1,59 @@
+package main
+
+import "strings"
+
+// Flush removes every key, and tells every tracking client to drop all it
+// cached. With async set the old keyspace is swapped out
+// and reclaimed on a background goroutine, so the flush costs the same
+// however many keys there were; otherwise it is cleared before Flush
+// returns. Either way the AOF record is written before Flush returns.
+// command is the name recorded in the AOF, FLUSHALL or FLUSHDB.
+func (r *RedisStore) Flush(command string, async bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	old := r.data
+	r.tracking.invalidateAll()
+	for key := range r.watched {
+		if _, ok := old[key]; ok {
+			r.touch(key)
+		}
+	}
+	if async {
+		r.data = make(map[string]*StoredValue)
+		go r.reclaim(old)
+	} else {
+		clear(old)
+	}
+	r.keyIndex = scanIndex{}
+	clear(r.volatile)
+	return r.writeAOF(command)
+}
+
+// reclaim drops every value in a keyspace no longer reachable from the
+// store.
+func (r *RedisStore) reclaim(old map[string]*StoredValue) {
+	if r.hook != nil {
+		r.hook.reclaiming()
+	}
+	n := len(old)
+	clear(old)
+	r.stats.lazyfreed(n)
+}
+
+// flushCommand handles FLUSHALL and FLUSHDB, which are the same while the
+// store has a single database.
+func flushCommand(cmd Command, rs *RedisStore) string {
+	async := false
+	switch {
+	case len(cmd.Args) == 0:
+	case len(cmd.Args) == 1 && strings.EqualFold(cmd.Args[0], "ASYNC"):
+		async = true
+	case len(cmd.Args) == 1 && strings.EqualFold(cmd.Args[0], "SYNC"):
+	default:
+		return formatError(errSyntax)
+	}
+	if err := rs.Flush(cmd.Name, async); err != nil {
+		return formatError(err)
+	}
+	return "OK"
+}
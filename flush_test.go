@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gateHook holds ASYNC flush reclamation until release is closed.
+type gateHook struct {
+	release chan struct{}
+}
+
+func (h *gateHook) processing(Command) {}
+func (h *gateHook) flushingAOF()       {}
+func (h *gateHook) reclaiming()        { <-h.release }
+
+func TestFlushAllAsync(t *testing.T) {
+	rs := newTestStore(t)
+	const keys = 10000
+	for i := range keys {
+		rs.Set("key:"+strconv.Itoa(i), "value")
+	}
+	hook := &gateHook{release: make(chan struct{})}
+	rs.hook = hook
+
+	// Reclamation is held back, so the reply can only arrive if the flush
+	// does not wait for it.
+	if got := run(rs, "FLUSHALL ASYNC"); got != "OK" {
+		t.Fatalf("FLUSHALL ASYNC = %q, want OK", got)
+	}
+	if got := run(rs, "GET key:0"); got != "nil" {
+		t.Errorf("GET after FLUSHALL ASYNC = %q, want nil", got)
+	}
+	if got := run(rs, "SCAN 0"); got != scanReply(0, nil) {
+		t.Errorf("SCAN after FLUSHALL ASYNC = %q, want no keys", got)
+	}
+	if strings.Contains(rs.Info("stats"), "lazyfreed_objects:"+strconv.Itoa(keys)) {
+		t.Fatal("keys were reclaimed before reclamation was released")
+	}
+
+	close(hook.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(rs.Info("stats"), "lazyfreed_objects:"+strconv.Itoa(keys)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("old keyspace not reclaimed:\n%s", rs.Info("stats"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlushDBSync(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+	run(rs, "RPUSH l x")
+	if got := run(rs, "FLUSHDB"); got != "OK" {
+		t.Fatalf("FLUSHDB = %q, want OK", got)
+	}
+	if got := run(rs, "SCAN 0"); got != scanReply(0, nil) {
+		t.Errorf("SCAN after FLUSHDB = %q, want no keys", got)
+	}
+	if got := run(rs, "FLUSHDB LATER"); got != formatError(errSyntax) {
+		t.Errorf("FLUSHDB LATER = %q, want a syntax error", got)
+	}
+}
+
+func TestFlushAllAsyncIsPersisted(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+	run(rs, "FLUSHALL ASYNC")
+	run(rs, "SET b 2")
+	rs = reopen(t, rs)
+	if got := run(rs, "GET a"); got != "nil" {
+		t.Errorf("GET a after reload = %q, want the flush replayed", got)
+	}
+	if got := run(rs, "GET b"); got != "2" {
+		t.Errorf("GET b after reload = %q, want 2", got)
+	}
+}
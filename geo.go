@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Positions are stored as sorted set scores holding a 52-bit geohash, 26
+// bits each of latitude and longitude interleaved, exactly as Redis does, so
+// GEO keys are ordinary sorted sets.
+const (
+	geoStep      = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	earthRadiusM = 6372797.560856
+)
+
+var errUnsupportedUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+// geoUnits converts each distance unit to meters.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(s string) (float64, error) {
+	unit, ok := geoUnits[strings.ToLower(s)]
+	if !ok {
+		return 0, errUnsupportedUnit
+	}
+	return unit, nil
+}
+
+// spread moves the low 32 bits of v into the even bit positions.
+func spread(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash is the inverse of spread, gathering the even bits of v.
+func squash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return v
+}
+
+func validLonLat(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+func geohashEncode(lon, lat float64) uint64 {
+	latOffset := (lat - geoLatMin) / (geoLatMax - geoLatMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	latBits := uint64(latOffset * (1 << geoStep))
+	lonBits := uint64(lonOffset * (1 << geoStep))
+	// The top of each range would overflow into the next bit.
+	latBits = min(latBits, 1<<geoStep-1)
+	lonBits = min(lonBits, 1<<geoStep-1)
+	return spread(latBits) | spread(lonBits)<<1
+}
+
+// geohashDecode returns the center of the cell a geohash names.
+func geohashDecode(hash uint64) (lon, lat float64) {
+	latBits, lonBits := squash(hash), squash(hash>>1)
+	cell := float64(uint64(1) << geoStep)
+	latScale, lonScale := geoLatMax-geoLatMin, geoLonMax-geoLonMin
+	lat = geoLatMin + (float64(latBits)+0.5)/cell*latScale
+	lon = geoLonMin + (float64(lonBits)+0.5)/cell*lonScale
+	return max(min(lon, geoLonMax), geoLonMin), max(min(lat, geoLatMax), geoLatMin)
+}
+
+// geoDistance is the haversine distance in meters between two points.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := lat1*math.Pi/180, lat2*math.Pi/180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+type geoPoint struct {
+	member   string
+	lon, lat float64
+}
+
+// GeoAdd adds or moves members to the given positions, returning how many
+// were new.
+func (r *RedisStore) GeoAdd(key string, points []geoPoint) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	z, err := r.zsetForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	args := []string{key}
+	for _, p := range points {
+		if _, exists := z.score(p.member); !exists {
+			added++
+		}
+		z.add(p.member, float64(geohashEncode(p.lon, p.lat)))
+		args = append(args, formatScore(p.lon), formatScore(p.lat), p.member)
+	}
+	z.convert(r.config.ZSetMaxListpackEntries, r.config.ZSetMaxListpackValue)
+	r.touch(key)
+	if err := r.writeAOF("GEOADD", args...); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// GeoPos returns the position of each member, nil for those not in the set.
+func (r *RedisStore) GeoPos(key string, members []string) ([]*geoPoint, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*geoPoint, len(members))
+	for i, member := range members {
+		if z == nil {
+			continue
+		}
+		if score, ok := z.score(member); ok {
+			lon, lat := geohashDecode(uint64(score))
+			points[i] = &geoPoint{member, lon, lat}
+		}
+	}
+	return points, nil
+}
+
+// GeoDist returns the distance in meters between two members, and false if
+// either is missing.
+func (r *RedisStore) GeoDist(key, m1, m2 string) (float64, bool, error) {
+	points, err := r.GeoPos(key, []string{m1, m2})
+	if err != nil || points[0] == nil || points[1] == nil {
+		return 0, false, err
+	}
+	return geoDistance(points[0].lon, points[0].lat, points[1].lon, points[1].lat), true, nil
+}
+
+// geoSearch are the parsed options of GEOSEARCH.
+type geoSearch struct {
+	fromMember string
+	lon, lat   float64
+	radius     float64 // in meters
+	unit       float64
+	desc       bool
+	count      int
+	withDist   bool
+	withCoord  bool
+}
+
+type geoResult struct {
+	geoPoint
+	dist float64
+}
+
+// GeoSearch returns the members within the search radius, nearest first
+// unless DESC was given. It checks every member of the set: without geohash
+// box indexing a search is linear in the size of the key.
+func (r *RedisStore) GeoSearch(key string, s geoSearch) ([]geoResult, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	lon, lat := s.lon, s.lat
+	if s.fromMember != "" {
+		score, ok := z.score(s.fromMember)
+		if !ok {
+			return nil, errors.New("ERR could not decode requested zset member")
+		}
+		lon, lat = geohashDecode(uint64(score))
+	}
+	var results []geoResult
+	for _, e := range z.entries {
+		mlon, mlat := geohashDecode(uint64(e.score))
+		if d := geoDistance(lon, lat, mlon, mlat); d <= s.radius {
+			results = append(results, geoResult{geoPoint{e.member, mlon, mlat}, d})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b geoResult) int {
+		if s.desc {
+			a, b = b, a
+		}
+		switch {
+		case a.dist < b.dist:
+			return -1
+		case a.dist > b.dist:
+			return 1
+		}
+		return 0
+	})
+	if s.count > 0 && len(results) > s.count {
+		results = results[:s.count]
+	}
+	return results, nil
+}
+
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lon) || math.IsNaN(lat) {
+		return 0, 0, errNotFloat
+	}
+	if !validLonLat(lon, lat) {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// parseGeoSearch parses the GEOSEARCH arguments after the key: FROMMEMBER or
+// FROMLONLAT, then BYRADIUS radius unit, with optional ASC|DESC, COUNT n,
+// WITHDIST and WITHCOORD.
+func parseGeoSearch(args []string) (geoSearch, error) {
+	s := geoSearch{unit: 1}
+	hasFrom, hasBy := false, false
+	for i := 0; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if left < 1 || hasFrom {
+				return s, errSyntax
+			}
+			s.fromMember, hasFrom = args[i+1], true
+			i++
+		case "FROMLONLAT":
+			if left < 2 || hasFrom {
+				return s, errSyntax
+			}
+			lon, lat, err := parseLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return s, err
+			}
+			s.lon, s.lat, hasFrom = lon, lat, true
+			i += 2
+		case "BYRADIUS":
+			if left < 2 || hasBy {
+				return s, errSyntax
+			}
+			radius, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || radius < 0 {
+				return s, errors.New("ERR radius cannot be negative")
+			}
+			unit, err := parseGeoUnit(args[i+2])
+			if err != nil {
+				return s, err
+			}
+			s.radius, s.unit, hasBy = radius*unit, unit, true
+			i += 2
+		case "ASC":
+			s.desc = false
+		case "DESC":
+			s.desc = true
+		case "COUNT":
+			if left < 1 {
+				return s, errSyntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return s, errors.New("ERR COUNT must be > 0")
+			}
+			s.count = n
+			i++
+		case "WITHDIST":
+			s.withDist = true
+		case "WITHCOORD":
+			s.withCoord = true
+		default:
+			return s, errSyntax
+		}
+	}
+	if !hasFrom {
+		return s, errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !hasBy {
+		return s, errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	return s, nil
+}
+
+func formatDist(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+func formatCoord(p geoPoint) string {
+	return formatArray([]string{formatScore(p.lon), formatScore(p.lat)})
+}
+
+func geoCommand(cmd Command, rs *RedisStore) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "GEOADD":
+		if len(args) >= 4 && (len(args)-1)%3 == 0 {
+			points := make([]geoPoint, 0, len(args)/3)
+			for i := 1; i < len(args); i += 3 {
+				lon, lat, err := parseLonLat(args[i], args[i+1])
+				if err != nil {
+					return formatError(err)
+				}
+				points = append(points, geoPoint{args[i+2], lon, lat})
+			}
+			n, err := rs.GeoAdd(args[0], points)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "GEOPOS":
+		if len(args) >= 1 {
+			points, err := rs.GeoPos(args[0], args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			items := make([]string, len(points))
+			for i, p := range points {
+				items[i] = "nil"
+				if p != nil {
+					items[i] = formatCoord(*p)
+				}
+			}
+			return formatArray(items)
+		}
+	case "GEODIST":
+		if len(args) == 3 || len(args) == 4 {
+			unit := 1.0
+			if len(args) == 4 {
+				var err error
+				if unit, err = parseGeoUnit(args[3]); err != nil {
+					return formatError(err)
+				}
+			}
+			d, ok, err := rs.GeoDist(args[0], args[1], args[2])
+			if err != nil {
+				return formatError(err)
+			}
+			if !ok {
+				return "nil"
+			}
+			return formatDist(d, unit)
+		}
+	case "GEOSEARCH":
+		if len(args) >= 1 {
+			s, err := parseGeoSearch(args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			results, err := rs.GeoSearch(args[0], s)
+			if err != nil {
+				return formatError(err)
+			}
+			items := make([]string, len(results))
+			for i, res := range results {
+				if !s.withDist && !s.withCoord {
+					items[i] = res.member
+					continue
+				}
+				item := []string{res.member}
+				if s.withDist {
+					item = append(item, formatDist(res.dist, s.unit))
+				}
+				if s.withCoord {
+					item = append(item, formatCoord(res.geoPoint))
+				}
+				items[i] = formatArray(item)
+			}
+			return formatArray(items)
+		}
+	}
+	return ""
+}
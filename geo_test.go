@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestGeoDist(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania"); got != "2" {
+		t.Fatalf("GEOADD = %q", got)
+	}
+	// Redis reports 166274.1516 m for the same pair.
+	d, err := strconv.ParseFloat(run(rs, "GEODIST Sicily Palermo Catania"), 64)
+	if err != nil || d < 166200 || d > 166350 {
+		t.Errorf("GEODIST Palermo Catania = %v (%v), want about 166274 m", d, err)
+	}
+	if got := run(rs, "GEODIST Sicily Palermo Catania km"); got != strconv.FormatFloat(d/1000, 'f', 4, 64) {
+		t.Errorf("GEODIST in km = %q", got)
+	}
+	if got := run(rs, "GEODIST Sicily Palermo Nowhere"); got != "nil" {
+		t.Errorf("GEODIST with a missing member = %q", got)
+	}
+	if got := run(rs, "ZCARD Sicily"); got != "2" {
+		t.Errorf("ZCARD of a GEO key = %q, want it to be a sorted set", got)
+	}
+}
+
+func TestGeoPos(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "GEOADD Sicily 13.361389 38.115556 Palermo")
+	items := rs.mustGeoPos(t, "Sicily", "Palermo")
+	if lon, lat := items.lon, items.lat; lon < 13.3613 || lon > 13.3615 || lat < 38.1155 || lat > 38.1156 {
+		t.Errorf("GEOPOS Palermo = %v,%v, want about 13.361389,38.115556", lon, lat)
+	}
+	if got := run(rs, "GEOPOS Sicily Palermo Nowhere"); got[len(got)-6:] != "2) nil" {
+		t.Errorf("GEOPOS with a missing member = %q", got)
+	}
+}
+
+func (r *RedisStore) mustGeoPos(t *testing.T, key, member string) geoPoint {
+	t.Helper()
+	points, err := r.GeoPos(key, []string{member})
+	if err != nil || points[0] == nil {
+		t.Fatalf("GeoPos(%q) = %v, %v", member, points, err)
+	}
+	return *points[0]
+}
+
+func TestGeoSearchByRadius(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania 12.758489 38.788135 edge1 2.349014 48.864716 Paris")
+	if got := run(rs, "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 200 km ASC"); got != "1) Palermo\n2) edge1\n3) Catania" {
+		t.Errorf("GEOSEARCH 200 km = %q", got)
+	}
+	if got := run(rs, "GEOSEARCH Sicily FROMMEMBER Palermo BYRADIUS 200 km DESC COUNT 1"); got != "1) Catania" {
+		t.Errorf("GEOSEARCH DESC COUNT 1 = %q", got)
+	}
+	if got := run(rs, "GEOSEARCH Sicily FROMLONLAT 15 37 BYRADIUS 100 km WITHDIST"); got != "1) 1) Catania\n   2) 56.4413" {
+		t.Errorf("GEOSEARCH FROMLONLAT WITHDIST = %q", got)
+	}
+}
+
+func TestGeoAddValidatesCoordinates(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "GEOADD k 200 10 x"); got != "-ERR invalid longitude,latitude pair 200.000000,10.000000" {
+		t.Errorf("GEOADD with a bad longitude = %q", got)
+	}
+	if got := run(rs, "GEOADD k 10 86 x"); got != "-ERR invalid longitude,latitude pair 10.000000,86.000000" {
+		t.Errorf("GEOADD with a bad latitude = %q", got)
+	}
+	if got := run(rs, "ZCARD k"); got != "0" {
+		t.Errorf("ZCARD after GEOADD with bad coordinates = %q, want no key", got)
+	}
+	if got := run(rs, "GEODIST k a b parsecs"); got != "-ERR unsupported unit provided. please use M, KM, FT, MI" {
+		t.Errorf("GEODIST with a bad unit = %q", got)
+	}
+}
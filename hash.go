@@ -0,0 +1,281 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+)
+
+type hashField struct {
+	field string
+	value string
+}
+
+// hashValue is a hash value. Small hashes use the listpack encoding: the
+// fields in insertion order, searched linearly. Once a hash grows past the
+// configured limits it converts to the hashtable encoding, a map from field
+// to value, along with an index of the fields for HSCAN. Like Redis, a hash
+// never converts back.
+type hashValue struct {
+	pairs []hashField
+	dict  map[string]string
+	index *scanIndex
+}
+
+func (h *hashValue) encoding() string {
+	if h.dict != nil {
+		return "hashtable"
+	}
+	return "listpack"
+}
+
+func (h *hashValue) len() int {
+	if h.dict != nil {
+		return len(h.dict)
+	}
+	return len(h.pairs)
+}
+
+func (h *hashValue) get(field string) (string, bool) {
+	if h.dict != nil {
+		v, ok := h.dict[field]
+		return v, ok
+	}
+	for _, p := range h.pairs {
+		if p.field == field {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// set stores value under field, reporting whether the field is new.
+func (h *hashValue) set(field, value string) bool {
+	if h.dict != nil {
+		_, exists := h.dict[field]
+		h.dict[field] = value
+		if !exists {
+			h.index.add(field)
+		}
+		return !exists
+	}
+	for i, p := range h.pairs {
+		if p.field == field {
+			h.pairs[i].value = value
+			return false
+		}
+	}
+	h.pairs = append(h.pairs, hashField{field, value})
+	return true
+}
+
+func (h *hashValue) remove(field string) bool {
+	if h.dict != nil {
+		_, exists := h.dict[field]
+		delete(h.dict, field)
+		h.index.remove(field)
+		return exists
+	}
+	for i, p := range h.pairs {
+		if p.field == field {
+			h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns every field and value, in insertion order for a listpack
+// and in no particular order for a hashtable.
+func (h *hashValue) fields() []hashField {
+	if h.dict == nil {
+		return slices.Clone(h.pairs)
+	}
+	fields := make([]hashField, 0, len(h.dict))
+	for f, v := range h.dict {
+		fields = append(fields, hashField{f, v})
+	}
+	return fields
+}
+
+// convert switches a listpack hash to the hashtable encoding once it holds
+// more than maxEntries fields or a field or value longer than maxValue bytes.
+func (h *hashValue) convert(maxEntries, maxValue int) {
+	if h.dict != nil {
+		return
+	}
+	fits := len(h.pairs) <= maxEntries
+	for _, p := range h.pairs {
+		fits = fits && len(p.field) <= maxValue && len(p.value) <= maxValue
+	}
+	if fits {
+		return
+	}
+	h.dict = make(map[string]string, len(h.pairs))
+	h.index = &scanIndex{}
+	for _, p := range h.pairs {
+		h.dict[p.field] = p.value
+		h.index.add(p.field)
+	}
+	h.pairs = nil
+}
+
+// getHash returns the hash at key, nil if the key does not exist, or
+// errWrongType if it holds another type. The caller must hold the mutex.
+func (r *RedisStore) getHash(key string) (*hashValue, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	h, ok := sv.value.(*hashValue)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+// HSet sets each field to its value, returning how many fields were new.
+func (r *RedisStore) HSet(key string, pairs []hashField) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, err := r.getHash(key)
+	if err != nil {
+		return 0, err
+	}
+	if h == nil {
+		h = &hashValue{}
+		r.data[key] = r.newValue(h)
+	}
+	added := 0
+	args := []string{key}
+	for _, p := range pairs {
+		if h.set(p.field, p.value) {
+			added++
+		}
+		args = append(args, p.field, p.value)
+	}
+	h.convert(r.config.HashMaxListpackEntries, r.config.HashMaxListpackValue)
+	r.touch(key)
+	if err := r.writeAOF("HSET", args...); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+func (r *RedisStore) HGet(key, field string) (string, bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	h, err := r.getHash(key)
+	r.stats.keyspaceRead(h != nil || err != nil)
+	if err != nil || h == nil {
+		return "", false, err
+	}
+	v, ok := h.get(field)
+	return v, ok, nil
+}
+
+// HDel removes fields from the hash at key, returning how many were present.
+func (r *RedisStore) HDel(key string, fields []string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, err := r.getHash(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range fields {
+		if h.remove(f) {
+			removed++
+		}
+	}
+	if h.len() == 0 {
+		delete(r.data, key)
+	}
+	if removed > 0 {
+		r.touch(key)
+		if err := r.writeAOF("HDEL", append([]string{key}, fields...)...); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+func (r *RedisStore) HLen(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	h, err := r.getHash(key)
+	r.stats.keyspaceRead(h != nil || err != nil)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	return h.len(), nil
+}
+
+// HGetAll returns every field of the hash at key followed by its value.
+func (r *RedisStore) HGetAll(key string) ([]string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	h, err := r.getHash(key)
+	r.stats.keyspaceRead(h != nil || err != nil)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	var reply []string
+	for _, p := range h.fields() {
+		reply = append(reply, p.field, p.value)
+	}
+	return reply, nil
+}
+
+func hashCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "HSET":
+		if len(args) >= 3 && len(args)%2 == 1 {
+			pairs := make([]hashField, 0, len(args)/2)
+			for i := 1; i < len(args); i += 2 {
+				pairs = append(pairs, hashField{args[i], args[i+1]})
+			}
+			n, err := rs.HSet(args[0], pairs)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "HGET":
+		if len(args) == 2 {
+			v, ok, err := rs.HGet(args[0], args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			if !ok {
+				return "nil"
+			}
+			return bulkReply(v)
+		}
+	case "HDEL":
+		if len(args) >= 2 {
+			n, err := rs.HDel(args[0], args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "HLEN":
+		if len(args) == 1 {
+			n, err := rs.HLen(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "HGETALL":
+		if len(args) == 1 {
+			reply, err := rs.HGetAll(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return formatArray(reply)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	rs := newTestStore(t)
+	tests := []struct{ cmd, want string }{
+		{"HSET h a 1 b 2", "2"},
+		{"HSET h a 3", "0"},
+		{"HGET h a", "3"},
+		{"HGET h missing", "nil"},
+		{"HLEN h", "2"},
+		{"HGETALL h", formatArray([]string{"a", "3", "b", "2"})},
+		{"HDEL h a missing", "1"},
+		{"HDEL h b", "1"},
+		{"HLEN h", "0"},
+		{"SET s x", "OK"},
+		{"HGET s a", formatError(errWrongType)},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestHashEncodingTransition(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "HSET h f short")
+	if got := run(rs, "OBJECT ENCODING h"); got != "listpack" {
+		t.Fatalf("OBJECT ENCODING = %q, want listpack", got)
+	}
+	run(rs, "HSET h f "+strings.Repeat("x", 65))
+	if got := run(rs, "OBJECT ENCODING h"); got != "hashtable" {
+		t.Fatalf("OBJECT ENCODING = %q after a long value, want hashtable", got)
+	}
+	run(rs, "HSET h f short")
+	if got := run(rs, "OBJECT ENCODING h"); got != "hashtable" {
+		t.Errorf("OBJECT ENCODING = %q, want hashtable to stick", got)
+	}
+}
+
+func TestHashSurvivesRewriteAndSnapshot(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "HSET h a 1 b 2")
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Save(); err != nil {
+		t.Fatal(err)
+	}
+	rs = reopen(t, rs)
+	if got := run(rs, "HGETALL h"); got != formatArray([]string{"a", "1", "b", "2"}) {
+		t.Errorf("HGETALL after reload = %q", got)
+	}
+}
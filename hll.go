@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// HyperLogLogs use Redis's parameters: 2^14 six-bit registers indexed by
+// the low bits of a 64-bit MurmurHash64A of each element, giving a standard
+// error of 0.81%.
+const (
+	hllP         = 14
+	hllRegisters = 1 << hllP
+	hllMaxValue  = 64 - hllP + 1
+	hllDenseSize = hllRegisters * 6 / 8
+	hllHeader    = "HYLL"
+	hllDense     = 0
+	hllSparse    = 1
+	// Sparse opcodes, as in Redis: ZERO is 00xxxxxx for a run of 1-64 zero
+	// registers, XZERO is 01xxxxxx yyyyyyyy for up to 16384 and VAL is
+	// 1vvvvvxx for 1-4 registers set to 1-32.
+	hllSparseValMax = 32
+	hllZeroMax      = 64
+	hllXZeroMax     = 16384
+	hllValRunMax    = 4
+)
+
+var errNotHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+// hyperLogLog is a sketch decoded into one byte per register. dense records
+// whether it was stored densely, since a sketch never goes back to sparse.
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+	dense     bool
+}
+
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// add records elem, reporting whether a register changed.
+func (h *hyperLogLog) add(elem string) bool {
+	hash := murmurHash64A([]byte(elem), 0xadc83b19)
+	index := hash & (hllRegisters - 1)
+	// The run of zeros after the index bits, plus one; the sentinel bit
+	// caps it at hllMaxValue.
+	count := uint8(bits.TrailingZeros64(hash>>hllP|1<<(64-hllP))) + 1
+	if count > h.registers[index] {
+		h.registers[index] = count
+		return true
+	}
+	return false
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, v := range other.registers {
+		h.registers[i] = max(h.registers[i], v)
+	}
+	h.dense = h.dense || other.dense
+}
+
+// count estimates the number of distinct elements added, falling back to
+// linear counting while many registers are still empty.
+func (h *hyperLogLog) count() int64 {
+	var sum float64
+	zeros := 0
+	for _, v := range h.registers {
+		sum += math.Ldexp(1, -int(v))
+		if v == 0 {
+			zeros++
+		}
+	}
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// encode returns the stored form of the sketch: the Redis-style header and
+// registers, sparse if they fit in maxSparse bytes, base64 encoded so the
+// value is a single word on the wire and in the AOF.
+func (h *hyperLogLog) encode(maxSparse int) string {
+	var payload []byte
+	if !h.dense {
+		payload = h.sparse(maxSparse)
+	}
+	enc := byte(hllSparse)
+	if payload == nil {
+		enc = hllDense
+		payload = make([]byte, hllDenseSize)
+		for i, v := range h.registers {
+			bit := i * 6
+			payload[bit/8] |= v << (bit % 8)
+			if bit%8 > 2 {
+				payload[bit/8+1] |= v >> (8 - bit%8)
+			}
+		}
+	}
+	raw := append([]byte(hllHeader), enc, 0, 0, 0)
+	return base64.RawStdEncoding.EncodeToString(append(raw, payload...))
+}
+
+// sparse returns the registers in the sparse encoding, or nil if a register
+// is too large for it or the result would exceed maxBytes.
+func (h *hyperLogLog) sparse(maxBytes int) []byte {
+	var out []byte
+	for i := 0; i < hllRegisters; {
+		v := h.registers[i]
+		run := 1
+		for i+run < hllRegisters && h.registers[i+run] == v {
+			run++
+		}
+		i += run
+		switch {
+		case v > hllSparseValMax:
+			return nil
+		case v == 0:
+			for ; run > hllZeroMax; run -= min(run, hllXZeroMax) {
+				n := min(run, hllXZeroMax) - 1
+				out = append(out, 0x40|byte(n>>8), byte(n))
+			}
+			if run > 0 {
+				out = append(out, byte(run-1))
+			}
+		default:
+			for ; run > 0; run -= min(run, hllValRunMax) {
+				out = append(out, 0x80|(v-1)<<2|byte(min(run, hllValRunMax)-1))
+			}
+		}
+		if len(out) > maxBytes {
+			return nil
+		}
+	}
+	return out
+}
+
+func decodeHLL(s string) (*hyperLogLog, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil || len(raw) < len(hllHeader)+4 || string(raw[:len(hllHeader)]) != hllHeader {
+		return nil, errNotHLL
+	}
+	enc, payload := raw[len(hllHeader)], raw[len(hllHeader)+4:]
+	h := &hyperLogLog{}
+	switch enc {
+	case hllDense:
+		if len(payload) != hllDenseSize {
+			return nil, errNotHLL
+		}
+		h.dense = true
+		for i := range h.registers {
+			bit := i * 6
+			v := payload[bit/8] >> (bit % 8)
+			if bit%8 > 2 {
+				v |= payload[bit/8+1] << (8 - bit%8)
+			}
+			h.registers[i] = v & 63
+		}
+	case hllSparse:
+		i := 0
+		for p := 0; p < len(payload); p++ {
+			op := payload[p]
+			var v uint8
+			var run int
+			switch {
+			case op&0xc0 == 0:
+				run = int(op&0x3f) + 1
+			case op&0xc0 == 0x40:
+				if p+1 == len(payload) {
+					return nil, errNotHLL
+				}
+				p++
+				run = int(op&0x3f)<<8 | int(payload[p]) + 1
+			default:
+				v, run = (op>>2)&0x1f+1, int(op&3)+1
+			}
+			if i+run > hllRegisters {
+				return nil, errNotHLL
+			}
+			for ; run > 0; run-- {
+				h.registers[i] = v
+				i++
+			}
+		}
+		if i != hllRegisters {
+			return nil, errNotHLL
+		}
+	default:
+		return nil, errNotHLL
+	}
+	for _, v := range h.registers {
+		if v > hllMaxValue {
+			return nil, errNotHLL
+		}
+	}
+	return h, nil
+}
+
+// getHLL returns the sketch stored at key, nil if the key does not exist, or
+// an error if it holds anything else. The caller must hold the mutex.
+func (r *RedisStore) getHLL(key string) (*hyperLogLog, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	s, ok := stringValue(sv.value)
+	if !ok {
+		return nil, errWrongType
+	}
+	return decodeHLL(s)
+}
+
+// putHLL stores h at key, keeping the key's TTL. The caller must hold the
+// mutex.
+func (r *RedisStore) putHLL(key string, h *hyperLogLog) {
+	r.putString(key, h.encode(r.config.HLLSparseMaxBytes))
+}
+
+// PFAdd adds elems to the HyperLogLog at key, creating it if needed. It
+// reports whether the estimate may have changed.
+func (r *RedisStore) PFAdd(key string, elems []string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, err := r.getHLL(key)
+	if err != nil {
+		return false, err
+	}
+	changed := h == nil
+	if h == nil {
+		h = &hyperLogLog{}
+	}
+	for _, elem := range elems {
+		if h.add(elem) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	r.putHLL(key, h)
+	r.touch(key)
+	if err := r.writeAOF("PFADD", append([]string{key}, elems...)...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PFCount estimates the number of distinct elements in the union of the
+// HyperLogLogs at keys. Missing keys count as empty.
+func (r *RedisStore) PFCount(keys []string) (int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	union := &hyperLogLog{}
+	for _, key := range keys {
+		h, err := r.getHLL(key)
+		if err != nil {
+			return 0, err
+		}
+		if h != nil {
+			union.merge(h)
+		}
+	}
+	return union.count(), nil
+}
+
+// PFMerge stores the union of dest and the HyperLogLogs at srcs in dest.
+func (r *RedisStore) PFMerge(dest string, srcs []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	union := &hyperLogLog{}
+	for _, key := range append([]string{dest}, srcs...) {
+		h, err := r.getHLL(key)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			union.merge(h)
+		}
+	}
+	r.putHLL(dest, union)
+	r.touch(dest)
+	return r.writeAOF("PFMERGE", append([]string{dest}, srcs...)...)
+}
+
+func hllCommand(cmd Command, rs *RedisStore) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "PFADD":
+		if len(args) >= 1 {
+			return boolReply(rs.PFAdd(args[0], args[1:]))
+		}
+	case "PFCOUNT":
+		if len(args) >= 1 {
+			n, err := rs.PFCount(args)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.FormatInt(n, 10)
+		}
+	case "PFMERGE":
+		if len(args) >= 1 {
+			if err := rs.PFMerge(args[0], args[1:]); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pfadd adds elements prefix0 ... prefix(n-1) to key in batches.
+func pfadd(rs *RedisStore, key, prefix string, n int) {
+	for start := 0; start < n; start += 1000 {
+		var b strings.Builder
+		b.WriteString("PFADD " + key)
+		for i := start; i < min(start+1000, n); i++ {
+			b.WriteString(" " + prefix + strconv.Itoa(i))
+		}
+		run(rs, b.String())
+	}
+}
+
+func within(t *testing.T, what string, got string, want int, tolerance float64) {
+	t.Helper()
+	n, err := strconv.Atoi(got)
+	if err != nil {
+		t.Fatalf("%s = %q", what, got)
+	}
+	if diff := float64(n-want) / float64(want); diff > tolerance || diff < -tolerance {
+		t.Errorf("%s = %d, want %d within %.0f%%", what, n, want, tolerance*100)
+	}
+}
+
+func TestPFCountEstimate(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "PFADD hll a b c"); got != "1" {
+		t.Errorf("PFADD of new elements = %q, want 1", got)
+	}
+	if got := run(rs, "PFADD hll a b"); got != "0" {
+		t.Errorf("PFADD of seen elements = %q, want 0", got)
+	}
+	if got := run(rs, "PFCOUNT hll"); got != "3" {
+		t.Errorf("PFCOUNT of 3 elements = %q", got)
+	}
+
+	pfadd(rs, "big", "elem:", 100000)
+	within(t, "PFCOUNT of 100000 elements", run(rs, "PFCOUNT big"), 100000, 0.03)
+	if got := run(rs, "OBJECT ENCODING big"); got != "raw" {
+		t.Errorf("OBJECT ENCODING of a HyperLogLog = %q, want raw", got)
+	}
+	if got := run(reopen(t, rs), "PFCOUNT big"); got != run(rs, "PFCOUNT big") {
+		t.Errorf("PFCOUNT after reload = %q", got)
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	rs := newTestStore(t)
+	pfadd(rs, "a", "x", 3000)
+	pfadd(rs, "b", "x", 5000)
+	if got := run(rs, "PFMERGE c a b"); got != "OK" {
+		t.Fatalf("PFMERGE = %q", got)
+	}
+	within(t, "PFCOUNT of the merge", run(rs, "PFCOUNT c"), 5000, 0.03)
+	within(t, "PFCOUNT of a union", run(rs, "PFCOUNT a b"), 5000, 0.03)
+}
+
+func TestHLLSparseToDense(t *testing.T) {
+	rs := newTestStore(t)
+	pfadd(rs, "small", "e", 100)
+	small, _, _ := rs.Get("small")
+	h, err := decodeHLL(small)
+	if err != nil || h.dense {
+		t.Fatalf("100 elements: dense=%v err=%v, want sparse", h != nil && h.dense, err)
+	}
+	pfadd(rs, "small", "f", 5000)
+	large, _, _ := rs.Get("small")
+	if h, err := decodeHLL(large); err != nil || !h.dense {
+		t.Fatalf("5100 elements: err=%v, want dense", err)
+	}
+	within(t, "PFCOUNT after converting to dense", run(rs, "PFCOUNT small"), 5100, 0.03)
+}
+
+func TestPFAddWrongType(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET s hello")
+	if got := run(rs, "PFADD s a"); got != "-WRONGTYPE Key is not a valid HyperLogLog string value." {
+		t.Errorf("PFADD on a plain string = %q", got)
+	}
+	run(rs, "RPUSH l a")
+	if got := run(rs, "PFCOUNT l"); got != formatError(errWrongType) {
+		t.Errorf("PFCOUNT on a list = %q", got)
+	}
+}
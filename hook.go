@@ -0,0 +1,15 @@
+package main
+
+// testHook lets tests observe or intervene in command processing, for
+// example advancing a fake clock to simulate a slow command or a slow disk
+// without real sleeps. Only tests install one; RedisStore.hook is nil in
+// production and every call site checks for that.
+type testHook interface {
+	// processing runs just before cmd executes.
+	processing(cmd Command)
+	// flushingAOF runs before each AOF write is flushed to disk.
+	flushingAOF()
+	// reclaiming runs on the background goroutine before the keyspace
+	// dropped by an ASYNC flush is reclaimed.
+	reclaiming()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// infoSection is one "# Name" block of the INFO reply. fields returns its
+// "name:value" lines and is called with the mutex held for reading. Sections
+// marked extra are only shown when asked for by name or with "all".
+type infoSection struct {
+	name   string
+	fields func(r *RedisStore) []string
+	extra  bool
+}
+
+var infoSections = []infoSection{
+	{name: "memory", fields: memoryInfo},
+	{name: "persistence", fields: persistenceInfo},
+	{name: "stats", fields: statsInfo},
+	{name: "replication", fields: replicationInfo},
+	{name: "commandstats", fields: commandStatsInfo, extra: true},
+	{name: "latencystats", fields: latencyStatsInfo, extra: true},
+}
+
+func persistenceInfo(r *RedisStore) []string {
+	status := "ok"
+	if r.aofErr != nil || r.aofSync.status() != nil {
+		status = "err"
+	}
+	return []string{
+		"aof_enabled:1",
+		fmt.Sprintf("aof_rewrite_in_progress:%d", boolInt(r.rewriteBuf != nil)),
+		"aof_last_write_status:" + status,
+		fmt.Sprintf("aof_current_size:%d", r.aofSize),
+		fmt.Sprintf("aof_base_size:%d", r.aofBaseSize),
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Info renders the named INFO section. An empty section or "default" selects
+// the default sections, and "all" or "everything" selects every one.
+func (r *RedisStore) Info(section string) string {
+	section = strings.ToLower(section)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var blocks []string
+	for _, s := range infoSections {
+		switch section {
+		case "all", "everything", s.name:
+		case "", "default":
+			if s.extra {
+				continue
+			}
+		default:
+			continue
+		}
+		title := "# " + strings.ToUpper(s.name[:1]) + s.name[1:]
+		blocks = append(blocks, strings.Join(append([]string{title}, s.fields(r)...), "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
@@ -0,0 +1,59 @@
+package main
+
+// indexKey brings keyIndex and volatile up to date with key. The caller
+// must hold the mutex.
+func (r *RedisStore) indexKey(key string) {
+	sv, ok := r.data[key]
+	if ok {
+		r.keyIndex.add(key)
+	} else {
+		r.keyIndex.remove(key)
+	}
+	if ok && sv.expireAt != 0 {
+		r.volatile[key] = struct{}{}
+	} else {
+		delete(r.volatile, key)
+	}
+}
+
+// randomKeyTries is how many keys RandomKey samples before it stops
+// trusting that most have not expired.
+const randomKeyTries = 100
+
+// RandomKey returns a random key, or false if the keyspace is empty. It
+// samples keyIndex, and only walks the keyspace when every sample has
+// expired.
+func (r *RedisStore) RandomKey() (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for range randomKeyTries {
+		key, ok := r.keyIndex.random()
+		if !ok {
+			return "", false
+		}
+		if r.lookupNoTouch(key) != nil {
+			return key, true
+		}
+	}
+	for key := range r.data {
+		if r.lookupNoTouch(key) != nil {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// DBSize returns the number of keys: all those held, less the keys with a
+// TTL that have expired but not yet been deleted.
+func (r *RedisStore) DBSize() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	n := len(r.data)
+	now := r.clock.Now()
+	for key := range r.volatile {
+		if r.data[key].expired(now) {
+			n--
+		}
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeysAcrossIndexResizes(t *testing.T) {
+	rs := newTestStore(t)
+	var want []string
+	for i := range 5 * keysPage {
+		key := fmt.Sprintf("key:%04d", i)
+		run(rs, "SET "+key+" v")
+		want = append(want, key)
+	}
+	run(rs, "SET other v")
+	got, err := rs.Keys("key:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("KEYS key:* returned %d keys, want %d in order", len(got), len(want))
+	}
+}
+
+func TestRandomKeyAndDBSize(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "RANDOMKEY"); got != "nil" {
+		t.Errorf("RANDOMKEY on an empty keyspace = %q, want nil", got)
+	}
+	if got := run(rs, "DBSIZE"); got != "0" {
+		t.Errorf("DBSIZE on an empty keyspace = %q, want 0", got)
+	}
+	run(rs, "SET a 1")
+	run(rs, "RPUSH b x")
+	if got := run(rs, "DBSIZE"); got != "2" {
+		t.Errorf("DBSIZE = %q, want 2", got)
+	}
+	for range 10 {
+		if got := run(rs, "RANDOMKEY"); got != "a" && got != "b" {
+			t.Fatalf("RANDOMKEY = %q, want a or b", got)
+		}
+	}
+
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "PEXPIRE b 100")
+	clk.Advance(200 * time.Millisecond)
+	if got := run(rs, "DBSIZE"); got != "1" {
+		t.Errorf("DBSIZE once b has expired = %q, want 1", got)
+	}
+	for range 10 {
+		if got := run(rs, "RANDOMKEY"); got != "a" {
+			t.Fatalf("RANDOMKEY once b has expired = %q, want a", got)
+		}
+	}
+}
+
+// BenchmarkWriteDuringKeys times SETs while another goroutine runs KEYS over
+// a large keyspace without stopping. A KEYS holding the read lock for its
+// whole walk, as "locked" does, holds each write up for that long; one
+// walking the key index holds it up only while a page is read.
+func BenchmarkWriteDuringKeys(b *testing.B) {
+	walks := []struct {
+		name string
+		walk func(rs *RedisStore)
+	}{
+		{"locked", func(rs *RedisStore) {
+			rs.mutex.RLock()
+			defer rs.mutex.RUnlock()
+			var keys []string
+			for key := range rs.data {
+				if matchPattern("*7*", key) {
+					keys = append(keys, key)
+				}
+			}
+			slices.Sort(keys)
+		}},
+		{"paged", func(rs *RedisStore) {
+			rs.Keys("*7*")
+		}},
+	}
+	for _, w := range walks {
+		b.Run(w.name, func(b *testing.B) {
+			rs := newBenchStore(b)
+			rs.config.AppendFsync = "no"
+			for i := range 100000 {
+				key := fmt.Sprintf("key:%d", i)
+				rs.data[key] = rs.newValue("v")
+				rs.indexKey(key)
+			}
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						w.walk(rs)
+					}
+				}
+			}()
+			for b.Loop() {
+				if err := rs.Set("written", "v"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencyHistogram counts command durations in log-linear buckets, as HDR
+// histograms do: exact below histSub nanoseconds, then histSub buckets for
+// each power of two, so a bucket is never wider than 1/histSub of its
+// values. Durations are clamped to histMax, one second like Redis, which
+// bounds it to a fixed number of buckets.
+type latencyHistogram struct {
+	counts [histBuckets]uint64
+	total  uint64
+}
+
+const (
+	histSubBits = 5
+	histSub     = 1 << histSubBits
+	histMax     = time.Second
+	// histMaxBits is bits.Len64 of histMax in nanoseconds.
+	histMaxBits = 30
+	histBuckets = histSub * (histMaxBits - histSubBits + 1)
+)
+
+// histBucket returns the index of the bucket counting v.
+func histBucket(v uint64) int {
+	if v < histSub {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	return histSub + shift*histSub + int(v>>shift) - histSub
+}
+
+// histUpper returns the largest value counted in bucket i.
+func histUpper(i int) uint64 {
+	if i < histSub {
+		return uint64(i)
+	}
+	shift := (i - histSub) / histSub
+	top := uint64(histSub + (i-histSub)%histSub)
+	return (top+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	d = min(max(d, 0), histMax)
+	h.counts[histBucket(uint64(d))]++
+	h.total++
+}
+
+// merge adds the durations recorded in other to h.
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+}
+
+// percentile returns the duration at or below which p percent of the
+// recorded ones fall, rounded up to the top of its bucket.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := max(uint64(math.Ceil(p/100*float64(h.total))), 1)
+	var seen uint64
+	for i, n := range h.counts {
+		if seen += n; seen >= rank {
+			return time.Duration(histUpper(i))
+		}
+	}
+	return histMax
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// listEnd selects the head (LEFT) or tail (RIGHT) of a list.
+type listEnd int
+
+const (
+	listLeft listEnd = iota
+	listRight
+)
+
+func (e listEnd) String() string {
+	if e == listLeft {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+func parseListEnd(s string) (listEnd, bool) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return listLeft, true
+	case "RIGHT":
+		return listRight, true
+	}
+	return 0, false
+}
+
+// listpackBytes are the size limits selected by a negative
+// list-max-listpack-size, from -1 up to -5.
+var listpackBytes = []int{4096, 8192, 16384, 32768, 65536}
+
+// listFits reports whether list is within a list-max-listpack-size limit,
+// scaled by scale. Byte sizes are estimated as each element plus two bytes
+// of listpack overhead.
+func listFits(list []string, maxSize int, scale float64) bool {
+	if maxSize >= 0 {
+		return float64(len(list)) <= float64(maxSize)*scale
+	}
+	limit := float64(listpackBytes[min(-maxSize, len(listpackBytes))-1]) * scale
+	size := 0
+	for _, e := range list {
+		size += len(e) + 2
+		if float64(size) > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// listEncoding returns the encoding for list given the one it has now. As in
+// Redis, a list grows out of listpack once it passes the limit but only goes
+// back once it has shrunk to half of it, so a list at the boundary does not
+// flip on every push and pop.
+func listEncoding(list []string, current string, maxSize int) string {
+	if current == "quicklist" {
+		if listFits(list, maxSize, 0.5) {
+			return "listpack"
+		}
+		return "quicklist"
+	}
+	if listFits(list, maxSize, 1) {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+// getList returns the list stored at key, nil if the key does not exist, or
+// errWrongType if it holds another type. The caller must hold the mutex.
+func (r *RedisStore) getList(key string) ([]string, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	list, ok := sv.value.([]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return list, nil
+}
+
+// push adds vals to one end of the list at key, creating it if needed, and
+// returns the new length. The caller must hold the mutex.
+func (r *RedisStore) push(key string, end listEnd, vals ...string) (int, error) {
+	list, err := r.getList(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, val := range vals {
+		if end == listLeft {
+			list = append([]string{val}, list...)
+		} else {
+			list = append(list, val)
+		}
+	}
+	sv := r.lookup(key)
+	if sv == nil {
+		sv = r.newValue(list)
+		r.data[key] = sv
+	}
+	sv.value = list
+	sv.encoding = listEncoding(list, sv.encoding, r.config.ListMaxListpackSize)
+	r.touch(key)
+	r.wakeWaiters(key)
+	return len(list), nil
+}
+
+// pop removes and returns the element at one end of the list at key,
+// deleting the key once the list is empty. The caller must hold the mutex.
+func (r *RedisStore) pop(key string, end listEnd) (string, bool, error) {
+	list, err := r.getList(key)
+	if err != nil || len(list) == 0 {
+		return "", false, err
+	}
+	var val string
+	if end == listLeft {
+		val, list = list[0], list[1:]
+	} else {
+		val, list = list[len(list)-1], list[:len(list)-1]
+	}
+	r.touch(key)
+	if len(list) == 0 {
+		delete(r.data, key)
+	} else {
+		sv := r.data[key]
+		sv.value = list
+		sv.encoding = listEncoding(list, sv.encoding, r.config.ListMaxListpackSize)
+	}
+	return val, true, nil
+}
+
+func (r *RedisStore) Push(key string, end listEnd, vals ...string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n, err := r.push(key, end, vals...)
+	if err != nil {
+		return 0, err
+	}
+	name := "RPUSH"
+	if end == listLeft {
+		name = "LPUSH"
+	}
+	if err := r.writeAOF(name, append([]string{key}, vals...)...); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *RedisStore) LLen(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list, err := r.getList(key)
+	r.stats.keyspaceRead(list != nil || err != nil)
+	return len(list), err
+}
+
+// LRange returns the elements between start and stop inclusive. Negative
+// indexes count back from the tail, as in Redis.
+func (r *RedisStore) LRange(key string, start, stop int) ([]string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list, err := r.getList(key)
+	r.stats.keyspaceRead(list != nil || err != nil)
+	if err != nil {
+		return nil, err
+	}
+	n := len(list)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	return append([]string(nil), list[start:stop+1]...), nil
+}
+
+// LMove atomically pops an element from one end of src and pushes it onto one
+// end of dst. src and dst may be the same key, which rotates the list. The
+// move is persisted as an LMOVE only when an element was actually moved.
+func (r *RedisStore) LMove(src, dst string, from, to listEnd) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lmove(src, dst, from, to)
+}
+
+// lmove implements LMove. The caller must hold the mutex.
+func (r *RedisStore) lmove(src, dst string, from, to listEnd) (string, bool, error) {
+	// Check the destination type up front so a WRONGTYPE error leaves the
+	// source untouched.
+	if _, err := r.getList(dst); err != nil {
+		return "", false, err
+	}
+	val, ok, err := r.pop(src, from)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if _, err := r.push(dst, to, val); err != nil {
+		return "", false, err
+	}
+	if err := r.writeAOF("LMOVE", src, dst, from.String(), to.String()); err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
+
+// LMPop pops up to count elements from one end of the first non-empty list
+// among keys, returning that key and the popped elements.
+func (r *RedisStore) LMPop(keys []string, end listEnd, count int) (string, []string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lmpop(keys, end, count)
+}
+
+// lmpop implements LMPop. It is persisted as an LMPOP of the single key that
+// was popped with the exact number of elements removed, so replay does not
+// depend on the state of the other keys. The caller must hold the mutex.
+func (r *RedisStore) lmpop(keys []string, end listEnd, count int) (string, []string, error) {
+	for _, key := range keys {
+		list, err := r.getList(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(list) == 0 {
+			continue
+		}
+		var popped []string
+		for len(popped) < count {
+			val, ok, _ := r.pop(key, end)
+			if !ok {
+				break
+			}
+			popped = append(popped, val)
+		}
+		if err := r.writeAOF("LMPOP", "1", key, end.String(), "COUNT", strconv.Itoa(len(popped))); err != nil {
+			return "", nil, err
+		}
+		return key, popped, nil
+	}
+	return "", nil, nil
+}
+
+var (
+	errNumKeys = errors.New("ERR numkeys should be greater than 0")
+	errCount   = errors.New("ERR count should be greater than 0")
+)
+
+// parseLMPopArgs parses the "numkeys key [key ...] LEFT|RIGHT [COUNT count]"
+// arguments shared by LMPOP and BLMPOP.
+func parseLMPopArgs(args []string) ([]string, listEnd, int, error) {
+	if len(args) < 3 {
+		return nil, 0, 0, errSyntax
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, 0, 0, errNumKeys
+	}
+	if numKeys > len(args)-2 {
+		return nil, 0, 0, errSyntax
+	}
+	keys := args[1 : numKeys+1]
+	end, ok := parseListEnd(args[numKeys+1])
+	if !ok {
+		return nil, 0, 0, errSyntax
+	}
+	count := 1
+	rest := args[numKeys+2:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "COUNT":
+		count, err = strconv.Atoi(rest[1])
+		if err != nil || count <= 0 {
+			return nil, 0, 0, errCount
+		}
+	default:
+		return nil, 0, 0, errSyntax
+	}
+	return keys, end, count, nil
+}
+
+func lmpopReply(key string, vals []string, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	if vals == nil {
+		return "nil"
+	}
+	return formatArray([]string{key, formatArray(vals)})
+}
+
+func lmoveReply(val string, ok bool, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	if !ok {
+		return "nil"
+	}
+	return bulkReply(val)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLMoveDirections(t *testing.T) {
+	tests := []struct {
+		from, to string
+		moved    string
+		src, dst string
+	}{
+		{"LEFT", "LEFT", "a", "1) b\n2) c", "1) a\n2) x\n3) y"},
+		{"LEFT", "RIGHT", "a", "1) b\n2) c", "1) x\n2) y\n3) a"},
+		{"RIGHT", "LEFT", "c", "1) a\n2) b", "1) c\n2) x\n3) y"},
+		{"RIGHT", "RIGHT", "c", "1) a\n2) b", "1) x\n2) y\n3) c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"_"+tt.to, func(t *testing.T) {
+			rs := newTestStore(t)
+			run(rs, "RPUSH src a b c")
+			run(rs, "RPUSH dst x y")
+			if got := run(rs, "LMOVE src dst "+tt.from+" "+tt.to); got != tt.moved {
+				t.Fatalf("LMOVE returned %q, want %q", got, tt.moved)
+			}
+			if got := run(rs, "LRANGE src 0 -1"); got != tt.src {
+				t.Errorf("src = %q, want %q", got, tt.src)
+			}
+			if got := run(rs, "LRANGE dst 0 -1"); got != tt.dst {
+				t.Errorf("dst = %q, want %q", got, tt.dst)
+			}
+		})
+	}
+}
+
+func TestLMoveSameKey(t *testing.T) {
+	tests := []struct {
+		from, to string
+		moved    string
+		list     string
+	}{
+		{"LEFT", "LEFT", "a", "1) a\n2) b\n3) c"},
+		{"LEFT", "RIGHT", "a", "1) b\n2) c\n3) a"},
+		{"RIGHT", "LEFT", "c", "1) c\n2) a\n3) b"},
+		{"RIGHT", "RIGHT", "c", "1) a\n2) b\n3) c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"_"+tt.to, func(t *testing.T) {
+			rs := newTestStore(t)
+			run(rs, "RPUSH l a b c")
+			if got := run(rs, "LMOVE l l "+tt.from+" "+tt.to); got != tt.moved {
+				t.Fatalf("LMOVE returned %q, want %q", got, tt.moved)
+			}
+			if got := run(rs, "LRANGE l 0 -1"); got != tt.list {
+				t.Errorf("list = %q, want %q", got, tt.list)
+			}
+		})
+	}
+
+	t.Run("single element", func(t *testing.T) {
+		rs := newTestStore(t)
+		run(rs, "RPUSH l a")
+		if got := run(rs, "LMOVE l l LEFT RIGHT"); got != "a" {
+			t.Fatalf("LMOVE returned %q, want a", got)
+		}
+		if got := run(rs, "LLEN l"); got != "1" {
+			t.Errorf("LLEN = %q, want 1", got)
+		}
+	})
+}
+
+func TestLMoveEmptyAndWrongType(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "LMOVE missing dst LEFT RIGHT"); got != "nil" {
+		t.Errorf("LMOVE on missing source = %q, want nil", got)
+	}
+	if _, exists := rs.data["dst"]; exists {
+		t.Error("LMOVE on missing source created the destination")
+	}
+
+	run(rs, "RPUSH src a")
+	run(rs, "SET str v")
+	if got := run(rs, "LMOVE src str LEFT LEFT"); !strings.HasPrefix(got, "-WRONGTYPE") {
+		t.Errorf("LMOVE onto a string = %q, want WRONGTYPE", got)
+	}
+	if got := run(rs, "LLEN src"); got != "1" {
+		t.Errorf("failed LMOVE changed the source: LLEN = %q", got)
+	}
+	if got := run(rs, "LMOVE src dst UP DOWN"); got != "-ERR syntax error" {
+		t.Errorf("LMOVE with bad direction = %q", got)
+	}
+}
+
+func TestLMovePersisted(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "RPUSH src a b c")
+	run(rs, "LMOVE src dst RIGHT LEFT")
+	run(rs, "LMOVE src src LEFT RIGHT")
+	run(rs, "LMOVE missing dst LEFT LEFT")
+	rs.Close()
+
+	aof := aofText(t, rs)
+	if n := strings.Count(string(aof), "LMOVE"); n != 2 {
+		t.Errorf("AOF has %d LMOVE records, want 2:\n%s", n, aof)
+	}
+
+	reloaded, err := NewRedisStore(rs.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	if err := reloaded.loadAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(reloaded, "LRANGE src 0 -1"); got != "1) b\n2) a" {
+		t.Errorf("reloaded src = %q", got)
+	}
+	if got := run(reloaded, "LRANGE dst 0 -1"); got != "1) c" {
+		t.Errorf("reloaded dst = %q", got)
+	}
+}
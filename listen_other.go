@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// setBacklog is a no-op where the listen queue cannot be resized after Go
+// has created the listener.
+func setBacklog(listener net.Listener, backlog int) error {
+	return nil
+}
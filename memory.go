@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// keyOverhead approximates the bookkeeping a key costs beyond its bytes:
+// the StoredValue and its slot in the keyspace map.
+const keyOverhead = 64
+
+// valueSize estimates the bytes a value holds: the bytes of its strings plus
+// a word for each element's header, and a float for each zset score.
+func valueSize(v any) int {
+	const word = 8
+	n := 0
+	switch v := v.(type) {
+	case string:
+		n = len(v)
+	case []byte:
+		n = cap(v)
+	case []string:
+		for _, s := range v {
+			n += len(s) + word
+		}
+	case map[string]struct{}:
+		for s := range v {
+			n += len(s) + word
+		}
+	case *hashValue:
+		for _, p := range v.fields() {
+			n += len(p.field) + len(p.value) + 2*word
+		}
+	case *sortedSet:
+		for _, e := range v.entries {
+			n += len(e.member) + 2*word
+		}
+		if v.dict != nil {
+			n += len(v.entries) * 2 * word
+		}
+	case *stream:
+		// Each entry has its ID, two words, and its field-value pairs;
+		// each consumer group its name and last delivered ID.
+		for _, e := range v.entries {
+			n += 2 * word
+			for _, f := range e.fields {
+				n += len(f) + word
+			}
+		}
+		for name := range v.groups {
+			n += len(name) + 3*word
+		}
+	}
+	return n
+}
+
+// usedMemory sums the estimated size of every key and value. The caller
+// must hold the mutex.
+func (r *RedisStore) usedMemory() int64 {
+	var n int64
+	for key, sv := range r.data {
+		n += int64(len(key) + keyOverhead + valueSize(sv.value))
+	}
+	return n
+}
+
+// MemoryUsage estimates the bytes the key and its value take, as INFO's
+// used_memory counts them. It reports false if the key does not exist.
+func (r *RedisStore) MemoryUsage(key string) (int64, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return 0, false
+	}
+	return int64(len(key) + keyOverhead + valueSize(sv.value)), true
+}
+
+// memoryCommand handles MEMORY USAGE key [SAMPLES count]. The estimate
+// covers every element, so SAMPLES is accepted but has no effect.
+func memoryCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "USAGE":
+		if len(args) == 2 || (len(args) == 4 && strings.ToUpper(args[2]) == "SAMPLES") {
+			if len(args) == 4 {
+				if _, err := strconv.Atoi(args[3]); err != nil {
+					return formatError(errNotInteger)
+				}
+			}
+			n, exists := rs.MemoryUsage(args[1])
+			if !exists {
+				return "nil"
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		if len(args) > 2 {
+			return formatError(errSyntax)
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
+
+// processRSS returns the resident set size of the process. It reads
+// /proc/self/statm where there is one and otherwise falls back to the memory
+// the Go runtime has obtained from the operating system.
+func processRSS() int64 {
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if f := strings.Fields(string(b)); len(f) > 1 {
+			if pages, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.Sys)
+}
+
+func memoryInfo(r *RedisStore) []string {
+	used, rss := r.usedMemory(), processRSS()
+	ratio := 0.0
+	if used > 0 {
+		ratio = float64(rss) / float64(used)
+	}
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		fmt.Sprintf("used_memory_rss:%d", rss),
+		fmt.Sprintf("mem_fragmentation_ratio:%.2f", ratio),
+		fmt.Sprintf("maxmemory:%d", r.config.Maxmemory),
+		"maxmemory_policy:" + r.config.MaxmemoryPolicy,
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// infoField returns the value of name in an INFO reply.
+func infoField(t *testing.T, info, name string) string {
+	t.Helper()
+	for line := range strings.SplitSeq(info, "\n") {
+		if v, ok := strings.CutPrefix(line, name+":"); ok {
+			return v
+		}
+	}
+	t.Fatalf("INFO has no %s:\n%s", name, info)
+	return ""
+}
+
+func usedMemory(t *testing.T, rs *RedisStore) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(infoField(t, run(rs, "INFO memory"), "used_memory"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUsedMemoryGrowsWithWrites(t *testing.T) {
+	rs := newTestStore(t)
+	before := usedMemory(t, rs)
+	big := strings.Repeat("x", 100_000)
+	for i := range 10 {
+		run(rs, "SET key"+strconv.Itoa(i)+" "+big)
+	}
+	run(rs, "RPUSH list "+big+" "+big)
+	after := usedMemory(t, rs)
+	if after-before < 12*100_000 {
+		t.Errorf("used_memory grew from %d to %d after writing 1.2MB", before, after)
+	}
+	run(rs, "FLUSHALL")
+	if n := usedMemory(t, rs); n != 0 {
+		t.Errorf("used_memory = %d after FLUSHALL, want 0", n)
+	}
+	info := run(rs, "INFO memory")
+	if rss, _ := strconv.ParseInt(infoField(t, info, "used_memory_rss"), 10, 64); rss <= 0 {
+		t.Errorf("used_memory_rss = %d, want the process RSS", rss)
+	}
+}
+
+func TestInfoReportsMaxmemory(t *testing.T) {
+	rs := newTestStore(t)
+	info := run(rs, "INFO memory")
+	if v := infoField(t, info, "maxmemory"); v != "0" {
+		t.Errorf("default maxmemory = %s, want 0", v)
+	}
+	if v := infoField(t, info, "maxmemory_policy"); v != "noeviction" {
+		t.Errorf("default maxmemory_policy = %s, want noeviction", v)
+	}
+	for _, tt := range []struct{ val, want string }{
+		{"1048576", "1048576"},
+		{"100mb", "104857600"},
+		{"2GB", "2147483648"},
+		{"5k", "5000"},
+	} {
+		if got := run(rs, "CONFIG SET maxmemory "+tt.val); got != "OK" {
+			t.Fatalf("CONFIG SET maxmemory %s = %q", tt.val, got)
+		}
+		if v := infoField(t, run(rs, "INFO memory"), "maxmemory"); v != tt.want {
+			t.Errorf("maxmemory %s reported as %s, want %s", tt.val, v, tt.want)
+		}
+	}
+	if got := run(rs, "CONFIG SET maxmemory lots"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("CONFIG SET maxmemory lots = %q, want an error", got)
+	}
+	run(rs, "CONFIG SET maxmemory-policy allkeys-lru")
+	if v := infoField(t, run(rs, "INFO memory"), "maxmemory_policy"); v != "allkeys-lru" {
+		t.Errorf("maxmemory_policy = %s, want allkeys-lru", v)
+	}
+}
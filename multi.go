@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// watchedKey is the version of a key that clients are WATCHing. Every write
+// to the key bumps it, whether or not the value changed, so EXEC only has to
+// compare each watched key's version with the one recorded by WATCH, however
+// many writes happened in between. watchers counts the clients watching the
+// key; the entry is dropped once there are none.
+type watchedKey struct {
+	version  uint64
+	watchers int
+}
+
+// watch is what a client recorded when it started watching a key. live is
+// whether the key existed then, so that EXEC notices it has since expired.
+type watch struct {
+	version uint64
+	live    bool
+}
+
+var (
+	errNestedMulti         = errors.New("ERR MULTI calls can not be nested")
+	errExecWithoutMulti    = errors.New("ERR EXEC without MULTI")
+	errDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	errWatchInMulti        = errors.New("ERR WATCH inside MULTI is not allowed")
+	errExecAbort           = errors.New("EXECABORT Transaction discarded because of previous errors.")
+)
+
+// touch marks key as modified for the transactions watching it and the
+// clients tracking it, and updates the key indexes. Every write calls it for
+// the keys it changes. The caller must hold the mutex.
+func (r *RedisStore) touch(key string) {
+	if w := r.watched[key]; w != nil {
+		w.version++
+	}
+	r.tracking.invalidate(key)
+	r.indexKey(key)
+}
+
+// Watch starts watching key, returning what EXEC compares against.
+func (r *RedisStore) Watch(key string) watch {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w := r.watched[key]
+	if w == nil {
+		w = &watchedKey{}
+		r.watched[key] = w
+	}
+	w.watchers++
+	return watch{version: w.version, live: r.lookupNoTouch(key) != nil}
+}
+
+// Unwatch stops watching every key in watches.
+func (r *RedisStore) Unwatch(watches map[string]watch) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key := range watches {
+		w := r.watched[key]
+		if w.watchers--; w.watchers == 0 {
+			delete(r.watched, key)
+		}
+	}
+}
+
+// watchesHold reports whether no key in watches has been written or has
+// expired since it was watched. The caller must hold the mutex.
+func (r *RedisStore) watchesHold(watches map[string]watch) bool {
+	for key, w := range watches {
+		if r.watched[key].version != w.version {
+			return false
+		}
+		if w.live && r.lookupNoTouch(key) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Exec runs the commands of a transaction with no other command running in
+// between, returning their replies. If a watched key has changed it runs
+// none of them and replies nil.
+func (r *RedisStore) Exec(watches map[string]watch, queued []Command) string {
+	r.execMu.Lock()
+	defer r.execMu.Unlock()
+	r.mutex.RLock()
+	hold := r.watchesHold(watches)
+	r.mutex.RUnlock()
+	if !hold {
+		return "nil"
+	}
+	replies := make([]string, len(queued))
+	for i, cmd := range queued {
+		replies[i] = executeCommand(cmd, r)
+	}
+	return formatArray(replies)
+}
+
+// transactionCommands run as soon as they are sent, even inside MULTI.
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+}
+
+// transaction handles MULTI, EXEC, DISCARD, WATCH and UNWATCH.
+func (c *client) transaction(cmd Command) string {
+	switch cmd.Name {
+	case "MULTI":
+		if len(cmd.Args) == 0 {
+			if c.multi {
+				return formatError(errNestedMulti)
+			}
+			c.multi = true
+			return "OK"
+		}
+	case "EXEC":
+		if len(cmd.Args) == 0 {
+			if !c.multi {
+				return formatError(errExecWithoutMulti)
+			}
+			queued, watches, dirty := c.queued, c.watches, c.dirty
+			c.multi, c.queued, c.dirty = false, nil, false
+			defer c.unwatch()
+			if dirty {
+				return formatError(errExecAbort)
+			}
+			return withAOFSync(c.rs, func() string { return c.rs.Exec(watches, queued) })
+		}
+	case "DISCARD":
+		if len(cmd.Args) == 0 {
+			if !c.multi {
+				return formatError(errDiscardWithoutMulti)
+			}
+			c.multi, c.queued, c.dirty = false, nil, false
+			c.unwatch()
+			return "OK"
+		}
+	case "WATCH":
+		if len(cmd.Args) >= 1 {
+			if c.multi {
+				return formatError(errWatchInMulti)
+			}
+			for _, key := range cmd.Args {
+				if _, ok := c.watches[key]; !ok {
+					c.watches[key] = c.rs.Watch(key)
+				}
+			}
+			return "OK"
+		}
+	case "UNWATCH":
+		if len(cmd.Args) == 0 {
+			c.unwatch()
+			return "OK"
+		}
+	}
+	return ""
+}
+
+// queue adds cmd to the transaction in progress. A command that could not
+// run, being unknown, given the wrong number of arguments, not allowed in a
+// transaction or a write while the server is read-only, is refused at once
+// and makes EXEC discard the transaction.
+func (c *client) queue(cmd Command) string {
+	var err error
+	switch {
+	case blockingCommands[cmd.Name] || subscribedCommands[cmd.Name] || cmd.Name == "CLIENT":
+		err = fmt.Errorf("ERR '%s' is not allowed in a transaction", strings.ToLower(cmd.Name))
+	case commandTable[cmd.Name].arity == 0:
+		err = errUnknownCommand(cmd)
+	case !checkArity(cmd):
+		err = errArity(cmd.Name)
+	case c.rs.readOnly() && isWriteCommand(cmd, c.rs):
+		err = errReadOnly
+	}
+	if err != nil {
+		c.dirty = true
+		return formatError(err)
+	}
+	c.queued = append(c.queued, cmd)
+	return "QUEUED"
+}
+
+func (c *client) unwatch() {
+	if len(c.watches) > 0 {
+		c.rs.Unwatch(c.watches)
+		clear(c.watches)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultiExec(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	for _, tt := range []struct{ cmd, want string }{
+		{"EXEC", formatError(errExecWithoutMulti)},
+		{"MULTI", "OK"},
+		{"MULTI", formatError(errNestedMulti)},
+		{"WATCH a", formatError(errWatchInMulti)},
+		{"SET a 1", "QUEUED"},
+		{"INCR a", "QUEUED"},
+		{"EXEC", "1) OK\n2) 2"},
+		{"GET a", "2"},
+		{"MULTI", "OK"},
+		{"SET a 3", "QUEUED"},
+		{"DISCARD", "OK"},
+		{"GET a", "2"},
+		{"DISCARD", formatError(errDiscardWithoutMulti)},
+	} {
+		if got := send(c, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestExecAbortsAfterQueueingErrors(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	for _, bad := range []struct{ cmd, want string }{
+		{"FOO a b", "-ERR unknown command 'FOO', with args beginning with: 'a' 'b' "},
+		{"GET a b", "-ERR wrong number of arguments for 'get' command"},
+		{"BLMOVE a b LEFT LEFT 0", "-ERR 'blmove' is not allowed in a transaction"},
+	} {
+		send(c, "MULTI")
+		if got := send(c, "SET a 1"); got != "QUEUED" {
+			t.Fatalf("SET a 1 = %q", got)
+		}
+		if got := send(c, bad.cmd); got != bad.want {
+			t.Errorf("%s = %q, want %q", bad.cmd, got, bad.want)
+		}
+		if got := send(c, "INCR b"); got != "QUEUED" {
+			t.Errorf("INCR b after the error = %q, want it still queued", got)
+		}
+		if got := send(c, "EXEC"); got != formatError(errExecAbort) {
+			t.Errorf("EXEC after %s = %q", bad.cmd, got)
+		}
+		if got := send(c, "GET a"); got != "nil" {
+			t.Errorf("GET a = %q, the aborted transaction ran", got)
+		}
+	}
+	// The flag goes with the transaction.
+	send(c, "MULTI")
+	send(c, "FOO")
+	send(c, "DISCARD")
+	send(c, "MULTI")
+	send(c, "SET a 1")
+	if got := send(c, "EXEC"); got != "1) OK" {
+		t.Errorf("EXEC of the next transaction = %q", got)
+	}
+}
+
+func TestWatchAbortsOnIdenticalSet(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	other, _ := newTestClient(t, rs)
+	send(c, "SET k v")
+	send(c, "WATCH k")
+	send(other, "SET k v")
+	send(c, "MULTI")
+	send(c, "SET k mine")
+	if got := send(c, "EXEC"); got != "nil" {
+		t.Errorf("EXEC after the watched key was rewritten = %q, want nil", got)
+	}
+	if got := send(c, "GET k"); got != "v" {
+		t.Errorf("GET k = %q, the aborted transaction ran", got)
+	}
+	// EXEC unwatches, so the same transaction now succeeds.
+	send(c, "MULTI")
+	send(c, "SET k mine")
+	if got := send(c, "EXEC"); got != "1) OK" {
+		t.Errorf("second EXEC = %q", got)
+	}
+	if len(rs.watched) != 0 {
+		t.Errorf("%d keys still watched after EXEC", len(rs.watched))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	rs := newTestStore(t)
+	tests := []struct {
+		name  string
+		setup string
+		write string
+		abort bool
+	}{
+		{"untouched", "SET k v", "SET other v", false},
+		{"missing key created", "", "SET k v", true},
+		{"missing key deleted", "", "DEL k", false},
+		{"deleted", "SET k v", "DEL k", true},
+		{"list push", "", "RPUSH k a", true},
+		{"set add", "", "SADD k a", true},
+		{"hash set", "", "HSET k f v", true},
+		{"expire", "SET k v", "EXPIRE k 100", true},
+		{"flushall", "SET k v", "FLUSHALL", true},
+		{"flushall without the key", "SET other v", "FLUSHALL", false},
+		{"append", "SET k v", "APPEND k x", true},
+		{"unwatch", "SET k v", "UNWATCH", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(t, rs)
+			other, _ := newTestClient(t, rs)
+			send(other, "FLUSHALL")
+			if tt.setup != "" {
+				send(other, tt.setup)
+			}
+			send(c, "WATCH k")
+			if tt.write == "UNWATCH" {
+				send(c, "UNWATCH")
+				send(other, "SET k changed")
+			} else {
+				send(other, tt.write)
+			}
+			send(c, "MULTI")
+			send(c, "SET done 1")
+			if got := send(c, "EXEC"); (got == "nil") != tt.abort {
+				t.Errorf("EXEC = %q, want abort %v", got, tt.abort)
+			}
+		})
+	}
+}
+
+func TestWatchAbortsOnExpiry(t *testing.T) {
+	rs := newTestStore(t)
+	clock := newFakeClock()
+	rs.clock = clock
+	c, _ := newTestClient(t, rs)
+	send(c, "SET k v")
+	send(c, "PEXPIRE k 100")
+	send(c, "WATCH k")
+	clock.Advance(time.Second)
+	send(c, "MULTI")
+	send(c, "SET done 1")
+	if got := send(c, "EXEC"); got != "nil" {
+		t.Errorf("EXEC after the watched key expired = %q, want nil", got)
+	}
+}
+
+func TestUnwatchOnClose(t *testing.T) {
+	rs := newTestStore(t)
+	c := newClient(&recorder{}, rs)
+	send(c, "WATCH a b")
+	c.close()
+	if len(rs.watched) != 0 {
+		t.Errorf("%d keys still watched after the client closed", len(rs.watched))
+	}
+}
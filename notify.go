@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Keyspace notification classes, as configured by notify-keyspace-events.
+// K and E select the keyspace and keyevent channels, and x the expired
+// events, the only ones published so far. Redis's other classes, such as g
+// for generic commands or $ for strings, are refused rather than accepted
+// and never fired. A is an alias for every event class there is.
+const (
+	notifyKeyspace = 'K'
+	notifyKeyevent = 'E'
+	notifyExpired  = 'x'
+	notifyAll      = "x"
+)
+
+var errInvalidNotifyFlags = errors.New("invalid notify-keyspace-events flags")
+
+// parseNotifyFlags checks a notify-keyspace-events value and expands A.
+func parseNotifyFlags(val string) (string, error) {
+	var flags strings.Builder
+	for _, f := range val {
+		switch {
+		case f == 'A':
+			flags.WriteString(notifyAll)
+		case strings.ContainsRune("KE"+notifyAll, f):
+			flags.WriteRune(f)
+		default:
+			return "", errInvalidNotifyFlags
+		}
+	}
+	return flags.String(), nil
+}
+
+// keyspaceEvent is a notification waiting to be published. db is the index
+// of the database holding key, which names the channels it is published on.
+type keyspaceEvent struct {
+	db    int
+	class byte
+	event string
+	key   string
+}
+
+// publishKeyspaceEvents publishes events on the channels that
+// notify-keyspace-events enables. It must be called without the mutex held,
+// since delivering to subscribers reads their output buffer limits under it.
+func (r *RedisStore) publishKeyspaceEvents(events []keyspaceEvent) {
+	r.mutex.RLock()
+	flags := r.config.NotifyKeyspaceEvents
+	r.mutex.RUnlock()
+	for _, ev := range events {
+		if strings.IndexByte(flags, ev.class) < 0 {
+			continue
+		}
+		db := strconv.Itoa(ev.db)
+		if strings.IndexByte(flags, notifyKeyspace) >= 0 {
+			r.Publish("__keyspace@"+db+"__:"+ev.key, ev.event)
+		}
+		if strings.IndexByte(flags, notifyKeyevent) >= 0 {
+			r.Publish("__keyevent@"+db+"__:"+ev.event, ev.key)
+		}
+	}
+}
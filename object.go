@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// sharedIntegers are the string values of 0 to 9999, which every key holding
+// one of them shares rather than having its own copy, as Redis shares its
+// integer objects. sharedRefcount is the refcount OBJECT REFCOUNT reports
+// for them, Redis's OBJ_SHARED_REFCOUNT.
+var sharedIntegers = func() []string {
+	s := make([]string, 10000)
+	for i := range s {
+		s[i] = strconv.Itoa(i)
+	}
+	return s
+}()
+
+const sharedRefcount = math.MaxInt32
+
+// sharedInteger returns the shared copy of val if it is one of the shared
+// integers, or val itself.
+func sharedInteger(val string) string {
+	if n, ok := sharedIndex(val); ok {
+		return sharedIntegers[n]
+	}
+	return val
+}
+
+func sharedIndex(val string) (int, bool) {
+	n, err := strconv.Atoi(val)
+	return n, err == nil && n >= 0 && n < len(sharedIntegers) && sharedIntegers[n] == val
+}
+
+// refcount reports the OBJECT REFCOUNT of a stored value: sharedRefcount if
+// it holds a shared integer and 1 otherwise. A value equal to a shared
+// integer but stored as its own copy, as by APPEND, is not shared.
+func refcount(sv *StoredValue) int {
+	if s, ok := sv.value.(string); ok {
+		if n, ok := sharedIndex(s); ok && unsafe.StringData(s) == unsafe.StringData(sharedIntegers[n]) {
+			return sharedRefcount
+		}
+	}
+	return 1
+}
+
+// isInt64 reports whether val is the canonical form of an int64, which
+// Redis stores as an integer rather than a string.
+func isInt64(val string) bool {
+	n, err := strconv.ParseInt(val, 10, 64)
+	return err == nil && strconv.FormatInt(n, 10) == val
+}
+
+// stringEncoding classifies a string value the way Redis encodes it: int for
+// values that round-trip through an int64, embstr for strings of at most
+// embstrLimit bytes and raw for anything longer.
+func stringEncoding(val string, embstrLimit int) string {
+	if isInt64(val) {
+		return "int"
+	}
+	if len(val) <= embstrLimit {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// objectEncoding reports the OBJECT ENCODING of a stored value.
+func objectEncoding(sv *StoredValue) string {
+	switch v := sv.value.(type) {
+	case string, []byte, []string, map[string]struct{}:
+		return sv.encoding
+	case *hashValue:
+		return v.encoding()
+	case *sortedSet:
+		return v.encoding()
+	case *stream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// typeName reports the TYPE of a value. Bitmaps and HyperLogLogs are
+// strings.
+func typeName(v any) string {
+	switch v.(type) {
+	case string, []byte:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]struct{}:
+		return "set"
+	case *hashValue:
+		return "hash"
+	case *sortedSet:
+		return "zset"
+	case *stream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// Type returns the type of the value at key, or none if it does not exist.
+func (r *RedisStore) Type(key string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return "none"
+	}
+	return typeName(sv.value)
+}
+
+// ObjectEncoding returns the encoding of the value at key.
+func (r *RedisStore) ObjectEncoding(key string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return "", false
+	}
+	return objectEncoding(sv), true
+}
+
+// ObjectRefcount returns the refcount of the value at key.
+func (r *RedisStore) ObjectRefcount(key string) (int, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return 0, false
+	}
+	return refcount(sv), true
+}
+
+// ObjectIdleTime returns how long ago the key at key was last accessed.
+func (r *RedisStore) ObjectIdleTime(key string) (time.Duration, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return 0, false
+	}
+	return r.clock.Now().Sub(time.Unix(0, sv.accessed.Load())), true
+}
+
+// ObjectFreq returns the LFU access counter of the key at key.
+func (r *RedisStore) ObjectFreq(key string) (int, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return 0, false
+	}
+	return int(sv.freq), true
+}
+
+var errNoSuchKey = errors.New("ERR no such key")
+
+// DebugObject describes the value at key the way DEBUG OBJECT does.
+func (r *RedisStore) DebugObject(key string) (string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookupNoTouch(key)
+	if sv == nil {
+		return "", errNoSuchKey
+	}
+	idle := r.clock.Now().Sub(time.Unix(0, sv.accessed.Load()))
+	desc := fmt.Sprintf("Value at:%p refcount:%d encoding:%s lru_seconds_idle:%d",
+		sv, refcount(sv), objectEncoding(sv), int64(idle/time.Second))
+	if s, ok := sv.value.(*stream); ok {
+		desc += fmt.Sprintf(" entries:%d", len(s.entries))
+	}
+	return desc, nil
+}
+
+func objectCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "ENCODING":
+		if len(args) == 2 {
+			enc, exists := rs.ObjectEncoding(args[1])
+			if !exists {
+				return "nil"
+			}
+			return enc
+		}
+	case "REFCOUNT":
+		if len(args) == 2 {
+			n, exists := rs.ObjectRefcount(args[1])
+			if !exists {
+				return "nil"
+			}
+			return strconv.Itoa(n)
+		}
+	case "IDLETIME":
+		if len(args) == 2 {
+			idle, exists := rs.ObjectIdleTime(args[1])
+			if !exists {
+				return "nil"
+			}
+			return strconv.FormatInt(int64(idle/time.Second), 10)
+		}
+	case "FREQ":
+		if len(args) == 2 {
+			freq, exists := rs.ObjectFreq(args[1])
+			if !exists {
+				return "nil"
+			}
+			return strconv.Itoa(freq)
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncodingStrings(t *testing.T) {
+	rs := newTestStore(t)
+	tests := []struct {
+		val, want string
+	}{
+		{"12345", "int"},
+		{"-9", "int"},
+		{"007", "embstr"},
+		{"hello_world", "embstr"},
+		{strings.Repeat("x", 44), "embstr"},
+		{strings.Repeat("x", 45), "raw"},
+	}
+	for _, tt := range tests {
+		run(rs, "SET k "+tt.val)
+		if got := run(rs, "OBJECT ENCODING k"); got != tt.want {
+			t.Errorf("OBJECT ENCODING of %q = %q, want %q", tt.val, got, tt.want)
+		}
+	}
+	if got := run(rs, "OBJECT ENCODING missing"); got != "nil" {
+		t.Errorf("OBJECT ENCODING of a missing key = %q, want nil", got)
+	}
+}
+
+func TestObjectEncodingEmbstrLimit(t *testing.T) {
+	rs := newTestStore(t)
+	rs.config.EmbstrSizeLimit = 4
+	run(rs, "SET k abcd")
+	if got := run(rs, "OBJECT ENCODING k"); got != "embstr" {
+		t.Errorf("4-byte value = %q, want embstr", got)
+	}
+	run(rs, "SET k abcde")
+	if got := run(rs, "OBJECT ENCODING k"); got != "raw" {
+		t.Errorf("5-byte value = %q, want raw", got)
+	}
+}
+
+func TestListEncodingTransition(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "CONFIG SET list-max-listpack-size 4")
+	var want []string
+	for i, v := range []string{"a", "b", "c", "d"} {
+		run(rs, "RPUSH l "+v)
+		want = append(want, fmt.Sprintf("%d) %s", i+1, v))
+	}
+	if got := run(rs, "OBJECT ENCODING l"); got != "listpack" {
+		t.Errorf("OBJECT ENCODING at the limit = %q, want listpack", got)
+	}
+	before := run(rs, "LRANGE l 0 -1")
+
+	run(rs, "RPUSH l e")
+	if got := run(rs, "OBJECT ENCODING l"); got != "quicklist" {
+		t.Errorf("OBJECT ENCODING past the limit = %q, want quicklist", got)
+	}
+	if !strings.Contains(run(rs, "DEBUG OBJECT l"), " encoding:quicklist ") {
+		t.Errorf("DEBUG OBJECT = %q", run(rs, "DEBUG OBJECT l"))
+	}
+	if got := run(rs, "LRANGE l 0 3"); got != before {
+		t.Errorf("LRANGE across the conversion = %q, want %q", got, before)
+	}
+	if got := run(rs, "LRANGE l 0 -1"); got != strings.Join(want, "\n")+"\n5) e" {
+		t.Errorf("LRANGE = %q", got)
+	}
+
+	// It only converts back once it has shrunk to half the limit.
+	run(rs, "LMPOP 1 l RIGHT COUNT 2")
+	if got := run(rs, "OBJECT ENCODING l"); got != "quicklist" {
+		t.Errorf("OBJECT ENCODING at 3 entries = %q, want quicklist still", got)
+	}
+	run(rs, "LMPOP 1 l RIGHT")
+	if got := run(rs, "OBJECT ENCODING l"); got != "listpack" {
+		t.Errorf("OBJECT ENCODING at 2 entries = %q, want listpack", got)
+	}
+	if got := run(rs, "LRANGE l 0 -1"); got != "1) a\n2) b" {
+		t.Errorf("LRANGE after shrinking = %q", got)
+	}
+}
+
+func TestListEncodingBySize(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "RPUSH l small")
+	if got := run(rs, "OBJECT ENCODING l"); got != "listpack" {
+		t.Errorf("OBJECT ENCODING of a small list = %q, want listpack", got)
+	}
+	run(rs, "RPUSH l "+strings.Repeat("x", 9000))
+	if got := run(rs, "OBJECT ENCODING l"); got != "quicklist" {
+		t.Errorf("OBJECT ENCODING past 8 KB = %q, want quicklist", got)
+	}
+	if got := run(rs, "CONFIG SET list-max-listpack-size -6"); !strings.HasPrefix(got, "-ERR Invalid argument") {
+		t.Errorf("CONFIG SET list-max-listpack-size -6 = %q", got)
+	}
+}
+
+func TestObjectRefcountSharedIntegers(t *testing.T) {
+	rs := newTestStore(t)
+	shared := fmt.Sprint(sharedRefcount)
+	run(rs, "SET k 100")
+	if got := run(rs, "OBJECT REFCOUNT k"); got != shared {
+		t.Errorf("OBJECT REFCOUNT of 100 = %q, want %s", got, shared)
+	}
+	run(rs, "SET k 999999")
+	if got := run(rs, "OBJECT REFCOUNT k"); got != "1" {
+		t.Errorf("OBJECT REFCOUNT of 999999 = %q, want 1", got)
+	}
+	run(rs, "SET k 0099")
+	if got := run(rs, "OBJECT REFCOUNT k"); got != "1" {
+		t.Errorf("OBJECT REFCOUNT of 0099 = %q, want 1", got)
+	}
+
+	run(rs, "SET n 9998")
+	run(rs, "INCR n")
+	if got := run(rs, "OBJECT REFCOUNT n"); got != shared {
+		t.Errorf("OBJECT REFCOUNT after INCR to 9999 = %q, want %s", got, shared)
+	}
+	run(rs, "INCR n")
+	if got := run(rs, "OBJECT REFCOUNT n"); got != "1" {
+		t.Errorf("OBJECT REFCOUNT after INCR to 10000 = %q, want 1", got)
+	}
+	run(rs, "SET a 1")
+	run(rs, "APPEND a 2")
+	if got := run(rs, "OBJECT REFCOUNT a"); got != "1" {
+		t.Errorf("OBJECT REFCOUNT after APPEND = %q, want 1", got)
+	}
+	if !strings.Contains(run(rs, "DEBUG OBJECT k"), " refcount:1 ") {
+		t.Errorf("DEBUG OBJECT = %q", run(rs, "DEBUG OBJECT k"))
+	}
+	if got := run(rs, "OBJECT REFCOUNT missing"); got != "nil" {
+		t.Errorf("OBJECT REFCOUNT of a missing key = %q, want nil", got)
+	}
+}
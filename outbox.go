@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// outbox is a client's output queue. Everything sent to the connection, its
+// own replies and Pub/Sub messages published by other clients alike, is
+// queued here and written out in queue order by a single goroutine. So a
+// subscriber receives messages in the order the PUBLISH calls reached it:
+// any one publisher's messages arrive in the order it published them, and
+// a reply never overtakes a message queued before it.
+type outbox struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending holds the frames not yet written. queued and written count
+	// the frames ever queued and written, so flush can wait for a point
+	// in the queue.
+	pending []string
+	queued  uint64
+	written uint64
+	// size is the bytes queued but not yet written, and overSoftSince
+	// when it last went over the soft limit, zero while it is under.
+	size          int64
+	overSoftSince time.Time
+	closed        bool
+	done          chan struct{}
+}
+
+// newOutbox starts the goroutine writing the outbox to w.
+func newOutbox(w io.Writer) *outbox {
+	o := &outbox{done: make(chan struct{})}
+	o.cond = sync.NewCond(&o.mu)
+	go o.run(w)
+	return o
+}
+
+func (o *outbox) run(w io.Writer) {
+	defer close(o.done)
+	var failed bool
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		for len(o.pending) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if len(o.pending) == 0 {
+			return
+		}
+		batch := o.pending
+		o.pending = nil
+		o.mu.Unlock()
+		for _, frame := range batch {
+			// Once the connection has failed the rest is discarded, but
+			// still counted as written so that flush returns.
+			if !failed {
+				_, err := io.WriteString(w, frame)
+				failed = err != nil
+			}
+		}
+		o.mu.Lock()
+		o.written += uint64(len(batch))
+		for _, frame := range batch {
+			o.size -= int64(len(frame))
+		}
+		o.cond.Broadcast()
+	}
+}
+
+// push queues frame, reporting whether it did. It is dropped if the outbox
+// is closed, or if queueing it would break limit, in which case the outbox
+// is closed and what it still holds discarded, and push reports overflowed
+// so that the caller disconnects the client.
+func (o *outbox) push(frame string, limit outputBufferLimit, now time.Time) (queued, overflowed bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return false, false
+	}
+	size := o.size + int64(len(frame))
+	if limit.exceeded(size, o.overSoftSince, now) {
+		o.discard()
+		return false, true
+	}
+	switch {
+	case limit.soft <= 0 || size < limit.soft:
+		o.overSoftSince = time.Time{}
+	case o.overSoftSince.IsZero():
+		o.overSoftSince = now
+	}
+	o.pending = append(o.pending, frame)
+	o.queued++
+	o.size = size
+	o.cond.Broadcast()
+	return true, false
+}
+
+// discard closes the outbox, dropping the frames not yet being written.
+// They count as written, so that flush returns. The caller must hold mu.
+func (o *outbox) discard() {
+	o.closed = true
+	o.written += uint64(len(o.pending))
+	for _, frame := range o.pending {
+		o.size -= int64(len(frame))
+	}
+	o.pending = nil
+	o.cond.Broadcast()
+}
+
+// flush waits until everything queued so far has been written.
+func (o *outbox) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for n := o.queued; o.written < n; {
+		o.cond.Wait()
+	}
+}
+
+// close writes out what is still queued and stops the writer.
+func (o *outbox) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Broadcast()
+	o.mu.Unlock()
+	<-o.done
+}
+
+// outputBufferLimit is a client-output-buffer-limit class: a client is
+// disconnected once its unwritten output reaches hard bytes, or has stayed
+// over soft bytes for softSeconds. Zero disables a limit.
+type outputBufferLimit struct {
+	hard, soft  int64
+	softSeconds time.Duration
+}
+
+// exceeded reports whether size bytes of unwritten output break l, with the
+// output over the soft limit since overSoftSince, if it is set.
+func (l outputBufferLimit) exceeded(size int64, overSoftSince, now time.Time) bool {
+	if l.hard > 0 && size >= l.hard {
+		return true
+	}
+	return l.soft > 0 && size >= l.soft && !overSoftSince.IsZero() && now.Sub(overSoftSince) >= l.softSeconds
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientPause is the state of CLIENT PAUSE. While a pause is on, commands
+// from clients wait for it to end, or only write commands do under PAUSE
+// WRITE. CLIENT itself is never held up, so that UNPAUSE can always run. It
+// has its own lock.
+type clientPause struct {
+	mu sync.Mutex
+	// end is closed when the pause ends, at its timeout or by UNPAUSE, and
+	// is nil when there is no pause.
+	end        chan struct{}
+	writesOnly bool
+}
+
+var errPauseTimeout = errors.New("ERR timeout is not an integer or out of range")
+
+// Pause holds up client commands, or only writes if writesOnly is set,
+// until timeout has elapsed or Unpause is called. A new pause replaces the
+// one in force.
+func (r *RedisStore) Pause(timeout time.Duration, writesOnly bool) {
+	p := &r.pause
+	p.mu.Lock()
+	if p.end != nil {
+		close(p.end)
+	}
+	end := make(chan struct{})
+	p.end, p.writesOnly = end, writesOnly
+	p.mu.Unlock()
+
+	expired := r.clock.After(timeout)
+	go func() {
+		select {
+		case <-expired:
+			p.finish(end)
+		case <-end:
+		}
+	}()
+}
+
+// Unpause ends the pause in force, waking the clients it held up.
+func (r *RedisStore) Unpause() {
+	p := &r.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.end != nil {
+		close(p.end)
+		p.end = nil
+	}
+}
+
+// finish ends the pause whose end channel is end, unless another pause has
+// replaced it since.
+func (p *clientPause) finish(end chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.end == end {
+		close(p.end)
+		p.end = nil
+	}
+}
+
+// waitPause waits until no pause holds up cmd.
+func (r *RedisStore) waitPause(cmd Command) {
+	p := &r.pause
+	for {
+		p.mu.Lock()
+		end, writesOnly := p.end, p.writesOnly
+		p.mu.Unlock()
+		if end == nil || writesOnly && !isWriteCommand(cmd, r) {
+			return
+		}
+		<-end
+	}
+}
+
+// pauseCommand handles CLIENT PAUSE timeout [WRITE|ALL], with timeout in
+// milliseconds.
+func pauseCommand(args []string, rs *RedisStore) string {
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || ms < 0 {
+		return formatError(errPauseTimeout)
+	}
+	writesOnly := false
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && strings.EqualFold(args[1], "WRITE"):
+		writesOnly = true
+	case len(args) == 2 && strings.EqualFold(args[1], "ALL"):
+	default:
+		return formatError(errSyntax)
+	}
+	rs.Pause(time.Duration(ms)*time.Millisecond, writesOnly)
+	return "OK"
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// procedure is a batch of commands registered with PROC REGISTER. Its
+// arguments may be placeholders, $1 for the first argument of PROC CALL and
+// so on, which are substituted when it is called.
+type procedure struct {
+	// body is the batch as registered, kept to persist it.
+	body  []string
+	batch []Command
+	// params is the highest placeholder used, which is how many arguments
+	// a call needs.
+	params int
+}
+
+func errNoSuchProc(name string) error {
+	return fmt.Errorf("ERR no such procedure '%s'", name)
+}
+
+// parseProcedure parses the body of PROC REGISTER, a batch of commands as
+// ATOMIC takes.
+func parseProcedure(body []string) (*procedure, error) {
+	batch, err := parseAtomicBatch(body)
+	if err != nil {
+		return nil, err
+	}
+	p := &procedure{body: body, batch: batch}
+	for _, cmd := range batch {
+		if cmd.Name == "PROC" {
+			return nil, errors.New("ERR ATOMIC aborted, 'proc' is not allowed in a batch")
+		}
+		for _, arg := range cmd.Args {
+			if n, ok := placeholder(arg); ok {
+				p.params = max(p.params, n)
+			}
+		}
+	}
+	return p, nil
+}
+
+// placeholder returns N if arg is the placeholder $N.
+func placeholder(arg string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "$"))
+	if !strings.HasPrefix(arg, "$") || err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// bind returns p's batch with its placeholders replaced by args.
+func (p *procedure) bind(args []string) []Command {
+	batch := make([]Command, len(p.batch))
+	for i, cmd := range p.batch {
+		bound := Command{Name: cmd.Name, Args: slices.Clone(cmd.Args)}
+		for j, arg := range bound.Args {
+			if n, ok := placeholder(arg); ok {
+				bound.Args[j] = args[n-1]
+			}
+		}
+		batch[i] = bound
+	}
+	return batch
+}
+
+// RegisterProc registers the procedure body under name, replacing any
+// procedure already registered there.
+func (r *RedisStore) RegisterProc(name string, body []string) error {
+	p, err := parseProcedure(body)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.procs == nil {
+		r.procs = make(map[string]*procedure)
+	}
+	r.procs[name] = p
+	return r.writeAOF("PROC", append([]string{"REGISTER", name}, body...)...)
+}
+
+// proc returns the procedure registered under name, or nil.
+func (r *RedisStore) proc(name string) *procedure {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.procs[name]
+}
+
+// procCommands returns the commands that register the current procedures,
+// for an AOF rewrite. The caller must hold the mutex.
+func (r *RedisStore) procCommands() []string {
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(r.procs)) {
+		lines = append(lines, aofLine("PROC", append([]string{"REGISTER", name}, r.procs[name].body...)...))
+	}
+	return lines
+}
+
+// procCommand handles PROC REGISTER name body and PROC CALL name [arg ...].
+// Like ATOMIC, processCommand holds execMu for writing while a call runs,
+// so no other command runs in the middle of it.
+func procCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "REGISTER":
+		if len(args) >= 3 {
+			if err := rs.RegisterProc(args[1], args[2:]); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	case "CALL":
+		if len(args) >= 2 {
+			p := rs.proc(args[1])
+			if p == nil {
+				return formatError(errNoSuchProc(args[1]))
+			}
+			if len(args)-2 != p.params {
+				return formatError(fmt.Errorf("ERR procedure '%s' takes %d arguments, got %d", args[1], p.params, len(args)-2))
+			}
+			batch := p.bind(args[2:])
+			replies := make([]string, len(batch))
+			for i, cmd := range batch {
+				replies[i] = executeCommand(cmd, rs)
+			}
+			return formatArray(replies)
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return formatError(errArity("PROC|" + strings.ToLower(args[0])))
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestProcIncrementAndRead(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "PROC REGISTER incr-read INCRBY $1 $2 ; GET $1"); got != "OK" {
+		t.Fatalf("PROC REGISTER = %q", got)
+	}
+	if got := run(rs, "PROC CALL incr-read counter 5"); got != "1) 5\n2) 5" {
+		t.Errorf("PROC CALL = %q", got)
+	}
+	if got := run(rs, "PROC CALL incr-read counter 2"); got != "1) 7\n2) 7" {
+		t.Errorf("second PROC CALL = %q", got)
+	}
+	if got := run(rs, "PROC CALL incr-read counter"); got != "-ERR procedure 'incr-read' takes 2 arguments, got 1" {
+		t.Errorf("PROC CALL with too few arguments = %q", got)
+	}
+	if got := run(rs, "PROC CALL nope"); got != "-ERR no such procedure 'nope'" {
+		t.Errorf("PROC CALL of an unknown procedure = %q", got)
+	}
+	if got := run(rs, "PROC REGISTER bad INCR"); got != "-ERR ATOMIC aborted, wrong number of arguments for 'incr' command" {
+		t.Errorf("PROC REGISTER with an arity error = %q", got)
+	}
+
+	// The registration survives a reload, from the AOF and from a rewrite.
+	rs = reopen(t, rs)
+	if got := run(rs, "PROC CALL incr-read counter 1"); got != "1) 8\n2) 8" {
+		t.Errorf("PROC CALL after a reload = %q", got)
+	}
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	rs = reopen(t, rs)
+	if got := run(rs, "PROC CALL incr-read counter 1"); got != "1) 9\n2) 9" {
+		t.Errorf("PROC CALL after a rewrite = %q", got)
+	}
+}
+
+func TestProcIsolation(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "PROC REGISTER reset SET $1 0 ; INCR $1 ; GET $1")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			run(rs, "INCR n")
+		}
+	}()
+	for range 100 {
+		if got := run(rs, "PROC CALL reset n"); got != "1) OK\n2) 1\n3) 1" {
+			t.Fatalf("PROC CALL interleaved with another client: %q", got)
+		}
+	}
+	<-done
+}
+
+func TestProcChecksPermissions(t *testing.T) {
+	rs := newACLStore(t, "default nopass +proc +get")
+	run(rs, "PROC REGISTER put SET $1 $2")
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "PROC CALL put k v"); got != "-NOPERM this user has no permissions to run the 'set' command" {
+		t.Errorf("PROC CALL with a forbidden command = %q", got)
+	}
+}
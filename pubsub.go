@@ -0,0 +1,178 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pubSub is a registry mapping channel names and patterns to their
+// subscribers. Regular and sharded channels live in separate registries, so
+// the same name in each is a distinct channel; only the regular registry has
+// pattern subscriptions.
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*client]struct{}
+	patterns map[string]map[*client]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*client]struct{}),
+		patterns: make(map[string]map[*client]struct{}),
+	}
+}
+
+func addSubscriber(m map[string]map[*client]struct{}, c *client, name string) {
+	subs, ok := m[name]
+	if !ok {
+		subs = make(map[*client]struct{})
+		m[name] = subs
+	}
+	subs[c] = struct{}{}
+}
+
+func removeSubscriber(m map[string]map[*client]struct{}, c *client, name string) {
+	subs := m[name]
+	delete(subs, c)
+	if len(subs) == 0 {
+		delete(m, name)
+	}
+}
+
+func (p *pubSub) subscribe(c *client, channel string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addSubscriber(p.channels, c, channel)
+}
+
+func (p *pubSub) unsubscribe(c *client, channel string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removeSubscriber(p.channels, c, channel)
+}
+
+func (p *pubSub) psubscribe(c *client, pattern string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addSubscriber(p.patterns, c, pattern)
+}
+
+func (p *pubSub) punsubscribe(c *client, pattern string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removeSubscriber(p.patterns, c, pattern)
+}
+
+// publish delivers message to every subscriber of channel as a frame of the
+// given kind ("message" or "smessage"), and as a "pmessage" frame to every
+// subscriber of a matching pattern. It returns how many deliveries it made,
+// so a client subscribed to the channel and a matching pattern counts twice.
+//
+// Each frame is queued on the subscriber's outbox before publish returns,
+// and the outbox writes frames in the order they were queued. So a
+// subscriber sees any one publisher's messages in the order it published
+// them, however many other publishers are interleaving with it. Queueing
+// never waits on the subscriber's connection: a subscriber too slow to
+// keep its output within the pubsub output buffer limit is disconnected
+// instead, and the message is not counted as delivered to it.
+func (p *pubSub) publish(kind, channel, message string) int {
+	type delivery struct {
+		c     *client
+		frame string
+	}
+	p.mu.RLock()
+	frame := formatArray([]string{kind, channel, message})
+	deliveries := make([]delivery, 0, len(p.channels[channel]))
+	for c := range p.channels[channel] {
+		deliveries = append(deliveries, delivery{c, frame})
+	}
+	for pattern, subs := range p.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		frame := formatArray([]string{"pmessage", pattern, channel, message})
+		for c := range subs {
+			deliveries = append(deliveries, delivery{c, frame})
+		}
+	}
+	p.mu.RUnlock()
+
+	n := 0
+	for _, d := range deliveries {
+		if d.c.deliver(d.frame) {
+			n++
+		}
+	}
+	return n
+}
+
+// numPat returns the number of patterns with at least one subscriber.
+func (p *pubSub) numPat() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.patterns)
+}
+
+// active returns the channels with at least one subscriber that match
+// pattern, or all of them if pattern is empty.
+func (p *pubSub) active(pattern string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var names []string
+	for name := range p.channels {
+		if pattern == "" || matchPattern(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// numSub returns each channel followed by its subscriber count.
+func (p *pubSub) numSub(channels []string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	reply := make([]string, 0, 2*len(channels))
+	for _, channel := range channels {
+		reply = append(reply, channel, strconv.Itoa(len(p.channels[channel])))
+	}
+	return reply
+}
+
+func (r *RedisStore) Publish(channel, message string) int {
+	return r.pubsub.publish("message", channel, message)
+}
+
+func (r *RedisStore) SPublish(channel, message string) int {
+	return r.shardPubsub.publish("smessage", channel, message)
+}
+
+func pubsubCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) <= 2 {
+			registry := rs.pubsub
+			if strings.ToUpper(args[0]) == "SHARDCHANNELS" {
+				registry = rs.shardPubsub
+			}
+			pattern := ""
+			if len(args) == 2 {
+				pattern = args[1]
+			}
+			return formatArray(registry.active(pattern))
+		}
+	case "NUMSUB":
+		return formatArray(rs.pubsub.numSub(args[1:]))
+	case "SHARDNUMSUB":
+		return formatArray(rs.shardPubsub.numSub(args[1:]))
+	case "NUMPAT":
+		if len(args) == 1 {
+			return strconv.Itoa(rs.pubsub.numPat())
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
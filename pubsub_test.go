@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a goroutine-safe writer capturing everything sent to a client.
+type recorder struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.String()
+}
+
+// newTestClient returns a client of rs whose output is captured.
+func newTestClient(t *testing.T, rs *RedisStore) (*client, *recorder) {
+	t.Helper()
+	out := &recorder{}
+	c := newClient(out, rs)
+	t.Cleanup(c.close)
+	return c, out
+}
+
+// send dispatches line on c and writes the reply as the connection would,
+// waiting until it has been sent.
+func send(c *client, line string) string {
+	reply := c.processCommand(parseCommand(line))
+	c.write(reply)
+	c.flush()
+	return reply
+}
+
+func TestShardedPubSubIsolated(t *testing.T) {
+	rs := newTestStore(t)
+	sharded, shardedOut := newTestClient(t, rs)
+	regular, regularOut := newTestClient(t, rs)
+
+	if got := send(sharded, "SSUBSCRIBE news"); got != "1) ssubscribe\n2) news\n3) 1" {
+		t.Errorf("SSUBSCRIBE reply = %q", got)
+	}
+	send(regular, "SUBSCRIBE news")
+
+	if got := run(rs, "SPUBLISH news hello"); got != "1" {
+		t.Errorf("SPUBLISH reached %s subscribers, want 1", got)
+	}
+	sharded.flush()
+	regular.flush()
+	if !strings.Contains(shardedOut.String(), "1) smessage\n2) news\n3) hello\n") {
+		t.Errorf("shard subscriber did not get the message:\n%s", shardedOut)
+	}
+	if strings.Contains(regularOut.String(), "hello") {
+		t.Errorf("regular subscriber got a shard message:\n%s", regularOut)
+	}
+
+	if got := run(rs, "PUBLISH news bye"); got != "1" {
+		t.Errorf("PUBLISH reached %s subscribers, want 1", got)
+	}
+	sharded.flush()
+	regular.flush()
+	if strings.Contains(shardedOut.String(), "bye") {
+		t.Errorf("shard subscriber got a regular message:\n%s", shardedOut)
+	}
+	if !strings.Contains(regularOut.String(), "1) message\n2) news\n3) bye\n") {
+		t.Errorf("regular subscriber did not get the message:\n%s", regularOut)
+	}
+}
+
+func TestPubSubShardIntrospection(t *testing.T) {
+	rs := newTestStore(t)
+	a, _ := newTestClient(t, rs)
+	b, _ := newTestClient(t, rs)
+	send(a, "SSUBSCRIBE orders events")
+	send(b, "SSUBSCRIBE orders")
+	send(b, "SUBSCRIBE chat")
+
+	if got := run(rs, "PUBSUB SHARDCHANNELS"); got != "1) events\n2) orders" {
+		t.Errorf("SHARDCHANNELS = %q", got)
+	}
+	if got := run(rs, "PUBSUB SHARDCHANNELS ord*"); got != "1) orders" {
+		t.Errorf("SHARDCHANNELS ord* = %q", got)
+	}
+	if got := run(rs, "PUBSUB SHARDNUMSUB orders chat"); got != "1) orders\n2) 2\n3) chat\n4) 0" {
+		t.Errorf("SHARDNUMSUB = %q", got)
+	}
+	if got := run(rs, "PUBSUB CHANNELS"); got != "1) chat" {
+		t.Errorf("CHANNELS = %q", got)
+	}
+
+	if got := send(a, "SUNSUBSCRIBE"); got != "1) sunsubscribe\n2) events\n3) 1\n1) sunsubscribe\n2) orders\n3) 0" {
+		t.Errorf("SUNSUBSCRIBE reply = %q", got)
+	}
+	if got := run(rs, "PUBSUB SHARDNUMSUB orders events"); got != "1) orders\n2) 1\n3) events\n4) 0" {
+		t.Errorf("SHARDNUMSUB after SUNSUBSCRIBE = %q", got)
+	}
+}
+
+func TestSubscribedClientRejectsCommands(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	send(c, "SSUBSCRIBE ch")
+	if got := send(c, "GET k"); !strings.HasPrefix(got, "-ERR Can't execute 'GET'") {
+		t.Errorf("GET while subscribed = %q", got)
+	}
+	send(c, "SUNSUBSCRIBE ch")
+	if got := send(c, "GET k"); got != "nil" {
+		t.Errorf("GET after unsubscribing = %q", got)
+	}
+}
+
+func TestSubscribeCountsAllSubscriptions(t *testing.T) {
+	rs := newTestStore(t)
+	c, _ := newTestClient(t, rs)
+	want := "1) subscribe\n2) a\n3) 1\n1) subscribe\n2) b\n3) 2\n1) subscribe\n2) c\n3) 3"
+	if got := send(c, "SUBSCRIBE a b c"); got != want {
+		t.Errorf("SUBSCRIBE a b c = %q, want counts 1, 2, 3", got)
+	}
+	if got := send(c, "PSUBSCRIBE news.*"); got != "1) psubscribe\n2) news.*\n3) 4" {
+		t.Errorf("PSUBSCRIBE = %q, want the count to include the channels", got)
+	}
+	if got := send(c, "SSUBSCRIBE s"); got != "1) ssubscribe\n2) s\n3) 1" {
+		t.Errorf("SSUBSCRIBE = %q, want shard channels counted on their own", got)
+	}
+	if got := send(c, "UNSUBSCRIBE b"); got != "1) unsubscribe\n2) b\n3) 3" {
+		t.Errorf("UNSUBSCRIBE b = %q", got)
+	}
+	if got := send(c, "PUNSUBSCRIBE"); got != "1) punsubscribe\n2) news.*\n3) 2" {
+		t.Errorf("PUNSUBSCRIBE = %q", got)
+	}
+	if got := send(c, "UNSUBSCRIBE"); got != "1) unsubscribe\n2) a\n3) 1\n1) unsubscribe\n2) c\n3) 0" {
+		t.Errorf("UNSUBSCRIBE = %q", got)
+	}
+}
+
+func TestPatternSubscription(t *testing.T) {
+	rs := newTestStore(t)
+	c, out := newTestClient(t, rs)
+	send(c, "PSUBSCRIBE news.*")
+	send(c, "SUBSCRIBE news.tech")
+	if got := run(rs, "PUBLISH news.tech hi"); got != "2" {
+		t.Errorf("PUBLISH to a channel and a matching pattern reached %s, want 2", got)
+	}
+	c.flush()
+	if !strings.Contains(out.String(), "1) pmessage\n2) news.*\n3) news.tech\n4) hi\n") {
+		t.Errorf("pattern subscriber did not get a pmessage:\n%s", out)
+	}
+	if got := run(rs, "PUBLISH sports hi"); got != "0" {
+		t.Errorf("PUBLISH to a non-matching channel reached %s", got)
+	}
+	if got := run(rs, "PUBSUB NUMPAT"); got != "1" {
+		t.Errorf("PUBSUB NUMPAT = %q", got)
+	}
+}
+
+func TestProtocolErrorUnsubscribes(t *testing.T) {
+	rs := newTestStore(t)
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConnection(server, rs, nil)
+		close(done)
+	}()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "SUBSCRIBE news\n")
+	for range 3 {
+		r.ReadString('\n')
+	}
+	if got := run(rs, "PUBSUB NUMSUB news"); got != "1) news\n2) 1" {
+		t.Fatalf("PUBSUB NUMSUB after SUBSCRIBE = %q", got)
+	}
+
+	go fmt.Fprintf(conn, "%s\n", strings.Repeat("x", bufio.MaxScanTokenSize))
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "-ERR Protocol error") {
+		t.Errorf("reply to an oversized line = %q, want a protocol error", line)
+	}
+	<-done
+	if got := run(rs, "PUBSUB NUMSUB news"); got != "1) news\n2) 0" {
+		t.Errorf("PUBSUB NUMSUB after the protocol error = %q, want the client unsubscribed", got)
+	}
+	conn.Close()
+}
+
+func TestPublishOrderPerSubscriber(t *testing.T) {
+	const publishers, messages = 8, 200
+	rs := newTestStore(t)
+	subs := make([]*client, 3)
+	outs := make([]*recorder, len(subs))
+	for i := range subs {
+		subs[i], outs[i] = newTestClient(t, rs)
+		send(subs[i], "SUBSCRIBE news")
+	}
+
+	var wg sync.WaitGroup
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range messages {
+				run(rs, fmt.Sprintf("PUBLISH news p%d:%d", p, n))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, sub := range subs {
+		sub.flush()
+		next := make([]int, publishers)
+		for _, line := range strings.Split(outs[i].String(), "\n") {
+			var p, n int
+			if _, err := fmt.Sscanf(line, "3) p%d:%d", &p, &n); err != nil {
+				continue
+			}
+			if n != next[p] {
+				t.Fatalf("subscriber %d got message %d from publisher %d, want %d", i, n, p, next[p])
+			}
+			next[p]++
+		}
+		for p, n := range next {
+			if n != messages {
+				t.Errorf("subscriber %d got %d messages from publisher %d, want %d", i, n, p, messages)
+			}
+		}
+	}
+}
+
+func TestPublishDisconnectsStalledSubscriber(t *testing.T) {
+	rs := newTestStore(t)
+	if err := rs.ConfigSet("client-output-buffer-limit", "pubsub 4kb 0 0"); err != nil {
+		t.Fatal(err)
+	}
+	// A subscriber on a pipe, which holds nothing unread, that stops
+	// reading once it has subscribed.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		handleConnection(conn, rs, nil)
+		close(done)
+	}()
+	fmt.Fprintln(peer, "SUBSCRIBE news")
+	stalled := bufio.NewReader(peer)
+	for range 3 {
+		if _, err := stalled.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	healthy, out := newTestClient(t, rs)
+	send(healthy, "SUBSCRIBE news")
+
+	msg := strings.Repeat("x", 100)
+	var counts []string
+	for range 100 {
+		counts = append(counts, run(rs, "PUBLISH news "+msg))
+		// Each message reaches the healthy subscriber while the other
+		// is stalled.
+		healthy.flush()
+	}
+	if n := strings.Count(out.String(), msg); n != 100 {
+		t.Errorf("healthy subscriber got %d messages, want 100", n)
+	}
+	if counts[0] != "2" || counts[len(counts)-1] != "1" {
+		t.Errorf("PUBLISH counts went from %s to %s, want 2 to 1", counts[0], counts[len(counts)-1])
+	}
+
+	// Cut off, the stalled subscriber reads what was in flight, then EOF,
+	// and its subscription goes with the connection.
+	if _, err := io.ReadAll(stalled); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got := run(rs, "PUBSUB NUMSUB news"); got != "1) news\n2) 1" {
+		t.Errorf("NUMSUB after the disconnect = %q, want 1", got)
+	}
+}
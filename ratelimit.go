@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errRateLimited = errors.New("ERR client command rate limit exceeded")
+
+// rateLimiter is a token bucket holding up to one second's worth of
+// commands, refilled continuously at the configured rate.
+type rateLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if one is available. Otherwise it returns how long
+// until one will be.
+func (l *rateLimiter) take(now time.Time, rate int) time.Duration {
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens = min(float64(rate), l.tokens+now.Sub(l.last).Seconds()*float64(rate))
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / float64(rate) * float64(time.Second))
+}
+
+// throttle enforces client-rate-limit on cmd. Under the delay policy it
+// waits until the command may run; under the error policy it returns
+// errRateLimited instead. Blocking commands and Pub/Sub subscription
+// commands are exempt, so a client waiting on them is never held up.
+func (c *client) throttle(cmd Command) error {
+	if blockingCommands[cmd.Name] || subscribedCommands[cmd.Name] {
+		return nil
+	}
+	for {
+		c.rs.mutex.RLock()
+		rate, policy := c.rs.config.ClientRateLimit, c.rs.config.ClientRateLimitPolicy
+		c.rs.mutex.RUnlock()
+		if rate <= 0 {
+			return nil
+		}
+		wait := c.limiter.take(c.rs.clock.Now(), rate)
+		if wait == 0 {
+			return nil
+		}
+		if policy == "error" {
+			return errRateLimited
+		}
+		<-c.rs.clock.After(wait)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitErrorPolicy(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+	run(rs, "CONFIG SET client-rate-limit 10")
+	run(rs, "CONFIG SET client-rate-limit-policy error")
+	runaway, _ := newTestClient(t, rs)
+	compliant, _ := newTestClient(t, rs)
+
+	for i := range 10 {
+		if got := send(runaway, "SET a 1"); got != "OK" {
+			t.Fatalf("command %d within the limit = %q", i, got)
+		}
+	}
+	if got := send(runaway, "SET a 1"); got != formatError(errRateLimited) {
+		t.Errorf("command over the limit = %q, want %q", got, formatError(errRateLimited))
+	}
+	if got := send(compliant, "SET b 1"); got != "OK" {
+		t.Errorf("another client's command = %q, want it unaffected", got)
+	}
+
+	rs.clock.(*fakeClock).Advance(100 * time.Millisecond)
+	if got := send(runaway, "SET a 1"); got != "OK" {
+		t.Errorf("command after the bucket refilled = %q, want OK", got)
+	}
+}
+
+func TestRateLimitDelayPolicy(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "CONFIG SET client-rate-limit 5")
+	runaway, _ := newTestClient(t, rs)
+	compliant, _ := newTestClient(t, rs)
+
+	for range 5 {
+		send(runaway, "SET a 1")
+	}
+	reply := make(chan string)
+	go func() { reply <- send(runaway, "SET a 2") }()
+	waitUntil(t, func() bool { return clk.pendingTimers() == 1 })
+
+	if got := send(compliant, "GET a"); got != "1" {
+		t.Errorf("GET on a compliant client = %q, want 1 without waiting", got)
+	}
+	select {
+	case got := <-reply:
+		t.Fatalf("throttled command returned %q before a token was available", got)
+	default:
+	}
+
+	clk.Advance(200 * time.Millisecond)
+	if got := <-reply; got != "OK" {
+		t.Errorf("throttled command = %q, want OK once delayed", got)
+	}
+}
+
+func TestRateLimitExemptsBlockingCommands(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+	run(rs, "CONFIG SET client-rate-limit 1")
+	run(rs, "CONFIG SET client-rate-limit-policy error")
+	c, _ := newTestClient(t, rs)
+	send(c, "RPUSH l a b")
+	if got := send(c, "BLMPOP 0 1 l LEFT"); got == formatError(errRateLimited) {
+		t.Error("BLMPOP was rate limited")
+	}
+}
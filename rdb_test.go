@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRDBCRC(t *testing.T) {
+	// The check value Redis's crc64 test uses.
+	if got := rdbCRC(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("rdbCRC = %#x, want 0xe9c6d914c4b8d9ca", got)
+	}
+}
+
+func TestRDBExportRoundTrip(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	long := strings.Repeat("x", 20000)
+	for _, cmd := range []string{
+		"SET str hello",
+		"SET long " + long,
+		"RPUSH list a b c",
+		"SADD set x y",
+		"HSET hash f1 v1 f2 v2",
+		"ZADD zset 1.5 a -inf b",
+		"SET volatile v",
+		"PEXPIRE volatile 60000",
+	} {
+		run(rs, cmd)
+	}
+	if got := run(rs, "DEBUG RDB-EXPORT dump.rdb"); got != "OK" {
+		t.Fatalf("DEBUG RDB-EXPORT = %q", got)
+	}
+	path := filepath.Join(rs.config.Dir, "dump.rdb")
+	for _, name := range []string{filepath.Join(t.TempDir(), "dump.rdb"), "../dump.rdb", "sub/dump.rdb", ".."} {
+		if got := run(rs, "DEBUG RDB-EXPORT "+name); got != formatError(errExportName) {
+			t.Errorf("DEBUG RDB-EXPORT %s = %q, want it refused", name, got)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte("REDIS0009")) {
+		t.Errorf("file starts %q, want the REDIS0009 header", raw[:9])
+	}
+	entries, err := readRDB(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.mutex.RLock()
+	want := rs.snapshotEntries()
+	rs.mutex.RUnlock()
+	if len(entries) != len(want) {
+		t.Fatalf("read %d keys, want %d", len(entries), len(want))
+	}
+
+	// Reloading the entries must reproduce the keyspace exactly.
+	loaded := newTestStore(t)
+	loaded.clock = clk
+	for _, e := range entries {
+		sv, err := loaded.storedValue(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sv.expireAt = e.ExpireAt
+		loaded.data[e.Key] = sv
+	}
+	if loaded.Digest() != rs.Digest() {
+		t.Error("keyspace read back from the RDB differs from the original")
+	}
+}
+
+func TestRDBRejectsCorruption(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+	var buf bytes.Buffer
+	rs.mutex.RLock()
+	entries := rs.snapshotEntries()
+	rs.mutex.RUnlock()
+	if err := writeRDB(&buf, entries, rs.clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	i := bytes.LastIndex(raw, []byte("1"))
+	corrupt := slices.Clone(raw)
+	corrupt[i] = '2'
+	if _, err := readRDB(bytes.NewReader(corrupt)); err == nil {
+		t.Error("readRDB accepted a file with a bad checksum")
+	}
+	if _, err := readRDB(bytes.NewReader(raw[:len(raw)-12])); err == nil {
+		t.Error("readRDB accepted a truncated file")
+	}
+}
@@ -2,56 +2,246 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Command struct {
 	Name string
 	Args []string
+	// client is the registry entry of the connection that sent the
+	// command, or nil for a command run internally.
+	client *clientInfo
 }
 
+// StoredValue is a single entry in the keyspace. value holds a string for
+// string keys, a []string for lists, a map[string]struct{} for sets, a
+// *hashValue for hashes, a *sortedSet for sorted sets or a *stream for
+// streams.
+type StoredValue struct {
+	value any
+	// encoding is the OBJECT ENCODING of a string, list or set value,
+	// updated whenever the value is stored.
+	encoding string
+	// expireAt is when the key expires, in Unix milliseconds, or zero if
+	// it has no TTL. Every expiry is kept to the millisecond, whichever
+	// command set it, so TTL and PTTL never disagree.
+	expireAt int64
+	// accessed is when the key was last read or written, in Unix
+	// nanoseconds. It is atomic because reads update it holding only the
+	// read lock.
+	accessed atomic.Int64
+	// freq is the LFU access counter given by RESTORE FREQ.
+	freq uint8
+	// reaped is set, under the mutex, once the value's lazy expiry has
+	// been propagated, so that it happens only once.
+	reaped bool
+	// members indexes the members of a hashtable-encoded set for SSCAN,
+	// and is nil for any other value.
+	members *scanIndex
+}
+
+// newValue returns a StoredValue holding value, accessed now.
+func (r *RedisStore) newValue(value any) *StoredValue {
+	sv := &StoredValue{value: value}
+	sv.accessed.Store(r.clock.Now().UnixNano())
+	return sv
+}
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type RedisStore struct {
-	data      map[string]string
-	mutex     sync.RWMutex
-	aofFile   *os.File
-	aofWriter *bufio.Writer
+	data map[string]*StoredValue
+	// db is the index of the database data is, which names the channels of
+	// its keyspace events. There is no SELECT, so it is always 0 outside
+	// tests.
+	db int
+	// keyIndex holds the keys of data in SCAN order and volatile those
+	// with a TTL, kept up to date by touch and guarded by mutex.
+	keyIndex scanIndex
+	volatile map[string]struct{}
+	mutex    sync.RWMutex
+	// execMu is held for reading while a command runs, and for writing by
+	// ATOMIC and PROC so that no other command runs in the middle of their
+	// batches. It is always taken before mutex.
+	execMu  sync.RWMutex
+	aofFile *os.File
+	// aofWriter is where AOF records go, normally aofFile. aofBuf holds
+	// records not yet written because an earlier write failed; they are
+	// retried before the next record. aofErr is the error from the last
+	// attempt, or nil once the AOF has caught up.
+	aofWriter io.Writer
+	aofBuf    []byte
+	aofErr    error
+	// aofAppended counts the records given to writeAOF and aofWritten
+	// those that have reached aofWriter; aofSync fsyncs them.
+	aofAppended uint64
+	aofWritten  atomic.Uint64
+	// aofSize is how many bytes the AOF holds and aofBaseSize how many it
+	// held after the last rewrite or at startup.
+	aofSize       int64
+	aofBaseSize   int64
+	aofSync       *aofSync
+	stopAOFTicker func()
+	// expiredKeys holds the expired keys lookups have found, for
+	// reapExpired to delete. It is guarded by expiredMu rather than mutex
+	// since reads add to it holding mutex only for reading.
+	expiredMu   sync.Mutex
+	expiredKeys map[string]*StoredValue
+	// stopActiveExpire stops the active expiry cycle, if it was started.
+	stopActiveExpire func()
+	// loading is set while the AOF is replayed so that replayed commands are
+	// not appended to the file a second time.
+	loading bool
+	clock   clock
+	config  Config
+	// waiters holds the clients blocked on each key, guarded by mutex.
+	waiters map[string][]*waiter
+	// readyKeys are the keys written since blocked clients were last
+	// served, in the order they were first written, guarded by mutex.
+	// anyReady is set while it is not empty, for serveBlocked to check
+	// without the lock.
+	readyKeys []string
+	anyReady  atomic.Bool
+	// watched holds the version of each key some client is WATCHing,
+	// guarded by mutex.
+	watched map[string]*watchedKey
+	// procs holds the procedures registered with PROC REGISTER, guarded
+	// by mutex.
+	procs   map[string]*procedure
+	clients clientRegistry
+	pause   clientPause
+	// pubsub and shardPubsub are the regular and sharded Pub/Sub channel
+	// registries. They have their own locks.
+	pubsub      *pubSub
+	shardPubsub *pubSub
+	// rewriteBuf collects AOF records written while BGREWRITEAOF is
+	// building the new file, so they can be appended before it replaces the
+	// old one. It is nil when no rewrite is running.
+	rewriteBuf *strings.Builder
+	repl       replication
+	tracking   tracking
+	slowlog    slowlog
+	stats      stats
+	hook       testHook
 }
 
-func NewRedisStore() (*RedisStore, error) {
-	fmt.Println("Creating RedisStore...")
-	aofFile, err := os.OpenFile("redisstore.aof", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func NewRedisStore(cfg Config) (*RedisStore, error) {
+	log.Println("Creating RedisStore...")
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &RedisStore{
+		data:        make(map[string]*StoredValue),
+		volatile:    make(map[string]struct{}),
+		clock:       realClock{},
+		config:      cfg,
+		waiters:     make(map[string][]*waiter),
+		watched:     make(map[string]*watchedKey),
+		pubsub:      newPubSub(),
+		shardPubsub: newPubSub(),
+	}
+	aofFile, err := r.openAOF()
 	if err != nil {
 		return nil, err
 	}
-	aofWriter := bufio.NewWriter(aofFile)
-	return &RedisStore{
-		data:      make(map[string]string),
-		aofFile:   aofFile,
-		aofWriter: aofWriter,
-	}, nil
+	r.aofFile = aofFile
+	r.aofWriter = aofFile
+	if err := r.resetAOFSize(); err != nil {
+		aofFile.Close()
+		return nil, err
+	}
+	r.startAOFSync(aofFile)
+	return r, nil
 }
 
 func (r *RedisStore) Close() {
+	if r.stopActiveExpire != nil {
+		r.stopActiveExpire()
+	}
 	if r.aofFile != nil {
-		r.aofWriter.Flush()
+		r.stopAOFTicker()
+		r.aofSync.close()
+		r.flushAOF()
 		r.aofFile.Close()
 	}
 }
 
-func (r *RedisStore) writeAOF(command string, args ...string) {
-	line := fmt.Sprintf("%s %s\n", command, strings.Join(args, " "))
-	r.aofWriter.WriteString(line)
-	r.aofWriter.Flush()
+// writeAOF appends a record to the AOF. If it cannot be written, the error
+// is returned only when aof-stop-writes-on-error is set, so that the client
+// is not told OK for a write that was not persisted; otherwise it is logged
+// and reported by INFO. The caller must hold the mutex.
+func (r *RedisStore) writeAOF(command string, args ...string) error {
+	if r.loading {
+		return nil
+	}
+	line := aofLine(command, args...)
+	r.aofBuf = append(r.aofBuf, line...)
+	r.aofAppended++
+	r.repl.propagate(len(line))
+	if r.rewriteBuf != nil {
+		r.rewriteBuf.WriteString(line)
+	}
+	if r.hook != nil {
+		r.hook.flushingAOF()
+	}
+	if err := r.flushAOF(); err != nil && r.config.AOFStopWritesOnError {
+		return fmt.Errorf("MISCONF Errors writing to the AOF file: %v", err)
+	}
+	return nil
+}
+
+// flushAOF writes the pending AOF records, keeping whatever could not be
+// written for the next attempt. The caller must hold the mutex.
+func (r *RedisStore) flushAOF() error {
+	if len(r.aofBuf) == 0 {
+		return nil
+	}
+	n, err := r.aofWriter.Write(r.aofBuf)
+	r.aofBuf = r.aofBuf[n:]
+	r.aofSize += int64(n)
+	if err != nil {
+		if r.aofErr == nil {
+			log.Println("error writing to the AOF file: ", err)
+		}
+		r.aofErr = err
+		return err
+	}
+	if r.aofErr != nil {
+		log.Println("AOF write error cleared, the AOF is up to date")
+	}
+	r.aofBuf, r.aofErr = nil, nil
+	r.aofWritten.Store(r.aofAppended)
+	return nil
+}
+
+// aofLine encodes a record as a RESP array of bulk strings, the form clients
+// send, so that arguments holding spaces, line breaks or nothing at all are
+// replayed as they were given.
+func aofLine(command string, args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(command), command)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
 }
 
 func (r *RedisStore) loadAOF() error {
-	file, err := os.Open("redisstore.aof")
+	file, err := os.Open(r.path(aofFilename))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -59,48 +249,151 @@ func (r *RedisStore) loadAOF() error {
 		return err
 	}
 	defer file.Close()
-
-	// Replace inputCapture with processAOFCommands
 	return r.processAOFCommands(file)
 }
 
-// New function to process AOF commands without entering an infinite loop
+// processAOFCommands replays each write through the normal dispatch with
+// AOF writes suppressed, so every persisted command is rebuilt the same way
+// it was first applied. Records are read as requests are, so a file written
+// as inline lines by an older version still loads. A last record cut short,
+// as a crash mid-write leaves it, is dropped.
 func (r *RedisStore) processAOFCommands(file io.Reader) error {
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+	r.loading = true
+	defer func() { r.loading = false }()
 
-		command := parseCommand(line)
-		// Only process SET commands when loading from AOF
-		if command.Name == "SET" && len(command.Args) >= 2 {
-			// Set directly to the data map without writing to AOF again
-			r.mutex.Lock()
-			r.data[command.Args[0]] = command.Args[1]
-			r.mutex.Unlock()
+	reader := bufio.NewReader(file)
+	for {
+		cmd, err := readRequest(reader)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			log.Println("ignoring the truncated last record of the AOF")
+			return nil
+		case err != nil:
+			return err
+		}
+		if cmd.Name != "" {
+			processCommand(cmd, r)
 		}
 	}
-
-	return scanner.Err()
 }
 
-func (r *RedisStore) Get(key string) (string, bool) {
+func (r *RedisStore) Get(key string) (string, bool, error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
-	val, exists := r.data[key]
-	if !exists {
-		return "", false
+	sv := r.lookup(key)
+	r.stats.keyspaceRead(sv != nil)
+	if sv == nil {
+		return "", false, nil
+	}
+	val, ok := stringValue(sv.value)
+	if !ok {
+		return "", false, errWrongType
 	}
-	return val, true
+	return val, true, nil
 }
 
-func (r *RedisStore) Set(key string, val string) {
+// Del removes keys, returning how many existed.
+func (r *RedisStore) Del(keys []string) (int, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	r.data[key] = val
-	r.writeAOF("SET", key, val)
+	removed := 0
+	for _, key := range keys {
+		if r.lookupNoTouch(key) != nil {
+			delete(r.data, key)
+			r.touch(key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		if err := r.writeAOF("DEL", keys...); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+func (r *RedisStore) Set(key string, val string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.newValue(sharedInteger(val))
+	sv.encoding = stringEncoding(val, r.config.EmbstrSizeLimit)
+	r.data[key] = sv
+	r.touch(key)
+	return r.writeAOF("SET", key, val)
+}
+
+// CompareAndSet sets key to val only if it currently holds expected,
+// reporting whether it did. A missing key only matches an empty expected
+// value. Like SET, a successful swap clears the key's TTL.
+func (r *RedisStore) CompareAndSet(key, expected, val string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var current string
+	if sv := r.lookup(key); sv != nil {
+		s, ok := stringValue(sv.value)
+		if !ok {
+			return false, errWrongType
+		}
+		current = s
+	} else if expected != "" {
+		return false, nil
+	}
+	if current != expected {
+		return false, nil
+	}
+	sv := r.newValue(sharedInteger(val))
+	sv.encoding = stringEncoding(val, r.config.EmbstrSizeLimit)
+	r.data[key] = sv
+	r.touch(key)
+	if err := r.writeAOF("SET", key, val); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+var errOverflow = errors.New("ERR increment or decrement would overflow")
+
+// IncrBy adds delta to the integer stored at key, treating a missing key as
+// 0, and returns the new value. The key keeps its TTL.
+func (r *RedisStore) IncrBy(key string, delta int64) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(key)
+	var n int64
+	if sv != nil {
+		s, ok := stringValue(sv.value)
+		if !ok {
+			return 0, errWrongType
+		}
+		var err error
+		if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, errOverflow
+	}
+	n += delta
+	val := sharedInteger(strconv.FormatInt(n, 10))
+	if sv == nil {
+		sv = r.newValue(val)
+		r.data[key] = sv
+	}
+	sv.value, sv.encoding = val, stringEncoding(val, r.config.EmbstrSizeLimit)
+	r.touch(key)
+	if err := r.writeAOF("INCRBY", key, strconv.FormatInt(delta, 10)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func incrReply(n int64, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	return strconv.FormatInt(n, 10)
 }
 
 func parseCommand(input string) Command {
@@ -114,56 +407,485 @@ func parseCommand(input string) Command {
 	}
 }
 
-func handleConnection(conn net.Conn, rs *RedisStore) {
+// handleConnection serves conn until it closes. tc, if not nil, is how the
+// server tracks the connection's in-flight commands for Shutdown. Requests
+// may be inline or RESP, and empty ones get no reply, as in Redis.
+func handleConnection(conn net.Conn, rs *RedisStore, tc *trackedConn) {
 	defer conn.Close()
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		command := parseCommand(scanner.Text())
-		response := processCommand(command, rs)
-		conn.Write([]byte(response + "\n"))
+	c := newClient(conn, rs)
+	defer c.close()
+	r := bufio.NewReader(conn)
+	for {
+		command, err := readRequest(r)
+		if err != nil {
+			// A malformed request is reported before the connection is
+			// dropped, whose deferred close releases the client's
+			// subscriptions.
+			if errors.Is(err, errProtocol) {
+				c.write(formatError(err))
+			}
+			return
+		}
+		if command.Name == "" {
+			continue
+		}
+		if !tc.begin() {
+			return
+		}
+		var response string
+		if blockingCommands[command.Name] {
+			stop := watchHangUp(conn, r, c.info)
+			response = c.processCommand(command)
+			stop()
+		} else {
+			response = c.processCommand(command)
+		}
+		c.write(response)
+		// The reply must be sent before Shutdown, which waits for the
+		// command to end, closes the connection.
+		c.flush()
+		if !tc.end() {
+			return
+		}
 	}
 }
 
-func StartServer(rs *RedisStore) error {
-	listener, err := net.Listen("tcp", ":6379")
-	if err != nil {
+// watchHangUp watches conn while a blocking command runs, so that a client
+// that hangs up stops waiting instead of being handed an element it can
+// never receive. It peeks rather than reads, leaving a command pipelined
+// after the blocking one for the next read. stop ends the watch, and must
+// be called before r is read again.
+func watchHangUp(conn net.Conn, r *bufio.Reader, ci *clientInfo) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			ci.hangUp()
+		}
+	}()
+	return func() {
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// configureConn enables TCP_NODELAY and keepalive on an accepted connection.
+// The options only exist for TCP, so other listeners such as Unix sockets
+// get their connections unchanged.
+func configureConn(conn net.Conn, cfg Config) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tcp.SetNoDelay(true); err != nil {
 		return err
 	}
-	defer listener.Close()
-	fmt.Printf("server started on %s\n", listener.Addr())
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Println("connection error: ", err)
-			continue
-		}
-		go handleConnection(conn, rs)
+	if cfg.TCPKeepAlive <= 0 {
+		return tcp.SetKeepAlive(false)
 	}
+	// Like Redis, probe every third of the idle time and give up on the peer
+	// after three unanswered probes.
+	return tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     cfg.TCPKeepAlive,
+		Interval: max(cfg.TCPKeepAlive/3, time.Second),
+		Count:    3,
+	})
+}
+
+// blockingCommands may wait for other clients, so the time they take says
+// nothing about how expensive they are.
+var blockingCommands = map[string]bool{
+	"BLMOVE": true,
+	"BLMPOP": true,
+	"BLPOP":  true,
+	"BRPOP":  true,
+	// XREAD and XREADGROUP only block with BLOCK, but take their locks
+	// the same way either way.
+	"XREAD":      true,
+	"XREADGROUP": true,
+	// WAIT waits for replicas rather than keys, without any lock held.
+	"WAIT": true,
 }
 
+// processCommand runs cmd and, if the AOF grew meanwhile, waits for the
+// appendfsync policy before the reply is sent. The records may be another
+// client's, in which case the wait is only conservative. It happens with no
+// locks held, so that concurrent writers share one fsync. An unknown
+// command, one with the wrong number of arguments, or a write while the
+// server is read-only, is refused before it runs.
 func processCommand(cmd Command, rs *RedisStore) string {
+	if _, known := commandTable[cmd.Name]; !known {
+		return formatError(errUnknownCommand(cmd))
+	}
+	if !checkArity(cmd) {
+		return formatError(errArity(cmd.Name))
+	}
+	if !rs.loading && rs.readOnly() && isWriteCommand(cmd, rs) {
+		return formatError(errReadOnly)
+	}
+	return withAOFSync(rs, func() string { return runCommand(cmd, rs) })
+}
+
+// withAOFSync calls run with the serving of blocked clients, expiry
+// reaping, invalidations, automatic AOF rewrites and appendfsync wait of
+// processCommand.
+func withAOFSync(rs *RedisStore, run func() string) string {
+	written := rs.aofWritten.Load()
+	reply := run()
+	rs.serveBlocked()
+	rs.reapExpired()
+	rs.sendInvalidations()
+	rs.autoRewriteAOF()
+	if n := rs.aofWritten.Load(); n > written {
+		if err := rs.waitAOFSync(n); err != nil {
+			return formatError(err)
+		}
+	}
+	return reply
+}
+
+// runCommand runs cmd, timing it for the slow log and command stats.
+func runCommand(cmd Command, rs *RedisStore) string {
+	// Blocking commands take execMu for each attempt instead, so that they
+	// do not hold up ATOMIC while they wait.
+	switch {
+	case cmd.Name == "ATOMIC" || cmd.Name == "PROC":
+		rs.execMu.Lock()
+		defer rs.execMu.Unlock()
+	case !blockingCommands[cmd.Name]:
+		rs.execMu.RLock()
+		defer rs.execMu.RUnlock()
+	}
+	start := rs.clock.Now()
+	if rs.hook != nil {
+		rs.hook.processing(cmd)
+	}
+	reply := executeCommand(cmd, rs)
+	if rs.loading {
+		return reply
+	}
+	var duration time.Duration
+	if !blockingCommands[cmd.Name] {
+		duration = rs.clock.Now().Sub(start)
+		rs.recordSlow(cmd, start, duration)
+	}
+	rs.stats.command(cmd.Name, duration)
+	return reply
+}
+
+// executeCommand runs cmd, a command in commandTable. One whose arguments
+// match none of its forms is answered with a syntax error.
+func executeCommand(cmd Command, rs *RedisStore) string {
+	if reply := dispatchCommand(cmd, rs); reply != "" {
+		return reply
+	}
+	return formatError(errSyntax)
+}
+
+// dispatchCommand runs cmd through the handler for its name. The handlers
+// return an empty reply when the arguments match none of cmd's forms.
+func dispatchCommand(cmd Command, rs *RedisStore) string {
 	switch cmd.Name {
-	case "GET":
+	case "GET", "SET", "DEL", "CAS", "INCR", "DECR", "INCRBY", "DECRBY",
+		"GETDEL", "GETEX", "STRLEN", "GETRANGE", "SETRANGE", "APPEND", "GETBIT", "BITFIELD",
+		"LPUSH", "RPUSH", "LLEN", "LRANGE",
+		"RENAME", "RENAMENX", "COPY",
+		"SADD", "SREM", "SISMEMBER", "SCARD", "SMEMBERS", "SINTERCARD", "SMOVE",
+		"HSET", "HGET", "HDEL", "HLEN", "HGETALL",
+		"XADD", "XLEN", "XRANGE", "XGROUP", "XDEL", "XTRIM", "XSETID",
+		"ZADD", "ZINCRBY", "ZREM", "ZSCORE", "ZCARD", "ZRANK", "ZRANGE",
+		"ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE", "ZINTERCARD",
+		"EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "PERSIST", "TTL", "PTTL",
+		"EXPIRETIME", "PEXPIRETIME":
+		return storeCommand(cmd, rs)
+	case "LMOVE":
+		if len(cmd.Args) == 4 {
+			from, ok1 := parseListEnd(cmd.Args[2])
+			to, ok2 := parseListEnd(cmd.Args[3])
+			if !ok1 || !ok2 {
+				return formatError(errSyntax)
+			}
+			return lmoveReply(rs.LMove(cmd.Args[0], cmd.Args[1], from, to))
+		}
+	case "BLMOVE":
+		if len(cmd.Args) == 5 {
+			from, ok1 := parseListEnd(cmd.Args[2])
+			to, ok2 := parseListEnd(cmd.Args[3])
+			if !ok1 || !ok2 {
+				return formatError(errSyntax)
+			}
+			timeout, err := parseTimeout(cmd.Args[4])
+			if err != nil {
+				return formatError(err)
+			}
+			return lmoveReply(rs.BLMove(cmd.client, cmd.Args[0], cmd.Args[1], from, to, timeout))
+		}
+	case "LMPOP":
+		if len(cmd.Args) >= 3 {
+			keys, end, count, err := parseLMPopArgs(cmd.Args)
+			if err != nil {
+				return formatError(err)
+			}
+			return lmpopReply(rs.LMPop(keys, end, count))
+		}
+	case "BLMPOP":
+		if len(cmd.Args) >= 4 {
+			timeout, err := parseTimeout(cmd.Args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			keys, end, count, err := parseLMPopArgs(cmd.Args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			return lmpopReply(rs.BLMPop(cmd.client, keys, end, count, timeout))
+		}
+	case "BLPOP", "BRPOP":
+		if len(cmd.Args) >= 2 {
+			keys := cmd.Args[:len(cmd.Args)-1]
+			timeout, err := parseTimeout(cmd.Args[len(cmd.Args)-1])
+			if err != nil {
+				return formatError(err)
+			}
+			end := listLeft
+			if cmd.Name == "BRPOP" {
+				end = listRight
+			}
+			key, vals, err := rs.BLMPop(cmd.client, keys, end, 1, timeout)
+			if err != nil {
+				return formatError(err)
+			}
+			if vals == nil {
+				return "nil"
+			}
+			return formatArray([]string{key, vals[0]})
+		}
+	case "XREAD":
+		if len(cmd.Args) >= 3 {
+			a, err := parseStreamReadArgs(cmd.Name, cmd.Args)
+			if err != nil {
+				return formatError(err)
+			}
+			return formatStreamReads(rs.XRead(cmd.client, a.keys, a.ids, a.count, a.timeout))
+		}
+	case "XREADGROUP":
+		if len(cmd.Args) >= 6 && strings.ToUpper(cmd.Args[0]) == "GROUP" {
+			a, err := parseStreamReadArgs(cmd.Name, cmd.Args[3:])
+			if err != nil {
+				return formatError(err)
+			}
+			return formatStreamReads(rs.XReadGroup(cmd.client, cmd.Args[1], cmd.Args[2], a.keys, a.ids, a.count, a.timeout))
+		}
+		return formatError(errSyntax)
+	case "RPOPLPUSH":
+		// Deprecated in favour of LMOVE source destination RIGHT LEFT.
+		if len(cmd.Args) == 2 {
+			return lmoveReply(rs.LMove(cmd.Args[0], cmd.Args[1], listRight, listLeft))
+		}
+	case "GEOADD", "GEOPOS", "GEODIST", "GEOSEARCH":
+		return geoCommand(cmd, rs)
+	case "PFADD", "PFCOUNT", "PFMERGE":
+		return hllCommand(cmd, rs)
+	case "DUMP":
 		if len(cmd.Args) == 1 {
-			val, exists := rs.Get(cmd.Args[0])
-			if exists {
-				return val
+			payload, exists := rs.Dump(cmd.Args[0])
+			if !exists {
+				return "nil"
+			}
+			return payload
+		}
+	case "RESTORE":
+		if len(cmd.Args) >= 3 {
+			return restoreCommand(cmd.Args, rs)
+		}
+	case "FLUSHALL", "FLUSHDB":
+		return flushCommand(cmd, rs)
+	case "SAVE":
+		if len(cmd.Args) == 0 {
+			if err := rs.Save(); err != nil {
+				return formatError(fmt.Errorf("ERR %v", err))
+			}
+			return "OK"
+		}
+	case "BGSAVE":
+		if len(cmd.Args) == 0 {
+			rs.BGSave()
+			return "Background saving started"
+		}
+	case "BGREWRITEAOF":
+		if len(cmd.Args) == 0 {
+			if err := rs.BGRewriteAOF(); err != nil {
+				return formatError(err)
+			}
+			return "Background append only file rewriting started"
+		}
+	case "SCAN":
+		if len(cmd.Args) >= 1 {
+			cursor, pattern, count, err := parseScanArgs(cmd.Args)
+			if err != nil {
+				return formatError(err)
+			}
+			return scanReply(rs.Scan(cursor, count, pattern))
+		}
+	case "KEYS":
+		if len(cmd.Args) == 1 {
+			keys, err := rs.Keys(cmd.Args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return formatArray(keys)
+		}
+	case "RANDOMKEY":
+		if len(cmd.Args) == 0 {
+			if key, ok := rs.RandomKey(); ok {
+				return bulkReply(key)
 			}
 			return "nil"
 		}
-	case "SET":
+	case "DBSIZE":
+		if len(cmd.Args) == 0 {
+			return strconv.Itoa(rs.DBSize())
+		}
+	case "HSCAN", "SSCAN", "ZSCAN":
 		if len(cmd.Args) >= 2 {
-			rs.Set(cmd.Args[0], cmd.Args[1])
-			return "OK"
+			return typeScanCommand(cmd, rs)
+		}
+	case "WAIT":
+		if len(cmd.Args) == 2 {
+			return waitCommand(cmd, rs)
+		}
+	case "REPLCONF":
+		return replconfCommand(cmd.Args, cmd.client, rs)
+	case "PUBLISH":
+		if len(cmd.Args) == 2 {
+			return strconv.Itoa(rs.Publish(cmd.Args[0], cmd.Args[1]))
+		}
+	case "SPUBLISH":
+		if len(cmd.Args) == 2 {
+			return strconv.Itoa(rs.SPublish(cmd.Args[0], cmd.Args[1]))
+		}
+	case "PUBSUB":
+		if len(cmd.Args) >= 1 {
+			return pubsubCommand(cmd.Args, rs)
+		}
+	case "CONFIG":
+		if len(cmd.Args) >= 1 {
+			return configCommand(cmd.Args, rs)
+		}
+	case "INFO":
+		switch len(cmd.Args) {
+		case 0:
+			return rs.Info("")
+		case 1:
+			return rs.Info(cmd.Args[0])
+		}
+	case "ATOMIC":
+		if len(cmd.Args) >= 1 {
+			return atomicCommand(cmd.Args, rs)
+		}
+	case "PROC":
+		if len(cmd.Args) >= 1 {
+			return procCommand(cmd.Args, rs)
+		}
+	case "SLOWLOG":
+		if len(cmd.Args) >= 1 {
+			return slowlogCommand(cmd.Args, rs)
+		}
+	case "OBJECT":
+		if len(cmd.Args) >= 1 {
+			return objectCommand(cmd.Args, rs)
+		}
+	case "TYPE":
+		if len(cmd.Args) == 1 {
+			return rs.Type(cmd.Args[0])
+		}
+	case "MEMORY":
+		if len(cmd.Args) >= 1 {
+			return memoryCommand(cmd.Args, rs)
+		}
+	case "DEBUG":
+		if len(cmd.Args) >= 1 {
+			switch strings.ToUpper(cmd.Args[0]) {
+			case "SCANALL":
+				return debugScanAll(cmd.Args[1:], rs)
+			case "OBJECT":
+				if len(cmd.Args) == 2 {
+					desc, err := rs.DebugObject(cmd.Args[1])
+					if err != nil {
+						return formatError(err)
+					}
+					return desc
+				}
+				return formatError(errSyntax)
+			case "DIGEST":
+				return debugDigest(cmd.Args[1:], rs)
+			case "DIGEST-VALUE":
+				return debugDigestValue(cmd.Args[1:], rs)
+			case "RDB-EXPORT":
+				return debugRDBExport(cmd.Args[1:], rs)
+			}
+			return formatError(errUnknownSubcommand(cmd.Args[0]))
 		}
 	}
 	return ""
 }
 
-func inputCapture(input io.Reader, rs *RedisStore) {
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+)
+
+func errUnknownSubcommand(name string) error {
+	return fmt.Errorf("ERR unknown subcommand '%s'", name)
+}
+
+// formatError renders err as an error reply.
+func formatError(err error) string {
+	return "-" + err.Error()
+}
+
+// formatArray renders a multi-element reply the way redis-cli displays it,
+// one numbered item per line. Nested multi-line items are indented under
+// their number.
+func formatArray(items []string) string {
+	if len(items) == 0 {
+		return "(empty array)"
+	}
+	var b strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		prefix := fmt.Sprintf("%d) ", i+1)
+		b.WriteString(prefix)
+		b.WriteString(strings.ReplaceAll(item, "\n", "\n"+strings.Repeat(" ", len(prefix))))
+	}
+	return b.String()
+}
+
+// isTerminal reports whether r is an interactive terminal rather than a
+// pipe or file.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// inputCapture runs commands read from input, writing each reply to output.
+// The "> " prompt is only shown when input is a terminal, so piping in a
+// command file gives just the replies.
+func inputCapture(input io.Reader, output io.Writer, rs *RedisStore) {
+	prompt := isTerminal(input)
 	scanner := bufio.NewScanner(input)
 	for {
-		fmt.Print("> ")
+		if prompt {
+			fmt.Fprint(output, "> ")
+		}
 		if !scanner.Scan() {
 			break
 		}
@@ -176,32 +898,88 @@ func inputCapture(input io.Reader, rs *RedisStore) {
 		args := parts[1:]
 		cmd := Command{Name: command, Args: args}
 		response := processCommand(cmd, rs)
-		fmt.Println(response)
-		if err := scanner.Err(); err != nil {
-			fmt.Println("error reading input: ", err)
-		}
+		fmt.Fprintln(output, response)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(output, "error reading input: ", err)
 	}
 }
 
 func main() {
-	rs, err := NewRedisStore()
+	cfg := DefaultConfig()
+	// The config file is read where -config appears, so that the flags
+	// after it override its settings.
+	flag.Func("config", "redis.conf-style config file, reloaded on SIGHUP", func(path string) error {
+		return loadConfigFile(&cfg, path)
+	})
+	flag.IntVar(&cfg.Port, "port", cfg.Port, "TCP port to listen on")
+	flag.StringVar(&cfg.Dir, "dir", cfg.Dir, "directory holding the AOF and snapshot files")
+	flag.IntVar(&cfg.TCPBacklog, "tcp-backlog", cfg.TCPBacklog, "length of the queue of pending connections")
+	flag.DurationVar(&cfg.TCPKeepAlive, "tcp-keepalive", cfg.TCPKeepAlive, "idle time before TCP keepalive probes, 0 to disable")
+	flag.Func("user", "ACL user rule, e.g. \"alice >secret +get +set\" (repeatable)", func(rule string) error {
+		u, err := parseACLUser(rule)
+		if err != nil {
+			return err
+		}
+		if cfg.Users == nil {
+			cfg.Users = make(map[string]*ACLUser)
+		}
+		cfg.Users[u.Name] = u
+		return nil
+	})
+	bench := benchConfig{}
+	flag.StringVar(&bench.addr, "benchmark", "", "instead of serving, load test the server at this address, e.g. localhost:6379")
+	flag.IntVar(&bench.clients, "benchmark-clients", 50, "parallel connections of -benchmark")
+	flag.IntVar(&bench.requests, "benchmark-requests", 100000, "total commands -benchmark sends")
+	flag.IntVar(&bench.pipeline, "benchmark-pipeline", 1, "commands each -benchmark connection pipelines")
+	flag.Float64Var(&bench.setRatio, "benchmark-set-ratio", 0.5, "share of -benchmark commands that are SETs rather than GETs")
+	flag.IntVar(&bench.keyspace, "benchmark-keyspace", 10000, "number of distinct keys -benchmark uses")
+	flag.IntVar(&bench.dataSize, "benchmark-data-size", 3, "bytes of each value -benchmark SETs")
+	flag.Parse()
+
+	if bench.addr != "" {
+		if err := benchmarkMain(bench, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	rs, err := NewRedisStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 	defer rs.Close()
 
-	if err := rs.loadAOF(); err != nil {
-		fmt.Println("Error loading AOF: ", err)
+	if err := rs.load(); err != nil {
+		log.Println("Error loading data: ", err)
 		return
 	}
+	rs.startActiveExpire()
 
+	srv := newServer(rs)
 	go func() {
-		if err := StartServer(rs); err != nil {
+		if err := srv.ListenAndServe(fmt.Sprintf(":%d", cfg.Port)); err != nil {
 			log.Fatal(err)
 		}
 	}()
+	// SIGINT and SIGTERM drain the connections and close the store before
+	// exiting, rather than killing commands mid-flight.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("shutting down, waiting for in-flight commands")
+		srv.Shutdown()
+		rs.Close()
+		os.Exit(0)
+	}()
+
+	// SIGHUP reloads the config file, applying what can change live.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnSignal(rs, hup)
 
 	// input -> redis store.
-	inputCapture(os.Stdin, rs)
+	inputCapture(os.Stdin, os.Stdout, rs)
 }
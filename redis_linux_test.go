@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sockopt reads an integer socket option from conn.
+func sockopt(t *testing.T, conn *net.TCPConn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var val int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		val, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return val
+}
+
+// acceptOne dials ln and returns the server side of the connection.
+func acceptOne(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+	client, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestConfigureConnTCP(t *testing.T) {
+	// Disable Go's own keepalive defaults so only configureConn's take effect.
+	lc := net.ListenConfig{KeepAlive: -1}
+	ln, err := lc.Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn := acceptOne(t, ln).(*net.TCPConn)
+	if err := conn.SetNoDelay(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := configureConn(conn, Config{TCPKeepAlive: 42 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got == 0 {
+		t.Error("TCP_NODELAY not set")
+	}
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got == 0 {
+		t.Error("SO_KEEPALIVE not set")
+	}
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 42 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 42", got)
+	}
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); got != 14 {
+		t.Errorf("TCP_KEEPINTVL = %d, want 14", got)
+	}
+
+	if err := configureConn(conn, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got != 0 {
+		t.Error("SO_KEEPALIVE still set with keepalive disabled")
+	}
+}
+
+func TestConfigureConnUnix(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "redis.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := configureConn(acceptOne(t, ln), DefaultConfig()); err != nil {
+		t.Errorf("configureConn on a Unix socket: %v", err)
+	}
+}
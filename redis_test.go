@@ -1,11 +1,199 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store whose files live in a fresh temporary
+// directory, so tests never touch the repository's redisstore.aof.
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	r, err := NewRedisStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// run dispatches a single inline command against rs.
+func run(rs *RedisStore, line string) string {
+	return processCommand(parseCommand(line), rs)
+}
 
 func TestSet(t *testing.T) {
-	r := NewRedisStore()
-	r.Set("foo", StoredValue{value: "bar"})
+	r := newTestStore(t)
+	r.Set("foo", "bar")
 	if r.data["foo"].value != "bar" {
 		t.Error("Expected bar, got", r.data["foo"].value)
 	}
 }
+
+func TestCompareAndSet(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "CAS k a b"); got != "0" {
+		t.Errorf("CAS of a missing key = %q, want 0", got)
+	}
+	run(rs, "SET k a")
+	if got := run(rs, "CAS k x b"); got != "0" {
+		t.Errorf("CAS with the wrong expected value = %q, want 0", got)
+	}
+	if got := run(rs, "CAS k a b"); got != "1" {
+		t.Errorf("CAS with the right expected value = %q, want 1", got)
+	}
+	if got := run(rs, "GET k"); got != "b" {
+		t.Errorf("GET after CAS = %q, want b", got)
+	}
+	run(rs, "RPUSH l a")
+	if got := run(rs, "CAS l a b"); got != formatError(errWrongType) {
+		t.Errorf("CAS on a list = %q", got)
+	}
+}
+
+func TestCompareAndSetRace(t *testing.T) {
+	rs := newTestStore(t)
+	const target = 500
+	run(rs, "SET n 0")
+	var wg sync.WaitGroup
+	successes := make([]int, 8)
+	for g := range successes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				cur, err := strconv.Atoi(run(rs, "GET n"))
+				if err != nil || cur >= target {
+					return
+				}
+				if run(rs, fmt.Sprintf("CAS n %d %d", cur, cur+1)) == "1" {
+					successes[g]++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, n := range successes {
+		total += n
+	}
+	if total != target {
+		t.Errorf("%d CAS attempts succeeded, want exactly %d", total, target)
+	}
+	if got := run(rs, "GET n"); got != strconv.Itoa(target) {
+		t.Errorf("GET n = %q, want %d", got, target)
+	}
+}
+
+// TestReadYourWrites checks that a GET straight after a SET on the same
+// connection sees the write while keys expire and other clients write.
+func TestReadYourWrites(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	const clients, rounds = 8, 200
+	for i := range clients {
+		for j := range 10 {
+			run(rs, fmt.Sprintf("SET c%d:k%d old", i, j))
+			run(rs, fmt.Sprintf("PEXPIRE c%d:k%d %d", i, j, j+1))
+		}
+	}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			clk.Advance(time.Millisecond)
+			run(rs, fmt.Sprintf("SET bg:%d x", n%100))
+			run(rs, fmt.Sprintf("PEXPIRE bg:%d 1", n%100))
+			run(rs, "SCAN 0 COUNT 100")
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan string, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := newClient(io.Discard, rs)
+			defer c.close()
+			for n := range rounds {
+				key, val := fmt.Sprintf("c%d:k%d", i, n%10), fmt.Sprintf("v%d", n)
+				c.processCommand(parseCommand("SET " + key + " " + val))
+				if got := c.processCommand(parseCommand("GET " + key)); got != val {
+					errs <- fmt.Sprintf("GET %s after SET %s = %q", key, val, got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestInputCaptureNoPromptWhenPiped(t *testing.T) {
+	rs := newTestStore(t)
+	var out strings.Builder
+	inputCapture(strings.NewReader("SET k v\nGET k\n"), &out, rs)
+	if got := out.String(); got != "OK\nv\n" {
+		t.Errorf("output for piped input = %q, want just the replies", got)
+	}
+}
+
+func TestArityErrors(t *testing.T) {
+	rs := newTestStore(t)
+	const getErr = "-ERR wrong number of arguments for 'get' command"
+	const setErr = "-ERR wrong number of arguments for 'set' command"
+	if got := run(rs, "GET"); got != getErr {
+		t.Errorf("GET with no arguments = %q", got)
+	}
+	if got := run(rs, "SET k"); got != setErr {
+		t.Errorf("SET with one argument = %q", got)
+	}
+
+	var out strings.Builder
+	inputCapture(strings.NewReader("GET\nSET k\n"), &out, rs)
+	if got, want := out.String(), getErr+"\n"+setErr+"\n"; got != want {
+		t.Errorf("REPL output = %q, want %q", got, want)
+	}
+
+	c, _ := newTestClient(t, rs)
+	if got := send(c, "GET"); got != getErr {
+		t.Errorf("GET with no arguments over a connection = %q", got)
+	}
+	if got := send(c, "SET k"); got != setErr {
+		t.Errorf("SET with one argument over a connection = %q", got)
+	}
+}
+
+func TestDel(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+	run(rs, "RPUSH b x")
+	if got := run(rs, "DEL a b missing"); got != "2" {
+		t.Errorf("DEL = %q, want 2", got)
+	}
+	if got := run(rs, "GET a"); got != "nil" {
+		t.Errorf("GET after DEL = %q, want nil", got)
+	}
+}
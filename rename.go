@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// The commands here read one key and write another. The keyspace is one
+// map under one mutex, so each holds it once for both keys and no other
+// command can see the source gone before the destination appears.
+
+var errSameObject = errors.New("ERR source and destination objects are the same")
+
+// Rename moves the value at src, with its TTL, to dst, replacing whatever
+// dst held. With nx set it does nothing if dst exists. It reports whether
+// it renamed.
+func (r *RedisStore) Rename(src, dst string, nx bool) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(src)
+	if sv == nil {
+		return false, errNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if nx && r.lookupNoTouch(dst) != nil {
+		return false, nil
+	}
+	delete(r.data, src)
+	r.data[dst] = sv
+	r.touch(src)
+	r.touch(dst)
+	r.wakeWaiters(dst)
+	return true, r.writeAOF("RENAME", src, dst)
+}
+
+// Copy copies the value at src, with its TTL, to dst. Unless replace is set
+// it does nothing if dst exists. It reports whether it copied.
+func (r *RedisStore) Copy(src, dst string, replace bool) (bool, error) {
+	if src == dst {
+		return false, errSameObject
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(src)
+	if sv == nil {
+		return false, nil
+	}
+	if !replace && r.lookupNoTouch(dst) != nil {
+		return false, nil
+	}
+	// The on-disk form is a deep copy, encoded afresh for the settings.
+	cp, err := r.storedValue(snapshotValue(dst, sv))
+	if err != nil {
+		return false, err
+	}
+	cp.expireAt = sv.expireAt
+	r.data[dst] = cp
+	r.touch(dst)
+	r.wakeWaiters(dst)
+	return true, r.writeAOF("COPY", src, dst, "REPLACE")
+}
+
+func renameCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "RENAME":
+		if len(args) == 2 {
+			if _, err := rs.Rename(args[0], args[1], false); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	case "RENAMENX":
+		if len(args) == 2 {
+			return boolReply(rs.Rename(args[0], args[1], true))
+		}
+	case "COPY":
+		if len(args) >= 2 {
+			replace := false
+			for _, opt := range args[2:] {
+				if !strings.EqualFold(opt, "REPLACE") {
+					return formatError(errSyntax)
+				}
+				replace = true
+			}
+			return boolReply(rs.Copy(args[0], args[1], replace))
+		}
+	}
+	return ""
+}
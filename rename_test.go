@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenameAndCopy(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "RPUSH src a b")
+	run(rs, "PEXPIRE src 5000")
+	if got := run(rs, "RENAME src dst"); got != "OK" {
+		t.Fatalf("RENAME = %q", got)
+	}
+	if got := run(rs, "LRANGE dst 0 -1"); got != "1) a\n2) b" {
+		t.Errorf("LRANGE dst = %q", got)
+	}
+	if got := run(rs, "PTTL dst"); got != "5000" {
+		t.Errorf("PTTL after RENAME = %q, want the TTL to move with the value", got)
+	}
+	if got := run(rs, "RENAME src dst"); got != "-ERR no such key" {
+		t.Errorf("RENAME of a missing key = %q", got)
+	}
+
+	run(rs, "SET other x")
+	if got := run(rs, "RENAMENX dst other"); got != "0" {
+		t.Errorf("RENAMENX onto an existing key = %q, want 0", got)
+	}
+	if got := run(rs, "COPY dst other"); got != "0" {
+		t.Errorf("COPY onto an existing key = %q, want 0", got)
+	}
+	if got := run(rs, "COPY dst other REPLACE"); got != "1" {
+		t.Fatalf("COPY REPLACE = %q", got)
+	}
+	// The copy is independent of the original.
+	run(rs, "RPUSH other c")
+	if got := run(rs, "LRANGE dst 0 -1"); got != "1) a\n2) b" {
+		t.Errorf("LRANGE of the original after pushing onto the copy = %q", got)
+	}
+	if got := run(rs, "COPY dst dst"); got != "-ERR source and destination objects are the same" {
+		t.Errorf("COPY onto itself = %q", got)
+	}
+
+	run(rs, "SADD s1 m n")
+	run(rs, "SET str v")
+	if got := run(rs, "SMOVE s1 s2 m"); got != "1" {
+		t.Errorf("SMOVE = %q, want 1", got)
+	}
+	if got := run(rs, "SMOVE s1 s2 missing"); got != "0" {
+		t.Errorf("SMOVE of a missing member = %q, want 0", got)
+	}
+	if got := run(rs, "SMOVE s1 str n"); !strings.HasPrefix(got, "-WRONGTYPE") {
+		t.Errorf("SMOVE into a string = %q", got)
+	}
+	if got := run(rs, "SMEMBERS s2"); got != "1) m" {
+		t.Errorf("SMEMBERS s2 = %q", got)
+	}
+
+	rs = reopen(t, rs)
+	if got := run(rs, "PTTL dst"); got != "5000" {
+		t.Errorf("PTTL after a reload = %q", got)
+	}
+	if got := run(rs, "LRANGE other 0 -1"); got != "1) a\n2) b\n3) c" {
+		t.Errorf("LRANGE other after a reload = %q", got)
+	}
+	if got := run(rs, "SMEMBERS s1"); got != "1) n" {
+		t.Errorf("SMEMBERS s1 after a reload = %q", got)
+	}
+}
+
+// TestRenameIsAtomic renames a value back and forth between two keys from
+// several goroutines, and moves set members between two sets, while another
+// watches: at every moment exactly one key holds the value and no member is
+// lost or duplicated.
+func TestRenameIsAtomic(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a v")
+	for i := range 50 {
+		run(rs, "SADD from "+strconv.Itoa(i))
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if (i+j)%2 == 0 {
+					run(rs, "RENAME a b")
+				} else {
+					run(rs, "RENAME b a")
+				}
+				member := strconv.Itoa((i*7 + j) % 50)
+				run(rs, "SMOVE from to "+member)
+				run(rs, "SMOVE to from "+member)
+			}
+		}()
+	}
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		got := run(rs, "ATOMIC GET a ; GET b ; SCARD from ; SCARD to")
+		parts := strings.Split(got, "\n")
+		if len(parts) != 4 || (parts[0] == "1) v") == (parts[1] == "2) v") {
+			t.Fatalf("both or neither key held the value: %q", got)
+		}
+		from, _ := strconv.Atoi(strings.TrimPrefix(parts[2], "3) "))
+		to, _ := strconv.Atoi(strings.TrimPrefix(parts[3], "4) "))
+		if from+to != 50 {
+			t.Fatalf("the sets hold %d members between them, want 50", from+to)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
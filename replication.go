@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replication tracks the master replication offset and how far each replica
+// has acknowledged it. The store does not stream its writes to replicas
+// yet: a connection becomes a replica by sending REPLCONF ACK, and WAIT
+// counts those that have acknowledged enough. It has its own lock, taken
+// after the store's mutex when both are held.
+type replication struct {
+	mu sync.Mutex
+	// offset counts the bytes of write commands propagated, as they are
+	// written to the AOF.
+	offset int64
+	acks   map[*clientInfo]int64
+	// acked is closed, and replaced, whenever a replica acknowledges.
+	acked chan struct{}
+}
+
+var errReplicaNoClient = errors.New("ERR REPLCONF ACK is only accepted from a connection")
+
+// propagate advances the offset past a write command of n bytes.
+func (p *replication) propagate(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offset += int64(n)
+}
+
+func (p *replication) currentOffset() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offset
+}
+
+// ack records that the replica ci has processed the replication stream up
+// to offset, which never moves its acknowledged offset back.
+func (p *replication) ack(ci *clientInfo, offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.acks == nil {
+		p.acks = make(map[*clientInfo]int64)
+	}
+	p.acks[ci] = max(p.acks[ci], offset)
+	if p.acked != nil {
+		close(p.acked)
+		p.acked = nil
+	}
+}
+
+// remove forgets ci, when it disconnects.
+func (p *replication) remove(ci *clientInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.acks, ci)
+}
+
+// count returns how many replicas have acknowledged offset, and a channel
+// closed at the next acknowledgement.
+func (p *replication) count(offset int64) (int, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, acked := range p.acks {
+		if acked >= offset {
+			n++
+		}
+	}
+	if p.acked == nil {
+		p.acked = make(chan struct{})
+	}
+	return n, p.acked
+}
+
+// Wait blocks until numReplicas replicas have acknowledged the replication
+// offset at the time of the call, or timeout has elapsed, and returns how
+// many have. A zero timeout waits forever. Like the blocking list commands,
+// CLIENT UNBLOCK and KILL end the wait early.
+func (r *RedisStore) Wait(ci *clientInfo, numReplicas int, timeout time.Duration) int {
+	offset := r.repl.currentOffset()
+	n, acked := r.repl.count(offset)
+	if n >= numReplicas {
+		return n
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = r.clock.After(timeout)
+	}
+	ci.setBlocked(true, r.clock.Now())
+	defer ci.setBlocked(false, time.Time{})
+	for n < numReplicas {
+		select {
+		case <-acked:
+		case <-deadline:
+			n, _ = r.repl.count(offset)
+			return n
+		case <-ci.unblocked():
+			n, _ = r.repl.count(offset)
+			return n
+		}
+		n, acked = r.repl.count(offset)
+	}
+	return n
+}
+
+func replicationInfo(r *RedisStore) []string {
+	p := &r.repl
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lines := []string{"role:master", fmt.Sprintf("connected_slaves:%d", len(p.acks))}
+	replicas := slices.SortedFunc(maps.Keys(p.acks), func(a, b *clientInfo) int { return cmp.Compare(a.id, b.id) })
+	for i, ci := range replicas {
+		lines = append(lines, fmt.Sprintf("slave%d:addr=%s,offset=%d", i, ci.addr, p.acks[ci]))
+	}
+	return append(lines, fmt.Sprintf("master_repl_offset:%d", p.offset))
+}
+
+// replconfCommand handles REPLCONF for the client ci. ACK registers it as a
+// replica that has processed the replication stream up to the given
+// offset. Redis sends no reply to ACK, but every command here has one, so
+// it replies OK. The options replicas send while connecting are accepted and
+// ignored.
+func replconfCommand(args []string, ci *clientInfo, rs *RedisStore) string {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return formatError(errSyntax)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "ACK":
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return formatError(errNotInteger)
+		}
+		if ci == nil {
+			return formatError(errReplicaNoClient)
+		}
+		rs.repl.ack(ci, offset)
+		return "OK"
+	case "LISTENING-PORT", "IP-ADDRESS", "CAPA":
+		return "OK"
+	}
+	return formatError(fmt.Errorf("ERR Unrecognized REPLCONF option: %s", args[0]))
+}
+
+func waitCommand(cmd Command, rs *RedisStore) string {
+	n, err := strconv.Atoi(cmd.Args[0])
+	if err != nil {
+		return formatError(errNotInteger)
+	}
+	ms, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return formatError(errors.New("ERR timeout is not an integer or out of range"))
+	}
+	if ms < 0 {
+		return formatError(errNegativeTimeout)
+	}
+	return strconv.Itoa(rs.Wait(cmd.client, n, time.Duration(ms)*time.Millisecond))
+}
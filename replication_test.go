@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitForReplicaAck(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	replica, _ := newTestClient(t, rs)
+	if got := send(replica, "REPLCONF ACK 0"); got != "OK" {
+		t.Fatalf("REPLCONF ACK = %q", got)
+	}
+	if got := run(rs, "WAIT 1 0"); got != "1" {
+		t.Errorf("WAIT with nothing written = %q, want 1", got)
+	}
+
+	run(rs, "SET k v")
+	offset := infoField(t, run(rs, "INFO replication"), "master_repl_offset")
+	if offset == "0" {
+		t.Fatal("SET did not advance master_repl_offset")
+	}
+	reply := make(chan string)
+	go func() { reply <- run(rs, "WAIT 1 0") }()
+	select {
+	case got := <-reply:
+		t.Fatalf("WAIT returned %q before the replica acked", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	send(replica, "REPLCONF ACK "+offset)
+	select {
+	case got := <-reply:
+		if got != "1" {
+			t.Errorf("WAIT after the ack = %q, want 1", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WAIT did not return after the replica acked")
+	}
+
+	// A write the replica has not acked times WAIT out.
+	run(rs, "SET k v2")
+	go func() { reply <- run(rs, "WAIT 1 100") }()
+	waitUntil(t, func() bool { return clk.pendingTimers() == 1 })
+	// An older offset does not count.
+	send(replica, "REPLCONF ACK "+offset)
+	clk.Advance(100 * time.Millisecond)
+	if got := <-reply; got != "0" {
+		t.Errorf("WAIT past its timeout = %q, want 0", got)
+	}
+
+	info := run(rs, "INFO replication")
+	if got := infoField(t, info, "connected_slaves"); got != "1" {
+		t.Errorf("connected_slaves = %q, want 1", got)
+	}
+	replica.close()
+	if got := infoField(t, run(rs, "INFO replication"), "connected_slaves"); got != "0" {
+		t.Errorf("connected_slaves after the replica left = %q, want 0", got)
+	}
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"WAIT 0 0", "0"},
+		{"WAIT 1 -1", "-ERR timeout is negative"},
+		{"REPLCONF ACK 5", "-" + errReplicaNoClient.Error()},
+		{"REPLCONF listening-port 6380", "OK"},
+		{"REPLCONF GETACK *", "-ERR Unrecognized REPLCONF option: GETACK"},
+	} {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits on a request, as in Redis: the longest inline command line, the
+// most elements in a RESP array and the longest bulk string.
+const (
+	maxInlineSize   = 64 * 1024
+	maxMultibulkLen = 1024 * 1024
+	maxBulkLen      = 512 * 1024 * 1024
+)
+
+var errProtocol = errors.New("ERR Protocol error")
+
+func protocolError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errProtocol, fmt.Sprintf(format, args...))
+}
+
+// readRequest reads the next request from r. A request starting with '*' is
+// a RESP array of bulk strings, as redis-cli and client libraries send, and
+// anything else an inline command, a line of words as typed into telnet.
+// The form is told apart for each request, so a connection may mix them. An
+// empty line or array is read as an empty Command. Malformed requests fail
+// with errProtocol, after which the stream cannot be trusted.
+func readRequest(r *bufio.Reader) (Command, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return Command{}, err
+	}
+	if first[0] == '*' {
+		return readMultibulk(r)
+	}
+	line, err := readLine(r)
+	if err != nil && (err != io.EOF || line == "") {
+		return Command{}, err
+	}
+	// A last line without a newline is still a command; the next read
+	// reports the end of the stream.
+	return parseCommand(line), nil
+}
+
+// readLine reads a line of at most maxInlineSize bytes, without its line
+// ending.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxInlineSize {
+			return "", protocolError("too big inline request")
+		}
+		if err != bufio.ErrBufferFull {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			return string(line), err
+		}
+	}
+}
+
+// readMultibulk reads a RESP array of bulk strings: *<count>, then for each
+// element $<length> and that many bytes, every line ending in CRLF.
+func readMultibulk(r *bufio.Reader) (Command, error) {
+	header, err := readLine(r)
+	if err != nil {
+		return Command{}, err
+	}
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n > maxMultibulkLen {
+		return Command{}, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return Command{}, nil
+	}
+	parts := make([]string, n)
+	for i := range parts {
+		line, err := readLine(r)
+		if err != nil {
+			return Command{}, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return Command{}, protocolError("expected '$', got '%.1s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return Command{}, protocolError("invalid bulk length")
+		}
+		buf, err := readBulk(r, size)
+		if err != nil {
+			return Command{}, err
+		}
+		parts[i] = buf
+	}
+	return Command{Name: strings.ToUpper(parts[0]), Args: parts[1:]}, nil
+}
+
+// bulkChunk is the most of a bulk string readBulk allocates ahead of the
+// bytes arriving.
+const bulkChunk = 64 * 1024
+
+// readBulk reads a bulk string of size bytes and the CRLF after it. The
+// buffer grows as the bytes arrive, at most bulkChunk ahead of them, so a
+// length a client only declares costs nothing.
+func readBulk(r *bufio.Reader, size int) (string, error) {
+	var buf bytes.Buffer
+	for buf.Len() < size+2 {
+		n := min(size+2-buf.Len(), bulkChunk)
+		buf.Grow(n)
+		if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\r\n")) {
+		return "", protocolError("bulk string of length %d not followed by CRLF", size)
+	}
+	return string(buf.Bytes()[:size]), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadRequestMixesInlineAndRESP(t *testing.T) {
+	in := "SET a 1\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$11\r\nhello world\r\n" +
+		"\n" +
+		"\r\n" +
+		"*0\r\n" +
+		"get a\n" +
+		"*2\r\n$3\r\nGET\r\n$0\r\n\r\n" +
+		"PING"
+	want := []Command{
+		{Name: "SET", Args: []string{"a", "1"}},
+		{Name: "SET", Args: []string{"b", "hello world"}},
+		{}, {}, {},
+		{Name: "GET", Args: []string{"a"}},
+		{Name: "GET", Args: []string{""}},
+		{Name: "PING", Args: []string{}},
+	}
+	r := bufio.NewReader(strings.NewReader(in))
+	for i, w := range want {
+		got, err := readRequest(r)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if got.Name != w.Name || !slices.Equal(got.Args, w.Args) {
+			t.Errorf("request %d = %s %q, want %s %q", i, got.Name, got.Args, w.Name, w.Args)
+		}
+	}
+	if _, err := readRequest(r); err != io.EOF {
+		t.Errorf("read past the end = %v, want EOF", err)
+	}
+}
+
+func TestReadRequestRejectsMalformedRESP(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"*x\r\n", "-ERR Protocol error: invalid multibulk length"},
+		{"*1\r\nGET\r\n", "-ERR Protocol error: expected '$', got 'G'"},
+		{"*1\r\n$-5\r\n", "-ERR Protocol error: invalid bulk length"},
+		{"*1\r\n$2\r\nabc\r\n", "-ERR Protocol error: bulk string of length 2 not followed by CRLF"},
+		{strings.Repeat("x", maxInlineSize+1), "-ERR Protocol error: too big inline request"},
+	}
+	for _, tt := range tests {
+		_, err := readRequest(bufio.NewReader(strings.NewReader(tt.in)))
+		if err == nil || formatError(err) != tt.want {
+			t.Errorf("readRequest(%.20q) = %v, want %s", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestReadRequestAllocatesAsBulkArrives(t *testing.T) {
+	// A header claiming nearly 512MB followed by a few bytes.
+	in := "*2\r\n$3\r\nSET\r\n$536870000\r\nabc"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readRequest(bufio.NewReader(strings.NewReader(in)))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated bulk = %v, want ErrUnexpectedEOF", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("reading 3 bytes of a declared 512MB bulk allocated %d bytes", n)
+	}
+
+	// A bulk longer than a chunk still arrives whole.
+	val := strings.Repeat("v", 3*bulkChunk+5)
+	in = "*2\r\n$3\r\nGET\r\n$" + strconv.Itoa(len(val)) + "\r\n" + val + "\r\n"
+	cmd, err := readRequest(bufio.NewReader(strings.NewReader(in)))
+	if err != nil || len(cmd.Args) != 1 || cmd.Args[0] != val {
+		t.Errorf("readRequest of a %d byte bulk = %v, %d args", len(val), err, len(cmd.Args))
+	}
+}
+
+func TestConnectionInterleavesInlineAndRESP(t *testing.T) {
+	rs := newTestStore(t)
+	_, addr := startServer(t, rs)
+	conn := dial(t, addr)
+	r := bufio.NewReader(conn)
+	exchange := func(req, want string) {
+		t.Helper()
+		io.WriteString(conn, req)
+		if line, err := r.ReadString('\n'); err != nil || line != want+"\n" {
+			t.Fatalf("reply to %q = %q, %v, want %q", req, line, err, want)
+		}
+	}
+
+	exchange("SET greeting hi\r\n", "OK")
+	// An empty line gets no reply, so the next reply is the RESP GET's.
+	exchange("\n*2\r\n$3\r\nGET\r\n$8\r\ngreeting\r\n", "hi")
+	exchange("*3\r\n$3\r\nSET\r\n$5\r\nspace\r\n$3\r\na b\r\n", "OK")
+	exchange("STRLEN space\n", "3")
+
+	io.WriteString(conn, "*1\r\nPING\r\n")
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "-ERR Protocol error") {
+		t.Errorf("reply to a malformed request = %q", line)
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("read after a protocol error = %v, want EOF", err)
+	}
+}
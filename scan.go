@@ -0,0 +1,409 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"hash/fnv"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+// scanHash places a key in SCAN order. A cursor is a position in this order
+// rather than an offset into a snapshot, so keys added or removed between
+// calls can't shift the remaining keys past the cursor. It is a 63-bit value
+// so that hash+1 always fits in the cursor.
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64() >> 1
+}
+
+// scanNames returns the next page of names starting at cursor, examining
+// about count of them, along with the cursor for the following call. A zero
+// cursor starts a new iteration and a returned zero cursor ends it. Non-zero
+// cursors are the scan hash of the next name to visit plus one. It sorts
+// every name from the cursor on, so it is only used for the compact
+// encodings, whose size the configuration bounds; larger collections and
+// the keyspace keep a scanIndex instead.
+func scanNames(names []string, cursor uint64, count int, pattern string) (uint64, []string) {
+	var entries []scanEntry
+	for _, name := range names {
+		if h := scanHash(name); cursor == 0 || h >= cursor-1 {
+			entries = append(entries, scanEntry{h, name})
+		}
+	}
+	slices.SortFunc(entries, compareScanEntries)
+
+	var page []string
+	for i, e := range entries {
+		// Never split names sharing a hash across pages, or the cursor
+		// could not resume between them.
+		if i >= count && e.hash != entries[i-1].hash {
+			return e.hash + 1, page
+		}
+		if pattern == "" || matchPattern(pattern, e.name) {
+			page = append(page, e.name)
+		}
+	}
+	return 0, page
+}
+
+type scanEntry struct {
+	hash uint64
+	name string
+}
+
+func compareScanEntries(a, b scanEntry) int {
+	if c := cmp.Compare(a.hash, b.hash); c != 0 {
+		return c
+	}
+	return strings.Compare(a.name, b.name)
+}
+
+// scanIndexMinBits and scanIndexMaxBits bound the number of buckets in a
+// scanIndex, as powers of two.
+const (
+	scanIndexMinBits = 4
+	scanIndexMaxBits = 40
+)
+
+// scanIndex holds names in SCAN order for paging through them without
+// sorting them all each time. They are grouped into buckets by the leading
+// bits of their scan hash, so each bucket covers a contiguous stretch of the
+// order and a page sorts only the few names of the buckets it visits. The
+// buckets double and halve to keep about one or two names each; that moves
+// no name in the order, so cursors stay valid across it. The zero value is
+// empty and ready to use.
+type scanIndex struct {
+	bits    uint
+	buckets []map[string]uint64
+	n       int
+}
+
+func (x *scanIndex) bucket(hash uint64) int {
+	return int(hash >> (63 - x.bits))
+}
+
+// bucketStart returns the first scan hash bucket b covers.
+func (x *scanIndex) bucketStart(b int) uint64 {
+	return uint64(b) << (63 - x.bits)
+}
+
+func (x *scanIndex) add(name string) {
+	if x.buckets == nil {
+		x.resize(scanIndexMinBits)
+	}
+	h := scanHash(name)
+	b := &x.buckets[x.bucket(h)]
+	if _, ok := (*b)[name]; ok {
+		return
+	}
+	if *b == nil {
+		*b = make(map[string]uint64, 1)
+	}
+	(*b)[name] = h
+	x.n++
+	if x.n > 2*len(x.buckets) && x.bits < scanIndexMaxBits {
+		x.resize(x.bits + 1)
+	}
+}
+
+func (x *scanIndex) remove(name string) {
+	if x.n == 0 {
+		return
+	}
+	b := x.buckets[x.bucket(scanHash(name))]
+	if _, ok := b[name]; !ok {
+		return
+	}
+	delete(b, name)
+	x.n--
+	if x.n < len(x.buckets)/8 && x.bits > scanIndexMinBits {
+		x.resize(x.bits - 1)
+	}
+}
+
+// random returns a name picked at random, or false if there is none. As
+// with Redis's RANDOMKEY, names sharing a bucket with fewer others come up
+// somewhat more often.
+func (x *scanIndex) random() (string, bool) {
+	if x.n == 0 {
+		return "", false
+	}
+	for {
+		b := x.buckets[rand.IntN(len(x.buckets))]
+		if len(b) == 0 {
+			continue
+		}
+		i := rand.IntN(len(b))
+		for name := range b {
+			if i == 0 {
+				return name, true
+			}
+			i--
+		}
+	}
+}
+
+func (x *scanIndex) resize(bits uint) {
+	old := x.buckets
+	x.bits = bits
+	x.buckets = make([]map[string]uint64, 1<<bits)
+	for _, b := range old {
+		for name, h := range b {
+			if x.buckets[x.bucket(h)] == nil {
+				x.buckets[x.bucket(h)] = make(map[string]uint64, 1)
+			}
+			x.buckets[x.bucket(h)][name] = h
+		}
+	}
+}
+
+// page returns the next page of names as scanNames does, visiting whole
+// buckets from the cursor's on until it has examined about count names, or
+// passed ten times as many empty buckets. keep, if given, leaves out names
+// the index still holds but that should not be returned.
+func (x *scanIndex) page(cursor uint64, count int, pattern string, keep func(string) bool) (uint64, []string) {
+	if x.n == 0 {
+		return 0, nil
+	}
+	var start uint64
+	if cursor > 0 {
+		start = cursor - 1
+	}
+	var page []string
+	var entries []scanEntry
+	examined, visited := 0, 0
+	for b := x.bucket(start); b < len(x.buckets); b++ {
+		if examined >= count || visited >= 10*count {
+			return x.bucketStart(b) + 1, page
+		}
+		visited++
+		entries = entries[:0]
+		for name, h := range x.buckets[b] {
+			if h >= start {
+				entries = append(entries, scanEntry{h, name})
+			}
+		}
+		slices.SortFunc(entries, compareScanEntries)
+		examined += len(entries)
+		for _, e := range entries {
+			if (keep == nil || keep(e.name)) && (pattern == "" || matchPattern(pattern, e.name)) {
+				page = append(page, e.name)
+			}
+		}
+	}
+	return 0, page
+}
+
+// parseScanArgs parses a SCAN-family cursor followed by the optional MATCH
+// and COUNT arguments.
+func parseScanArgs(args []string) (cursor uint64, pattern string, count int, err error) {
+	cursor, err = strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", 0, errInvalidCursor
+	}
+	count = 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, "", 0, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, "", 0, errNotInteger
+			}
+			if count < 1 {
+				return 0, "", 0, errSyntax
+			}
+		default:
+			return 0, "", 0, errSyntax
+		}
+	}
+	return cursor, pattern, count, nil
+}
+
+// Scan iterates the keyspace incrementally, with the guarantees Redis
+// documents: every key present for the whole iteration is returned at least
+// once, and a key deleted before it started is never returned. Each page is
+// read from the keyspace's scanIndex and a key's place in the order depends
+// only on its name, so other keys coming and going cannot move it behind the
+// cursor. A key added or deleted during the iteration may or may not be
+// returned.
+func (r *RedisStore) Scan(cursor uint64, count int, pattern string) (uint64, []string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.keyIndex.page(cursor, count, pattern, func(key string) bool { return r.lookupNoTouch(key) != nil })
+}
+
+// keysPage is how many keys KEYS reads from keyIndex under each hold of
+// the read lock.
+const keysPage = 256
+
+// Keys returns the keys matching pattern, sorted. Over a keyspace too large
+// to list within command-time-budget it fails, pointing at SCAN. It walks
+// keyIndex a page at a time as SCAN does, so writers wait only while a page
+// is read.
+func (r *RedisStore) Keys(pattern string) ([]string, error) {
+	r.mutex.RLock()
+	b := r.newBudget()
+	r.mutex.RUnlock()
+	var keys []string
+	for cursor := uint64(0); ; {
+		next, page := r.Scan(cursor, keysPage, "")
+		for _, key := range page {
+			if !b.spend() {
+				return nil, errOverBudget("KEYS", "SCAN")
+			}
+			if matchPattern(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// The index may have been resized between pages, which can return a
+	// key twice.
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+// ScanElements iterates the elements of the set, hash or sorted set at key,
+// returning each hash field or sorted set member followed by its value or
+// score. The cursor is the same scan hash order SCAN uses, taken over the
+// element names, so it does not depend on how the value is encoded: a
+// listpack converting to a hashtable between calls neither loses nor
+// repeats elements present throughout. Large encodings page through the
+// scanIndex they keep; the compact ones are small enough to sort. typ is
+// the type the command expects.
+func (r *RedisStore) ScanElements(typ, key string, cursor uint64, count int, pattern string) (uint64, []string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sv := r.lookup(key)
+	r.stats.keyspaceRead(sv != nil)
+	if sv == nil {
+		return 0, nil, nil
+	}
+	var index *scanIndex
+	var names func() []string
+	var value func(name string) string
+	switch v := sv.value.(type) {
+	case map[string]struct{}:
+		if typ != "set" {
+			return 0, nil, errWrongType
+		}
+		index = sv.members
+		names = func() []string { return slices.Collect(maps.Keys(v)) }
+	case *hashValue:
+		if typ != "hash" {
+			return 0, nil, errWrongType
+		}
+		index = v.index
+		names = func() []string {
+			var fields []string
+			for _, p := range v.fields() {
+				fields = append(fields, p.field)
+			}
+			return fields
+		}
+		value = func(field string) string {
+			val, _ := v.get(field)
+			return val
+		}
+	case *sortedSet:
+		if typ != "zset" {
+			return 0, nil, errWrongType
+		}
+		index = v.index
+		names = func() []string {
+			members := make([]string, len(v.entries))
+			for i, e := range v.entries {
+				members[i] = e.member
+			}
+			return members
+		}
+		value = func(member string) string {
+			score, _ := v.score(member)
+			return formatScore(score)
+		}
+	default:
+		return 0, nil, errWrongType
+	}
+	var next uint64
+	var page []string
+	if index != nil {
+		next, page = index.page(cursor, count, pattern, nil)
+	} else {
+		next, page = scanNames(names(), cursor, count, pattern)
+	}
+	if value == nil {
+		return next, page, nil
+	}
+	items := make([]string, 0, 2*len(page))
+	for _, name := range page {
+		items = append(items, name, value(name))
+	}
+	return next, items, nil
+}
+
+var scanTypes = map[string]string{"HSCAN": "hash", "SSCAN": "set", "ZSCAN": "zset"}
+
+func typeScanCommand(cmd Command, rs *RedisStore) string {
+	cursor, pattern, count, err := parseScanArgs(cmd.Args[1:])
+	if err != nil {
+		return formatError(err)
+	}
+	next, items, err := rs.ScanElements(scanTypes[cmd.Name], cmd.Args[0], cursor, count, pattern)
+	if err != nil {
+		return formatError(err)
+	}
+	return scanReply(next, items)
+}
+
+func scanReply(cursor uint64, items []string) string {
+	return formatArray([]string{strconv.FormatUint(cursor, 10), formatArray(items)})
+}
+
+// dbKey identifies a key together with the database holding it.
+type dbKey struct {
+	db  int
+	key string
+}
+
+// ScanAll iterates the keys of every database in a single pass for admin and
+// backup tooling, so callers need not SELECT each database in turn. The store
+// currently has a single keyspace, so the cursor is the one SCAN uses.
+func (r *RedisStore) ScanAll(cursor uint64, count int, pattern string) (uint64, []dbKey) {
+	next, keys := r.Scan(cursor, count, pattern)
+	found := make([]dbKey, len(keys))
+	for i, key := range keys {
+		found[i] = dbKey{db: r.db, key: key}
+	}
+	return next, found
+}
+
+func debugScanAll(args []string, rs *RedisStore) string {
+	if len(args) == 0 {
+		return formatError(errSyntax)
+	}
+	cursor, pattern, count, err := parseScanArgs(args)
+	if err != nil {
+		return formatError(err)
+	}
+	next, found := rs.ScanAll(cursor, count, pattern)
+	pairs := make([]string, len(found))
+	for i, k := range found {
+		pairs[i] = formatArray([]string{strconv.Itoa(k.db), k.key})
+	}
+	return scanReply(next, pairs)
+}
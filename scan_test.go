@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users", false},
+		{"a/*", "a/b/c", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestScanCoversKeyspace(t *testing.T) {
+	rs := newTestStore(t)
+	for i := range 50 {
+		rs.Set(fmt.Sprintf("key:%d", i), "v")
+	}
+	seen := map[string]int{}
+	cursor, calls := uint64(0), 0
+	for {
+		var keys []string
+		cursor, keys = rs.Scan(cursor, 7, "key:*")
+		for _, k := range keys {
+			seen[k]++
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("SCAN visited %d keys, want 50", len(seen))
+	}
+	if calls < 2 {
+		t.Errorf("SCAN finished in %d call, want several pages", calls)
+	}
+}
+
+func TestScanGuarantees(t *testing.T) {
+	rs := newTestStore(t)
+	for i := range 100 {
+		rs.Set(fmt.Sprintf("stable:%d", i), "v")
+		rs.Set(fmt.Sprintf("gone:%d", i), "v")
+		rs.Set(fmt.Sprintf("churn:%d", i), "v")
+	}
+	// Deleted before the scan starts.
+	for i := range 100 {
+		rs.Del([]string{fmt.Sprintf("gone:%d", i)})
+	}
+
+	seen := map[string]bool{}
+	cursor := uint64(0)
+	for call := 0; ; call++ {
+		var keys []string
+		cursor, keys = rs.Scan(cursor, 5, "")
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		// Between calls, delete and re-add keys and grow the keyspace
+		// enough for the map to rehash.
+		for i := range 10 {
+			churn := fmt.Sprintf("churn:%d", (call*10+i)%100)
+			rs.Del([]string{churn})
+			rs.Set(churn, "v")
+			rs.Set(fmt.Sprintf("new:%d:%d", call, i), "v")
+		}
+	}
+
+	for i := range 100 {
+		if k := fmt.Sprintf("stable:%d", i); !seen[k] {
+			t.Errorf("SCAN never returned %s, present throughout", k)
+		}
+		if k := fmt.Sprintf("gone:%d", i); seen[k] {
+			t.Errorf("SCAN returned %s, deleted before it started", k)
+		}
+	}
+}
+
+func TestScanIndexSurvivesResize(t *testing.T) {
+	var x scanIndex
+	for i := range 1000 {
+		x.add(fmt.Sprintf("stable:%d", i))
+	}
+	seen := map[string]bool{}
+	cursor := uint64(0)
+	for call := 0; ; call++ {
+		var names []string
+		cursor, names = x.page(cursor, 10, "", nil)
+		for _, name := range names {
+			seen[name] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		// Grow the index to several times its size, then shrink it back,
+		// halfway through, so the buckets split and merge under the cursor.
+		switch call {
+		case 20:
+			for i := range 10000 {
+				x.add(fmt.Sprintf("extra:%d", i))
+			}
+		case 40:
+			for i := range 10000 {
+				x.remove(fmt.Sprintf("extra:%d", i))
+			}
+		}
+	}
+	for i := range 1000 {
+		if name := fmt.Sprintf("stable:%d", i); !seen[name] {
+			t.Errorf("page never returned %s, present throughout", name)
+		}
+	}
+	if x.n != 1000 || len(x.buckets) > 1024 {
+		t.Errorf("index holds %d names in %d buckets after shrinking", x.n, len(x.buckets))
+	}
+}
+
+func TestScanElementsOfLargeEncodings(t *testing.T) {
+	rs := newTestStore(t)
+	for i := range 1000 {
+		m := fmt.Sprintf("m%d", i)
+		run(rs, "SADD s "+m)
+		run(rs, "HSET h "+m+" v")
+		run(rs, "ZADD z 1 "+m)
+	}
+	run(rs, "SREM s m0")
+	run(rs, "HDEL h m0")
+	run(rs, "ZREM z m0")
+	for _, tt := range []struct{ typ, key string }{{"set", "s"}, {"hash", "h"}, {"zset", "z"}} {
+		seen := map[string]bool{}
+		cursor, pages := uint64(0), 0
+		for {
+			next, items, err := rs.ScanElements(tt.typ, tt.key, cursor, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			step := 1
+			if tt.typ != "set" {
+				step = 2
+			}
+			for i := 0; i < len(items); i += step {
+				seen[items[i]] = true
+			}
+			pages++
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+		if len(seen) != 999 || seen["m0"] {
+			t.Errorf("scan of %s returned %d members, want the 999 left", tt.typ, len(seen))
+		}
+		if pages < 10 {
+			t.Errorf("scan of %s took %d pages, want it paged", tt.typ, pages)
+		}
+	}
+}
+
+func TestDebugScanAll(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET a 1")
+	run(rs, "RPUSH b x")
+	run(rs, "SET c 3")
+
+	seen := map[dbKey]bool{}
+	cursor := uint64(0)
+	for {
+		var found []dbKey
+		cursor, found = rs.ScanAll(cursor, 1, "")
+		for _, k := range found {
+			seen[k] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if !seen[dbKey{db: 0, key: key}] {
+			t.Errorf("SCANALL did not visit %q", key)
+		}
+	}
+
+	if got := run(rs, "DEBUG SCANALL 0 MATCH b"); got != "1) 0\n2) 1) 1) 0\n      2) b" {
+		t.Errorf("DEBUG SCANALL reply = %q", got)
+	}
+}
+
+func TestHScanSurvivesEncodingTransition(t *testing.T) {
+	rs := newTestStore(t)
+	for i := range 100 {
+		rs.HSet("h", []hashField{{fmt.Sprintf("f%d", i), "v"}})
+	}
+	if enc, _ := rs.ObjectEncoding("h"); enc != "listpack" {
+		t.Fatalf("encoding = %q before the transition, want listpack", enc)
+	}
+
+	seen := map[string]bool{}
+	collect := func(items []string) {
+		for i := 0; i < len(items); i += 2 {
+			seen[items[i]] = true
+		}
+	}
+	cursor, items, err := rs.ScanElements("hash", "h", 0, 30, "")
+	if err != nil || cursor == 0 {
+		t.Fatalf("first HSCAN = %d, %v, want a continuation cursor", cursor, err)
+	}
+	collect(items)
+
+	// Growing past hash-max-listpack-entries converts the hash to a
+	// hashtable in the middle of the iteration.
+	for i := 100; i < 300; i++ {
+		rs.HSet("h", []hashField{{fmt.Sprintf("f%d", i), "v"}})
+	}
+	if enc, _ := rs.ObjectEncoding("h"); enc != "hashtable" {
+		t.Fatalf("encoding = %q after the transition, want hashtable", enc)
+	}
+	for cursor != 0 {
+		cursor, items, err = rs.ScanElements("hash", "h", cursor, 30, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		collect(items)
+	}
+	for i := range 100 {
+		if f := fmt.Sprintf("f%d", i); !seen[f] {
+			t.Errorf("HSCAN lost %s, present for the whole iteration", f)
+		}
+	}
+}
+
+func TestTypeScanReplies(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "HSET h a 1")
+	run(rs, "SADD s a")
+	run(rs, "ZADD z 1.5 a")
+	tests := []struct{ cmd, want string }{
+		{"HSCAN h 0", scanReply(0, []string{"a", "1"})},
+		{"SSCAN s 0", scanReply(0, []string{"a"})},
+		{"ZSCAN z 0", scanReply(0, []string{"a", "1.5"})},
+		{"HSCAN missing 0", scanReply(0, nil)},
+		{"SSCAN h 0", formatError(errWrongType)},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkScanPage is one SCAN page of a large keyspace, which should not
+// cost more for the keys it does not visit.
+func BenchmarkScanPage(b *testing.B) {
+	rs := newBenchStore(b)
+	rs.config.AppendFsync = "no"
+	for i := range 100000 {
+		rs.Set(fmt.Sprintf("key:%d", i), "v")
+	}
+	cursor := uint64(0)
+	for b.Loop() {
+		cursor, _ = rs.Scan(cursor, 10, "")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Repeated accept errors, such as running out of file descriptors, are
+// retried after a delay that starts at minAcceptBackoff and doubles up to
+// maxAcceptBackoff, so that a listener stuck failing does not spin.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// server accepts client connections and tracks them so that Shutdown can
+// let in-flight commands finish before closing everything.
+type server struct {
+	rs       *RedisStore
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*trackedConn]struct{}
+	draining bool
+	// handlers counts the running connection handlers.
+	handlers sync.WaitGroup
+}
+
+// trackedConn is a connection known to a server. busy is set while one of
+// its commands is running, guarded by the server's mutex.
+type trackedConn struct {
+	srv  *server
+	conn net.Conn
+	busy bool
+}
+
+func newServer(rs *RedisStore) *server {
+	return &server{rs: rs, conns: make(map[*trackedConn]struct{})}
+}
+
+// begin marks a command as in flight, reporting false once the server is
+// draining and the command should not be started. A nil trackedConn, for a
+// connection outside any server, always proceeds.
+func (tc *trackedConn) begin() bool {
+	if tc == nil {
+		return true
+	}
+	tc.srv.mu.Lock()
+	defer tc.srv.mu.Unlock()
+	if tc.srv.draining {
+		return false
+	}
+	tc.busy = true
+	return true
+}
+
+// end marks the in-flight command as done, reporting false if the server
+// has started draining and the connection should now close.
+func (tc *trackedConn) end() bool {
+	if tc == nil {
+		return true
+	}
+	tc.srv.mu.Lock()
+	defer tc.srv.mu.Unlock()
+	tc.busy = false
+	return !tc.srv.draining
+}
+
+// ListenAndServe listens on addr, with the configured tcp-backlog, and
+// serves connections until Shutdown.
+func (s *server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.rs.mutex.RLock()
+	backlog := s.rs.config.TCPBacklog
+	s.rs.mutex.RUnlock()
+	if err := setBacklog(listener, backlog); err != nil {
+		listener.Close()
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until Shutdown closes it.
+func (s *server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	log.Printf("server started on %s", listener.Addr())
+	var backoff time.Duration
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			log.Printf("accept error: %v; retrying in %v", err, backoff)
+			<-s.rs.clock.After(backoff)
+			continue
+		}
+		backoff = 0
+		s.rs.mutex.RLock()
+		cfg := s.rs.config
+		s.rs.mutex.RUnlock()
+		if err := configureConn(conn, cfg); err != nil {
+			log.Println("error setting connection options: ", err)
+		}
+		s.mu.Lock()
+		if s.draining {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		tc := &trackedConn{srv: s, conn: conn}
+		s.conns[tc] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.handlers.Done()
+			handleConnection(conn, s.rs, tc)
+			s.mu.Lock()
+			delete(s.conns, tc)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and closes the idle ones at once.
+// Connections running a command are closed as soon as it has replied, and
+// any still running after shutdown-timeout are closed regardless. It
+// returns once every connection handler has exited.
+func (s *server) Shutdown() {
+	s.rs.mutex.RLock()
+	timeout := s.rs.config.ShutdownTimeout
+	s.rs.mutex.RUnlock()
+
+	s.mu.Lock()
+	s.draining = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for tc := range s.conns {
+		if !tc.busy {
+			tc.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-s.rs.clock.After(timeout):
+	}
+	s.mu.Lock()
+	log.Printf("shutdown timeout reached, closing %d connections still running commands", len(s.conns))
+	for tc := range s.conns {
+		tc.conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// holdHook holds SET commands until release is closed, telling entered when
+// one starts.
+type holdHook struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (h *holdHook) processing(cmd Command) {
+	if cmd.Name == "SET" {
+		h.entered <- struct{}{}
+		<-h.release
+	}
+}
+func (h *holdHook) flushingAOF() {}
+func (h *holdHook) reclaiming()  {}
+
+// startServer serves rs on a local port and returns the server and its
+// address.
+func startServer(t *testing.T, rs *RedisStore) (*server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(rs)
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestShutdownDrainsInFlightCommand(t *testing.T) {
+	rs := newTestStore(t)
+	hook := &holdHook{entered: make(chan struct{}), release: make(chan struct{})}
+	rs.hook = hook
+	srv, addr := startServer(t, rs)
+
+	busy, idle := dial(t, addr), dial(t, addr)
+	io.WriteString(idle, "GET a\n")
+	idleReader := bufio.NewReader(idle)
+	if line, _ := idleReader.ReadString('\n'); line != "nil\n" {
+		t.Fatalf("GET = %q", line)
+	}
+	io.WriteString(busy, "SET a 1\n")
+	<-hook.entered
+
+	done := make(chan struct{})
+	go func() {
+		srv.Shutdown()
+		close(done)
+	}()
+	// The idle connection is closed straight away.
+	if _, err := idleReader.ReadString('\n'); err != io.EOF {
+		t.Errorf("read on the idle connection = %v, want EOF", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a command was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(hook.release)
+	busyReader := bufio.NewReader(busy)
+	if line, err := busyReader.ReadString('\n'); line != "OK\n" {
+		t.Errorf("in-flight SET = %q, %v, want OK before the connection closes", line, err)
+	}
+	<-done
+	if _, err := busyReader.ReadString('\n'); err != io.EOF {
+		t.Errorf("read after shutdown = %v, want EOF", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("server still accepts connections after Shutdown")
+	}
+}
+
+func TestShutdownTimeoutClosesStuckConnections(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	hook := &holdHook{entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(hook.release)
+	rs.hook = hook
+	srv, addr := startServer(t, rs)
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "SET a 1\n")
+	<-hook.entered
+
+	done := make(chan struct{})
+	go func() {
+		srv.Shutdown()
+		close(done)
+	}()
+	waitUntil(t, func() bool { return clk.pendingTimers() == 1 })
+	clk.Advance(rs.config.ShutdownTimeout)
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != io.EOF {
+		t.Errorf("read on the stuck connection = %v, want EOF after the timeout", err)
+	}
+	hook.release <- struct{}{}
+	<-done
+}
+
+// failingListener fails every Accept with a temporary error until it is
+// closed, counting the attempts.
+type failingListener struct {
+	accepts atomic.Int32
+	closed  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, syscall.EMFILE
+	}
+}
+
+func (l *failingListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestAcceptErrorsBackOff(t *testing.T) {
+	rs := newTestStore(t)
+	clock := newFakeClock()
+	rs.clock = clock
+	ln := &failingListener{closed: make(chan struct{})}
+	srv := newServer(rs)
+	done := make(chan error)
+	go func() { done <- srv.Serve(ln) }()
+
+	accepts := int32(1)
+	for _, backoff := range []time.Duration{
+		5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+		40 * time.Millisecond, 80 * time.Millisecond, 160 * time.Millisecond,
+		320 * time.Millisecond, 640 * time.Millisecond, time.Second, time.Second,
+	} {
+		waitUntil(t, func() bool { return clock.pendingTimers() == 1 })
+		clock.Advance(backoff - time.Nanosecond)
+		time.Sleep(time.Millisecond)
+		if n := ln.accepts.Load(); n != accepts {
+			t.Fatalf("%d accepts before the %v backoff elapsed, want %d", n, backoff, accepts)
+		}
+		clock.Advance(time.Nanosecond)
+		accepts++
+		waitUntil(t, func() bool { return ln.accepts.Load() == accepts })
+	}
+	waitUntil(t, func() bool { return clock.pendingTimers() == 1 })
+	srv.Shutdown()
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("Serve = %v after Shutdown", err)
+	}
+}
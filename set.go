@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// setEncoding returns the encoding for set given the one it has now: intset
+// while it holds only integers and is within set-max-intset-entries,
+// listpack while it is within the set-max-listpack limits, and hashtable
+// otherwise. Like Redis, a set never converts back to a smaller encoding
+// once it has grown out of one.
+func setEncoding(set map[string]struct{}, current string, cfg *Config) string {
+	switch current {
+	case "", "intset":
+		if len(set) <= cfg.SetMaxIntsetEntries && allMembers(set, isInt64) {
+			return "intset"
+		}
+		fallthrough
+	case "listpack":
+		if len(set) <= cfg.SetMaxListpackEntries && allMembers(set, func(m string) bool { return len(m) <= cfg.SetMaxListpackValue }) {
+			return "listpack"
+		}
+	}
+	return "hashtable"
+}
+
+// indexMembers builds the SSCAN index of the set sv holds once it has the
+// hashtable encoding. Writes keep an index already built up to date.
+func indexMembers(sv *StoredValue, set map[string]struct{}) {
+	if sv.encoding != "hashtable" || sv.members != nil {
+		return
+	}
+	sv.members = &scanIndex{}
+	for m := range set {
+		sv.members.add(m)
+	}
+}
+
+func allMembers(set map[string]struct{}, ok func(string) bool) bool {
+	for m := range set {
+		if !ok(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// getSet returns the set stored at key, nil if the key does not exist, or
+// errWrongType if it holds another type. The caller must hold the mutex.
+func (r *RedisStore) getSet(key string) (map[string]struct{}, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	set, ok := sv.value.(map[string]struct{})
+	if !ok {
+		return nil, errWrongType
+	}
+	return set, nil
+}
+
+// SAdd adds members to the set at key, returning how many were new.
+func (r *RedisStore) SAdd(key string, members ...string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	set, err := r.getSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		set = make(map[string]struct{})
+		r.data[key] = r.newValue(set)
+	}
+	sv := r.data[key]
+	added := 0
+	for _, m := range members {
+		if _, ok := set[m]; !ok {
+			set[m] = struct{}{}
+			if sv.members != nil {
+				sv.members.add(m)
+			}
+			added++
+		}
+	}
+	sv.encoding = setEncoding(set, sv.encoding, &r.config)
+	indexMembers(sv, set)
+	r.touch(key)
+	if err := r.writeAOF("SADD", append([]string{key}, members...)...); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// SRem removes members from the set at key, returning how many were present.
+func (r *RedisStore) SRem(key string, members ...string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	set, err := r.getSet(key)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	sv := r.data[key]
+	removed := 0
+	for _, m := range members {
+		if _, ok := set[m]; ok {
+			delete(set, m)
+			if sv.members != nil {
+				sv.members.remove(m)
+			}
+			removed++
+		}
+	}
+	if len(set) == 0 {
+		delete(r.data, key)
+	}
+	if removed > 0 {
+		r.touch(key)
+		if err := r.writeAOF("SREM", append([]string{key}, members...)...); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+// SMove moves member from the set at src to the set at dst, reporting
+// whether src had it. Both keys must hold sets, or not exist, for it to do
+// anything.
+func (r *RedisStore) SMove(src, dst, member string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	from, err := r.getSet(src)
+	if err != nil {
+		return false, err
+	}
+	to, err := r.getSet(dst)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := from[member]; !ok {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+	delete(from, member)
+	if fsv := r.data[src]; fsv.members != nil {
+		fsv.members.remove(member)
+	}
+	if len(from) == 0 {
+		delete(r.data, src)
+	}
+	if to == nil {
+		to = make(map[string]struct{})
+		r.data[dst] = r.newValue(to)
+	}
+	to[member] = struct{}{}
+	sv := r.data[dst]
+	if sv.members != nil {
+		sv.members.add(member)
+	}
+	sv.encoding = setEncoding(to, sv.encoding, &r.config)
+	indexMembers(sv, to)
+	r.touch(src)
+	r.touch(dst)
+	return true, r.writeAOF("SMOVE", src, dst, member)
+}
+
+func (r *RedisStore) SIsMember(key, member string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	set, err := r.getSet(key)
+	r.stats.keyspaceRead(set != nil || err != nil)
+	_, ok := set[member]
+	return ok, err
+}
+
+func (r *RedisStore) SCard(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	set, err := r.getSet(key)
+	r.stats.keyspaceRead(set != nil || err != nil)
+	return len(set), err
+}
+
+// SMembers returns the members of the set at key in sorted order.
+func (r *RedisStore) SMembers(key string) ([]string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	set, err := r.getSet(key)
+	r.stats.keyspaceRead(set != nil || err != nil)
+	if err != nil {
+		return nil, err
+	}
+	b := r.newBudget()
+	members := make([]string, 0, len(set))
+	for m := range set {
+		if !b.spend() {
+			return nil, errOverBudget("SMEMBERS", "SSCAN")
+		}
+		members = append(members, m)
+	}
+	slices.Sort(members)
+	return members, nil
+}
+
+// SInterCard returns the size of the intersection of the sets at keys,
+// stopping early once it reaches limit. A limit of 0 means no limit.
+func (r *RedisStore) SInterCard(keys []string, limit int) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		set, err := r.getSet(key)
+		if err != nil {
+			return 0, err
+		}
+		sets[i] = set
+	}
+	// Any missing key makes the intersection empty; otherwise walk the
+	// smallest set.
+	slices.SortFunc(sets, func(a, b map[string]struct{}) int { return len(a) - len(b) })
+	count := 0
+members:
+	for m := range sets[0] {
+		for _, set := range sets[1:] {
+			if _, ok := set[m]; !ok {
+				continue members
+			}
+		}
+		count++
+		if count == limit {
+			break
+		}
+	}
+	return count, nil
+}
+
+var errNegativeLimit = errors.New("ERR LIMIT can't be negative")
+
+// parseInterCardArgs parses "numkeys key [key ...] [LIMIT limit]", shared by
+// SINTERCARD and ZINTERCARD.
+func parseInterCardArgs(args []string) ([]string, int, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, 0, errNumKeys
+	}
+	if numKeys > len(args)-1 {
+		return nil, 0, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[1 : numKeys+1]
+	rest := args[numKeys+1:]
+	limit := 0
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil {
+			return nil, 0, errNotInteger
+		}
+		if limit < 0 {
+			return nil, 0, errNegativeLimit
+		}
+	default:
+		return nil, 0, errSyntax
+	}
+	return keys, limit, nil
+}
+
+func setCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "SADD", "SREM":
+		if len(args) >= 2 {
+			op := rs.SAdd
+			if cmd.Name == "SREM" {
+				op = rs.SRem
+			}
+			n, err := op(args[0], args[1:]...)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "SMOVE":
+		if len(args) == 3 {
+			return boolReply(rs.SMove(args[0], args[1], args[2]))
+		}
+	case "SISMEMBER":
+		if len(args) == 2 {
+			ok, err := rs.SIsMember(args[0], args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			if ok {
+				return "1"
+			}
+			return "0"
+		}
+	case "SCARD":
+		if len(args) == 1 {
+			n, err := rs.SCard(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "SMEMBERS":
+		if len(args) == 1 {
+			members, err := rs.SMembers(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return formatArray(members)
+		}
+	case "SINTERCARD":
+		if len(args) >= 2 {
+			keys, limit, err := parseInterCardArgs(args)
+			if err != nil {
+				return formatError(err)
+			}
+			n, err := rs.SInterCard(keys, limit)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "SADD s b a c a"); got != "3" {
+		t.Errorf("SADD = %q, want 3", got)
+	}
+	if got := run(rs, "SMEMBERS s"); got != "1) a\n2) b\n3) c" {
+		t.Errorf("SMEMBERS = %q", got)
+	}
+	if got := run(rs, "SISMEMBER s b"); got != "1" {
+		t.Errorf("SISMEMBER = %q", got)
+	}
+	if got := run(rs, "SREM s a x"); got != "1" {
+		t.Errorf("SREM = %q", got)
+	}
+	if got := run(rs, "SCARD s"); got != "2" {
+		t.Errorf("SCARD = %q", got)
+	}
+	run(rs, "SET str v")
+	if got := run(rs, "SADD str m"); !strings.HasPrefix(got, "-WRONGTYPE") {
+		t.Errorf("SADD on a string = %q", got)
+	}
+
+	if err := rs.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(reopen(t, rs), "SMEMBERS s"); got != "1) b\n2) c" {
+		t.Errorf("SMEMBERS after rewrite and reload = %q", got)
+	}
+}
+
+func TestInterCardLimit(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SADD a 1 2 3 4 5")
+	run(rs, "SADD b 2 3 4 5 6")
+	run(rs, "ZADD za 1 x 2 y 3 z 4 w")
+	run(rs, "ZADD zb 9 x 9 y 9 z")
+
+	tests := []struct {
+		cmd, want string
+	}{
+		{"SINTERCARD 2 a b", "4"},
+		{"SINTERCARD 2 a b LIMIT 0", "4"},
+		{"SINTERCARD 2 a b LIMIT 2", "2"},
+		{"SINTERCARD 2 a b LIMIT 10", "4"},
+		{"SINTERCARD 2 a missing LIMIT 0", "0"},
+		{"SINTERCARD 2 a b LIMIT -1", "-ERR LIMIT can't be negative"},
+		{"SINTERCARD 0 a", "-ERR numkeys should be greater than 0"},
+		{"SINTERCARD 3 a b", "-ERR Number of keys can't be greater than number of args"},
+		{"ZINTERCARD 2 za zb", "3"},
+		{"ZINTERCARD 2 za zb LIMIT 0", "3"},
+		{"ZINTERCARD 2 za zb LIMIT 1", "1"},
+		{"ZINTERCARD 2 za zb LIMIT -5", "-ERR LIMIT can't be negative"},
+		{"ZINTERCARD 1 za LIMIT 0", "4"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestSetEncodingSurvivesReload(t *testing.T) {
+	rs := newTestStore(t)
+	rs.config.SetMaxIntsetEntries = 3
+	rs.config.SetMaxListpackEntries = 4
+	run(rs, "SADD small 1 2 3")
+	run(rs, "SADD words a b")
+	run(rs, "SADD shrunk 1 2 3 4 5")
+	run(rs, "SREM shrunk 4 5")
+	want := map[string]string{"small": "intset", "words": "listpack", "shrunk": "hashtable"}
+	for key, enc := range want {
+		if got := run(rs, "OBJECT ENCODING "+key); got != enc {
+			t.Errorf("OBJECT ENCODING %s = %q, want %q", key, got, enc)
+		}
+	}
+	if got := run(rs, "SAVE"); got != "OK" {
+		t.Fatalf("SAVE = %q", got)
+	}
+
+	// Replaying the AOF repeats the commands, so each set ends up as it was.
+	rs = reopen(t, rs)
+	for key, enc := range want {
+		if got := run(rs, "OBJECT ENCODING "+key); got != enc {
+			t.Errorf("OBJECT ENCODING %s after an AOF reload = %q, want %q", key, got, enc)
+		}
+	}
+	// A set loaded from a snapshot takes the encoding its contents choose,
+	// as a new set would.
+	want["shrunk"] = "intset"
+	rs.Close()
+	if err := os.Remove(rs.path(aofFilename)); err != nil {
+		t.Fatal(err)
+	}
+	rs = reopen(t, rs)
+	for key, enc := range want {
+		if got := run(rs, "OBJECT ENCODING "+key); got != enc {
+			t.Errorf("OBJECT ENCODING %s after a snapshot reload = %q, want %q", key, got, enc)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+}
+
+// slowlog records commands that took longer than slowlog-log-slower-than,
+// newest first.
+type slowlog struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries []slowlogEntry
+}
+
+// slowlogArgs copies a command for the log, trimming it the way Redis does so
+// huge commands don't bloat the log.
+func slowlogArgs(cmd Command) []string {
+	all := append([]string{cmd.Name}, cmd.Args...)
+	args := make([]string, 0, min(len(all), slowlogMaxArgs))
+	for i, arg := range all {
+		if i == slowlogMaxArgs-1 && len(all) > slowlogMaxArgs {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(all)-i))
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (s *slowlog) add(cmd Command, at time.Time, duration time.Duration, maxLen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := slowlogEntry{id: s.nextID, time: at, duration: duration, args: slowlogArgs(cmd)}
+	s.nextID++
+	s.entries = append([]slowlogEntry{entry}, s.entries...)
+	if len(s.entries) > maxLen {
+		s.entries = s.entries[:maxLen]
+	}
+}
+
+func (s *slowlog) get(count int) []slowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	return append([]slowlogEntry(nil), s.entries[:count]...)
+}
+
+func (s *slowlog) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *slowlog) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+}
+
+// recordSlow logs cmd if it ran for at least slowlog-log-slower-than. A
+// negative threshold disables the log.
+func (r *RedisStore) recordSlow(cmd Command, start time.Time, duration time.Duration) {
+	r.mutex.RLock()
+	threshold, maxLen := r.config.SlowlogLogSlowerThan, r.config.SlowlogMaxLen
+	r.mutex.RUnlock()
+	if threshold < 0 || duration < threshold {
+		return
+	}
+	r.slowlog.add(cmd, start, duration, maxLen)
+}
+
+func slowlogCommand(args []string, rs *RedisStore) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		count := 10
+		if len(args) == 2 {
+			var err error
+			count, err = strconv.Atoi(args[1])
+			if err != nil || count < -1 {
+				return formatError(errors.New("ERR count should be greater than or equal to -1"))
+			}
+		}
+		if len(args) <= 2 {
+			entries := rs.slowlog.get(count)
+			items := make([]string, len(entries))
+			for i, e := range entries {
+				items[i] = formatArray([]string{
+					strconv.FormatInt(e.id, 10),
+					strconv.FormatInt(e.time.Unix(), 10),
+					strconv.FormatInt(e.duration.Microseconds(), 10),
+					formatArray(e.args),
+				})
+			}
+			return formatArray(items)
+		}
+	case "LEN":
+		if len(args) == 1 {
+			return strconv.Itoa(rs.slowlog.len())
+		}
+	case "RESET":
+		if len(args) == 1 {
+			rs.slowlog.reset()
+			return "OK"
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// delayHook makes commands appear slow by advancing a fake clock while they
+// run, either before named commands execute or during every AOF flush.
+type delayHook struct {
+	clk      *fakeClock
+	commands map[string]time.Duration
+	aofFlush time.Duration
+}
+
+func (h *delayHook) processing(cmd Command) {
+	h.clk.Advance(h.commands[cmd.Name])
+}
+
+func (h *delayHook) flushingAOF() {
+	h.clk.Advance(h.aofFlush)
+}
+
+func (h *delayHook) reclaiming() {}
+
+func TestSlowlogCapturesSlowCommand(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	rs.hook = &delayHook{clk: clk, commands: map[string]time.Duration{"SET": 25 * time.Millisecond}}
+
+	run(rs, "GET k")
+	run(rs, "SET k v")
+	run(rs, "GET k")
+
+	if got := run(rs, "SLOWLOG LEN"); got != "1" {
+		t.Fatalf("SLOWLOG LEN = %q, want 1", got)
+	}
+	want := "1) 1) 0\n   2) " + itoa64(clk.Now().Add(-25*time.Millisecond).Unix()) + "\n   3) 25000\n   4) 1) SET\n      2) k\n      3) v"
+	if got := run(rs, "SLOWLOG GET"); got != want {
+		t.Errorf("SLOWLOG GET = %q, want %q", got, want)
+	}
+	if got := run(rs, "SLOWLOG RESET"); got != "OK" {
+		t.Errorf("SLOWLOG RESET = %q", got)
+	}
+	if got := run(rs, "SLOWLOG LEN"); got != "0" {
+		t.Errorf("SLOWLOG LEN after RESET = %q", got)
+	}
+}
+
+func TestSlowlogSlowAOFFlush(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	rs.hook = &delayHook{clk: clk, aofFlush: 15 * time.Millisecond}
+
+	run(rs, "GET k")
+	run(rs, "RPUSH l a")
+	entries := rs.slowlog.get(-1)
+	if len(entries) != 1 || entries[0].args[0] != "RPUSH" || entries[0].duration != 15*time.Millisecond {
+		t.Errorf("slow log = %+v, want just the RPUSH at 15ms", entries)
+	}
+}
+
+func TestSlowlogThresholdAndTrimming(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "CONFIG SET slowlog-log-slower-than 0")
+	run(rs, "CONFIG SET slowlog-max-len 2")
+	run(rs, "SET a 1")
+	run(rs, "SET b "+strings.Repeat("x", 200))
+	run(rs, "GET a")
+	entries := rs.slowlog.get(-1)
+	if len(entries) != 2 {
+		t.Fatalf("slow log has %d entries, want 2", len(entries))
+	}
+	if entries[0].args[0] != "GET" {
+		t.Errorf("newest entry = %v, want the GET", entries[0].args)
+	}
+	if got := entries[1].args[2]; got != strings.Repeat("x", 128)+"... (72 more bytes)" {
+		t.Errorf("long argument logged as %q", got)
+	}
+
+	run(rs, "CONFIG SET slowlog-log-slower-than -1")
+	run(rs, "SLOWLOG RESET")
+	run(rs, "SET c 3")
+	if got := run(rs, "SLOWLOG LEN"); got != "0" {
+		t.Errorf("SLOWLOG LEN with the log disabled = %q", got)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+)
+
+const (
+	snapshotFilename = "redisstore.snap"
+	snapshotVersion  = 1
+)
+
+// snapshot is the on-disk form written by SAVE and BGSAVE.
+type snapshot struct {
+	Version int
+	Entries []snapshotEntry
+}
+
+// snapshotEntry is one key. Exactly one of the value fields is set, as
+// selected by Type. ExpireAt is the absolute expiry in Unix milliseconds, or
+// zero, so a reload keeps the original deadline rather than restarting the
+// TTL.
+type snapshotEntry struct {
+	Key      string
+	Type     string
+	String   string
+	List     []string
+	Set      []string
+	ZSet     []snapshotMember
+	Hash     []snapshotField
+	Stream   []snapshotStreamEntry
+	LastID   string
+	Groups   []snapshotGroup
+	ExpireAt int64
+}
+
+type snapshotField struct {
+	Field string
+	Value string
+}
+
+type snapshotStreamEntry struct {
+	ID     string
+	Fields []string
+}
+
+type snapshotGroup struct {
+	Name          string
+	LastDelivered string
+}
+
+type snapshotMember struct {
+	Member string
+	Score  float64
+}
+
+// snapshotEntries copies the live keyspace into its on-disk form. The caller
+// must hold the mutex.
+func (r *RedisStore) snapshotEntries() []snapshotEntry {
+	entries := make([]snapshotEntry, 0, len(r.data))
+	now := r.clock.Now()
+	for key, sv := range r.data {
+		if sv.expired(now) {
+			continue
+		}
+		entries = append(entries, snapshotValue(key, sv))
+	}
+	return entries
+}
+
+// snapshotValue copies a single key into its on-disk form.
+func snapshotValue(key string, sv *StoredValue) snapshotEntry {
+	e := snapshotEntry{Key: key}
+	if sv.expireAt != 0 {
+		e.ExpireAt = sv.expireAt
+	}
+	switch v := sv.value.(type) {
+	case string:
+		e.Type, e.String = "string", v
+	case []byte:
+		e.Type, e.String = "string", string(v)
+	case []string:
+		e.Type, e.List = "list", slices.Clone(v)
+	case map[string]struct{}:
+		e.Type = "set"
+		for m := range v {
+			e.Set = append(e.Set, m)
+		}
+	case *hashValue:
+		e.Type = "hash"
+		for _, p := range v.fields() {
+			e.Hash = append(e.Hash, snapshotField{p.field, p.value})
+		}
+	case *sortedSet:
+		e.Type = "zset"
+		for _, m := range v.entries {
+			e.ZSet = append(e.ZSet, snapshotMember{m.member, m.score})
+		}
+	case *stream:
+		e.Type = "stream"
+		for _, se := range v.entries {
+			e.Stream = append(e.Stream, snapshotStreamEntry{se.id.String(), slices.Clone(se.fields)})
+		}
+		e.LastID = v.lastID.String()
+		for name, g := range v.groups {
+			e.Groups = append(e.Groups, snapshotGroup{name, g.lastDelivered.String()})
+		}
+	}
+	return e
+}
+
+// storedValue rebuilds the value of an on-disk entry, encoded for the
+// current settings. Its expiry is left for the caller to set.
+func (r *RedisStore) storedValue(e snapshotEntry) (*StoredValue, error) {
+	sv := r.newValue(nil)
+	switch e.Type {
+	case "string":
+		sv.value = sharedInteger(e.String)
+		sv.encoding = stringEncoding(e.String, r.config.EmbstrSizeLimit)
+	case "list":
+		sv.value = e.List
+		sv.encoding = listEncoding(e.List, "", r.config.ListMaxListpackSize)
+	case "set":
+		set := make(map[string]struct{}, len(e.Set))
+		for _, m := range e.Set {
+			set[m] = struct{}{}
+		}
+		sv.value = set
+		sv.encoding = setEncoding(set, "", &r.config)
+		indexMembers(sv, set)
+	case "hash":
+		h := &hashValue{}
+		for _, f := range e.Hash {
+			h.set(f.Field, f.Value)
+		}
+		h.convert(r.config.HashMaxListpackEntries, r.config.HashMaxListpackValue)
+		sv.value = h
+	case "zset":
+		z := &sortedSet{}
+		for _, m := range e.ZSet {
+			z.add(m.Member, m.Score)
+		}
+		z.convert(r.config.ZSetMaxListpackEntries, r.config.ZSetMaxListpackValue)
+		sv.value = z
+	case "stream":
+		s := &stream{}
+		for _, se := range e.Stream {
+			id, err := parseStreamID(se.ID, 0)
+			if err != nil {
+				return nil, err
+			}
+			s.add(id, se.Fields)
+		}
+		// Snapshots from before LastID was kept have the last entry's.
+		if e.LastID != "" {
+			id, err := parseStreamID(e.LastID, 0)
+			if err != nil {
+				return nil, err
+			}
+			s.lastID = id
+		}
+		for _, g := range e.Groups {
+			id, err := parseStreamID(g.LastDelivered, 0)
+			if err != nil {
+				return nil, err
+			}
+			if s.groups == nil {
+				s.groups = make(map[string]*streamGroup)
+			}
+			s.groups[g.Name] = &streamGroup{lastDelivered: id}
+		}
+		sv.value = s
+	default:
+		return nil, fmt.Errorf("unknown type %q", e.Type)
+	}
+	return sv, nil
+}
+
+// writeSnapshot writes entries to a temporary file and renames it into place,
+// so a crash mid-save never leaves a truncated snapshot behind.
+func (r *RedisStore) writeSnapshot(entries []snapshotEntry) error {
+	tmp, err := os.CreateTemp(r.config.Dir, "temp-snapshot-*.snap")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(snapshot{Version: snapshotVersion, Entries: entries})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path(snapshotFilename))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Save writes a snapshot of the keyspace.
+func (r *RedisStore) Save() error {
+	r.mutex.RLock()
+	entries := r.snapshotEntries()
+	r.mutex.RUnlock()
+	return r.writeSnapshot(entries)
+}
+
+// BGSave copies the keyspace and writes the snapshot in the background.
+func (r *RedisStore) BGSave() {
+	r.mutex.RLock()
+	entries := r.snapshotEntries()
+	r.mutex.RUnlock()
+	go func() {
+		if err := r.writeSnapshot(entries); err != nil {
+			log.Println("background save failed: ", err)
+		}
+	}()
+}
+
+// loadSnapshot replaces the keyspace with the snapshot file's contents, if
+// there is one. Keys whose absolute expiry has already passed are dropped.
+func (r *RedisStore) loadSnapshot() error {
+	file, err := os.Open(r.path(snapshotFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.clock.Now()
+	for _, e := range snap.Entries {
+		sv, err := r.storedValue(e)
+		if err != nil {
+			return fmt.Errorf("%v for key %q in snapshot", err, e.Key)
+		}
+		sv.expireAt = e.ExpireAt
+		if sv.expired(now) {
+			continue
+		}
+		r.data[e.Key] = sv
+		r.indexKey(e.Key)
+	}
+	return nil
+}
+
+// load restores the keyspace at startup. The AOF is preferred since it is
+// the more complete record; the snapshot is only used when the AOF is empty,
+// in which case the AOF is then rewritten from the loaded data so later
+// writes append to a complete file.
+func (r *RedisStore) load() error {
+	info, err := os.Stat(r.path(aofFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && info.Size() > 0 {
+		return r.loadAOF()
+	}
+	if err := r.loadSnapshot(); err != nil {
+		return err
+	}
+	r.mutex.RLock()
+	empty := len(r.data) == 0
+	r.mutex.RUnlock()
+	if empty {
+		return nil
+	}
+	return r.RewriteAOF()
+}
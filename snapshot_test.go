@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSnapshotKeepsAbsoluteTTL(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+
+	run(rs, "SET k v")
+	run(rs, "EXPIRE k 10")
+	run(rs, "SET gone soon")
+	run(rs, "PEXPIRE gone 2500")
+	run(rs, "RPUSH l a b")
+	run(rs, "ZADD z 1.5 m")
+	clk.Advance(2 * time.Second)
+	if got := run(rs, "SAVE"); got != "OK" {
+		t.Fatalf("SAVE = %q", got)
+	}
+	rs.Close()
+	// Load from the snapshot alone.
+	if err := os.Remove(rs.path(aofFilename)); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(3 * time.Second)
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "PTTL k"); got != "5000" {
+		t.Errorf("PTTL after reload = %q, want 5000", got)
+	}
+	if got := run(reloaded, "GET gone"); got != "nil" {
+		t.Errorf("key that expired while the server was down = %q, want nil", got)
+	}
+	if got := run(reloaded, "LRANGE l 0 -1"); got != "1) a\n2) b" {
+		t.Errorf("LRANGE l = %q", got)
+	}
+	if got := run(reloaded, "ZSCORE z m"); got != "1.5" {
+		t.Errorf("ZSCORE z m = %q", got)
+	}
+
+	// The AOF is rebuilt from the snapshot, so a restart that finds it still
+	// has the expiry.
+	again := reopen(t, reloaded)
+	if got := run(again, "PTTL k"); got != "5000" {
+		t.Errorf("PTTL after reloading the rebuilt AOF = %q, want 5000", got)
+	}
+}
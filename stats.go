@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type commandStat struct {
+	calls    int64
+	duration time.Duration
+	latency  latencyHistogram
+}
+
+// stats holds the cumulative counters reported by INFO. They have their own
+// lock so CONFIG RESETSTAT can zero them all at once without touching the
+// keyspace.
+type stats struct {
+	mu            sync.Mutex
+	totalCommands int64
+	commands      map[string]*commandStat
+	evictedKeys   int64
+	// keyspaceHits and keyspaceMisses count reads of a key that did and
+	// did not exist.
+	keyspaceHits   int64
+	keyspaceMisses int64
+	// lazyfreedObjects counts keys reclaimed in the background after an
+	// ASYNC flush.
+	lazyfreedObjects int64
+}
+
+func (s *stats) command(name string, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commands == nil {
+		s.commands = make(map[string]*commandStat)
+	}
+	cs := s.commands[name]
+	if cs == nil {
+		cs = &commandStat{}
+		s.commands[name] = cs
+	}
+	cs.calls++
+	cs.duration += duration
+	cs.latency.record(duration)
+	s.totalCommands++
+}
+
+// keyspaceRead records a read command's lookup of a key.
+func (s *stats) keyspaceRead(found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found {
+		s.keyspaceHits++
+	} else {
+		s.keyspaceMisses++
+	}
+}
+
+func (s *stats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totalCommands = 0
+	s.commands = nil
+	s.evictedKeys = 0
+	s.keyspaceHits = 0
+	s.keyspaceMisses = 0
+	s.lazyfreedObjects = 0
+}
+
+func (s *stats) lazyfreed(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lazyfreedObjects += int64(n)
+}
+
+func statsInfo(r *RedisStore) []string {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	return []string{
+		fmt.Sprintf("total_commands_processed:%d", r.stats.totalCommands),
+		fmt.Sprintf("evicted_keys:%d", r.stats.evictedKeys),
+		fmt.Sprintf("keyspace_hits:%d", r.stats.keyspaceHits),
+		fmt.Sprintf("keyspace_misses:%d", r.stats.keyspaceMisses),
+		fmt.Sprintf("lazyfreed_objects:%d", r.stats.lazyfreedObjects),
+	}
+}
+
+func commandStatsInfo(r *RedisStore) []string {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(r.stats.commands)) {
+		cs := r.stats.commands[name]
+		usec := cs.duration.Microseconds()
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f",
+			strings.ToLower(name), cs.calls, usec, float64(usec)/float64(cs.calls)))
+	}
+	return lines
+}
+
+// latencyPercentiles are the percentiles INFO latencystats reports, as
+// Redis does by default.
+var latencyPercentiles = []float64{50, 99, 99.9}
+
+func latencyStatsInfo(r *RedisStore) []string {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(r.stats.commands)) {
+		h := &r.stats.commands[name].latency
+		fields := make([]string, len(latencyPercentiles))
+		for i, p := range latencyPercentiles {
+			usec := float64(h.percentile(p)) / float64(time.Microsecond)
+			fields[i] = fmt.Sprintf("p%s=%.3f", strconv.FormatFloat(p, 'f', -1, 64), usec)
+		}
+		lines = append(lines, fmt.Sprintf("latency_percentiles_usec_%s:%s", strings.ToLower(name), strings.Join(fields, ",")))
+	}
+	return lines
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceHitsAndMisses(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET k v")
+	run(rs, "GET k")
+	run(rs, "GET missing")
+	run(rs, "LRANGE missing 0 -1")
+
+	info := run(rs, "INFO stats")
+	if !strings.Contains(info, "keyspace_hits:1\n") || !strings.Contains(info, "keyspace_misses:2") {
+		t.Errorf("INFO stats = %q, want 1 hit and 2 misses", info)
+	}
+	run(rs, "CONFIG RESETSTAT")
+	info = run(rs, "INFO stats")
+	if !strings.Contains(info, "keyspace_hits:0\n") || !strings.Contains(info, "keyspace_misses:0") {
+		t.Errorf("INFO stats after RESETSTAT = %q, want the counters zeroed", info)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, histSub - 1, histSub, 1000, 123456, uint64(histMax)} {
+		i := histBucket(v)
+		if i >= histBuckets {
+			t.Fatalf("bucket of %d = %d, past the last", v, i)
+		}
+		if upper := histUpper(i); upper < v || upper > v+v/histSub {
+			t.Errorf("bucket of %d tops out at %d", v, upper)
+		}
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	hook := &delayHook{clk: clk, commands: map[string]time.Duration{"GET": 10 * time.Microsecond}}
+	rs.hook = hook
+	for range 90 {
+		run(rs, "GET k")
+	}
+	hook.commands["GET"] = 2 * time.Millisecond
+	for range 10 {
+		run(rs, "GET k")
+	}
+
+	line := infoField(t, run(rs, "INFO latencystats"), "latency_percentiles_usec_get")
+	got := make(map[string]float64)
+	for field := range strings.SplitSeq(line, ",") {
+		name, val, _ := strings.Cut(field, "=")
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			t.Fatalf("latency_percentiles_usec_get = %q", line)
+		}
+		got[name] = f
+	}
+	// Each percentile is the top of the bucket the durations fall in, at
+	// most 1/histSub above them.
+	for name, want := range map[string]float64{"p50": 10, "p99": 2000, "p99.9": 2000} {
+		if got[name] < want || got[name] > want*(1+1.0/histSub) {
+			t.Errorf("%s = %v, want the bucket of %vus", name, got[name], want)
+		}
+	}
+
+	run(rs, "CONFIG RESETSTAT")
+	if info := run(rs, "INFO latencystats"); strings.Contains(info, "latency_percentiles_usec_get") {
+		t.Errorf("INFO latencystats after RESETSTAT = %q", info)
+	}
+}
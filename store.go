@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// Store is the keyspace as the data commands see it: strings, expiry and
+// the per-type operations. Command dispatch for these commands depends only
+// on Store, so another backend, or a test double, can stand in for
+// RedisStore. Server-level commands such as persistence, Pub/Sub and
+// introspection still work on RedisStore directly.
+type Store interface {
+	// Now is the store's current time, against which relative expiries
+	// are resolved.
+	Now() time.Time
+
+	Get(key string) (string, bool, error)
+	SetWithOptions(key, val string, o setOptions) (string, bool, bool, error)
+	GetDel(key string) (string, bool, error)
+	GetEx(key string, e getExExpiry) (string, bool, error)
+	Set(key, val string) error
+	Del(keys []string) (int, error)
+	CompareAndSet(key, expected, val string) (bool, error)
+	IncrBy(key string, delta int64) (int64, error)
+	StrLen(key string) (int, error)
+	GetRange(key string, start, end int64) (string, error)
+	SetRange(key string, offset int64, val string) (int, error)
+	Append(key, val string) (int, error)
+	GetBit(key string, offset int64) (int, error)
+	BitField(key string, ops []bitfieldOp, args []string) ([]*int64, error)
+
+	Rename(src, dst string, nx bool) (bool, error)
+	Copy(src, dst string, replace bool) (bool, error)
+
+	ExpireAt(key string, at int64) (bool, error)
+	Persist(key string) (bool, error)
+	ExpireTime(key string) (int64, int)
+
+	Push(key string, end listEnd, vals ...string) (int, error)
+	LLen(key string) (int, error)
+	LRange(key string, start, stop int) ([]string, error)
+
+	SAdd(key string, members ...string) (int, error)
+	SRem(key string, members ...string) (int, error)
+	SIsMember(key, member string) (bool, error)
+	SCard(key string) (int, error)
+	SMembers(key string) ([]string, error)
+	SInterCard(keys []string, limit int) (int, error)
+	SMove(src, dst, member string) (bool, error)
+
+	HSet(key string, pairs []hashField) (int, error)
+	HGet(key, field string) (string, bool, error)
+	HDel(key string, fields []string) (int, error)
+	HLen(key string) (int, error)
+	HGetAll(key string) ([]string, error)
+
+	ZAdd(key string, entries []zsetEntry) (int, error)
+	ZIncrBy(key string, incr float64, member string) (float64, error)
+	ZRem(key string, members []string) (int, error)
+	ZScore(key, member string) (float64, bool, error)
+	ZCard(key string) (int, error)
+	ZRank(key, member string) (int, error)
+	ZRange(key string, start, stop int) ([]zsetEntry, error)
+	ZInterCard(keys []string, limit int) (int, error)
+	ZStore(op zsetOp, dest string, keys []string, weights []float64, agg zsetAggregate, args []string) (int, error)
+
+	XAdd(key string, id *streamID, fields []string, opts xaddOptions) (streamID, bool, error)
+	XLen(key string) (int, error)
+	XRange(key string, start, end streamID, count int) ([]streamEntry, error)
+	XDel(key string, ids []streamID) (int, error)
+	XTrim(key string, t streamTrim) (int, error)
+	XSetID(key string, id streamID) error
+	XGroupCreate(key, group string, id *streamID, mkstream bool) error
+	XGroupSetID(key, group string, id *streamID) error
+}
+
+var _ Store = (*RedisStore)(nil)
+
+func (r *RedisStore) Now() time.Time {
+	return r.clock.Now()
+}
+
+// storeCommand dispatches the commands that only need a Store.
+func storeCommand(cmd Command, rs Store) string {
+	switch cmd.Name {
+	case "GET":
+		if len(cmd.Args) == 1 {
+			val, exists, err := rs.Get(cmd.Args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			if exists {
+				return bulkReply(val)
+			}
+			return "nil"
+		}
+	case "SET":
+		if len(cmd.Args) == 2 {
+			if err := rs.Set(cmd.Args[0], cmd.Args[1]); err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+		if len(cmd.Args) > 2 {
+			o, err := parseSetOptions(cmd.Args[2:], rs.Now())
+			if err != nil {
+				return formatError(err)
+			}
+			old, existed, set, err := rs.SetWithOptions(cmd.Args[0], cmd.Args[1], o)
+			switch {
+			case o.get || err != nil:
+				return getReply(old, existed, err)
+			case !set:
+				return "nil"
+			}
+			return "OK"
+		}
+	case "DEL":
+		if len(cmd.Args) >= 1 {
+			n, err := rs.Del(cmd.Args)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "CAS":
+		if len(cmd.Args) == 3 {
+			return boolReply(rs.CompareAndSet(cmd.Args[0], cmd.Args[1], cmd.Args[2]))
+		}
+	case "INCR", "DECR":
+		if len(cmd.Args) == 1 {
+			delta := int64(1)
+			if cmd.Name == "DECR" {
+				delta = -1
+			}
+			return incrReply(rs.IncrBy(cmd.Args[0], delta))
+		}
+	case "INCRBY", "DECRBY":
+		if len(cmd.Args) == 2 {
+			delta, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+			if err != nil {
+				return formatError(errNotInteger)
+			}
+			if cmd.Name == "DECRBY" {
+				if delta == math.MinInt64 {
+					return formatError(errOverflow)
+				}
+				delta = -delta
+			}
+			return incrReply(rs.IncrBy(cmd.Args[0], delta))
+		}
+	case "LPUSH", "RPUSH":
+		if len(cmd.Args) >= 2 {
+			end := listLeft
+			if cmd.Name == "RPUSH" {
+				end = listRight
+			}
+			n, err := rs.Push(cmd.Args[0], end, cmd.Args[1:]...)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "LLEN":
+		if len(cmd.Args) == 1 {
+			n, err := rs.LLen(cmd.Args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "LRANGE":
+		if len(cmd.Args) == 3 {
+			start, err1 := strconv.Atoi(cmd.Args[1])
+			stop, err2 := strconv.Atoi(cmd.Args[2])
+			if err1 != nil || err2 != nil {
+				return formatError(errNotInteger)
+			}
+			items, err := rs.LRange(cmd.Args[0], start, stop)
+			if err != nil {
+				return formatError(err)
+			}
+			return formatArray(items)
+		}
+	case "GETDEL", "GETEX", "STRLEN", "GETRANGE", "SETRANGE", "APPEND", "GETBIT", "BITFIELD":
+		return stringCommand(cmd, rs)
+	case "ZADD", "ZINCRBY", "ZREM", "ZSCORE", "ZCARD", "ZRANK", "ZRANGE",
+		"ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE", "ZINTERCARD":
+		return zsetCommand(cmd, rs)
+	case "RENAME", "RENAMENX", "COPY":
+		return renameCommand(cmd, rs)
+	case "SADD", "SREM", "SISMEMBER", "SCARD", "SMEMBERS", "SINTERCARD", "SMOVE":
+		return setCommand(cmd, rs)
+	case "HSET", "HGET", "HDEL", "HLEN", "HGETALL":
+		return hashCommand(cmd, rs)
+	case "XADD", "XLEN", "XRANGE", "XGROUP":
+		return streamCommand(cmd, rs)
+	case "XDEL", "XTRIM", "XSETID":
+		return streamTrimCommand(cmd, rs)
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "PERSIST", "TTL", "PTTL",
+		"EXPIRETIME", "PEXPIRETIME":
+		return expireCommand(cmd, rs)
+	}
+	return ""
+}
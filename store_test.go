@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mockStore records the Store calls made by dispatch. Embedding the
+// interface satisfies the methods a test does not override; calling one of
+// those panics.
+type mockStore struct {
+	Store
+	now   time.Time
+	calls []string
+}
+
+func (m *mockStore) record(format string, args ...any) {
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *mockStore) Now() time.Time { return m.now }
+
+func (m *mockStore) Get(key string) (string, bool, error) {
+	m.record("Get(%s)", key)
+	return "stored", true, nil
+}
+
+func (m *mockStore) Set(key, val string) error {
+	m.record("Set(%s, %s)", key, val)
+	return nil
+}
+
+func (m *mockStore) Del(keys []string) (int, error) {
+	m.record("Del(%v)", keys)
+	return len(keys), nil
+}
+
+func (m *mockStore) ExpireAt(key string, at int64) (bool, error) {
+	m.record("ExpireAt(%s, %d)", key, at)
+	return true, nil
+}
+
+func (m *mockStore) HSet(key string, pairs []hashField) (int, error) {
+	m.record("HSet(%s, %v)", key, pairs)
+	return len(pairs), nil
+}
+
+func (m *mockStore) Push(key string, end listEnd, vals ...string) (int, error) {
+	m.record("Push(%s, %v, %v)", key, end, vals)
+	return len(vals), nil
+}
+
+func TestStoreCommandDispatchesToStore(t *testing.T) {
+	m := &mockStore{now: time.UnixMilli(1_000_000)}
+	tests := []struct{ cmd, reply, call string }{
+		{"GET k", "stored", "Get(k)"},
+		{"SET k v", "OK", "Set(k, v)"},
+		{"DEL a b", "2", "Del([a b])"},
+		{"PEXPIRE k 500", "1", "ExpireAt(k, 1000500)"},
+		{"HSET h f v", "1", "HSet(h, [{f v}])"},
+		{"RPUSH l x y", "2", fmt.Sprintf("Push(l, %v, [x y])", listRight)},
+	}
+	for _, tt := range tests {
+		m.calls = nil
+		if got := storeCommand(parseCommand(tt.cmd), m); got != tt.reply {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.reply)
+		}
+		if !slices.Equal(m.calls, []string{tt.call}) {
+			t.Errorf("%s called %v, want [%s]", tt.cmd, m.calls, tt.call)
+		}
+	}
+}
+
+func TestStoreCommandIgnoresOtherCommands(t *testing.T) {
+	m := &mockStore{}
+	if got := storeCommand(parseCommand("PUBLISH ch msg"), m); got != "" {
+		t.Errorf("PUBLISH = %q, want it left to the server", got)
+	}
+	if len(m.calls) != 0 {
+		t.Errorf("PUBLISH called %v on the store", m.calls)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID is the ID of a stream entry, written ms-seq: the Unix time in
+// milliseconds the entry was added at, and a sequence number telling apart
+// entries added in the same millisecond.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	if c := cmp.Compare(id.ms, other.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.seq, other.seq)
+}
+
+// next returns the smallest ID after id, reporting false if id is the
+// largest there is.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id == maxStreamID:
+		return id, false
+	case id.seq == math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return streamID{id.ms, id.seq + 1}, true
+}
+
+// streamEntry is one entry of a stream: its ID and its field-value pairs,
+// flattened.
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// stream is a stream value, an append-only log of entries in increasing ID
+// order. lastID is the ID of the last entry added, which every new entry's
+// ID must exceed. groups are its consumer groups by name.
+type stream struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+var (
+	errStreamID        = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDSmall   = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDZero    = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// parseStreamID parses an ID written ms-seq, or ms alone with the sequence
+// number missingSeq.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	seq := missingSeq
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, errStreamID
+		}
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses an XRANGE bound: - and + for the smallest and largest
+// IDs, and an ID without a sequence number covering the whole millisecond.
+func parseRangeID(s string, end bool) (streamID, error) {
+	switch {
+	case s == "-":
+		return streamID{}, nil
+	case s == "+":
+		return maxStreamID, nil
+	case end:
+		return parseStreamID(s, math.MaxUint64)
+	}
+	return parseStreamID(s, 0)
+}
+
+// nextID returns the ID for an entry added at Unix millisecond ms: ms-0,
+// or the one after lastID if the clock has not moved past it.
+func (s *stream) nextID(ms uint64) (streamID, error) {
+	if ms > s.lastID.ms {
+		return streamID{ms, 0}, nil
+	}
+	id, ok := s.lastID.next()
+	if !ok {
+		return streamID{}, errStreamExhausted
+	}
+	return id, nil
+}
+
+func (s *stream) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id, fields})
+	s.lastID = id
+}
+
+// after returns up to count of the entries with IDs greater than id, or all
+// of them if count is negative.
+func (s *stream) after(id streamID, count int) []streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.between(start, maxStreamID, count)
+}
+
+// between returns up to count of the entries with IDs from start to end
+// inclusive, or all of them if count is negative.
+func (s *stream) between(start, end streamID, count int) []streamEntry {
+	i, _ := slices.BinarySearchFunc(s.entries, start, func(e streamEntry, id streamID) int { return e.id.compare(id) })
+	var found []streamEntry
+	for ; i < len(s.entries) && s.entries[i].id.compare(end) <= 0 && count != 0; i++ {
+		found = append(found, s.entries[i])
+		count--
+	}
+	return found
+}
+
+// getStream returns the stream at key, nil if the key does not exist, or
+// errWrongType if it holds another type. The caller must hold the mutex.
+func (r *RedisStore) getStream(key string) (*stream, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	s, ok := sv.value.(*stream)
+	if !ok {
+		return nil, errWrongType
+	}
+	return s, nil
+}
+
+// xaddOptions are XADD's options: noMkStream to not create a missing
+// stream, and trim to trim the stream after adding to it.
+type xaddOptions struct {
+	noMkStream bool
+	trim       *streamTrim
+}
+
+// XAdd appends an entry with the given field-value pairs to the stream at
+// key, creating it if needed, and returns the entry's ID. id is the ID to
+// give it, or nil to generate one from the clock. It reports false, adding
+// nothing, if the stream is missing and opts.noMkStream is set. The entry
+// is persisted with its ID, so that replaying it recreates the same entry.
+func (r *RedisStore) XAdd(key string, id *streamID, fields []string, opts xaddOptions) (streamID, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil {
+		return streamID{}, false, err
+	}
+	created := s == nil
+	if created {
+		if opts.noMkStream {
+			return streamID{}, false, nil
+		}
+		s = &stream{}
+	}
+	var next streamID
+	switch {
+	case id == nil:
+		if next, err = s.nextID(uint64(max(r.clock.Now().UnixMilli(), 0))); err != nil {
+			return streamID{}, false, err
+		}
+	case *id == streamID{}:
+		return streamID{}, false, errStreamIDZero
+	case id.compare(s.lastID) <= 0:
+		return streamID{}, false, errStreamIDSmall
+	default:
+		next = *id
+	}
+	if created {
+		r.data[key] = r.newValue(s)
+	}
+	s.add(next, slices.Clone(fields))
+	args := []string{key}
+	if opts.trim != nil {
+		s.trim(*opts.trim)
+		args = append(args, opts.trim.args()...)
+	}
+	r.touch(key)
+	r.wakeWaiters(key)
+	args = append(append(args, next.String()), fields...)
+	if err := r.writeAOF("XADD", args...); err != nil {
+		return streamID{}, false, err
+	}
+	return next, true, nil
+}
+
+// XLen returns the number of entries in the stream at key.
+func (r *RedisStore) XLen(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, err := r.getStream(key)
+	r.stats.keyspaceRead(s != nil || err != nil)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	return len(s.entries), nil
+}
+
+// XRange returns up to count entries of the stream at key with IDs from
+// start to end inclusive, in ID order, or all of them if count is negative.
+func (r *RedisStore) XRange(key string, start, end streamID, count int) ([]streamEntry, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, err := r.getStream(key)
+	r.stats.keyspaceRead(s != nil || err != nil)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return s.between(start, end, count), nil
+}
+
+// streamReadArgs are the arguments XREAD and XREADGROUP share. count is -1
+// without COUNT and timeout negative without BLOCK.
+type streamReadArgs struct {
+	count     int
+	timeout   time.Duration
+	noack     bool
+	keys, ids []string
+}
+
+// parseStreamReadArgs parses [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...], NOACK being only for XREADGROUP.
+func parseStreamReadArgs(name string, args []string) (streamReadArgs, error) {
+	a := streamReadArgs{count: -1, timeout: -1}
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return a, errNotInteger
+			}
+			// As in Redis, a count of zero or less is no limit.
+			a.count = n
+			if n <= 0 {
+				a.count = -1
+			}
+		case opt == "BLOCK" && i+1 < len(args):
+			i++
+			ms, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return a, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return a, errNegativeTimeout
+			}
+			a.timeout = time.Duration(ms) * time.Millisecond
+		case opt == "NOACK" && name == "XREADGROUP":
+			a.noack = true
+		case opt == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return a, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(name))
+			}
+			a.keys, a.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			return a, nil
+		default:
+			return a, errSyntax
+		}
+	}
+	return a, errSyntax
+}
+
+// streamRead is the entries read from one stream by XREAD or XREADGROUP.
+type streamRead struct {
+	key     string
+	entries []streamEntry
+}
+
+// XRead returns up to count entries after ids[i] from each stream keys[i],
+// an ID of $ standing for the stream's last ID. Only streams with entries
+// to return are included. If there are none it waits, as block does, for
+// one to be added, unless timeout is negative.
+func (r *RedisStore) XRead(ci *clientInfo, keys, ids []string, count int, timeout time.Duration) ([]streamRead, error) {
+	after := make([]streamID, len(ids))
+	r.mutex.RLock()
+	for i, id := range ids {
+		var err error
+		if id != "$" {
+			after[i], err = parseStreamID(id, 0)
+		} else if s, serr := r.getStream(keys[i]); s != nil {
+			after[i] = s.lastID
+		} else {
+			err = serr
+		}
+		if err != nil {
+			r.mutex.RUnlock()
+			return nil, err
+		}
+	}
+	r.mutex.RUnlock()
+
+	var found []streamRead
+	_, err := r.block(ci, keys, timeout, func() (bool, error) {
+		found = nil
+		for i, key := range keys {
+			s, err := r.getStream(key)
+			if err != nil {
+				return false, err
+			}
+			if s == nil {
+				continue
+			}
+			if entries := s.after(after[i], count); len(entries) > 0 {
+				found = append(found, streamRead{key, entries})
+			}
+		}
+		return len(found) > 0, nil
+	})
+	return found, err
+}
+
+// formatStreamReads renders the reply of XREAD and XREADGROUP: nil if
+// nothing was read, and otherwise each stream's key and entries.
+func formatStreamReads(reads []streamRead, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	if len(reads) == 0 {
+		return "nil"
+	}
+	items := make([]string, len(reads))
+	for i, sr := range reads {
+		items[i] = formatArray([]string{sr.key, formatStreamEntries(sr.entries)})
+	}
+	return formatArray(items)
+}
+
+// formatStreamEntries renders entries as XRANGE replies them: each entry its
+// ID followed by its field-value pairs.
+func formatStreamEntries(entries []streamEntry) string {
+	items := make([]string, len(entries))
+	for i, e := range entries {
+		items[i] = formatArray([]string{e.id.String(), formatArray(e.fields)})
+	}
+	return formatArray(items)
+}
+
+// parseXAddOptions parses the options XADD takes before the ID, returning
+// the arguments after them.
+func parseXAddOptions(args []string) (xaddOptions, []string, error) {
+	var opts xaddOptions
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NOMKSTREAM":
+			opts.noMkStream = true
+			args = args[1:]
+		case "MAXLEN", "MINID":
+			t, rest, err := parseStreamTrim(args)
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.trim = &t
+			args = rest
+		default:
+			return opts, args, nil
+		}
+	}
+	return opts, args, nil
+}
+
+func streamCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "XADD":
+		if len(args) < 2 {
+			break
+		}
+		opts, rest, err := parseXAddOptions(args[1:])
+		if err != nil {
+			return formatError(err)
+		}
+		if len(rest) < 3 || len(rest)%2 == 0 {
+			return formatError(errArity(cmd.Name))
+		}
+		var id *streamID
+		if rest[0] != "*" {
+			parsed, err := parseStreamID(rest[0], 0)
+			if err != nil {
+				return formatError(err)
+			}
+			id = &parsed
+		}
+		added, ok, err := rs.XAdd(args[0], id, rest[1:], opts)
+		if err != nil {
+			return formatError(err)
+		}
+		if !ok {
+			return "nil"
+		}
+		return added.String()
+	case "XGROUP":
+		return xgroupCommand(args, rs)
+	case "XLEN":
+		if len(args) == 1 {
+			n, err := rs.XLen(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "XRANGE":
+		if len(args) == 3 || (len(args) == 5 && strings.ToUpper(args[3]) == "COUNT") {
+			start, err := parseRangeID(args[1], false)
+			if err != nil {
+				return formatError(err)
+			}
+			end, err := parseRangeID(args[2], true)
+			if err != nil {
+				return formatError(err)
+			}
+			count := -1
+			if len(args) == 5 {
+				if count, err = strconv.Atoi(args[4]); err != nil {
+					return formatError(errNotInteger)
+				}
+				count = max(count, 0)
+			}
+			entries, err := rs.XRange(args[0], start, end, count)
+			if err != nil {
+				return formatError(err)
+			}
+			return formatStreamEntries(entries)
+		}
+		if len(args) > 3 {
+			return formatError(errSyntax)
+		}
+	}
+	return ""
+}
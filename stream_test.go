@@ -0,0 +1,354 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXAddAutoIDsIncrease(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	ms := "1704067200000"
+
+	var ids []string
+	for range 3 {
+		ids = append(ids, run(rs, "XADD s * n 1"))
+	}
+	clk.Advance(5 * time.Millisecond)
+	ids = append(ids, run(rs, "XADD s * n 2"))
+	want := []string{ms + "-0", ms + "-1", ms + "-2", "1704067200005-0"}
+	if strings.Join(ids, " ") != strings.Join(want, " ") {
+		t.Errorf("auto IDs = %v, want %v", ids, want)
+	}
+
+	// An explicit ID ahead of the clock holds the auto IDs back to it.
+	if got := run(rs, "XADD s 1704067300000-7 n 3"); got != "1704067300000-7" {
+		t.Errorf("XADD with an explicit ID = %q", got)
+	}
+	if got := run(rs, "XADD s * n 4"); got != "1704067300000-8" {
+		t.Errorf("XADD after an ID ahead of the clock = %q, want 1704067300000-8", got)
+	}
+	if got := run(rs, "XLEN s"); got != "6" {
+		t.Errorf("XLEN = %q, want 6", got)
+	}
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"XADD s 1704067300000-8 n 5", "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{"XADD s 5-0 n 5", "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{"XADD other 0-0 n 5", "-ERR The ID specified in XADD must be greater than 0-0"},
+		{"XADD s 1-x n 5", "-ERR Invalid stream ID specified as stream command argument"},
+		{"XADD s * n", "-ERR wrong number of arguments for 'xadd' command"},
+		{"XADD s * n 5 m", "-ERR wrong number of arguments for 'xadd' command"},
+	} {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	if got := run(rs, "XLEN other"); got != "0" {
+		t.Errorf("XLEN of a stream whose XADD failed = %q, want 0", got)
+	}
+	run(rs, "SET str v")
+	if got := run(rs, "XADD str * n 1"); got != "-"+errWrongType.Error() {
+		t.Errorf("XADD on a string = %q", got)
+	}
+}
+
+func TestXRange(t *testing.T) {
+	rs := newTestStore(t)
+	for _, cmd := range []string{
+		"XADD s 1-0 a 1",
+		"XADD s 1-1 b 2",
+		"XADD s 2-0 c 3 d 4",
+		"XADD s 3-0 e 5",
+	} {
+		run(rs, cmd)
+	}
+	tests := []struct{ cmd, want string }{
+		{"XRANGE s 1-1 2", "1) 1) 1-1\n   2) 1) b\n      2) 2\n2) 1) 2-0\n   2) 1) c\n      2) 3\n      3) d\n      4) 4"},
+		{"XRANGE s - + COUNT 2", "1) 1) 1-0\n   2) 1) a\n      2) 1\n2) 1) 1-1\n   2) 1) b\n      2) 2"},
+		{"XRANGE s 3 +", "1) 1) 3-0\n   2) 1) e\n      2) 5"},
+		{"XRANGE s - + COUNT 0", "(empty array)"},
+		{"XRANGE s 4 +", "(empty array)"},
+		{"XRANGE missing - +", "(empty array)"},
+		{"XRANGE s - + LIMIT 2", "-ERR syntax error"},
+		{"XRANGE s x +", "-ERR Invalid stream ID specified as stream command argument"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	if got := run(rs, "OBJECT ENCODING s"); got != "stream" {
+		t.Errorf("OBJECT ENCODING = %q, want stream", got)
+	}
+}
+
+func TestStreamPersists(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "XADD s * a 1")
+	run(rs, "XADD s * b 2")
+	want := run(rs, "XRANGE s - +")
+
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "XRANGE s - +"); got != want {
+		t.Errorf("XRANGE after replaying the AOF = %q, want %q", got, want)
+	}
+	if err := reloaded.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(reloaded, "SAVE"); got != "OK" {
+		t.Fatalf("SAVE = %q", got)
+	}
+	rewritten := reopen(t, reloaded)
+	if got := run(rewritten, "XRANGE s - +"); got != want {
+		t.Errorf("XRANGE after a rewrite and snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestXRead(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "XADD a 1-0 f 1")
+	run(rs, "XADD a 2-0 f 2")
+	run(rs, "XADD b 1-0 g 1")
+	tests := []struct{ cmd, want string }{
+		{"XREAD STREAMS a b 1-0 0", "1) 1) a\n   2) 1) 1) 2-0\n         2) 1) f\n            2) 2\n2) 1) b\n   2) 1) 1) 1-0\n         2) 1) g\n            2) 1"},
+		{"XREAD COUNT 1 STREAMS a 0", "1) 1) a\n   2) 1) 1) 1-0\n         2) 1) f\n            2) 1"},
+		{"XREAD STREAMS a b $ $", "nil"},
+		{"XREAD STREAMS a missing 2-0 0", "nil"},
+		{"XREAD STREAMS a b 0", "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."},
+		{"XREAD BLOCK -1 STREAMS a 0", "-ERR timeout is negative"},
+		{"XREAD STREAMS a >", "-ERR Invalid stream ID specified as stream command argument"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestXReadBlockWakesOnXAdd(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "XADD s 1-0 old 1")
+
+	reply := make(chan string)
+	go func() { reply <- run(rs, "XREAD BLOCK 5000 STREAMS other s 0 $") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "s") == 1 && clk.pendingTimers() == 1 })
+
+	run(rs, "XADD s 2-0 new 2")
+	select {
+	case got := <-reply:
+		if want := "1) 1) s\n   2) 1) 1) 2-0\n         2) 1) new\n            2) 2"; got != want {
+			t.Errorf("XREAD returned %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XREAD did not wake on XADD")
+	}
+	if n := blockedOn(rs, "s") + blockedOn(rs, "other"); n != 0 {
+		t.Errorf("%d waiters left registered", n)
+	}
+
+	// The timer is set before the waiter is registered.
+	go func() { reply <- run(rs, "XREAD BLOCK 100 STREAMS s $") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "s") == 1 })
+	clk.Advance(100 * time.Millisecond)
+	select {
+	case got := <-reply:
+		if got != "nil" {
+			t.Errorf("XREAD after its timeout = %q, want nil", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XREAD did not time out")
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	rs := newTestStore(t)
+	clk := newFakeClock()
+	rs.clock = clk
+	run(rs, "XADD s 1-0 a 1")
+	if got := run(rs, "XGROUP CREATE s g $"); got != "OK" {
+		t.Fatalf("XGROUP CREATE = %q", got)
+	}
+	if got := run(rs, "XGROUP CREATE s g 0"); got != "-BUSYGROUP Consumer Group name already exists" {
+		t.Errorf("XGROUP CREATE of an existing group = %q", got)
+	}
+	if got := run(rs, "XREADGROUP GROUP g alice STREAMS s >"); got != "nil" {
+		t.Errorf("XREADGROUP before new entries = %q, want nil", got)
+	}
+
+	run(rs, "XADD s 2-0 b 2")
+	run(rs, "XADD s 3-0 c 3")
+	if got, want := run(rs, "XREADGROUP GROUP g alice COUNT 1 STREAMS s >"), "1) 1) s\n   2) 1) 1) 2-0\n         2) 1) b\n            2) 2"; got != want {
+		t.Errorf("first XREADGROUP = %q, want %q", got, want)
+	}
+	// The group, not the consumer, tracks what was delivered.
+	if got, want := run(rs, "XREADGROUP GROUP g bob STREAMS s >"), "1) 1) s\n   2) 1) 1) 3-0\n         2) 1) c\n            2) 3"; got != want {
+		t.Errorf("second XREADGROUP = %q, want %q", got, want)
+	}
+
+	reply := make(chan string)
+	go func() { reply <- run(rs, "XREADGROUP GROUP g alice BLOCK 0 STREAMS s >") }()
+	waitUntil(t, func() bool { return blockedOn(rs, "s") == 1 })
+	run(rs, "XADD s 4-0 d 4")
+	select {
+	case got := <-reply:
+		if want := "1) 1) s\n   2) 1) 1) 4-0\n         2) 1) d\n            2) 4"; got != want {
+			t.Errorf("blocked XREADGROUP returned %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XREADGROUP did not wake on XADD")
+	}
+
+	// The last delivered ID survives a restart.
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "XREADGROUP GROUP g alice STREAMS s >"); got != "nil" {
+		t.Errorf("XREADGROUP after a reload = %q, want nil", got)
+	}
+	if got := run(reloaded, "XGROUP SETID s g 2-0"); got != "OK" {
+		t.Errorf("XGROUP SETID = %q", got)
+	}
+	if got := run(reloaded, "XREADGROUP GROUP g alice COUNT 1 STREAMS s >"); !strings.Contains(got, "3-0") {
+		t.Errorf("XREADGROUP after SETID = %q, want entry 3-0", got)
+	}
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"XREADGROUP GROUP nope alice STREAMS s >", "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option"},
+		{"XREADGROUP GROUP g alice STREAMS s 0", "-ERR XREADGROUP only supports the > ID, as pending entries are not tracked"},
+		{"XGROUP CREATE missing g $", "-" + errNoStreamKey.Error()},
+		{"XGROUP CREATE missing g $ MKSTREAM", "OK"},
+		{"XLEN missing", "0"},
+	} {
+		if got := run(reloaded, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestXTrimAndXDel(t *testing.T) {
+	rs := newTestStore(t)
+	for i := 1; i <= 5; i++ {
+		run(rs, "XADD s "+strconv.Itoa(i)+"-0 n "+strconv.Itoa(i))
+	}
+	if got := run(rs, "XTRIM s MAXLEN 3"); got != "2" {
+		t.Errorf("XTRIM MAXLEN 3 = %q, want 2", got)
+	}
+	// The oldest entries go.
+	if got, want := run(rs, "XRANGE s - + COUNT 1"), "1) 1) 3-0\n   2) 1) n\n      2) 3"; got != want {
+		t.Errorf("first entry after XTRIM = %q, want %q", got, want)
+	}
+	if got := run(rs, "XTRIM s MAXLEN ~ 3"); got != "0" {
+		t.Errorf("XTRIM of a short enough stream = %q, want 0", got)
+	}
+
+	if got := run(rs, "XDEL s 4-0 4-0 9-0"); got != "1" {
+		t.Errorf("XDEL of a middle entry = %q, want 1", got)
+	}
+	if got, want := run(rs, "XRANGE s - +"), "1) 1) 3-0\n   2) 1) n\n      2) 3\n2) 1) 5-0\n   2) 1) n\n      2) 5"; got != want {
+		t.Errorf("XRANGE after XDEL = %q, want %q", got, want)
+	}
+	run(rs, "XDEL s 5-0")
+	// Deleting the last entry does not let its ID be reused.
+	if got := run(rs, "XADD s 5-0 n 6"); got != "-"+errStreamIDSmall.Error() {
+		t.Errorf("XADD of a deleted entry's ID = %q", got)
+	}
+
+	run(rs, "XADD m 1-0 a 1")
+	run(rs, "XADD m 2-0 b 2")
+	run(rs, "XADD m MINID 2 3-0 c 3")
+	if got := run(rs, "XLEN m"); got != "2" {
+		t.Errorf("XLEN after XADD with MINID = %q, want 2", got)
+	}
+	run(rs, "XTRIM m MAXLEN 0")
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"XADD missing NOMKSTREAM * a 1", "nil"},
+		{"XLEN missing", "0"},
+		{"XTRIM s MAXLEN -1", "-ERR The MAXLEN argument must be >= 0."},
+		{"XTRIM s MAXLEN 1 LIMIT 10", "-ERR syntax error, LIMIT cannot be used without the special ~ option"},
+		{"XTRIM s LEN 1", "-ERR syntax error"},
+		{"XDEL missing 1-0", "0"},
+		{"XSETID s 1-0", "-ERR The ID specified in XSETID is smaller than the target stream top item"},
+	} {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	want := run(rs, "XRANGE s - +")
+	reloaded := reopen(t, rs)
+	if got := run(reloaded, "XRANGE s - +"); got != want {
+		t.Errorf("XRANGE after replaying the AOF = %q, want %q", got, want)
+	}
+	if err := reloaded.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	rewritten := reopen(t, reloaded)
+	for _, cmd := range []string{"XADD s 5-0 n 6", "XADD m 3-0 n 6"} {
+		if got := run(rewritten, cmd); got != "-"+errStreamIDSmall.Error() {
+			t.Errorf("%s after a rewrite = %q", cmd, got)
+		}
+	}
+	if got := run(rewritten, "XLEN m"); got != "0" {
+		t.Errorf("XLEN of an emptied stream after a rewrite = %q, want 0", got)
+	}
+	// The snapshot keeps the last ID too.
+	run(rewritten, "SAVE")
+	if err := rewritten.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(reopen(t, rewritten), "XADD s 5-0 n 6"); got != "-"+errStreamIDSmall.Error() {
+		t.Errorf("XADD below the last ID after a snapshot = %q", got)
+	}
+}
+
+func TestStreamIntrospection(t *testing.T) {
+	rs := newTestStore(t)
+	payload := 0
+	for i := 1; i <= 5; i++ {
+		field, value := "field"+strconv.Itoa(i), strings.Repeat("v", 20)
+		run(rs, "XADD s "+strconv.Itoa(i)+"-0 "+field+" "+value)
+		payload += len(field) + len(value)
+	}
+	if got := run(rs, "TYPE s"); got != "stream" {
+		t.Errorf("TYPE = %q, want stream", got)
+	}
+	if got := run(rs, "OBJECT ENCODING s"); got != "stream" {
+		t.Errorf("OBJECT ENCODING = %q, want stream", got)
+	}
+	if got := run(rs, "DEBUG OBJECT s"); !strings.HasSuffix(got, " entries:5") {
+		t.Errorf("DEBUG OBJECT = %q, want the entry count", got)
+	}
+
+	usage, err := strconv.Atoi(run(rs, "MEMORY USAGE s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fields and values, plus some overhead for each entry but not
+	// an unreasonable amount.
+	if usage <= payload || usage > 4*payload {
+		t.Errorf("MEMORY USAGE = %d for %d bytes of fields and values", usage, payload)
+	}
+	run(rs, "XADD s 6-0 f v")
+	if grown, _ := strconv.Atoi(run(rs, "MEMORY USAGE s SAMPLES 5")); grown <= usage {
+		t.Errorf("MEMORY USAGE after XADD = %d, want more than %d", grown, usage)
+	}
+
+	run(rs, "SET str v")
+	run(rs, "RPUSH l a")
+	for _, tt := range []struct{ cmd, want string }{
+		{"TYPE str", "string"},
+		{"TYPE l", "list"},
+		{"TYPE missing", "none"},
+		{"MEMORY USAGE missing", "nil"},
+		{"MEMORY USAGE s SAMPLES x", "-ERR value is not an integer or out of range"},
+	} {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// streamGroup is a consumer group of a stream. lastDelivered is the ID of
+// the last entry delivered to any of its consumers, after which XREADGROUP
+// with the > ID reads. Pending entries are not tracked, so every entry is
+// delivered once and there is nothing to acknowledge or claim.
+type streamGroup struct {
+	lastDelivered streamID
+}
+
+var (
+	errBusyGroup       = errors.New("BUSYGROUP Consumer Group name already exists")
+	errNoStreamKey     = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	errGroupPendingIDs = errors.New("ERR XREADGROUP only supports the > ID, as pending entries are not tracked")
+)
+
+// XGroupCreate creates the consumer group group of the stream at key,
+// starting after id, or after the stream's last ID if id is nil. With
+// mkstream a missing stream is created empty.
+func (r *RedisStore) XGroupCreate(key, group string, id *streamID, mkstream bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		if !mkstream {
+			return errNoStreamKey
+		}
+		s = &stream{}
+		r.data[key] = r.newValue(s)
+	}
+	if s.groups[group] != nil {
+		return errBusyGroup
+	}
+	start := s.lastID
+	if id != nil {
+		start = *id
+	}
+	if s.groups == nil {
+		s.groups = make(map[string]*streamGroup)
+	}
+	s.groups[group] = &streamGroup{lastDelivered: start}
+	r.touch(key)
+	return r.writeAOF("XGROUP", "CREATE", key, group, start.String(), "MKSTREAM")
+}
+
+// XGroupSetID sets the last delivered ID of the consumer group group of the
+// stream at key, or its last ID if id is nil.
+func (r *RedisStore) XGroupSetID(key, group string, id *streamID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errNoStreamKey
+	}
+	g := s.groups[group]
+	if g == nil {
+		return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+	}
+	g.lastDelivered = s.lastID
+	if id != nil {
+		g.lastDelivered = *id
+	}
+	r.touch(key)
+	return r.writeAOF("XGROUP", "SETID", key, group, g.lastDelivered.String())
+}
+
+// XReadGroup reads, for consumer of group, up to count entries from each of
+// the streams keys that were not yet delivered to the group, moving its
+// last delivered ID past them. Every ID in ids must be >. Like XRead, it
+// waits for new entries unless timeout is negative. The new last delivered
+// IDs are persisted as XGROUP SETID.
+func (r *RedisStore) XReadGroup(ci *clientInfo, group, consumer string, keys, ids []string, count int, timeout time.Duration) ([]streamRead, error) {
+	for _, id := range ids {
+		if id != ">" {
+			return nil, errGroupPendingIDs
+		}
+	}
+	var found []streamRead
+	_, err := r.block(ci, keys, timeout, func() (bool, error) {
+		found = nil
+		for _, key := range keys {
+			s, err := r.getStream(key)
+			if err != nil {
+				return false, err
+			}
+			if s == nil || s.groups[group] == nil {
+				return false, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, group)
+			}
+			g := s.groups[group]
+			entries := s.after(g.lastDelivered, count)
+			if len(entries) == 0 {
+				continue
+			}
+			g.lastDelivered = entries[len(entries)-1].id
+			found = append(found, streamRead{key, entries})
+			r.touch(key)
+			if err := r.writeAOF("XGROUP", "SETID", key, group, g.lastDelivered.String()); err != nil {
+				return false, err
+			}
+		}
+		return len(found) > 0, nil
+	})
+	return found, err
+}
+
+// parseGroupStartID parses the ID XGROUP CREATE and SETID take, returning
+// nil for $, the stream's last ID.
+func parseGroupStartID(s string) (*streamID, error) {
+	if s == "$" {
+		return nil, nil
+	}
+	id, err := parseStreamID(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func xgroupCommand(args []string, rs Store) string {
+	switch strings.ToUpper(args[0]) {
+	case "CREATE":
+		if len(args) == 4 || (len(args) == 5 && strings.ToUpper(args[4]) == "MKSTREAM") {
+			id, err := parseGroupStartID(args[3])
+			if err == nil {
+				err = rs.XGroupCreate(args[1], args[2], id, len(args) == 5)
+			}
+			if err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+		if len(args) > 4 {
+			return formatError(errSyntax)
+		}
+	case "SETID":
+		if len(args) == 4 {
+			id, err := parseGroupStartID(args[3])
+			if err == nil {
+				err = rs.XGroupSetID(args[1], args[2], id)
+			}
+			if err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	default:
+		return formatError(errUnknownSubcommand(args[0]))
+	}
+	return ""
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// streamTrim is a trimming strategy of XTRIM and XADD: MAXLEN keeps at
+// most maxLen entries and MINID drops the entries with IDs below minID.
+// The approximate form, ~, is accepted but trims exactly, which it is
+// allowed to.
+type streamTrim struct {
+	minIDStrategy bool
+	maxLen        int64
+	minID         streamID
+}
+
+var (
+	errMaxLen       = errors.New("ERR The MAXLEN argument must be >= 0.")
+	errSetIDTooLow  = errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+	errTrimLimitUse = errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+)
+
+// parseStreamTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] at the
+// start of args, returning the arguments after it. The LIMIT on how much
+// an approximate trim may remove has no effect, as trims are exact.
+func parseStreamTrim(args []string) (streamTrim, []string, error) {
+	var t streamTrim
+	switch strings.ToUpper(args[0]) {
+	case "MAXLEN":
+	case "MINID":
+		t.minIDStrategy = true
+	default:
+		return t, nil, errSyntax
+	}
+	args = args[1:]
+	approx := false
+	if len(args) > 0 && (args[0] == "=" || args[0] == "~") {
+		approx = args[0] == "~"
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return t, nil, errSyntax
+	}
+	if t.minIDStrategy {
+		id, err := parseStreamID(args[0], 0)
+		if err != nil {
+			return t, nil, err
+		}
+		t.minID = id
+	} else {
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return t, nil, errNotInteger
+		}
+		if n < 0 {
+			return t, nil, errMaxLen
+		}
+		t.maxLen = n
+	}
+	args = args[1:]
+	if len(args) >= 2 && strings.ToUpper(args[0]) == "LIMIT" {
+		if !approx {
+			return t, nil, errTrimLimitUse
+		}
+		if n, err := strconv.ParseInt(args[1], 10, 64); err != nil || n < 0 {
+			return t, nil, errNotInteger
+		}
+		args = args[2:]
+	}
+	return t, args, nil
+}
+
+// args renders t as the exact trim it performs, to be persisted.
+func (t streamTrim) args() []string {
+	if t.minIDStrategy {
+		return []string{"MINID", "=", t.minID.String()}
+	}
+	return []string{"MAXLEN", "=", strconv.FormatInt(t.maxLen, 10)}
+}
+
+// trim removes the oldest entries t selects, returning how many there were.
+func (s *stream) trim(t streamTrim) int {
+	n := 0
+	if t.minIDStrategy {
+		n, _ = slices.BinarySearchFunc(s.entries, t.minID, func(e streamEntry, id streamID) int { return e.id.compare(id) })
+	} else if int64(len(s.entries)) > t.maxLen {
+		n = len(s.entries) - int(t.maxLen)
+	}
+	s.entries = slices.Delete(s.entries, 0, n)
+	return n
+}
+
+// XDel removes the entries with the given IDs from the stream at key,
+// returning how many existed. The stream's last ID is unchanged, so the
+// IDs of deleted entries are not reused.
+func (r *RedisStore) XDel(key string, ids []streamID) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	removed := 0
+	args := []string{key}
+	for _, id := range ids {
+		i, found := slices.BinarySearchFunc(s.entries, id, func(e streamEntry, id streamID) int { return e.id.compare(id) })
+		if found {
+			s.entries = slices.Delete(s.entries, i, i+1)
+			removed++
+			args = append(args, id.String())
+		}
+	}
+	if removed > 0 {
+		r.touch(key)
+		if err := r.writeAOF("XDEL", args...); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+// XTrim trims the stream at key as t says, returning how many entries it
+// removed.
+func (r *RedisStore) XTrim(key string, t streamTrim) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	n := s.trim(t)
+	if n > 0 {
+		r.touch(key)
+		if err := r.writeAOF("XTRIM", append([]string{key}, t.args()...)...); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// XSetID sets the last ID of the stream at key, which may not be below its
+// last entry's. AOF rewrites use it to keep a last ID whose entry was
+// deleted.
+func (r *RedisStore) XSetID(key string, id streamID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s, err := r.getStream(key)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errNoSuchKey
+	}
+	if n := len(s.entries); n > 0 && id.compare(s.entries[n-1].id) < 0 {
+		return errSetIDTooLow
+	}
+	s.lastID = id
+	r.touch(key)
+	return r.writeAOF("XSETID", key, id.String())
+}
+
+func streamTrimCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "XDEL":
+		if len(args) >= 2 {
+			ids := make([]streamID, len(args)-1)
+			for i, s := range args[1:] {
+				id, err := parseStreamID(s, 0)
+				if err != nil {
+					return formatError(err)
+				}
+				ids[i] = id
+			}
+			n, err := rs.XDel(args[0], ids)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "XTRIM":
+		if len(args) >= 3 {
+			t, rest, err := parseStreamTrim(args[1:])
+			if err == nil && len(rest) > 0 {
+				err = errSyntax
+			}
+			if err != nil {
+				return formatError(err)
+			}
+			n, err := rs.XTrim(args[0], t)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "XSETID":
+		if len(args) == 2 {
+			id, err := parseStreamID(args[1], 0)
+			if err == nil {
+				err = rs.XSetID(args[0], id)
+			}
+			if err != nil {
+				return formatError(err)
+			}
+			return "OK"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strings are byte strings, as in Redis: every offset and length below
+// counts bytes, never runes, so ranges may split a multibyte UTF-8
+// character.
+//
+// A string is stored as a Go string until APPEND or SETRANGE modifies it,
+// which switches it to a []byte grown in place. The slice keeps spare
+// capacity, so repeated appends cost amortized O(1) each rather than
+// copying the whole value every time.
+
+// maxStringSize is the largest string SETRANGE may create, Redis's
+// proto-max-bulk-len default.
+const maxStringSize = 512 << 20
+
+// emptyReply is how an empty string reply is displayed, as redis-cli does.
+// A bare empty reply would mean the arguments matched none of the command's
+// forms.
+const emptyReply = `""`
+
+// bulkReply renders a string value, which may be empty.
+func bulkReply(val string) string {
+	if val == "" {
+		return emptyReply
+	}
+	return val
+}
+
+var (
+	errOffsetRange    = errors.New("ERR offset is out of range")
+	errStringTooLarge = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	errBitOffset      = errors.New("ERR bit offset is not an integer or out of range")
+)
+
+// stringValue returns v as a Go string if it holds a string value.
+func stringValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// getString returns the string at key and whether it exists, or
+// errWrongType. The caller must hold the mutex.
+func (r *RedisStore) getString(key string) (string, bool, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return "", false, nil
+	}
+	s, ok := stringValue(sv.value)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return s, true, nil
+}
+
+// GetDel returns the string at key and deletes the key.
+func (r *RedisStore) GetDel(key string) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	val, exists, err := r.getString(key)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	delete(r.data, key)
+	r.touch(key)
+	return val, true, r.writeAOF("DEL", key)
+}
+
+// getExExpiry is what GETEX does to a key's TTL: set it to at, in Unix
+// milliseconds, remove it with persist, or with neither leave it alone.
+type getExExpiry struct {
+	at      int64
+	persist bool
+}
+
+// GetEx returns the string at key, changing its TTL as e says. An expiry
+// already past deletes the key once it is read.
+func (r *RedisStore) GetEx(key string, e getExExpiry) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	val, exists, err := r.getString(key)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	sv := r.data[key]
+	switch {
+	case e.at != 0 && e.at <= r.clock.Now().UnixMilli():
+		delete(r.data, key)
+		r.touch(key)
+		err = r.writeAOF("DEL", key)
+	case e.at != 0:
+		sv.expireAt = e.at
+		r.touch(key)
+		err = r.writeAOF("PEXPIREAT", key, strconv.FormatInt(e.at, 10))
+	case e.persist && sv.expireAt != 0:
+		sv.expireAt = 0
+		r.touch(key)
+		err = r.writeAOF("PERSIST", key)
+	}
+	return val, true, err
+}
+
+// parseGetExExpiry parses the options of GETEX, at most one of EX seconds,
+// PX milliseconds, EXAT timestamp, PXAT timestamp-milliseconds and PERSIST.
+func parseGetExExpiry(args []string, now time.Time) (getExExpiry, error) {
+	var e getExExpiry
+	switch {
+	case len(args) == 0:
+		return e, nil
+	case len(args) == 1 && strings.EqualFold(args[0], "PERSIST"):
+		e.persist = true
+		return e, nil
+	case len(args) != 2:
+		return e, errSyntax
+	}
+	seconds, relative, ok := expiryUnits(args[0])
+	if !ok {
+		return e, errSyntax
+	}
+	at, err := parseExpiry("GETEX", args[1], seconds, relative, now)
+	e.at = at
+	return e, err
+}
+
+// expiryUnits returns how the EX, PX, EXAT or PXAT option of SET and GETEX
+// measures its argument, or false for any other option.
+func expiryUnits(opt string) (seconds, relative, ok bool) {
+	switch strings.ToUpper(opt) {
+	case "EX":
+		return true, true, true
+	case "PX":
+		return false, true, true
+	case "EXAT":
+		return true, false, true
+	case "PXAT":
+		return false, false, true
+	}
+	return false, false, false
+}
+
+// parseExpiry parses the argument of an expiry option of command to the
+// absolute milliseconds it is stored as. It must be positive.
+func parseExpiry(command, arg string, seconds, relative bool, now time.Time) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	at, ok := expiryMillis(n, seconds, relative, now)
+	if n <= 0 || !ok {
+		return 0, errInvalidExpire(command)
+	}
+	return at, nil
+}
+
+// setOptions are the options of SET: the expiry to give the key, in Unix
+// milliseconds, or keepTTL to leave its TTL alone; nx or xx to set it only
+// if it is missing or present; and get to return the value it replaced.
+type setOptions struct {
+	at      int64
+	keepTTL bool
+	nx, xx  bool
+	get     bool
+}
+
+// parseSetOptions parses the options of SET after the key and value.
+func parseSetOptions(args []string, now time.Time) (setOptions, error) {
+	var o setOptions
+	expiry := false
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		seconds, relative, isExpiry := expiryUnits(opt)
+		switch {
+		case isExpiry && !expiry && i+1 < len(args):
+			at, err := parseExpiry("SET", args[i+1], seconds, relative, now)
+			if err != nil {
+				return o, err
+			}
+			o.at, expiry = at, true
+			i++
+		case opt == "KEEPTTL" && !expiry:
+			o.keepTTL, expiry = true, true
+		case opt == "NX" && !o.xx:
+			o.nx = true
+		case opt == "XX" && !o.nx:
+			o.xx = true
+		case opt == "GET":
+			o.get = true
+		default:
+			return o, errSyntax
+		}
+	}
+	return o, nil
+}
+
+// SetWithOptions sets key to val as SET does with the options o. It reports
+// whether it set the key, and with get, the string it replaced; a key of
+// another type is then left alone. An expiry already past deletes the key.
+func (r *RedisStore) SetWithOptions(key, val string, o setOptions) (old string, existed, set bool, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sv := r.lookup(key)
+	if o.get && sv != nil {
+		s, ok := stringValue(sv.value)
+		if !ok {
+			return "", false, false, errWrongType
+		}
+		old, existed = s, true
+	}
+	if (o.nx && sv != nil) || (o.xx && sv == nil) {
+		return old, existed, false, nil
+	}
+	if o.at != 0 && o.at <= r.clock.Now().UnixMilli() {
+		if sv != nil {
+			delete(r.data, key)
+			r.touch(key)
+			err = r.writeAOF("DEL", key)
+		}
+		return old, existed, true, err
+	}
+	nsv := r.newValue(sharedInteger(val))
+	nsv.encoding = stringEncoding(val, r.config.EmbstrSizeLimit)
+	switch {
+	case o.at != 0:
+		nsv.expireAt = o.at
+	case o.keepTTL && sv != nil:
+		nsv.expireAt = sv.expireAt
+	}
+	r.data[key] = nsv
+	r.touch(key)
+	if err = r.writeAOF("SET", key, val); err == nil && nsv.expireAt != 0 {
+		err = r.writeAOF("PEXPIREAT", key, strconv.FormatInt(nsv.expireAt, 10))
+	}
+	return old, existed, true, err
+}
+
+// putString stores val at key, keeping the key's TTL. The caller must hold
+// the mutex.
+func (r *RedisStore) putString(key, val string) {
+	sv := r.lookup(key)
+	if sv == nil {
+		sv = r.newValue(val)
+		r.data[key] = sv
+	}
+	sv.value, sv.encoding = val, stringEncoding(val, r.config.EmbstrSizeLimit)
+}
+
+// stringBytes returns the string at key for APPEND and SETRANGE to modify
+// in place, nil if the key does not exist, or errWrongType. The caller must
+// hold the mutex and store the result with putBytes.
+func (r *RedisStore) stringBytes(key string) ([]byte, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	switch v := sv.value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errWrongType
+}
+
+// putBytes stores b at key, keeping the key's TTL. Like Redis, a string
+// modified in place always has the raw encoding. The caller must hold the
+// mutex.
+func (r *RedisStore) putBytes(key string, b []byte) {
+	sv := r.lookup(key)
+	if sv == nil {
+		sv = r.newValue(b)
+		r.data[key] = sv
+	}
+	sv.value, sv.encoding = b, "raw"
+}
+
+// StrLen returns the length in bytes of the string at key.
+func (r *RedisStore) StrLen(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, ok, err := r.getString(key)
+	r.stats.keyspaceRead(ok || err != nil)
+	return len(s), err
+}
+
+// GetRange returns the bytes of the string at key from start to end
+// inclusive. Negative offsets count back from the end.
+func (r *RedisStore) GetRange(key string, start, end int64) (string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, ok, err := r.getString(key)
+	r.stats.keyspaceRead(ok || err != nil)
+	if err != nil {
+		return "", err
+	}
+	n := int64(len(s))
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end {
+		return "", nil
+	}
+	return s[start : end+1], nil
+}
+
+// SetRange overwrites the string at key with val from byte offset onwards,
+// padding with zero bytes if the string is shorter, and returns the new
+// length. An empty val leaves the key untouched, not even creating it.
+func (r *RedisStore) SetRange(key string, offset int64, val string) (int, error) {
+	if offset < 0 {
+		return 0, errOffsetRange
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, err := r.stringBytes(key)
+	if err != nil {
+		return 0, err
+	}
+	if val == "" {
+		return len(b), nil
+	}
+	if offset+int64(len(val)) > maxStringSize {
+		return 0, errStringTooLarge
+	}
+	if end := int(offset) + len(val); end > len(b) {
+		b = append(b, make([]byte, end-len(b))...)
+	}
+	copy(b[offset:], val)
+	r.putBytes(key, b)
+	r.touch(key)
+	if err := r.writeAOF("SETRANGE", key, strconv.FormatInt(offset, 10), val); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Append adds val to the end of the string at key, creating it if needed,
+// and returns the new length.
+func (r *RedisStore) Append(key, val string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, err := r.stringBytes(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b)+len(val) > maxStringSize {
+		return 0, errStringTooLarge
+	}
+	b = append(b, val...)
+	r.putBytes(key, b)
+	r.touch(key)
+	if err := r.writeAOF("APPEND", key, val); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// GetBit returns the bit at offset in the string at key, counting from the
+// most significant bit of the first byte. Bits past the end are 0.
+func (r *RedisStore) GetBit(key string, offset int64) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, ok, err := r.getString(key)
+	r.stats.keyspaceRead(ok || err != nil)
+	if err != nil {
+		return 0, err
+	}
+	i := offset / 8
+	if i >= int64(len(s)) {
+		return 0, nil
+	}
+	return int(s[i]>>(7-offset%8)) & 1, nil
+}
+
+// parseBitOffset parses a bit offset, which Redis limits to 2^32-1.
+func parseBitOffset(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n >= 1<<32 {
+		return 0, errBitOffset
+	}
+	return n, nil
+}
+
+// getReply renders the result of a GET-like command: the value, or nil if
+// the key did not exist.
+func getReply(val string, exists bool, err error) string {
+	if err != nil {
+		return formatError(err)
+	}
+	if !exists {
+		return "nil"
+	}
+	return bulkReply(val)
+}
+
+func stringCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "GETDEL":
+		if len(args) == 1 {
+			return getReply(rs.GetDel(args[0]))
+		}
+	case "GETEX":
+		if len(args) >= 1 {
+			e, err := parseGetExExpiry(args[1:], rs.Now())
+			if err != nil {
+				return formatError(err)
+			}
+			return getReply(rs.GetEx(args[0], e))
+		}
+	case "STRLEN":
+		if len(args) == 1 {
+			n, err := rs.StrLen(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "GETRANGE":
+		if len(args) == 3 {
+			start, err1 := strconv.ParseInt(args[1], 10, 64)
+			end, err2 := strconv.ParseInt(args[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return formatError(errNotInteger)
+			}
+			s, err := rs.GetRange(args[0], start, end)
+			if err != nil {
+				return formatError(err)
+			}
+			return bulkReply(s)
+		}
+	case "SETRANGE":
+		if len(args) == 3 {
+			offset, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return formatError(errNotInteger)
+			}
+			n, err := rs.SetRange(args[0], offset, args[2])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "APPEND":
+		if len(args) == 2 {
+			n, err := rs.Append(args[0], args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "GETBIT":
+		if len(args) == 2 {
+			offset, err := parseBitOffset(args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			bit, err := rs.GetBit(args[0], offset)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(bit)
+		}
+	case "BITFIELD":
+		if len(args) >= 1 {
+			return bitfieldCommand(args, rs)
+		}
+	}
+	return ""
+}
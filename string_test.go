@@ -0,0 +1,237 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The multibyte values below are "héllo" (h, é as 2 bytes, llo) and three
+// 4-byte emoji.
+func TestStringCommandsUseBytes(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET word héllo")
+	run(rs, "SET emoji 😀😃😄")
+	tests := []struct{ cmd, want string }{
+		{"STRLEN word", "6"},
+		{"STRLEN emoji", "12"},
+		{"STRLEN missing", "0"},
+		{"GETRANGE word 0 1", "h\xc3"},
+		{"GETRANGE word 1 2", "é"},
+		{"GETRANGE word -3 -1", "llo"},
+		{"GETRANGE emoji 4 7", "😃"},
+		{"GETRANGE emoji 0 1", "\xf0\x9f"},
+		{"GETRANGE emoji 10 100", "\x98\x84"},
+		{"GETRANGE emoji 5 2", `""`},
+		{"GETRANGE missing 0 -1", `""`},
+		// é is 0xc3 0xa9: bits 8-15 are 11000011.
+		{"GETBIT word 8", "1"},
+		{"GETBIT word 10", "0"},
+		{"GETBIT word 14", "1"},
+		{"GETBIT word 48", "0"},
+		{"GETBIT word -1", formatError(errBitOffset)},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestSetRangeUsesBytes(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET word héllo")
+	// Offset 2 is the second byte of é, so overwriting it leaves a lone
+	// 0xc3 lead byte, exactly as Redis would.
+	if got := run(rs, "SETRANGE word 2 X"); got != "6" {
+		t.Fatalf("SETRANGE = %q, want 6", got)
+	}
+	if got := run(rs, "GET word"); got != "h\xc3Xllo" {
+		t.Errorf("GET after SETRANGE = %q, want %q", got, "h\xc3Xllo")
+	}
+	if got := run(rs, "SETRANGE pad 3 é"); got != "5" {
+		t.Fatalf("SETRANGE past the end = %q, want 5", got)
+	}
+	if got := run(rs, "GET pad"); got != "\x00\x00\x00é" {
+		t.Errorf("GET pad = %q, want zero padding then é", got)
+	}
+	if got := run(rs, "APPEND pad 😀"); got != "9" {
+		t.Errorf("APPEND = %q, want 9 bytes", got)
+	}
+	if got := run(rs, "SETRANGE word -1 x"); got != formatError(errOffsetRange) {
+		t.Errorf("SETRANGE with a negative offset = %q", got)
+	}
+	if got := run(rs, "SETRANGE word 536870912 x"); got != formatError(errStringTooLarge) {
+		t.Errorf("SETRANGE past 512MB = %q", got)
+	}
+}
+
+func TestSetRangeIsPersisted(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET word héllo")
+	run(rs, "SETRANGE word 1 e")
+	run(rs, "APPEND word !")
+	rs = reopen(t, rs)
+	if got := run(rs, "GET word"); got != "he\xa9llo!" {
+		t.Errorf("GET after reload = %q", got)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+	run(rs, "RPUSH l x")
+	tests := []struct{ cmd, want string }{
+		{"SET k v NX", "OK"},
+		{"SET k w NX", "nil"},
+		{"GET k", "v"},
+		{"SET missing v XX", "nil"},
+		{"GET missing", "nil"},
+		{"SET k w XX EX 10", "OK"},
+		{"TTL k", "10"},
+		{"SET k x KEEPTTL", "OK"},
+		{"TTL k", "10"},
+		{"SET k y", "OK"},
+		{"TTL k", "-1"},
+		{"SET k z PX 1500 GET", "y"},
+		{"PTTL k", "1500"},
+		{"SET new v GET", "nil"},
+		{"SET k v NX GET", "z"},
+		{"SET l v GET", formatError(errWrongType)},
+		{"LLEN l", "1"},
+		{"SET k v NX XX", formatError(errSyntax)},
+		{"SET k v EX 10 PX 10", formatError(errSyntax)},
+		{"SET k v EX 10 KEEPTTL", formatError(errSyntax)},
+		{"SET k v EX", formatError(errSyntax)},
+		{"SET k v BOGUS", formatError(errSyntax)},
+		{"SET k v EX 0", formatError(errInvalidExpire("set"))},
+		{"SET k v EX x", formatError(errNotInteger)},
+		{"PTTL k", "1500"},
+		{"SET k v PXAT 1", "OK"},
+		{"GET k", "nil"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	run(rs, "SET k v EX 100")
+	rs = reopen(t, rs)
+	if got := run(rs, "TTL k"); got != "100" {
+		t.Errorf("TTL after reload = %q, want 100", got)
+	}
+}
+
+func TestGetDelAndGetEx(t *testing.T) {
+	rs := newTestStore(t)
+	rs.clock = newFakeClock()
+	run(rs, "SET a 1")
+	run(rs, "SET b 2")
+	run(rs, "LPUSH l x")
+	tests := []struct{ cmd, want string }{
+		{"GETDEL a", "1"},
+		{"GET a", "nil"},
+		{"GETDEL a", "nil"},
+		{"GETDEL l", formatError(errWrongType)},
+		{"GETEX b PX 1500", "2"},
+		{"PTTL b", "1500"},
+		{"GETEX b", "2"},
+		{"PTTL b", "1500"},
+		{"GETEX b PERSIST", "2"},
+		{"TTL b", "-1"},
+		{"GETEX b EX 0", formatError(errInvalidExpire("getex"))},
+		{"GETEX b EX 1 PX 1", formatError(errSyntax)},
+		{"GETEX missing EX 10", "nil"},
+	}
+	for _, tt := range tests {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	run(rs, "GETEX b EX 10")
+	rs = reopen(t, rs)
+	if got := run(rs, "TTL b"); got != "10" {
+		t.Errorf("TTL after reload = %q, want 10", got)
+	}
+}
+
+func TestAppendGrowsInPlace(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "SET s abc")
+	run(rs, "APPEND s def")
+	run(rs, "SETRANGE s 7 xy")
+	if got := run(rs, "GET s"); got != "abcdef\x00xy" {
+		t.Fatalf("GET = %q, want the exact bytes", got)
+	}
+	if got := run(rs, "STRLEN s"); got != "9" {
+		t.Errorf("STRLEN = %q, want 9 despite the spare capacity", got)
+	}
+	if got := run(rs, "OBJECT ENCODING s"); got != "raw" {
+		t.Errorf("OBJECT ENCODING = %q, want raw", got)
+	}
+	// A value read earlier must not see later in-place writes.
+	before := run(rs, "GET s")
+	run(rs, "SETRANGE s 0 Z")
+	run(rs, "APPEND s !")
+	if before != "abcdef\x00xy" {
+		t.Errorf("earlier GET changed to %q", before)
+	}
+	if got := run(rs, "GETRANGE s 0 0"); got != "Z" {
+		t.Errorf("GETRANGE after SETRANGE = %q, want Z", got)
+	}
+	if got := run(rs, "INCR s"); got != formatError(errNotInteger) {
+		t.Errorf("INCR of an appended string = %q", got)
+	}
+	run(rs, "SET n 1")
+	run(rs, "APPEND n 2")
+	if got := run(rs, "INCR n"); got != "13" {
+		t.Errorf("INCR after APPEND = %q, want 13", got)
+	}
+	rs = reopen(t, rs)
+	if got := run(rs, "GET s"); got != "Zbcdef\x00xy!" {
+		t.Errorf("GET after reload = %q", got)
+	}
+}
+
+// Appending a byte at a time to a large string must not copy the whole
+// string each time. Total allocation is deterministic where timings are
+// not, so that is what is bounded.
+func TestRepeatedAppendIsLinear(t *testing.T) {
+	rs := newTestStore(t)
+	const size, appends = 1 << 20, 10_000
+	run(rs, "SET s "+strings.Repeat("x", size))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for range appends {
+		if _, err := rs.Append("s", "y"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	// Copying the string on every append would allocate about 10GB.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16*size {
+		t.Errorf("%d appends to a %d byte string allocated %d bytes", appends, size, alloc)
+	}
+	if n, _ := rs.StrLen("s"); n != size+appends {
+		t.Errorf("STRLEN = %d, want %d", n, size+appends)
+	}
+}
+
+// BenchmarkAppendByte appends a byte ten thousand times to one key.
+func BenchmarkAppendByte(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.Dir = b.TempDir()
+	rs, err := NewRedisStore(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(rs.Close)
+	for b.Loop() {
+		rs.Del([]string{"s"})
+		for range 10_000 {
+			rs.Append("s", "x")
+		}
+	}
+}
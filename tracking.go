@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// invalidateChannel is the channel invalidation messages are sent on.
+const invalidateChannel = "__redis__:invalidate"
+
+// tracking is the table of client-side caching: the clients with CLIENT
+// TRACKING on, and the keys each has read since they last changed. A
+// change to a tracked key queues an invalidation for its readers and stops
+// tracking it for them until they read it again, as in Redis's default
+// mode. It has its own lock, taken after the mutex when both are held.
+type tracking struct {
+	mu sync.Mutex
+	// clients maps each tracking client to the ID of the client its
+	// invalidations are redirected to, or 0 to send them to itself.
+	clients map[*client]int64
+	readers map[string]map[*client]struct{}
+	// pending are the invalidations queued while the mutex was held,
+	// which sendInvalidations delivers once it is not.
+	pending []invalidation
+}
+
+// invalidation is a queued invalidation of key for reader, or of every key
+// it holds when all is set.
+type invalidation struct {
+	reader *client
+	key    string
+	all    bool
+}
+
+var errNoRedirectClient = errors.New("ERR The client ID you want redirect to does not exist")
+
+// enable turns tracking on for c, sending its invalidations to the client
+// with ID redirect, or to c itself if it is 0.
+func (t *tracking) enable(c *client, redirect int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[*client]int64)
+	}
+	t.clients[c] = redirect
+}
+
+// disable turns tracking off for c and forgets the keys it read.
+func (t *tracking) disable(c *client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[c]; !ok {
+		return
+	}
+	delete(t.clients, c)
+	for key, readers := range t.readers {
+		delete(readers, c)
+		if len(readers) == 0 {
+			delete(t.readers, key)
+		}
+	}
+}
+
+// track records that c read keys, if it has tracking on.
+func (t *tracking) track(c *client, keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[c]; !ok {
+		return
+	}
+	if t.readers == nil {
+		t.readers = make(map[string]map[*client]struct{})
+	}
+	for _, key := range keys {
+		if t.readers[key] == nil {
+			t.readers[key] = make(map[*client]struct{})
+		}
+		t.readers[key][c] = struct{}{}
+	}
+}
+
+// invalidate queues an invalidation of key for each client that read it.
+// The caller holds the mutex, as touch does.
+func (t *tracking) invalidate(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.readers[key] {
+		t.pending = append(t.pending, invalidation{reader: c, key: key})
+	}
+	delete(t.readers, key)
+}
+
+// invalidateAll queues an invalidation of every key for each tracking
+// client, whatever it read, as a flush does. The caller holds the mutex.
+func (t *tracking) invalidateAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.clients {
+		t.pending = append(t.pending, invalidation{reader: c, all: true})
+	}
+	clear(t.readers)
+}
+
+// sendInvalidations delivers the queued invalidations, each as a message on
+// invalidateChannel carrying the key, or nil for all of them. An
+// invalidation for a client whose redirect target has gone, or that turned
+// tracking off since, is dropped. The caller must not hold the mutex.
+func (r *RedisStore) sendInvalidations() {
+	t := &r.tracking
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	targets := make([]int64, len(pending))
+	tracked := make([]bool, len(pending))
+	for i, inv := range pending {
+		targets[i], tracked[i] = t.clients[inv.reader]
+	}
+	t.mu.Unlock()
+
+	for i, inv := range pending {
+		if !tracked[i] {
+			continue
+		}
+		keys := formatArray([]string{inv.key})
+		if inv.all {
+			keys = "nil"
+		}
+		frame := formatArray([]string{"message", invalidateChannel, keys})
+		if targets[i] == 0 {
+			inv.reader.deliver(frame)
+		} else if ci := r.findClient(targets[i]); ci != nil && ci.push != nil {
+			ci.push(frame)
+		}
+	}
+}
+
+// trackingCommand handles CLIENT TRACKING ON [REDIRECT id] and OFF for c.
+func (c *client) trackingCommand(args []string) string {
+	switch {
+	case len(args) == 1 && strings.ToUpper(args[0]) == "OFF":
+		c.rs.tracking.disable(c)
+		return "OK"
+	case strings.ToUpper(args[0]) != "ON":
+		return formatError(errSyntax)
+	}
+	var redirect int64
+	switch {
+	case len(args) == 3 && strings.ToUpper(args[1]) == "REDIRECT":
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return formatError(errNotInteger)
+		}
+		if id != c.info.id {
+			if c.rs.findClient(id) == nil {
+				return formatError(errNoRedirectClient)
+			}
+			redirect = id
+		}
+	case len(args) != 1:
+		return formatError(errSyntax)
+	}
+	c.rs.tracking.enable(c, redirect)
+	return "OK"
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotFloat = errors.New("ERR value is not a valid float")
+	errScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+)
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// compareEntries orders entries by score, breaking ties by member.
+func compareEntries(a, b zsetEntry) int {
+	switch {
+	case a.score < b.score:
+		return -1
+	case a.score > b.score:
+		return 1
+	}
+	return strings.Compare(a.member, b.member)
+}
+
+// sortedSet is a zset value. Small sets use the listpack encoding: just the
+// ordered entries, searched linearly. Once a set grows past the configured
+// limits it converts to the skiplist encoding, which adds a member index for
+// constant-time score lookups alongside the ordered entries, and an index
+// of the members for ZSCAN. Like Redis, a set never converts back.
+type sortedSet struct {
+	entries []zsetEntry
+	dict    map[string]float64
+	index   *scanIndex
+}
+
+func (z *sortedSet) encoding() string {
+	if z.dict != nil {
+		return "skiplist"
+	}
+	return "listpack"
+}
+
+func (z *sortedSet) len() int {
+	return len(z.entries)
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	if z.dict != nil {
+		score, ok := z.dict[member]
+		return score, ok
+	}
+	for _, e := range z.entries {
+		if e.member == member {
+			return e.score, true
+		}
+	}
+	return 0, false
+}
+
+// rank returns the position of member in score order, or -1.
+func (z *sortedSet) rank(member string) int {
+	score, ok := z.score(member)
+	if !ok {
+		return -1
+	}
+	i, _ := slices.BinarySearchFunc(z.entries, zsetEntry{member, score}, compareEntries)
+	return i
+}
+
+// add sets member's score, reporting whether it was newly added.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.score(member)
+	if exists {
+		if old == score {
+			return false
+		}
+		z.remove(member)
+	}
+	e := zsetEntry{member, score}
+	i, _ := slices.BinarySearchFunc(z.entries, e, compareEntries)
+	z.entries = slices.Insert(z.entries, i, e)
+	if z.dict != nil {
+		z.dict[member] = score
+		z.index.add(member)
+	}
+	return !exists
+}
+
+func (z *sortedSet) remove(member string) bool {
+	i := z.rank(member)
+	if i < 0 {
+		return false
+	}
+	z.entries = slices.Delete(z.entries, i, i+1)
+	if z.dict != nil {
+		delete(z.dict, member)
+		z.index.remove(member)
+	}
+	return true
+}
+
+// convert switches a listpack set to the skiplist encoding once it holds more
+// than maxEntries members or a member longer than maxValue bytes.
+func (z *sortedSet) convert(maxEntries, maxValue int) {
+	if z.dict != nil {
+		return
+	}
+	fits := len(z.entries) <= maxEntries
+	for _, e := range z.entries {
+		fits = fits && len(e.member) <= maxValue
+	}
+	if fits {
+		return
+	}
+	z.dict = make(map[string]float64, len(z.entries))
+	z.index = &scanIndex{}
+	for _, e := range z.entries {
+		z.dict[e.member] = e.score
+		z.index.add(e.member)
+	}
+}
+
+// parseScore parses a zset score. Infinities are valid scores; NaN is not.
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errNotFloat
+	}
+	if math.IsNaN(f) {
+		return 0, errScoreNaN
+	}
+	return f, nil
+}
+
+// formatScore renders a score the way Redis replies with it.
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// getZSet returns the sorted set at key, nil if the key does not exist, or
+// errWrongType if it holds another type. The caller must hold the mutex.
+func (r *RedisStore) getZSet(key string) (*sortedSet, error) {
+	sv := r.lookup(key)
+	if sv == nil {
+		return nil, nil
+	}
+	z, ok := sv.value.(*sortedSet)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+// zsetForWrite returns the sorted set at key, creating an empty one if the
+// key does not exist. The caller must hold the mutex.
+func (r *RedisStore) zsetForWrite(key string) (*sortedSet, error) {
+	z, err := r.getZSet(key)
+	if err != nil || z != nil {
+		return z, err
+	}
+	z = &sortedSet{}
+	r.data[key] = r.newValue(z)
+	return z, nil
+}
+
+// ZAdd adds or updates members, returning how many were newly added.
+func (r *RedisStore) ZAdd(key string, entries []zsetEntry) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	z, err := r.zsetForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	args := []string{key}
+	for _, e := range entries {
+		if z.add(e.member, e.score) {
+			added++
+		}
+		args = append(args, formatScore(e.score), e.member)
+	}
+	z.convert(r.config.ZSetMaxListpackEntries, r.config.ZSetMaxListpackValue)
+	r.touch(key)
+	if err := r.writeAOF("ZADD", args...); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// ZIncrBy adds incr to member's score, treating a missing member as 0. An
+// increment that would leave the score NaN, adding inf to -inf, fails
+// without changing anything.
+func (r *RedisStore) ZIncrBy(key string, incr float64, member string) (float64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	z, err := r.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	var score float64
+	if z != nil {
+		score, _ = z.score(member)
+	}
+	score += incr
+	if math.IsNaN(score) {
+		return 0, errScoreNaN
+	}
+	if z, err = r.zsetForWrite(key); err != nil {
+		return 0, err
+	}
+	z.add(member, score)
+	z.convert(r.config.ZSetMaxListpackEntries, r.config.ZSetMaxListpackValue)
+	r.touch(key)
+	if err := r.writeAOF("ZINCRBY", key, formatScore(incr), member); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+func (r *RedisStore) ZRem(key string, members []string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	z, err := r.getZSet(key)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			removed++
+		}
+	}
+	if z.len() == 0 {
+		delete(r.data, key)
+	}
+	if removed > 0 {
+		r.touch(key)
+		if err := r.writeAOF("ZREM", append([]string{key}, members...)...); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+func (r *RedisStore) ZScore(key, member string) (float64, bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	r.stats.keyspaceRead(z != nil || err != nil)
+	if err != nil || z == nil {
+		return 0, false, err
+	}
+	score, ok := z.score(member)
+	return score, ok, nil
+}
+
+func (r *RedisStore) ZCard(key string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	r.stats.keyspaceRead(z != nil || err != nil)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	return z.len(), nil
+}
+
+// ZRank returns member's position in score order, or -1 if it is absent.
+func (r *RedisStore) ZRank(key, member string) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	r.stats.keyspaceRead(z != nil || err != nil)
+	if err != nil || z == nil {
+		return -1, err
+	}
+	return z.rank(member), nil
+}
+
+// ZRange returns the entries between ranks start and stop inclusive, with
+// negative ranks counting back from the highest score.
+func (r *RedisStore) ZRange(key string, start, stop int) ([]zsetEntry, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	z, err := r.getZSet(key)
+	r.stats.keyspaceRead(z != nil || err != nil)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	n := z.len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	return slices.Clone(z.entries[start : stop+1]), nil
+}
+
+// ZInterCard returns the size of the intersection of the sorted sets at
+// keys, stopping early once it reaches limit. A limit of 0 means no limit.
+func (r *RedisStore) ZInterCard(keys []string, limit int) (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sets := make([]*sortedSet, len(keys))
+	for i, key := range keys {
+		z, err := r.getZSet(key)
+		if err != nil {
+			return 0, err
+		}
+		if z == nil {
+			z = &sortedSet{}
+		}
+		sets[i] = z
+	}
+	slices.SortFunc(sets, func(a, b *sortedSet) int { return a.len() - b.len() })
+	count := 0
+members:
+	for _, e := range sets[0].entries {
+		for _, z := range sets[1:] {
+			if _, ok := z.score(e.member); !ok {
+				continue members
+			}
+		}
+		count++
+		if count == limit {
+			break
+		}
+	}
+	return count, nil
+}
+
+// zsetAggregate selects how ZUNIONSTORE and ZINTERSTORE combine the scores
+// of a member present in several inputs.
+type zsetAggregate int
+
+const (
+	aggregateSum zsetAggregate = iota
+	aggregateMin
+	aggregateMax
+)
+
+// weightScore multiplies score by weight. Like Redis, 0 * ±inf is taken to
+// be 0 rather than NaN.
+func weightScore(score, weight float64) float64 {
+	v := score * weight
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// aggregate combines two weighted scores. Summing +inf and -inf gives 0,
+// matching Redis, rather than NaN.
+func (a zsetAggregate) aggregate(x, y float64) float64 {
+	switch a {
+	case aggregateMin:
+		return math.Min(x, y)
+	case aggregateMax:
+		return math.Max(x, y)
+	}
+	v := x + y
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// zsetOp is a set operation performed by the ZUNIONSTORE family.
+type zsetOp string
+
+const (
+	zsetUnion zsetOp = "ZUNIONSTORE"
+	zsetInter zsetOp = "ZINTERSTORE"
+	zsetDiff  zsetOp = "ZDIFFSTORE"
+)
+
+// ZStore computes op over the sorted sets at keys and stores the result at
+// dest, replacing whatever it held, and returns the result's cardinality.
+// Missing keys count as empty sets. weights has one entry per key; ZDIFFSTORE
+// ignores weights and agg, as in Redis. args are the original command
+// arguments, which are persisted as is since the result is deterministic.
+func (r *RedisStore) ZStore(op zsetOp, dest string, keys []string, weights []float64, agg zsetAggregate, args []string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sets := make([]*sortedSet, len(keys))
+	for i, key := range keys {
+		z, err := r.getZSet(key)
+		if err != nil {
+			return 0, err
+		}
+		if z == nil {
+			z = &sortedSet{}
+		}
+		sets[i] = z
+	}
+
+	scores := make(map[string]float64)
+	switch op {
+	case zsetUnion:
+		for i, z := range sets {
+			for _, e := range z.entries {
+				v := weightScore(e.score, weights[i])
+				if old, ok := scores[e.member]; ok {
+					v = agg.aggregate(old, v)
+				}
+				scores[e.member] = v
+			}
+		}
+	case zsetInter:
+	members:
+		for _, e := range sets[0].entries {
+			v := weightScore(e.score, weights[0])
+			for i, z := range sets[1:] {
+				score, ok := z.score(e.member)
+				if !ok {
+					continue members
+				}
+				v = agg.aggregate(v, weightScore(score, weights[i+1]))
+			}
+			scores[e.member] = v
+		}
+	case zsetDiff:
+		for _, e := range sets[0].entries {
+			found := false
+			for _, z := range sets[1:] {
+				if _, ok := z.score(e.member); ok {
+					found = true
+					break
+				}
+			}
+			if !found {
+				scores[e.member] = e.score
+			}
+		}
+	}
+
+	delete(r.data, dest)
+	if len(scores) > 0 {
+		result := &sortedSet{entries: make([]zsetEntry, 0, len(scores))}
+		for member, score := range scores {
+			result.entries = append(result.entries, zsetEntry{member, score})
+		}
+		slices.SortFunc(result.entries, compareEntries)
+		result.convert(r.config.ZSetMaxListpackEntries, r.config.ZSetMaxListpackValue)
+		r.data[dest] = r.newValue(result)
+	}
+	r.touch(dest)
+	if err := r.writeAOF(string(op), args...); err != nil {
+		return 0, err
+	}
+	return len(scores), nil
+}
+
+var errWeight = errors.New("ERR weight value is not a float")
+
+// parseZStoreArgs parses "destination numkeys key [key ...]" followed, for
+// ZUNIONSTORE and ZINTERSTORE, by optional WEIGHTS and AGGREGATE clauses.
+func parseZStoreArgs(op zsetOp, args []string) (string, []string, []float64, zsetAggregate, error) {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", nil, nil, 0, errNotInteger
+	}
+	if numKeys <= 0 {
+		return "", nil, nil, 0, fmt.Errorf("ERR at least 1 input key is needed for '%s' command", strings.ToLower(string(op)))
+	}
+	if numKeys > len(args)-2 {
+		return "", nil, nil, 0, errSyntax
+	}
+	keys := args[2 : numKeys+2]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	agg := aggregateSum
+	rest := args[numKeys+2:]
+	for len(rest) > 0 {
+		if op == zsetDiff {
+			return "", nil, nil, 0, errSyntax
+		}
+		switch strings.ToUpper(rest[0]) {
+		case "WEIGHTS":
+			if numKeys > len(rest)-1 {
+				return "", nil, nil, 0, errSyntax
+			}
+			for i := range weights {
+				w, err := strconv.ParseFloat(rest[i+1], 64)
+				if err != nil || math.IsNaN(w) {
+					return "", nil, nil, 0, errWeight
+				}
+				weights[i] = w
+			}
+			rest = rest[numKeys+1:]
+		case "AGGREGATE":
+			if len(rest) < 2 {
+				return "", nil, nil, 0, errSyntax
+			}
+			switch strings.ToUpper(rest[1]) {
+			case "SUM":
+				agg = aggregateSum
+			case "MIN":
+				agg = aggregateMin
+			case "MAX":
+				agg = aggregateMax
+			default:
+				return "", nil, nil, 0, errSyntax
+			}
+			rest = rest[2:]
+		default:
+			return "", nil, nil, 0, errSyntax
+		}
+	}
+	return args[0], keys, weights, agg, nil
+}
+
+func zsetCommand(cmd Command, rs Store) string {
+	args := cmd.Args
+	switch cmd.Name {
+	case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+		if len(args) >= 3 {
+			op := zsetOp(cmd.Name)
+			dest, keys, weights, agg, err := parseZStoreArgs(op, args)
+			if err != nil {
+				return formatError(err)
+			}
+			n, err := rs.ZStore(op, dest, keys, weights, agg, args)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "ZINTERCARD":
+		if len(args) >= 2 {
+			keys, limit, err := parseInterCardArgs(args)
+			if err != nil {
+				return formatError(err)
+			}
+			n, err := rs.ZInterCard(keys, limit)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "ZADD":
+		if len(args) >= 3 && len(args)%2 == 1 {
+			entries := make([]zsetEntry, 0, len(args)/2)
+			for i := 1; i < len(args); i += 2 {
+				score, err := parseScore(args[i])
+				if err != nil {
+					return formatError(err)
+				}
+				entries = append(entries, zsetEntry{member: args[i+1], score: score})
+			}
+			n, err := rs.ZAdd(args[0], entries)
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "ZINCRBY":
+		if len(args) == 3 {
+			incr, err := parseScore(args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			score, err := rs.ZIncrBy(args[0], incr, args[2])
+			if err != nil {
+				return formatError(err)
+			}
+			return formatScore(score)
+		}
+	case "ZREM":
+		if len(args) >= 2 {
+			n, err := rs.ZRem(args[0], args[1:])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "ZSCORE":
+		if len(args) == 2 {
+			score, ok, err := rs.ZScore(args[0], args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			if !ok {
+				return "nil"
+			}
+			return formatScore(score)
+		}
+	case "ZCARD":
+		if len(args) == 1 {
+			n, err := rs.ZCard(args[0])
+			if err != nil {
+				return formatError(err)
+			}
+			return strconv.Itoa(n)
+		}
+	case "ZRANK":
+		if len(args) == 2 {
+			rank, err := rs.ZRank(args[0], args[1])
+			if err != nil {
+				return formatError(err)
+			}
+			if rank < 0 {
+				return "nil"
+			}
+			return strconv.Itoa(rank)
+		}
+	case "ZRANGE":
+		withScores := len(args) == 4 && strings.ToUpper(args[3]) == "WITHSCORES"
+		if len(args) == 3 || withScores {
+			start, err1 := strconv.Atoi(args[1])
+			stop, err2 := strconv.Atoi(args[2])
+			if err1 != nil || err2 != nil {
+				return formatError(errNotInteger)
+			}
+			entries, err := rs.ZRange(args[0], start, stop)
+			if err != nil {
+				return formatError(err)
+			}
+			return formatArray(zsetReply(entries, withScores))
+		}
+	}
+	return ""
+}
+
+// zsetReply flattens entries into members, each followed by its score when
+// withScores is set.
+func zsetReply(entries []zsetEntry, withScores bool) []string {
+	items := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		items = append(items, e.member)
+		if withScores {
+			items = append(items, formatScore(e.score))
+		}
+	}
+	return items
+}
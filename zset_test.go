@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestZSetEncodingTransition(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "ZADD z 1 a 2 b 3 c")
+	if got := run(rs, "OBJECT ENCODING z"); got != "listpack" {
+		t.Fatalf("small zset encoding = %q, want listpack", got)
+	}
+
+	long := strings.Repeat("m", 65)
+	run(rs, "ZADD z 1.5 "+long)
+	if got := run(rs, "OBJECT ENCODING z"); got != "skiplist" {
+		t.Fatalf("encoding after adding a long member = %q, want skiplist", got)
+	}
+
+	want := "1) a\n2) 1\n3) " + long + "\n4) 1.5\n5) b\n6) 2\n7) c\n8) 3"
+	if got := run(rs, "ZRANGE z 0 -1 WITHSCORES"); got != want {
+		t.Errorf("ZRANGE after conversion = %q, want %q", got, want)
+	}
+	if got := run(rs, "ZSCORE z "+long); got != "1.5" {
+		t.Errorf("ZSCORE of long member = %q", got)
+	}
+	if got := run(rs, "ZINCRBY z 10 a"); got != "11" {
+		t.Errorf("ZINCRBY = %q", got)
+	}
+	if got := run(rs, "ZRANK z a"); got != "3" {
+		t.Errorf("ZRANK after ZINCRBY = %q, want 3", got)
+	}
+	run(rs, "ZREM z "+long)
+	if got := run(rs, "OBJECT ENCODING z"); got != "skiplist" {
+		t.Errorf("encoding after shrinking = %q, want skiplist to stick", got)
+	}
+	if got := run(rs, "ZCARD z"); got != "3" {
+		t.Errorf("ZCARD = %q", got)
+	}
+}
+
+func TestZSetEntriesThreshold(t *testing.T) {
+	rs := newTestStore(t)
+	rs.config.ZSetMaxListpackEntries = 2
+	run(rs, "ZADD z 1 a 2 b")
+	if got := run(rs, "OBJECT ENCODING z"); got != "listpack" {
+		t.Fatalf("encoding at the limit = %q, want listpack", got)
+	}
+	run(rs, "ZADD z 0 c")
+	if got := run(rs, "OBJECT ENCODING z"); got != "skiplist" {
+		t.Fatalf("encoding past the limit = %q, want skiplist", got)
+	}
+	if got := run(rs, "ZRANGE z 0 -1"); got != "1) c\n2) a\n3) b" {
+		t.Errorf("ZRANGE = %q", got)
+	}
+}
+
+func TestZAddScores(t *testing.T) {
+	rs := newTestStore(t)
+	if got := run(rs, "ZADD z inf top -inf bottom 0 mid"); got != "3" {
+		t.Errorf("ZADD with infinite scores = %q, want 3", got)
+	}
+	if got := run(rs, "ZRANGE z 0 -1 WITHSCORES"); got != "1) bottom\n2) -inf\n3) mid\n4) 0\n5) top\n6) inf" {
+		t.Errorf("ZRANGE = %q", got)
+	}
+	if got := run(rs, "ZADD z abc x"); got != "-ERR value is not a valid float" {
+		t.Errorf("ZADD with bad score = %q", got)
+	}
+	if got := run(rs, "ZADD z 5 mid"); got != "0" {
+		t.Errorf("ZADD updating a member = %q, want 0", got)
+	}
+	run(rs, "SET s v")
+	if got := run(rs, "ZADD s 1 a"); !strings.HasPrefix(got, "-WRONGTYPE") {
+		t.Errorf("ZADD on a string = %q", got)
+	}
+}
+
+func TestZScoreNaNRejected(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "ZADD z inf top")
+	for _, tt := range []struct{ cmd, want string }{
+		{"ZADD z nan x", "-" + errScoreNaN.Error()},
+		{"ZINCRBY z nan top", "-" + errScoreNaN.Error()},
+		// inf + -inf is NaN.
+		{"ZINCRBY z -inf top", "-" + errScoreNaN.Error()},
+		{"ZSCORE z top", "inf"},
+		{"ZCARD z", "1"},
+		{"ZINCRBY z +inf top", "inf"},
+		{"ZINCRBY z -inf bottom", "-inf"},
+		{"ZINCRBY missing nan x", "-" + errScoreNaN.Error()},
+		{"ZCARD missing", "0"},
+	} {
+		if got := run(rs, tt.cmd); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	if got := run(reopen(t, rs), "ZRANGE z 0 -1 WITHSCORES"); got != "1) bottom\n2) -inf\n3) top\n4) inf" {
+		t.Errorf("ZRANGE after a reload = %q", got)
+	}
+}
+
+func TestZUnionStoreInfinityAndZeroWeight(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "ZADD a inf x 1 y")
+	run(rs, "ZADD b -inf x 2 y")
+
+	// 0 * inf is 0, not NaN.
+	if got := run(rs, "ZUNIONSTORE out 2 a b WEIGHTS 0 1"); got != "2" {
+		t.Fatalf("ZUNIONSTORE = %q, want 2", got)
+	}
+	if got := run(rs, "ZRANGE out 0 -1 WITHSCORES"); got != "1) x\n2) -inf\n3) y\n4) 2" {
+		t.Errorf("zero-weighted union = %q", got)
+	}
+	if got := run(rs, "ZUNIONSTORE out 2 a b WEIGHTS 0 0"); got != "2" {
+		t.Fatalf("ZUNIONSTORE = %q, want 2", got)
+	}
+	if got := run(rs, "ZRANGE out 0 -1 WITHSCORES"); got != "1) x\n2) 0\n3) y\n4) 0" {
+		t.Errorf("all-zero-weighted union = %q", got)
+	}
+
+	// inf + -inf sums to 0, not NaN.
+	run(rs, "ZUNIONSTORE out 2 a b")
+	if got := run(rs, "ZSCORE out x"); got != "0" {
+		t.Errorf("inf + -inf = %q, want 0", got)
+	}
+
+	run(rs, "ZUNIONSTORE out 2 a b AGGREGATE MIN")
+	if got := run(rs, "ZSCORE out x"); got != "-inf" {
+		t.Errorf("MIN(inf, -inf) = %q", got)
+	}
+	run(rs, "ZUNIONSTORE out 2 a b WEIGHTS 0 1 AGGREGATE MAX")
+	if got := run(rs, "ZSCORE out x"); got != "0" {
+		t.Errorf("MAX(0*inf, -inf) = %q, want 0", got)
+	}
+	run(rs, "ZUNIONSTORE out 2 a b WEIGHTS -1 1 AGGREGATE MAX")
+	if got := run(rs, "ZSCORE out x"); got != "-inf" {
+		t.Errorf("MAX(-1*inf, -inf) = %q", got)
+	}
+}
+
+func TestZInterAndDiffStore(t *testing.T) {
+	rs := newTestStore(t)
+	run(rs, "ZADD a 1 x 2 y 3 z")
+	run(rs, "ZADD b 10 y 20 z 30 w")
+
+	if got := run(rs, "ZINTERSTORE out 2 a b WEIGHTS 2 0.5"); got != "2" {
+		t.Fatalf("ZINTERSTORE = %q, want 2", got)
+	}
+	if got := run(rs, "ZRANGE out 0 -1 WITHSCORES"); got != "1) y\n2) 9\n3) z\n4) 16" {
+		t.Errorf("weighted intersection = %q", got)
+	}
+
+	if got := run(rs, "ZDIFFSTORE out 2 a b"); got != "1" {
+		t.Fatalf("ZDIFFSTORE = %q, want 1", got)
+	}
+	if got := run(rs, "ZRANGE out 0 -1 WITHSCORES"); got != "1) x\n2) 1" {
+		t.Errorf("difference = %q", got)
+	}
+	if got := run(rs, "ZDIFFSTORE out 2 a b WEIGHTS 1 1"); got != "-ERR syntax error" {
+		t.Errorf("ZDIFFSTORE with WEIGHTS = %q", got)
+	}
+
+	if got := run(rs, "ZINTERSTORE out 2 a missing"); got != "0" {
+		t.Errorf("intersection with a missing key = %q, want 0", got)
+	}
+	if got := run(rs, "ZCARD out"); got != "0" {
+		t.Errorf("empty result left out with ZCARD %q", got)
+	}
+	if got := run(rs, "ZUNIONSTORE out 2 a b WEIGHTS 1 nan"); got != "-ERR weight value is not a float" {
+		t.Errorf("NaN weight = %q", got)
+	}
+	if got := run(rs, "ZUNIONSTORE out 0 a"); got != "-ERR at least 1 input key is needed for 'zunionstore' command" {
+		t.Errorf("zero numkeys = %q", got)
+	}
+	// A numkeys that would overflow once the other arguments are added.
+	huge := strconv.Itoa(math.MaxInt)
+	for _, op := range []string{"ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE"} {
+		cmd := op + " out " + huge + " a"
+		if got := run(rs, cmd); got != formatError(errSyntax) {
+			t.Errorf("%s = %q, want a syntax error", cmd, got)
+		}
+	}
+	if got := run(rs, "ZUNIONSTORE out 2 a b WEIGHTS 1"); got != formatError(errSyntax) {
+		t.Errorf("WEIGHTS short of numkeys = %q, want a syntax error", got)
+	}
+}